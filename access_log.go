@@ -0,0 +1,178 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPServerMetrics are request metrics for a HTTP server. Requests are
+// labelled with the Twirp service and method that handled them, when
+// available.
+type HTTPServerMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewHTTPServerMetrics registers a set of HTTP server metrics with the provided
+// registerer.
+func NewHTTPServerMetrics(
+	registerer prometheus.Registerer,
+) (*HTTPServerMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	requests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_server_requests_total",
+			Help: "A counter for requests handled by the HTTP server.",
+		},
+		[]string{"service", "method", "code"},
+	)
+
+	duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_server_request_duration_seconds",
+			Help:    "A histogram of HTTP server request latencies.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "method"},
+	)
+
+	collectors := []prometheus.Collector{requests, duration}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	m := HTTPServerMetrics{
+		requests: requests,
+		duration: duration,
+	}
+
+	return &m, nil
+}
+
+// AccessLogMiddleware logs one line per request with the response status,
+// duration, and the log metadata that was set while handling the request. As
+// the logging and auth hooks for Twirp set the service, method, and subject as
+// log metadata the access log entries can be joined with the Twirp telemetry.
+//
+// The middleware adds a log metadata map to the request context if it's
+// missing. Metrics are optional and are only recorded if metrics is non-nil.
+func AccessLogMiddleware(
+	logger *slog.Logger, metrics *HTTPServerMetrics, next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if GetLogMetadata(ctx) == nil {
+			ctx = WithLogMetadata(ctx)
+			r = r.WithContext(ctx)
+		}
+
+		start := time.Now()
+		rec := statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(&rec, r)
+
+		duration := time.Since(start)
+		status := rec.Status()
+		meta := GetLogMetadata(ctx)
+
+		service, _ := meta[LogKeyService].(string)
+		method, _ := meta[LogKeyMethod].(string)
+
+		if metrics != nil {
+			metrics.requests.WithLabelValues(
+				service, method, strconv.Itoa(status),
+			).Inc()
+			metrics.duration.WithLabelValues(
+				service, method,
+			).Observe(duration.Seconds())
+		}
+
+		args := []any{
+			LogKeyStatusCode, status,
+			LogKeyDuration, duration,
+			LogKeyHTTPMethod, r.Method,
+			LogKeyRoute, r.URL.Path,
+		}
+
+		keys := make([]string, 0, len(meta))
+
+		for k := range meta {
+			keys = append(keys, k)
+		}
+
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			args = append(args, k, meta[k])
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+
+		// The metadata has already been added explicitly, log without
+		// the request context so that it doesn't get duplicated.
+		logger.Log(context.Background(), level, "http request", args...)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if sr.status == 0 {
+		sr.status = statusCode
+	}
+
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sr *statusRecorder) Write(data []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+
+	return sr.ResponseWriter.Write(data) //nolint:wrapcheck
+}
+
+// Flush implements http.Flusher.
+func (sr *statusRecorder) Flush() {
+	f, ok := sr.ResponseWriter.(http.Flusher)
+	if ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) Status() int {
+	if sr.status == 0 {
+		return http.StatusOK
+	}
+
+	return sr.status
+}
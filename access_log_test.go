@@ -0,0 +1,74 @@
+package elephantine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var logBuf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&logBuf, nil))
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewHTTPServerMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	// Simulates what the Twirp logging hooks do.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		elephantine.SetLogMetadata(ctx, elephantine.LogKeyService, "Documents")
+		elephantine.SetLogMetadata(ctx, elephantine.LogKeyMethod, "Update")
+		elephantine.SetLogMetadata(ctx, elephantine.LogKeySubject, "core://user/1")
+
+		w.WriteHeader(http.StatusConflict)
+	})
+
+	mw := elephantine.AccessLogMiddleware(logger, metrics, handler)
+
+	req := httptest.NewRequest(http.MethodPost,
+		"/twirp/elephant.repository.Documents/Update", nil)
+	rec := httptest.NewRecorder()
+
+	mw.ServeHTTP(rec, req)
+
+	test.Equal(t, http.StatusConflict, rec.Code, "pass through the status")
+
+	var entry map[string]any
+
+	err = json.Unmarshal(logBuf.Bytes(), &entry)
+	test.Must(t, err, "parse log entry")
+
+	test.Equal[any](t, float64(http.StatusConflict),
+		entry[elephantine.LogKeyStatusCode], "log the status code")
+	test.Equal[any](t, "Documents",
+		entry[elephantine.LogKeyService], "log the service")
+	test.Equal[any](t, "Update",
+		entry[elephantine.LogKeyMethod], "log the method")
+	test.Equal[any](t, "core://user/1",
+		entry[elephantine.LogKeySubject], "log the subject")
+
+	_, hasDuration := entry[elephantine.LogKeyDuration]
+	test.Equal(t, true, hasDuration, "log the duration")
+
+	expected := `
+# HELP http_server_requests_total A counter for requests handled by the HTTP server.
+# TYPE http_server_requests_total counter
+http_server_requests_total{code="409",method="Update",service="Documents"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"http_server_requests_total")
+	test.Must(t, err, "count the request with method labels")
+}
@@ -0,0 +1,152 @@
+package elephantine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned by AllowlistTransport for requests to hosts
+// that aren't allowed.
+var ErrHostNotAllowed = errors.New("host is not allowed")
+
+// AllowlistOptions controls which hosts an AllowlistTransport lets through.
+type AllowlistOptions struct {
+	// Name of the client, used in the audit log.
+	Name string
+	// Hosts are the allowed hosts. A host is either a host name,
+	// "*.example.com" to allow all subdomains of a domain, or an IP
+	// address. A host can be followed by a port, like
+	// "files.example.com:8443", to only allow that port.
+	Hosts []string
+	// Ports are the ports that are allowed for hosts that don't specify
+	// a port. Defaults to 80 and 443.
+	Ports []int
+	// Logger is used to log blocked requests. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// AllowlistTransport is a http.RoundTripper that only lets requests through to
+// allowed hosts and ports, to protect against server-side request forgery when
+// fetching URLs from user content. Redirects are checked as well, as every
+// request passes through the transport. Blocked requests are logged as
+// warnings.
+//
+// Hosts are matched by name, so an allowed host name that resolves to an
+// internal address will still be contacted.
+type AllowlistTransport struct {
+	base   http.RoundTripper
+	opts   AllowlistOptions
+	logger *slog.Logger
+}
+
+// NewAllowlistTransport creates an allowlist transport that wraps the base
+// transport. The base defaults to http.DefaultTransport.
+func NewAllowlistTransport(
+	base http.RoundTripper, opts AllowlistOptions,
+) *AllowlistTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if len(opts.Ports) == 0 {
+		opts.Ports = []int{80, 443}
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &AllowlistTransport{
+		base:   base,
+		opts:   opts,
+		logger: logger,
+	}
+}
+
+// AllowHosts restricts the client to the allowed hosts, see
+// AllowlistTransport.
+func AllowHosts(opts AllowlistOptions) HTTPClientOption {
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return NewAllowlistTransport(base, opts)
+	})
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *AllowlistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	port := req.URL.Port()
+
+	if port == "" {
+		port = "80"
+
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if !t.Allowed(host, port) {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+
+		t.logger.WarnContext(req.Context(), "blocked outbound request",
+			LogKeyName, t.opts.Name,
+			LogKeyHTTPMethod, req.Method,
+			LogKeyURL, req.URL.Redacted())
+
+		return nil, fmt.Errorf("%w: %s",
+			ErrHostNotAllowed, net.JoinHostPort(host, port))
+	}
+
+	return t.base.RoundTrip(req) //nolint:wrapcheck
+}
+
+// Allowed returns true if requests to the host and port are allowed.
+func (t *AllowlistTransport) Allowed(host string, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, pattern := range t.opts.Hosts {
+		pHost, pPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			pHost = pattern
+		}
+
+		pHost = strings.ToLower(strings.Trim(pHost, "[]"))
+
+		if !matchesHostPattern(host, pHost) {
+			continue
+		}
+
+		if pPort != "" {
+			if pPort == port {
+				return true
+			}
+
+			continue
+		}
+
+		n, err := strconv.Atoi(port)
+		if err == nil && slices.Contains(t.opts.Ports, n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesHostPattern(host string, pattern string) bool {
+	domain, wildcard := strings.CutPrefix(pattern, "*.")
+	if wildcard {
+		return strings.HasSuffix(host, "."+domain)
+	}
+
+	return host == pattern
+}
@@ -0,0 +1,67 @@
+package elephantine_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestAllowlistTransport(t *testing.T) {
+	allowlist := elephantine.NewAllowlistTransport(nil, elephantine.AllowlistOptions{
+		Hosts: []string{
+			"files.example.com",
+			"*.cdn.example.com",
+			"upload.example.com:8443",
+		},
+	})
+
+	cases := map[string]bool{
+		"https://files.example.com/a.jpg":       true,
+		"http://files.example.com/a.jpg":        true,
+		"https://files.example.com:8080/a.jpg":  false,
+		"https://img.cdn.example.com/a.jpg":     true,
+		"https://cdn.example.com/a.jpg":         false,
+		"https://upload.example.com:8443/":      true,
+		"https://upload.example.com/":           false,
+		"http://169.254.169.254/latest/":        false,
+		"https://files.example.com.evil.test/":  false,
+		"https://FILES.example.com./uppercase/": true,
+	}
+
+	for raw, allowed := range cases {
+		u, err := url.Parse(raw)
+		test.Must(t, err, "parse URL")
+
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+		}
+
+		test.Equal(t, allowed, allowlist.Allowed(u.Hostname(), port),
+			"check %q", raw)
+	}
+
+	server := httptest.NewServer(http.RedirectHandler(
+		"http://169.254.169.254/latest/meta-data", http.StatusFound))
+
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	test.Must(t, err, "parse server URL")
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.AllowHosts(elephantine.AllowlistOptions{
+			Hosts: []string{serverURL.Host},
+		}))
+
+	_, err = client.Get(server.URL)
+
+	test.Equal(t, true, errors.Is(err, elephantine.ErrHostNotAllowed),
+		"block redirects to hosts that aren't allowed")
+}
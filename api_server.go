@@ -12,6 +12,9 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -101,10 +104,23 @@ type APIServer struct {
 	addr        string
 	profileAddr string
 	handler     *handlerWrapper
+	accessLog   bool
+	metrics     *HTTPServerMetrics
+
+	tracerProvider trace.TracerProvider
 
 	Mux    *http.ServeMux
 	Health *HealthServer
 	CORS   *CORSOptions
+
+	// Restarter is used to create the listeners for the API and health
+	// servers if set, which makes them survive in-place restarts.
+	// ListenAndServe calls Restarter.Ready once the listeners have been
+	// created.
+	Restarter *Restarter
+	// DynamicConfig is used to override the CORS options if set. The
+	// watcher is run together with the server.
+	DynamicConfig *ConfigWatcher
 }
 
 func (s *APIServer) Addr() string {
@@ -145,13 +161,59 @@ func (s *APIServer) RegisterAPI(
 	}))
 }
 
+// EnableAccessLog enables access logging and HTTP server metrics for the API
+// server. The access log entries and metrics include the Twirp service and
+// method when logging hooks have been added to the service options.
+func (s *APIServer) EnableAccessLog(reg prometheus.Registerer) error {
+	metrics, err := NewHTTPServerMetrics(reg)
+	if err != nil {
+		return fmt.Errorf("set up HTTP server metrics: %w", err)
+	}
+
+	s.accessLog = true
+	s.metrics = metrics
+
+	return nil
+}
+
+// EnableTracing makes the API server create a span for every HTTP request,
+// continuing traces that are propagated by the client in the W3C traceparent
+// header. The spans are created by the global tracer provider if tp is nil.
+// Use ServiceOptions.AddTracingHooks to get spans for the Twirp RPCs as well.
+func (s *APIServer) EnableTracing(tp trace.TracerProvider) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	s.tracerProvider = tp
+}
+
 func (s *APIServer) ListenAndServe(ctx context.Context) error {
 	var handler http.Handler = s.Mux
 
-	if s.CORS != nil {
+	switch {
+	case s.DynamicConfig != nil:
+		handler = s.DynamicConfig.CORSMiddleware(s.CORS, s.Mux)
+	case s.CORS != nil:
 		handler = CORSMiddleware(*s.CORS, s.Mux)
 	}
 
+	if s.accessLog {
+		handler = AccessLogMiddleware(s.logger, s.metrics, handler)
+	}
+
+	if s.tracerProvider != nil {
+		handler = otelhttp.NewHandler(handler, "api",
+			otelhttp.WithTracerProvider(s.tracerProvider),
+			otelhttp.WithPropagators(tracePropagator()),
+			otelhttp.WithSpanNameFormatter(func(
+				_ string, r *http.Request,
+			) string {
+				return r.Method + " " + r.URL.Path
+			}),
+		)
+	}
+
 	var loggingHandler http.HandlerFunc = func(w http.ResponseWriter, r *http.Request) {
 		ctx := WithLogMetadata(r.Context())
 
@@ -171,13 +233,57 @@ func (s *APIServer) ListenAndServe(ctx context.Context) error {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	serveAPI := func(ctx context.Context) error {
+		return ListenAndServeContext(ctx, &server, 10*time.Second)
+	}
+
+	serveHealth := s.Health.ListenAndServe
+
+	if s.Restarter != nil {
+		apiLn, err := s.Restarter.Listen(s.addr)
+		if err != nil {
+			return fmt.Errorf("create API listener: %w", err)
+		}
+
+		healthLn, err := s.Restarter.Listen(s.profileAddr)
+		if err != nil {
+			_ = apiLn.Close()
+
+			return fmt.Errorf("create health listener: %w", err)
+		}
+
+		// Connections queue up in the listen backlog until we start
+		// serving, so the parent can start draining now.
+		err = s.Restarter.Ready()
+		if err != nil {
+			_ = apiLn.Close()
+			_ = healthLn.Close()
+
+			return fmt.Errorf("signal restart readiness: %w", err)
+		}
+
+		serveAPI = func(ctx context.Context) error {
+			return ServeContext(ctx, &server, apiLn, 10*time.Second)
+		}
+
+		serveHealth = func(ctx context.Context) error {
+			return s.Health.Serve(ctx, healthLn)
+		}
+	}
+
 	grp, gCtx := errgroup.WithContext(ctx)
 
+	if s.DynamicConfig != nil {
+		grp.Go(func() error {
+			return s.DynamicConfig.Run(gCtx)
+		})
+	}
+
 	grp.Go(func() error {
 		s.logger.Info("starting health server",
 			"addr", s.profileAddr)
 
-		err := s.Health.ListenAndServe(gCtx)
+		err := serveHealth(gCtx)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("health server error: %w", err)
 		}
@@ -191,7 +297,7 @@ func (s *APIServer) ListenAndServe(ctx context.Context) error {
 		s.logger.Info("starting API server",
 			"addr", s.addr)
 
-		err := ListenAndServeContext(ctx, &server, 10*time.Second)
+		err := serveAPI(ctx)
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			return fmt.Errorf("API server error: %w", err)
 		}
@@ -220,7 +326,8 @@ const (
 
 // NewDefaultServiceOptions sets up the standard options for our Twirp
 // services. This sets up authentication, logging and metrics. Apply the options
-// to your Twirp servers using the ServerOptions() method.
+// to your Twirp servers using the ServerOptions() method. The auth failure
+// metrics are available as ServiceOptions.AuthFailures.
 func NewDefaultServiceOptions(
 	logger *slog.Logger,
 	parser AuthInfoParser,
@@ -239,15 +346,28 @@ func NewDefaultServiceOptions(
 		return ServiceOptions{}, fmt.Errorf("set up metrics: %w", err)
 	}
 
+	authFailures, err := NewAuthFailureMetrics(reg)
+	if err != nil {
+		return ServiceOptions{}, fmt.Errorf("set up auth failure metrics: %w", err)
+	}
+
+	so.AddAuthFailureMetrics(authFailures)
+
 	return so, nil
 }
 
 type ServiceOptions struct {
 	Hooks          *twirp.ServerHooks
+	Interceptors   []twirp.Interceptor
 	AuthMiddleware func(
 		w http.ResponseWriter, r *http.Request, next http.Handler,
 	) error
 
+	// AuthFailures are the metrics used to count rejected requests, set
+	// by AddAuthFailureMetrics. Share them with HTTPAuthOptions.Metrics,
+	// as the metrics can only be registered once.
+	AuthFailures *AuthFailureMetrics
+
 	// JSONSkipDefaults configures JSON serialization to skip unpopulated or
 	// default values in JSON responses, which results in smaller responses
 	// that are easier to read if your messages contain lots of fields that
@@ -261,6 +381,7 @@ func (so *ServiceOptions) ServerOptions() twirp.ServerOption {
 	return func(opts *twirp.ServerOptions) {
 		twirp.WithServerJSONSkipDefaults(so.JSONSkipDefaults)(opts)
 		twirp.WithServerHooks(so.Hooks)(opts)
+		twirp.WithServerInterceptors(so.Interceptors...)(opts)
 	}
 }
 
@@ -270,6 +391,13 @@ func (so *ServiceOptions) AddLoggingHooks(
 	so.Hooks = twirp.ChainHooks(LoggingHooks(logger), so.Hooks)
 }
 
+// AddTracingHooks adds hooks that create a span for every RPC, see
+// TracingHooks. The spans are created by the global tracer provider if tp is
+// nil.
+func (so *ServiceOptions) AddTracingHooks(tp trace.TracerProvider) {
+	so.Hooks = twirp.ChainHooks(TracingHooks(tp), so.Hooks)
+}
+
 func (so *ServiceOptions) AddMetricsHooks(reg prometheus.Registerer) error {
 	hooks, err := NewTwirpMetricsHooks(WithTwirpMetricsRegisterer(reg))
 	if err != nil {
@@ -294,6 +422,10 @@ func (so *ServiceOptions) SetAuthInfoValidation(
 			},
 		)
 
+		ctx = withRequestIfMatch(ctx, r.Header.Values(IfMatchHeader))
+		ctx = withImpersonateSubject(ctx,
+			r.Header.Get(ImpersonateSubjectHeader))
+
 		next.ServeHTTP(w, r.WithContext(ctx))
 
 		return nil
@@ -310,12 +442,14 @@ func (so *ServiceOptions) SetAuthInfoValidation(
 			auth, err := parser.AuthInfoFromHeader(headers.Get("Authorization"))
 			if errors.Is(err, ErrNoAuthorization) {
 				if requireAuth {
-					return ctx, twirp.Unauthenticated.Error(
-						"authentication required")
+					return withAuthFailure(ctx, AuthFailureMissing),
+						twirp.Unauthenticated.Error(
+							"authentication required")
 				}
 			} else if err != nil {
-				return ctx, twirp.PermissionDenied.Errorf(
-					"invalid authorization: %v", err)
+				return withAuthFailure(ctx, AuthFailureReason(err)),
+					twirp.PermissionDenied.Errorf(
+						"invalid authorization: %v", err)
 			} else if auth == nil {
 				return ctx, twirp.InternalError(
 					"invalid auth info parser response")
@@ -327,6 +461,101 @@ func (so *ServiceOptions) SetAuthInfoValidation(
 				SetLogMetadata(ctx,
 					LogKeySubject, auth.Claims.Subject,
 				)
+
+				if auth.Actor != "" {
+					SetLogMetadata(ctx, LogKeyActor, auth.Actor)
+				}
+			}
+
+			return ctx, nil
+		},
+	}
+
+	if so.Hooks != nil {
+		so.Hooks = twirp.ChainHooks(so.Hooks, &hooks)
+	} else {
+		so.Hooks = &hooks
+	}
+}
+
+// UnknownMethodPolicy controls how calls to methods that are missing from a
+// method scope map are treated.
+type UnknownMethodPolicy bool
+
+const (
+	// UnknownMethodDeny respond with a Twirp PermissionDenied error for
+	// calls to methods that are missing from the scope map.
+	UnknownMethodDeny UnknownMethodPolicy = false
+	// UnknownMethodAllow let calls to methods that are missing from the
+	// scope map through to the service implementation.
+	UnknownMethodAllow UnknownMethodPolicy = true
+)
+
+// SetMethodScopes enforces scope requirements per Twirp method. The scopes map
+// is keyed by method name, or "Service/Method" for when the options are shared
+// between services with overlapping method names. A call is allowed if the
+// client has any of the listed scopes, an empty list allows all callers.
+//
+// The check runs in the RequestRouted hook and must be set after
+// SetAuthInfoValidation so that the auth info is available.
+func (so *ServiceOptions) SetMethodScopes(
+	scopes map[string][]string, unknown UnknownMethodPolicy,
+) {
+	so.setMethodScopes(func() map[string][]string {
+		return scopes
+	}, unknown)
+}
+
+// SetDynamicMethodScopes works like SetMethodScopes, but reads the scope map
+// from the active configuration of the watcher for every call. Fails if the
+// active configuration has no method scopes, and makes the watcher reject
+// configurations without method scopes from then on.
+func (so *ServiceOptions) SetDynamicMethodScopes(
+	watcher *ConfigWatcher, unknown UnknownMethodPolicy,
+) error {
+	err := watcher.requireMethodScopes()
+	if err != nil {
+		return fmt.Errorf("use dynamic method scopes: %w", err)
+	}
+
+	so.setMethodScopes(func() map[string][]string {
+		return watcher.Config().MethodScopes
+	}, unknown)
+
+	return nil
+}
+
+func (so *ServiceOptions) setMethodScopes(
+	getScopes func() map[string][]string, unknown UnknownMethodPolicy,
+) {
+	hooks := twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			scopes := getScopes()
+
+			method, _ := twirp.MethodName(ctx)
+			service, _ := twirp.ServiceName(ctx)
+
+			required, ok := scopes[service+"/"+method]
+			if !ok {
+				required, ok = scopes[method]
+			}
+
+			switch {
+			case !ok && unknown == UnknownMethodAllow:
+				return ctx, nil
+			case !ok:
+				return withAuthFailure(ctx, AuthFailureInsufficientScope),
+					twirp.PermissionDenied.Errorf(
+						"no access policy for the method %q", method)
+			case len(required) == 0:
+				return ctx, nil
+			}
+
+			_, err := RequireAnyScope(ctx, required...)
+			if IsTwirpErrorCode(err, twirp.Unauthenticated) {
+				return withAuthFailure(ctx, AuthFailureMissing), err
+			} else if err != nil {
+				return withAuthFailure(ctx, AuthFailureInsufficientScope), err
 			}
 
 			return ctx, nil
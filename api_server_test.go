@@ -0,0 +1,62 @@
+package elephantine_test
+
+import (
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+)
+
+func TestMethodScopes(t *testing.T) {
+	scopes := map[string][]string{
+		"GetDocument":       {"doc_read", "doc_admin"},
+		"Documents/Update":  {"doc_write"},
+		"Schemas/Update":    {"schema_admin"},
+		"GetStatusOverview": {},
+	}
+
+	call := func(
+		policy elephantine.UnknownMethodPolicy,
+		service string, method string, scope string,
+	) error {
+		var so elephantine.ServiceOptions
+
+		so.SetMethodScopes(scopes, policy)
+
+		ctx := ctxsetters.WithServiceName(test.Context(t), service)
+		ctx = ctxsetters.WithMethodName(ctx, method)
+
+		if scope != "" {
+			ctx = elephantine.SetAuthInfo(ctx, &elephantine.AuthInfo{
+				Claims: elephantine.JWTClaims{Scope: scope},
+			})
+		}
+
+		_, err := so.Hooks.RequestRouted(ctx)
+
+		return err //nolint:wrapcheck
+	}
+
+	deny := elephantine.UnknownMethodDeny
+	allow := elephantine.UnknownMethodAllow
+
+	test.Must(t, call(deny, "Documents", "GetDocument", "doc_admin"),
+		"allow call with one of the scopes")
+	test.Must(t, call(deny, "Documents", "Update", "doc_write"),
+		"allow call matching service qualified method")
+	test.Must(t, call(deny, "Documents", "GetStatusOverview", ""),
+		"allow call to method without scope requirements")
+	test.Must(t, call(allow, "Documents", "Delete", "doc_read"),
+		"allow call to unknown method")
+
+	test.IsTwirpError(t, call(deny, "Schemas", "Update", "doc_write"),
+		twirp.PermissionDenied)
+	test.IsTwirpError(t, call(deny, "Documents", "GetDocument", "doc_write"),
+		twirp.PermissionDenied)
+	test.IsTwirpError(t, call(deny, "Documents", "GetDocument", ""),
+		twirp.Unauthenticated)
+	test.IsTwirpError(t, call(deny, "Documents", "Delete", "doc_admin"),
+		twirp.PermissionDenied)
+}
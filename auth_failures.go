@@ -0,0 +1,146 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twitchtv/twirp"
+)
+
+// Auth failure reasons used as the "reason" label of auth_failures_total.
+const (
+	AuthFailureMissing           = "missing"
+	AuthFailureExpired           = "expired"
+	AuthFailureBadSignature      = "bad_signature"
+	AuthFailureWrongAudience     = "wrong_audience"
+	AuthFailureInsufficientScope = "insufficient_scope"
+	AuthFailureRevoked           = "revoked"
+	AuthFailurePolicy            = "policy"
+	AuthFailureInvalid           = "invalid"
+)
+
+// AuthFailureReason classifies an error returned by an AuthInfoParser.
+func AuthFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNoAuthorization):
+		return AuthFailureMissing
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return AuthFailureExpired
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return AuthFailureBadSignature
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return AuthFailureWrongAudience
+	case errors.Is(err, ErrTokenRevoked):
+		return AuthFailureRevoked
+	case errors.Is(err, ErrTokenPolicy):
+		return AuthFailurePolicy
+	default:
+		return AuthFailureInvalid
+	}
+}
+
+// AuthFailureMetrics counts rejected requests by reason.
+type AuthFailureMetrics struct {
+	failures *prometheus.CounterVec
+}
+
+// NewAuthFailureMetrics registers the auth failure metrics with the provided
+// registerer.
+func NewAuthFailureMetrics(
+	registerer prometheus.Registerer,
+) (*AuthFailureMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := AuthFailureMetrics{
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "Number of requests that were rejected because of missing or invalid authorization.",
+		}, []string{"reason"}),
+	}
+
+	err := registerer.Register(m.failures)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+// Failed increments the failure count for the given reason. Safe to call on a
+// nil AuthFailureMetrics.
+func (m *AuthFailureMetrics) Failed(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.failures.WithLabelValues(reason).Inc()
+}
+
+type authFailureCtxKey struct{}
+
+type authFailureMetricsCtxKey struct{}
+
+// withAuthFailureMetrics adds the metrics to the context, so that handlers
+// behind the HTTP auth middleware can count their denials.
+func withAuthFailureMetrics(
+	ctx context.Context, metrics *AuthFailureMetrics,
+) context.Context {
+	if metrics == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, authFailureMetricsCtxKey{}, metrics)
+}
+
+// authFailed counts a failure with the metrics in the context, if any.
+func authFailed(ctx context.Context, reason string) {
+	metrics, _ := ctx.Value(authFailureMetricsCtxKey{}).(*AuthFailureMetrics)
+
+	metrics.Failed(reason)
+}
+
+// withAuthFailure marks the context with the reason that a request was
+// rejected, so that the error hook can report it.
+func withAuthFailure(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, authFailureCtxKey{}, reason)
+}
+
+// errInsufficientScope is wrapped by the permission errors of the Require*Scope
+// helpers so that the error hook can tell them apart from other denials.
+var errInsufficientScope = errors.New("insufficient scope")
+
+// AddAuthFailureMetrics counts requests that were rejected by
+// SetAuthInfoValidation or SetMethodScopes. Errors from RequireAnyScope,
+// RequireAllScopes, and RequireScopes in the service implementation are counted
+// as missing authorization or insufficient scope, other unauthenticated errors
+// as missing authorization. Pass the same
+// metrics to HTTPAuthOptions.Metrics to count failures of plain HTTP handlers
+// as well.
+func (so *ServiceOptions) AddAuthFailureMetrics(metrics *AuthFailureMetrics) {
+	so.AuthFailures = metrics
+
+	hooks := twirp.ServerHooks{
+		Error: func(ctx context.Context, err twirp.Error) context.Context {
+			reason, ok := ctx.Value(authFailureCtxKey{}).(string)
+
+			switch {
+			case ok:
+				metrics.Failed(reason)
+			case errors.Is(err, errInsufficientScope):
+				metrics.Failed(AuthFailureInsufficientScope)
+			case err.Code() == twirp.Unauthenticated:
+				metrics.Failed(AuthFailureMissing)
+			}
+
+			return ctx
+		},
+	}
+
+	so.Hooks = twirp.ChainHooks(so.Hooks, &hooks)
+}
@@ -0,0 +1,260 @@
+package elephantine_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+func TestAuthFailureMetrics(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create other signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(
+		jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+			Audience: "repository",
+		})
+
+	sign := func(key *ecdsa.PrivateKey, claims jwt.RegisteredClaims) string {
+		claims.Subject = "someone"
+
+		token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+			RegisteredClaims: claims,
+		})
+
+		ss, err := token.SignedString(key)
+		test.Must(t, err, "sign JWT token")
+
+		return "Bearer " + ss
+	}
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewAuthFailureMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	mw := elephantine.NewHTTPAuthMiddleware(parser, elephantine.HTTPAuthOptions{
+		Required: true,
+		Metrics:  metrics,
+	})
+
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	requests := []string{
+		"",
+		sign(jwtKey, jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{"repository"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		}),
+		sign(otherKey, jwt.RegisteredClaims{
+			Audience: jwt.ClaimStrings{"repository"},
+		}),
+		sign(jwtKey, jwt.RegisteredClaims{
+			Audience: jwt.ClaimStrings{"index"},
+		}),
+		"Bearer nope",
+		sign(jwtKey, jwt.RegisteredClaims{
+			Audience: jwt.ClaimStrings{"repository"},
+		}),
+	}
+
+	for _, authorization := range requests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expected := `
+# HELP auth_failures_total Number of requests that were rejected because of missing or invalid authorization.
+# TYPE auth_failures_total counter
+auth_failures_total{reason="bad_signature"} 1
+auth_failures_total{reason="expired"} 1
+auth_failures_total{reason="invalid"} 1
+auth_failures_total{reason="missing"} 1
+auth_failures_total{reason="wrong_audience"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"auth_failures_total")
+	test.Must(t, err, "count failures by reason")
+}
+
+func TestAuthFailureReason(t *testing.T) {
+	missingExp := fmt.Errorf("%w: exp", jwt.ErrTokenRequiredClaimMissing)
+
+	test.Equal(t, elephantine.AuthFailureInvalid,
+		elephantine.AuthFailureReason(missingExp),
+		"report missing claims other than aud as invalid")
+	test.Equal(t, elephantine.AuthFailureWrongAudience,
+		elephantine.AuthFailureReason(jwt.ErrTokenInvalidAudience),
+		"report wrong audience")
+	test.Equal(t, elephantine.AuthFailureInvalid,
+		elephantine.AuthFailureReason(errors.New("garbage")),
+		"report unknown errors as invalid")
+}
+
+func TestAddAuthFailureMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewAuthFailureMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	var so elephantine.ServiceOptions
+
+	so.AddAuthFailureMetrics(metrics)
+
+	ctx := elephantine.SetAuthInfo(test.Context(t), &elephantine.AuthInfo{
+		Claims: elephantine.JWTClaims{Scope: "doc_read"},
+	})
+
+	expr, err := elephantine.ParseScopeExpression("doc_write || doc_admin")
+	test.Must(t, err, "parse scope expression")
+
+	require := map[string]func(ctx context.Context) error{
+		"RequireAnyScope": func(ctx context.Context) error {
+			_, err := elephantine.RequireAnyScope(ctx, "doc_write")
+
+			return err
+		},
+		"RequireAllScopes": func(ctx context.Context) error {
+			_, err := elephantine.RequireAllScopes(ctx, "doc_read", "doc_write")
+
+			return err
+		},
+		"RequireScopes": func(ctx context.Context) error {
+			_, err := elephantine.RequireScopes(ctx, expr)
+
+			return err
+		},
+	}
+
+	for name, fn := range require {
+		var twErr twirp.Error
+
+		err := fn(ctx)
+		if !errors.As(err, &twErr) {
+			t.Fatalf("expected a twirp error from %s, got %v", name, err)
+		}
+
+		so.Hooks.Error(ctx, twErr)
+	}
+
+	// Other permission errors from the service aren't auth failures.
+	so.Hooks.Error(ctx, twirp.PermissionDenied.Error("document is locked"))
+
+	var twErr twirp.Error
+
+	_, err = elephantine.RequireAnyScope(test.Context(t), "doc_write")
+	if !errors.As(err, &twErr) {
+		t.Fatalf("expected a twirp error, got %v", err)
+	}
+
+	so.Hooks.Error(ctx, twErr)
+
+	expected := `
+# HELP auth_failures_total Number of requests that were rejected because of missing or invalid authorization.
+# TYPE auth_failures_total counter
+auth_failures_total{reason="insufficient_scope"} 3
+auth_failures_total{reason="missing"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"auth_failures_total")
+	test.Must(t, err, "count failures by reason")
+}
+
+func TestAuthFailureMetricsShared(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(
+		jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{})
+
+	reg := prometheus.NewRegistry()
+
+	so, err := elephantine.NewDefaultServiceOptions(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		parser, reg, elephantine.ServiceAuthRequired)
+	test.Must(t, err, "create service options")
+
+	mw := elephantine.NewHTTPAuthMiddleware(parser, elephantine.HTTPAuthOptions{
+		Required: true,
+		Metrics:  so.AuthFailures,
+	})
+
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/", nil))
+
+	scoped := mw(elephantine.HTTPErrorHandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) error {
+		_, err := elephantine.RequireAnyScopeHTTP(r.Context(), "doc_write")
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "someone"},
+		Scope:            "doc_read",
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	req.Header.Set("Authorization", "Bearer "+ss)
+
+	rec := httptest.NewRecorder()
+
+	scoped.ServeHTTP(rec, req)
+
+	test.Equal(t, http.StatusForbidden, rec.Code,
+		"deny requests without the required scope")
+
+	expected := `
+# HELP auth_failures_total Number of requests that were rejected because of missing or invalid authorization.
+# TYPE auth_failures_total counter
+auth_failures_total{reason="insufficient_scope"} 1
+auth_failures_total{reason="missing"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"auth_failures_total")
+	test.Must(t, err, "count HTTP failures with the service metrics")
+}
@@ -0,0 +1,68 @@
+package elephantine
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthForwardingTransport is a http.RoundTripper that forwards the credentials
+// of the AuthInfo in the request context, falling back to a token source for
+// requests made without an authenticated client. Requests that already have an
+// Authorization header are left as-is.
+type AuthForwardingTransport struct {
+	// Base is the underlying transport, defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+	// Fallback is used to get a token when the request context lacks
+	// AuthInfo. Requests are sent without authorization if nil.
+	Fallback oauth2.TokenSource
+}
+
+// NewAuthForwardingTransport creates a new AuthForwardingTransport.
+func NewAuthForwardingTransport(
+	base http.RoundTripper, fallback oauth2.TokenSource,
+) *AuthForwardingTransport {
+	return &AuthForwardingTransport{
+		Base:     base,
+		Fallback: fallback,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *AuthForwardingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if req.Header.Get("Authorization") != "" {
+		return base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	var authorization string
+
+	auth, ok := GetAuthInfo(req.Context())
+
+	switch {
+	case ok && auth.Token != "":
+		authorization = "Bearer " + auth.Token
+	case t.Fallback != nil:
+		token, err := t.Fallback.Token()
+		if err != nil {
+			return nil, fmt.Errorf("get fallback token: %w", err)
+		}
+
+		authorization = token.Type() + " " + token.AccessToken
+	default:
+		return base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	// RoundTrippers must not modify the original request.
+	r := req.Clone(req.Context())
+
+	r.Header.Set("Authorization", authorization)
+
+	return base.RoundTrip(r) //nolint:wrapcheck
+}
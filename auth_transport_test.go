@@ -0,0 +1,68 @@
+package elephantine_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"golang.org/x/oauth2"
+)
+
+func TestAuthForwardingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		_, _ = fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := http.Client{
+		Transport: elephantine.NewAuthForwardingTransport(nil,
+			oauth2.StaticTokenSource(&oauth2.Token{
+				AccessToken: "service-token",
+			})),
+	}
+
+	call := func(t *testing.T, req *http.Request) string {
+		t.Helper()
+
+		res, err := client.Do(req)
+		test.Must(t, err, "perform request")
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		test.Must(t, err, "read response")
+
+		return string(body)
+	}
+
+	ctx := elephantine.SetAuthInfo(test.Context(t), &elephantine.AuthInfo{
+		Token: "user-token",
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	test.Must(t, err, "create request")
+
+	test.Equal(t, "Bearer user-token", call(t, req),
+		"forward the end-user token")
+	test.Equal(t, "", req.Header.Get("Authorization"),
+		"leave the original request unmodified")
+
+	req, err = http.NewRequestWithContext(test.Context(t),
+		http.MethodGet, server.URL, nil)
+	test.Must(t, err, "create request")
+
+	test.Equal(t, "Bearer service-token", call(t, req),
+		"fall back to the token source")
+
+	req.Header.Set("Authorization", "Bearer explicit")
+
+	test.Equal(t, "Bearer explicit", call(t, req),
+		"keep an explicit authorization header")
+}
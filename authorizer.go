@@ -0,0 +1,296 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/twitchtv/twirp"
+)
+
+// DefaultAuthorizationCacheTTL is the default time that authorization
+// decisions are cached for.
+const DefaultAuthorizationCacheTTL = 30 * time.Second
+
+// AuthorizationInput describes a call that needs an authorization decision.
+type AuthorizationInput struct {
+	Subject string   `json:"subject"`
+	Actor   string   `json:"actor,omitempty"`
+	Scopes  []string `json:"scopes"`
+	Units   []string `json:"units"`
+	Service string   `json:"service"`
+	Method  string   `json:"method"`
+	// Resource contains attributes of the resource that the call acts
+	// on, see AuthorizerOptions.Resource.
+	Resource map[string]string `json:"resource,omitempty"`
+}
+
+// AuthorizationDecision is the result of an authorization check.
+type AuthorizationDecision struct {
+	Allow bool `json:"allow"`
+	// Reason is an optional explanation that is passed on to the client
+	// when the call is denied.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Authorizer makes authorization decisions, f.ex. by consulting an external
+// policy decision point.
+type Authorizer interface {
+	Authorize(
+		ctx context.Context, input AuthorizationInput,
+	) (AuthorizationDecision, error)
+}
+
+// HTTPAuthorizerOptions controls the behaviour of a HTTP authorizer.
+type HTTPAuthorizerOptions struct {
+	// Client is used to make requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Timeout for decision requests. Defaults to 5s.
+	Timeout time.Duration
+}
+
+// HTTPAuthorizer asks a policy decision point for decisions over HTTP. The
+// request and response formats are compatible with the Open Policy Agent data
+// API: the input is posted as {"input": {...}}, and the response is expected
+// to be either {"result": true} or {"result": {"allow": true, "reason": ""}}.
+type HTTPAuthorizer struct {
+	url  string
+	opts HTTPAuthorizerOptions
+}
+
+// NewHTTPAuthorizer creates an authorizer that posts decision requests to the
+// URL, f.ex. "http://localhost:8181/v1/data/elephant/authz".
+func NewHTTPAuthorizer(url string, opts HTTPAuthorizerOptions) *HTTPAuthorizer {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	return &HTTPAuthorizer{
+		url:  url,
+		opts: opts,
+	}
+}
+
+// Authorize implements Authorizer.
+func (a *HTTPAuthorizer) Authorize(
+	ctx context.Context, input AuthorizationInput,
+) (AuthorizationDecision, error) {
+	body, err := json.Marshal(map[string]any{
+		"input": input,
+	})
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"marshal decision request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := a.opts.Client.Do(req)
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"perform request: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"policy decision point responded with: %q", res.Status)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&result)
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"parse decision response: %w", err)
+	}
+
+	var decision AuthorizationDecision
+
+	switch {
+	case len(result.Result) == 0 || string(result.Result) == "null":
+		// OPA omits the result when the policy is undefined.
+		return AuthorizationDecision{
+			Reason: "no policy decision",
+		}, nil
+	case bytes.HasPrefix(result.Result, []byte("{")):
+		err = json.Unmarshal(result.Result, &decision)
+	default:
+		err = json.Unmarshal(result.Result, &decision.Allow)
+	}
+
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"parse decision: %w", err)
+	}
+
+	return decision, nil
+}
+
+// AuthorizerOptions controls how an authorizer is used by
+// ServiceOptions.SetAuthorizer.
+type AuthorizerOptions struct {
+	// Methods limits authorization checks to the listed methods, given as
+	// method names or "Service/Method". All methods are checked if empty.
+	Methods []string
+	// Resource extracts resource attributes from the request message.
+	Resource func(
+		ctx context.Context, method string, request any,
+	) map[string]string
+	// CacheTTL is how long decisions are cached. Defaults to
+	// DefaultAuthorizationCacheTTL, a negative value disables caching.
+	CacheTTL time.Duration
+	// CacheSize is the maximum number of cached decisions. Defaults to
+	// DefaultAuthInfoCacheSize.
+	CacheSize uint64
+	// Logger is used to log authorizer failures. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// SetAuthorizer delegates authorization decisions to the authorizer, as an
+// alternative or complement to SetMethodScopes. The decision request contains
+// the subject, scopes, and units of the caller, the called method, and
+// resource attributes from the request message. Calls are denied if the
+// authorizer fails.
+//
+// Must be set after SetAuthInfoValidation so that the auth info is available.
+func (so *ServiceOptions) SetAuthorizer(
+	authorizer Authorizer, opts AuthorizerOptions,
+) {
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultAuthorizationCacheTTL
+	}
+
+	if opts.CacheSize == 0 {
+		opts.CacheSize = DefaultAuthInfoCacheSize
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	var cache *ttlcache.Cache[[sha256.Size]byte, AuthorizationDecision]
+
+	if opts.CacheTTL > 0 {
+		cache = ttlcache.New(
+			ttlcache.WithTTL[[sha256.Size]byte, AuthorizationDecision](
+				opts.CacheTTL),
+			ttlcache.WithCapacity[[sha256.Size]byte, AuthorizationDecision](
+				opts.CacheSize),
+		)
+	}
+
+	interceptor := func(next twirp.Method) twirp.Method {
+		return func(ctx context.Context, request any) (any, error) {
+			method, _ := twirp.MethodName(ctx)
+			service, _ := twirp.ServiceName(ctx)
+
+			if len(opts.Methods) > 0 &&
+				!slices.Contains(opts.Methods, method) &&
+				!slices.Contains(opts.Methods, service+"/"+method) {
+				return next(ctx, request)
+			}
+
+			auth, ok := GetAuthInfo(ctx)
+			if !ok {
+				return nil, twirp.Unauthenticated.Error(
+					"no anonymous access allowed")
+			}
+
+			input := AuthorizationInput{
+				Subject: auth.Claims.Subject,
+				Actor:   auth.Actor,
+				Scopes:  strings.Fields(auth.Claims.Scope),
+				Units:   auth.Claims.Units,
+				Service: service,
+				Method:  method,
+			}
+
+			if opts.Resource != nil {
+				input.Resource = opts.Resource(ctx, method, request)
+			}
+
+			decision, err := cachedDecision(ctx, authorizer, cache, input)
+			if err != nil {
+				opts.Logger.ErrorContext(ctx, "authorization check failed",
+					LogKeyError, err,
+					LogKeySubject, input.Subject)
+
+				return nil, twirp.Unavailable.Error(
+					"authorization is temporarily unavailable")
+			}
+
+			if !decision.Allow {
+				msg := "access denied by policy"
+				if decision.Reason != "" {
+					msg += ": " + decision.Reason
+				}
+
+				return nil, twirp.PermissionDenied.Error(msg)
+			}
+
+			return next(ctx, request)
+		}
+	}
+
+	so.Interceptors = append(so.Interceptors, interceptor)
+}
+
+func cachedDecision(
+	ctx context.Context, authorizer Authorizer,
+	cache *ttlcache.Cache[[sha256.Size]byte, AuthorizationDecision],
+	input AuthorizationInput,
+) (AuthorizationDecision, error) {
+	if cache == nil {
+		return authorizer.Authorize(ctx, input) //nolint:wrapcheck
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return AuthorizationDecision{}, fmt.Errorf(
+			"marshal input: %w", err)
+	}
+
+	key := sha256.Sum256(data)
+
+	item := cache.Get(key)
+	if item != nil && !item.IsExpired() {
+		return item.Value(), nil
+	}
+
+	decision, err := authorizer.Authorize(ctx, input)
+	if err != nil {
+		return AuthorizationDecision{}, err //nolint:wrapcheck
+	}
+
+	cache.Set(key, decision, ttlcache.DefaultTTL)
+
+	return decision, nil
+}
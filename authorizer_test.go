@@ -0,0 +1,124 @@
+package elephantine_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+)
+
+func TestHTTPAuthorizer(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		requests.Add(1)
+
+		var body struct {
+			Input elephantine.AuthorizationInput `json:"input"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		in := body.Input
+
+		switch {
+		case in.Resource["document"] == "secret":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"result": map[string]any{
+					"allow":  false,
+					"reason": "classified document",
+				},
+			})
+		case in.Method == "Undefined":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"result": in.Subject == "core://user/editor",
+			})
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	var so elephantine.ServiceOptions
+
+	so.SetAuthorizer(
+		elephantine.NewHTTPAuthorizer(server.URL,
+			elephantine.HTTPAuthorizerOptions{}),
+		elephantine.AuthorizerOptions{
+			Methods: []string{"Documents/Update", "Undefined"},
+			Resource: func(
+				_ context.Context, _ string, request any,
+			) map[string]string {
+				return map[string]string{
+					"document": request.(string),
+				}
+			},
+		})
+
+	call := func(method string, subject string, document string) error {
+		ctx := ctxsetters.WithServiceName(test.Context(t), "Documents")
+		ctx = ctxsetters.WithMethodName(ctx, method)
+
+		if subject != "" {
+			ctx = elephantine.SetAuthInfo(ctx, &elephantine.AuthInfo{
+				Claims: elephantine.JWTClaims{Scope: "doc_write"},
+			})
+
+			auth, _ := elephantine.GetAuthInfo(ctx)
+
+			auth.Claims.Subject = subject
+		}
+
+		var handler twirp.Method = func(
+			_ context.Context, _ any,
+		) (any, error) {
+			return "ok", nil
+		}
+
+		for _, i := range so.Interceptors {
+			handler = i(handler)
+		}
+
+		_, err := handler(ctx, document)
+
+		return err //nolint:wrapcheck
+	}
+
+	test.Must(t, call("Update", "core://user/editor", "a"),
+		"allow the editor")
+	test.Must(t, call("Update", "core://user/editor", "a"),
+		"allow the editor again")
+	test.Equal(t, 1, int(requests.Load()), "cache the decision")
+
+	test.Must(t, call("Get", "core://user/reader", "a"),
+		"not check methods that aren't listed")
+
+	test.IsTwirpError(t, call("Update", "core://user/reader", "a"),
+		twirp.PermissionDenied)
+	test.IsTwirpError(t, call("Update", "core://user/editor", "secret"),
+		twirp.PermissionDenied)
+	test.IsTwirpError(t, call("Undefined", "core://user/editor", "a"),
+		twirp.PermissionDenied)
+	test.IsTwirpError(t, call("Update", "", "a"),
+		twirp.Unauthenticated)
+
+	server.Close()
+
+	test.IsTwirpError(t, call("Update", "core://user/editor", "b"),
+		twirp.Unavailable)
+}
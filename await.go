@@ -0,0 +1,119 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Defaults used by AwaitDependency.
+const (
+	DefaultAwaitTimeout        = 5 * time.Minute
+	DefaultAwaitAttemptTimeout = 10 * time.Second
+)
+
+// AwaitPolicy controls how long and how often AwaitDependency checks a
+// dependency.
+type AwaitPolicy struct {
+	// Timeout is the total time to wait for the dependency. Defaults to
+	// DefaultAwaitTimeout.
+	Timeout time.Duration
+	// AttemptTimeout is the timeout for every check. Defaults to
+	// DefaultAwaitAttemptTimeout.
+	AttemptTimeout time.Duration
+	// Backoff controls the delay between checks. Defaults to an
+	// exponential backoff from 1s to 30s.
+	Backoff BackoffFunction
+	// Logger is used to log failed checks. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// ExponentialBackoff returns a backoff function that doubles the delay for
+// every retry, starting at initial, up to limit.
+func ExponentialBackoff(initial time.Duration, limit time.Duration) BackoffFunction {
+	return func(retry int) time.Duration {
+		wait := initial
+
+		for i := 1; i < retry && wait < limit; i++ {
+			wait *= 2
+		}
+
+		return min(wait, limit)
+	}
+}
+
+// AwaitDependency blocks until the check passes, the timeout expires, or the
+// context is cancelled. Use it at startup to wait for dependencies like the
+// database, instead of crashing and relying on restarts:
+//
+//	err := elephantine.AwaitDependency(ctx, "postgres",
+//		func(ctx context.Context) error {
+//			return pool.Ping(ctx)
+//		}, elephantine.AwaitPolicy{})
+func AwaitDependency(
+	ctx context.Context, name string, check ReadyFunc, policy AwaitPolicy,
+) error {
+	if policy.Timeout == 0 {
+		policy.Timeout = DefaultAwaitTimeout
+	}
+
+	if policy.AttemptTimeout == 0 {
+		policy.AttemptTimeout = DefaultAwaitAttemptTimeout
+	}
+
+	if policy.Backoff == nil {
+		policy.Backoff = ExponentialBackoff(time.Second, 30*time.Second)
+	}
+
+	if policy.Logger == nil {
+		policy.Logger = slog.Default()
+	}
+
+	start := time.Now()
+	deadline := time.After(policy.Timeout)
+
+	var tries int
+
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, policy.AttemptTimeout)
+		err := check(attemptCtx)
+
+		cancel()
+
+		if err == nil {
+			if tries > 0 {
+				policy.Logger.InfoContext(ctx, "dependency is ready",
+					LogKeyName, name,
+					LogKeyAttempts, tries+1,
+					LogKeyDelay, slog.DurationValue(
+						time.Since(start).Round(time.Millisecond)))
+			}
+
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("wait for %s: %w", name, ctx.Err())
+		}
+
+		tries++
+
+		wait := policy.Backoff(tries)
+
+		policy.Logger.WarnContext(ctx, "waiting for dependency",
+			LogKeyName, name,
+			LogKeyError, err,
+			LogKeyAttempts, tries,
+			LogKeyDelay, slog.DurationValue(wait))
+
+		select {
+		case <-time.After(wait):
+		case <-deadline:
+			return fmt.Errorf("%s was not ready after %s: %w",
+				name, policy.Timeout, err)
+		case <-ctx.Done():
+			return fmt.Errorf("wait for %s: %w", name, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestAwaitDependency(t *testing.T) {
+	ctx := test.Context(t)
+
+	policy := elephantine.AwaitPolicy{
+		Timeout: time.Second,
+		Backoff: elephantine.StaticBackoff(10 * time.Millisecond),
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	var calls int
+
+	err := elephantine.AwaitDependency(ctx, "flaky",
+		func(_ context.Context) error {
+			calls++
+
+			if calls < 3 {
+				return errors.New("not yet")
+			}
+
+			return nil
+		}, policy)
+	test.Must(t, err, "wait for dependency to become ready")
+
+	test.Equal(t, 3, calls, "check until the dependency is ready")
+
+	policy.Timeout = 50 * time.Millisecond
+
+	err = elephantine.AwaitDependency(ctx, "down",
+		func(_ context.Context) error {
+			return errors.New("connection refused")
+		}, policy)
+	test.MustNot(t, err, "time out waiting for dependency")
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := elephantine.ExponentialBackoff(time.Second, 5*time.Second)
+
+	var got []time.Duration
+
+	for i := 1; i <= 5; i++ {
+		got = append(got, backoff(i))
+	}
+
+	test.EqualDiff(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second,
+	}, got, "double the delay up to the limit")
+}
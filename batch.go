@@ -0,0 +1,312 @@
+package elephantine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/twitchtv/twirp"
+)
+
+// BatchItem is the result of processing a single item of a batch request.
+type BatchItem struct {
+	// Index is the position of the item in the request.
+	Index int `json:"index"`
+	// ID is an optional identifier of the item, like a document UUID.
+	ID string `json:"id,omitempty"`
+	// Status is the HTTP status of the item.
+	Status int `json:"status"`
+	// Code is the Twirp error code for failed items.
+	Code string `json:"code,omitempty"`
+	// Error is the error message for failed items.
+	Error string `json:"error,omitempty"`
+}
+
+// OK returns true if the item was processed successfully.
+func (i BatchItem) OK() bool {
+	return i.Status >= 200 && i.Status < 300
+}
+
+// Retryable returns true if the item failed in a way that could succeed if
+// retried, like unavailability or rate limiting.
+func (i BatchItem) Retryable() bool {
+	if i.OK() {
+		return false
+	}
+
+	switch twirp.ErrorCode(i.Code) { //nolint:exhaustive
+	case twirp.Unavailable, twirp.ResourceExhausted,
+		twirp.DeadlineExceeded, twirp.Aborted:
+		return true
+	}
+
+	switch i.Status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// BatchResult collects the per-item results of a batch request, so that one
+// bad item doesn't fail the entire batch. Used as the response envelope of bulk
+// HTTP endpoints, and as the source for per-item status lists in Twirp
+// responses.
+type BatchResult struct {
+	Items     []BatchItem `json:"items"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+
+	errs []error
+}
+
+// Record adds the result of processing an item, a nil error means that the
+// item was processed successfully.
+func (r *BatchResult) Record(index int, id string, err error) {
+	item := BatchItem{
+		Index:  index,
+		ID:     id,
+		Status: http.StatusOK,
+	}
+
+	if err == nil {
+		r.Items = append(r.Items, item)
+		r.Succeeded++
+
+		return
+	}
+
+	code, status := batchErrorStatus(err)
+
+	item.Status = status
+	item.Code = string(code)
+	item.Error = err.Error()
+
+	r.Items = append(r.Items, item)
+	r.Failed++
+	r.errs = append(r.errs, fmt.Errorf("item %d: %w", index, err))
+}
+
+func batchErrorStatus(err error) (twirp.ErrorCode, int) {
+	var te twirp.Error
+
+	if errors.As(err, &te) {
+		return te.Code(), twirp.ServerHTTPStatusFromErrorCode(te.Code())
+	}
+
+	var he *HTTPError
+
+	if errors.As(err, &he) && he.StatusCode != 0 {
+		return "", he.StatusCode
+	}
+
+	return twirp.Internal, http.StatusInternalServerError
+}
+
+// FailedItems returns the items that failed.
+func (r *BatchResult) FailedItems() []BatchItem {
+	var failed []BatchItem
+
+	for _, item := range r.Items {
+		if !item.OK() {
+			failed = append(failed, item)
+		}
+	}
+
+	return failed
+}
+
+// Err returns a BatchError if any item failed.
+func (r *BatchResult) Err() error {
+	if r.Failed == 0 {
+		return nil
+	}
+
+	return &BatchError{
+		Failed: r.FailedItems(),
+		Total:  len(r.Items),
+		errs:   r.errs,
+	}
+}
+
+// HTTPStatus returns the status that should be used for the batch response.
+// That's 200 if all items succeeded, the status of the failures if all items
+// failed with the same status, and otherwise 207 Multi-Status.
+func (r *BatchResult) HTTPStatus() int {
+	if r.Failed == 0 {
+		return http.StatusOK
+	}
+
+	if r.Succeeded > 0 {
+		return http.StatusMultiStatus
+	}
+
+	status := r.Items[0].Status
+
+	for _, item := range r.Items[1:] {
+		if item.Status != status {
+			return http.StatusMultiStatus
+		}
+	}
+
+	return status
+}
+
+// BatchError describes the failed items of a batch.
+type BatchError struct {
+	Failed []BatchItem
+	Total  int
+
+	errs []error
+}
+
+// Error implements the error interface.
+func (e *BatchError) Error() string {
+	if len(e.Failed) == 0 {
+		return "batch failed"
+	}
+
+	return fmt.Sprintf("%d of %d items failed, first failure: item %d: %s",
+		len(e.Failed), e.Total, e.Failed[0].Index, e.Failed[0].Error)
+}
+
+// Unwrap returns the item errors. Only available on the server side, errors
+// aren't preserved when a result is read from a response.
+func (e *BatchError) Unwrap() []error {
+	return e.errs
+}
+
+// WriteBatchResult writes the batch result as a JSON response using the status
+// from BatchResult.HTTPStatus().
+func WriteBatchResult(w http.ResponseWriter, r *BatchResult) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(r.HTTPStatus())
+
+	err := json.NewEncoder(w).Encode(r)
+	if err != nil {
+		return fmt.Errorf("encode batch result: %w", err)
+	}
+
+	return nil
+}
+
+// ReadBatchResult reads a batch result from a response written by
+// WriteBatchResult. Responses that don't contain a batch result are returned
+// as a HTTPError.
+func ReadBatchResult(res *http.Response) (*BatchResult, error) {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return nil, HTTPErrorFromResponse(res)
+	}
+
+	var result BatchResult
+
+	err := json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("decode batch result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// BatchRetryOptions controls how failed batch items are retried.
+type BatchRetryOptions struct {
+	// Attempts is the maximum number of times a batch is sent, defaults
+	// to 3.
+	Attempts int
+	// Backoff is the delay before the first retry, it's doubled for every
+	// subsequent retry. Defaults to 500ms.
+	Backoff time.Duration
+}
+
+// RetryFailedItems sends a batch of items and then resends the items that
+// failed with retryable errors until they succeed or the attempts run out. The
+// returned result has the indexes of the original items. An error is only
+// returned if a send failed as a whole.
+func RetryFailedItems[T any](
+	ctx context.Context, items []T, opts BatchRetryOptions,
+	send func(ctx context.Context, batch []T) (*BatchResult, error),
+) (*BatchResult, error) {
+	if opts.Attempts == 0 {
+		opts.Attempts = 3
+	}
+
+	if opts.Backoff == 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+
+	final := make([]BatchItem, len(items))
+	pending := make([]int, len(items))
+
+	for i := range items {
+		pending[i] = i
+		final[i] = BatchItem{
+			Index:  i,
+			Status: http.StatusInternalServerError,
+			Code:   string(twirp.Internal),
+			Error:  "no result was returned for the item",
+		}
+	}
+
+	backoff := opts.Backoff
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		batch := make([]T, len(pending))
+
+		for i, idx := range pending {
+			batch[i] = items[idx]
+		}
+
+		res, err := send(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("send batch: %w", err)
+		}
+
+		var retry []int
+
+		for _, item := range res.Items {
+			if item.Index < 0 || item.Index >= len(pending) {
+				return nil, fmt.Errorf(
+					"result for unknown item index %d", item.Index)
+			}
+
+			item.Index = pending[item.Index]
+			final[item.Index] = item
+
+			if item.Retryable() {
+				retry = append(retry, item.Index)
+			}
+		}
+
+		pending = retry
+
+		if len(pending) == 0 || attempt >= opts.Attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err() //nolint:wrapcheck
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+	result := BatchResult{Items: final}
+
+	for _, item := range final {
+		if item.OK() {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,90 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+func TestBatchResult(t *testing.T) {
+	var result elephantine.BatchResult
+
+	result.Record(0, "a", nil)
+	result.Record(1, "b", twirp.InvalidArgument.Error("bad row"))
+
+	test.Equal(t, http.StatusMultiStatus, result.HTTPStatus(),
+		"use 207 for partial failures")
+
+	err := result.Err()
+	test.MustNot(t, err, "report the failed item")
+
+	var batchErr *elephantine.BatchError
+
+	test.Equal(t, true, errors.As(err, &batchErr), "get a batch error")
+	test.Equal(t, 1, len(batchErr.Failed), "get one failed item")
+	test.Equal(t, true, elephantine.IsTwirpErrorCode(err, twirp.InvalidArgument),
+		"preserve the item errors")
+
+	rec := httptest.NewRecorder()
+
+	err = elephantine.WriteBatchResult(rec, &result)
+	test.Must(t, err, "write batch result")
+
+	read, err := elephantine.ReadBatchResult(rec.Result())
+	test.Must(t, err, "read batch result")
+
+	test.EqualDiff(t, result.Items, read.Items, "round-trip the items")
+
+	var failed elephantine.BatchResult
+
+	failed.Record(0, "a", twirp.InvalidArgument.Error("bad row"))
+	failed.Record(1, "b", twirp.InvalidArgument.Error("bad row"))
+
+	test.Equal(t, http.StatusBadRequest, failed.HTTPStatus(),
+		"use the common status when all items failed")
+}
+
+func TestRetryFailedItems(t *testing.T) {
+	var sent [][]string
+
+	flaky := map[string]int{"b": 1}
+
+	res, err := elephantine.RetryFailedItems(context.Background(),
+		[]string{"a", "b", "c"},
+		elephantine.BatchRetryOptions{Backoff: time.Millisecond},
+		func(_ context.Context, batch []string) (*elephantine.BatchResult, error) {
+			sent = append(sent, batch)
+
+			var r elephantine.BatchResult
+
+			for i, v := range batch {
+				switch {
+				case v == "c":
+					r.Record(i, v, twirp.InvalidArgument.Error("bad row"))
+				case flaky[v] > 0:
+					flaky[v]--
+
+					r.Record(i, v, twirp.Unavailable.Error("try again"))
+				default:
+					r.Record(i, v, nil)
+				}
+			}
+
+			return &r, nil
+		})
+	test.Must(t, err, "send batch")
+
+	test.EqualDiff(t, [][]string{{"a", "b", "c"}, {"b"}}, sent,
+		"only retry the items that failed with retryable errors")
+	test.Equal(t, 2, res.Succeeded, "get the number of successful items")
+	test.Equal(t, 1, res.Failed, "get the number of failed items")
+	test.Equal(t, 1, res.Items[1].Index, "map results to the original index")
+	test.Equal(t, true, res.Items[1].OK(), "record the successful retry")
+}
@@ -0,0 +1,291 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned by CircuitBreakerTransport when requests to a host
+// are rejected because the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState string
+
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects all requests.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe request through to test if the
+	// host has recovered.
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+var circuitStates = []CircuitState{
+	CircuitClosed, CircuitOpen, CircuitHalfOpen,
+}
+
+// CircuitBreakerMetrics are metrics for circuit breakers.
+type CircuitBreakerMetrics struct {
+	state    *prometheus.GaugeVec
+	rejected *prometheus.CounterVec
+}
+
+// NewCircuitBreakerMetrics registers circuit breaker metrics with the provided
+// registerer.
+func NewCircuitBreakerMetrics(
+	registerer prometheus.Registerer,
+) (*CircuitBreakerMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := CircuitBreakerMetrics{
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "client_circuit_breaker_state",
+			Help: "Circuit breaker state per host, 1 for the current state.",
+		}, []string{"client", "host", "state"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_circuit_breaker_rejected_total",
+			Help: "Number of requests rejected by an open circuit breaker.",
+		}, []string{"client", "host"}),
+	}
+
+	collectors := []prometheus.Collector{m.state, m.rejected}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+func (m *CircuitBreakerMetrics) setState(
+	client string, host string, state CircuitState,
+) {
+	if m == nil {
+		return
+	}
+
+	for _, s := range circuitStates {
+		var v float64
+
+		if s == state {
+			v = 1
+		}
+
+		m.state.WithLabelValues(client, host, string(s)).Set(v)
+	}
+}
+
+func (m *CircuitBreakerMetrics) reject(client string, host string) {
+	if m == nil {
+		return
+	}
+
+	m.rejected.WithLabelValues(client, host).Inc()
+}
+
+// CircuitBreakerOptions controls when a circuit breaker trips.
+type CircuitBreakerOptions struct {
+	// Name of the client, used to label metrics.
+	Name string
+	// FailureThreshold is the number of consecutive failures that opens
+	// the circuit for a host. Defaults to 5.
+	FailureThreshold int
+	// SlowThreshold makes requests that take longer than the threshold
+	// count as failures. Latency isn't checked if zero.
+	SlowThreshold time.Duration
+	// OpenDuration is how long the circuit stays open before a probe
+	// request is let through. Defaults to 30s.
+	OpenDuration time.Duration
+	// Metrics is used to expose the circuit states if set.
+	Metrics *CircuitBreakerMetrics
+}
+
+// CircuitBreakerTransport is a http.RoundTripper that stops sending requests to
+// a host after repeated failures, so that callers fail fast instead of piling
+// up requests against a slow or broken upstream. Connection errors and 5xx
+// responses count as failures.
+//
+// Requests that are rejected fail with an error that wraps ErrCircuitOpen.
+type CircuitBreakerTransport struct {
+	base http.RoundTripper
+	opts CircuitBreakerOptions
+
+	m     sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreakerTransport creates a circuit breaker that wraps the base
+// transport. The base defaults to http.DefaultTransport.
+func NewCircuitBreakerTransport(
+	base http.RoundTripper, opts CircuitBreakerOptions,
+) *CircuitBreakerTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if opts.FailureThreshold == 0 {
+		opts.FailureThreshold = 5
+	}
+
+	if opts.OpenDuration == 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+
+	return &CircuitBreakerTransport{
+		base:  base,
+		opts:  opts,
+		hosts: make(map[string]*circuit),
+	}
+}
+
+// State returns the circuit state for a host.
+func (t *CircuitBreakerTransport) State(host string) CircuitState {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	c, ok := t.hosts[host]
+	if !ok {
+		return CircuitClosed
+	}
+
+	return c.state
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	probe, err := t.allow(host)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	res, err := t.base.RoundTrip(req)
+
+	failed := err != nil || res.StatusCode >= 500 ||
+		(t.opts.SlowThreshold > 0 && time.Since(start) > t.opts.SlowThreshold)
+
+	// Cancellation by the caller says nothing about the health of the
+	// host.
+	if err != nil && errors.Is(err, context.Canceled) {
+		if probe {
+			t.release(host)
+		}
+
+		return nil, err //nolint:wrapcheck
+	}
+
+	t.record(host, probe, failed)
+
+	return res, err //nolint:wrapcheck
+}
+
+// allow checks if a request may be sent to the host. Returns true if the
+// request is the probe of a half-open circuit.
+func (t *CircuitBreakerTransport) allow(host string) (bool, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	c, ok := t.hosts[host]
+	if !ok {
+		c = &circuit{state: CircuitClosed}
+		t.hosts[host] = c
+
+		t.opts.Metrics.setState(t.opts.Name, host, c.state)
+	}
+
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= t.opts.OpenDuration {
+		t.setState(host, c, CircuitHalfOpen)
+	}
+
+	switch {
+	case c.state == CircuitOpen,
+		c.state == CircuitHalfOpen && c.probing:
+		t.opts.Metrics.reject(t.opts.Name, host)
+
+		return false, fmt.Errorf("request to %q rejected: %w",
+			host, ErrCircuitOpen)
+	case c.state == CircuitHalfOpen:
+		c.probing = true
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// release lets another probe through if a probe request was cancelled.
+func (t *CircuitBreakerTransport) release(host string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.hosts[host].probing = false
+}
+
+func (t *CircuitBreakerTransport) record(host string, probe bool, failed bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	c := t.hosts[host]
+
+	// Only the probe may clear the probing flag, requests that were sent
+	// before the circuit opened can still be in flight.
+	if probe {
+		c.probing = false
+	}
+
+	switch {
+	case !failed:
+		c.failures = 0
+
+		t.setState(host, c, CircuitClosed)
+	case c.state == CircuitHalfOpen:
+		c.openedAt = time.Now()
+
+		t.setState(host, c, CircuitOpen)
+	case c.state == CircuitClosed:
+		c.failures++
+
+		if c.failures >= t.opts.FailureThreshold {
+			c.openedAt = time.Now()
+
+			t.setState(host, c, CircuitOpen)
+		}
+	}
+}
+
+func (t *CircuitBreakerTransport) setState(
+	host string, c *circuit, state CircuitState,
+) {
+	if c.state == state {
+		return
+	}
+
+	c.state = state
+
+	t.opts.Metrics.setState(t.opts.Name, host, state)
+}
@@ -0,0 +1,177 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	var healthy atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewCircuitBreakerMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	transport := elephantine.NewCircuitBreakerTransport(nil,
+		elephantine.CircuitBreakerOptions{
+			Name:             "test",
+			FailureThreshold: 3,
+			OpenDuration:     50 * time.Millisecond,
+			Metrics:          metrics,
+		})
+
+	client := http.Client{Transport: transport}
+
+	call := func() error {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+
+		_ = res.Body.Close()
+
+		return nil
+	}
+
+	host := server.Listener.Addr().String()
+
+	for range 3 {
+		test.Must(t, call(), "get error responses")
+	}
+
+	test.Equal(t, elephantine.CircuitOpen, transport.State(host),
+		"open the circuit after three failures")
+
+	err = call()
+	test.Equal(t, true, errors.Is(err, elephantine.ErrCircuitOpen),
+		"reject requests when the circuit is open")
+
+	test.Equal(t, 1, testutil.CollectAndCount(reg,
+		"client_circuit_breaker_rejected_total"),
+		"count the rejected request")
+
+	time.Sleep(60 * time.Millisecond)
+
+	test.Must(t, call(), "let a probe through after the open duration")
+
+	test.Equal(t, elephantine.CircuitOpen, transport.State(host),
+		"open the circuit again after a failed probe")
+
+	healthy.Store(true)
+
+	time.Sleep(60 * time.Millisecond)
+
+	test.Must(t, call(), "let a probe through after the open duration")
+
+	test.Equal(t, elephantine.CircuitClosed, transport.State(host),
+		"close the circuit after a successful probe")
+}
+
+func TestCircuitBreakerProbeOwnership(t *testing.T) {
+	arrived := make(chan struct{})
+
+	// The base transport fails "/fail" requests and holds all other
+	// requests until they are cancelled.
+	base := promhttp.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/fail" {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		}
+
+		arrived <- struct{}{}
+
+		<-req.Context().Done()
+
+		return nil, req.Context().Err()
+	})
+
+	transport := elephantine.NewCircuitBreakerTransport(base,
+		elephantine.CircuitBreakerOptions{
+			FailureThreshold: 1,
+			OpenDuration:     50 * time.Millisecond,
+		})
+
+	client := http.Client{Transport: transport}
+
+	hold := func(ctx context.Context) <-chan error {
+		done := make(chan error, 1)
+
+		req, err := http.NewRequestWithContext(ctx,
+			http.MethodGet, "http://upstream/hold", nil)
+		test.Must(t, err, "create request")
+
+		go func() {
+			_, err := client.Do(req) //nolint:bodyclose
+			done <- err
+		}()
+
+		<-arrived
+
+		return done
+	}
+
+	call := func(path string) error {
+		res, err := client.Get("http://upstream" + path)
+		if err != nil {
+			return err
+		}
+
+		_ = res.Body.Close()
+
+		return nil
+	}
+
+	ctx := test.Context(t)
+
+	// Sent while the circuit is closed, so not a probe.
+	slowCtx, cancelSlow := context.WithCancel(ctx)
+	slow := hold(slowCtx)
+
+	test.Must(t, call("/fail"), "get an error response")
+
+	test.Equal(t, elephantine.CircuitOpen, transport.State("upstream"),
+		"open the circuit")
+
+	time.Sleep(60 * time.Millisecond)
+
+	probeCtx, cancelProbe := context.WithCancel(ctx)
+	probe := hold(probeCtx)
+
+	cancelSlow()
+	<-slow
+
+	err := call("/fail")
+	test.Equal(t, true, errors.Is(err, elephantine.ErrCircuitOpen),
+		"keep rejecting requests when a non-probe request is cancelled")
+
+	cancelProbe()
+	<-probe
+
+	test.Must(t, call("/fail"),
+		"let a new probe through when the probe is cancelled")
+}
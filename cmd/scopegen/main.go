@@ -0,0 +1,73 @@
+// Command scopegen generates Go constants from a YAML scope registry, see the
+// scopegen package for the registry format. Use it with go:generate:
+//
+//	//go:generate go run github.com/ttab/elephantine/cmd/scopegen -in scopes.yaml -out scopes_gen.go -package scopes
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ttab/elephantine/scopegen"
+)
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scopegen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		in      string
+		out     string
+		pkgName string
+	)
+
+	flag.StringVar(&in, "in", "scopes.yaml", "scope registry file")
+	flag.StringVar(&out, "out", "scopes_gen.go", "output file")
+	flag.StringVar(&pkgName, "package", os.Getenv("GOPACKAGE"),
+		"package name, defaults to $GOPACKAGE when run by go generate")
+
+	flag.Parse()
+
+	if pkgName == "" {
+		return errors.New("missing package name")
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return fmt.Errorf("open registry: %w", err)
+	}
+
+	defer f.Close()
+
+	reg, err := scopegen.ParseRegistry(f)
+	if err != nil {
+		return fmt.Errorf("read %q: %w", in, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = scopegen.Generate(&buf, reg, scopegen.Options{
+		Package: pkgName,
+		Source:  filepath.Base(in),
+	})
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	//nolint:gosec
+	err = os.WriteFile(out, buf.Bytes(), 0o644)
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package elephantine
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Dial timeouts for the different endpoint classes.
+const (
+	// DialTimeoutInternal is the dial timeout for endpoints in the same
+	// cluster or private network.
+	DialTimeoutInternal = 2 * time.Second
+	// DialTimeoutExternal is the dial timeout for endpoints on the
+	// internet.
+	DialTimeoutExternal = 10 * time.Second
+	// DialTimeoutSlow is the dial timeout for endpoints that are known to
+	// be slow to accept connections.
+	DialTimeoutSlow = 30 * time.Second
+)
+
+// EndpointClass classifies an endpoint by where it's located.
+type EndpointClass string
+
+const (
+	EndpointInternal EndpointClass = "internal"
+	EndpointExternal EndpointClass = "external"
+	EndpointSlow     EndpointClass = "slow"
+)
+
+// DefaultInternalSuffixes are the host suffixes of cluster-local services.
+var DefaultInternalSuffixes = []string{
+	"svc", "svc.cluster.local", "cluster.local", "internal", "localhost",
+}
+
+// DefaultInternalNetworks are the loopback, link-local, and private
+// (RFC 1918 and RFC 4193) networks.
+var DefaultInternalNetworks = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+}
+
+// DialPolicy picks the dial timeout for a connection based on the
+// classification of the host, so that callers don't have to pick the right
+// timeout for every client.
+//
+// Hosts are classified by name before they are resolved. Hosts that match
+// SlowHosts are slow, hosts without a dot, hosts that match InternalSuffixes,
+// and IP addresses in InternalNetworks are internal, everything else is
+// external.
+type DialPolicy struct {
+	// InternalSuffixes are the domains of internal hosts, a suffix
+	// matches the domain itself and all its subdomains. Defaults to
+	// DefaultInternalSuffixes.
+	InternalSuffixes []string
+	// InternalNetworks are the networks of internal IP addresses.
+	// Defaults to DefaultInternalNetworks.
+	InternalNetworks []netip.Prefix
+	// SlowHosts are the domains of hosts that are slow to accept
+	// connections, matched like InternalSuffixes.
+	SlowHosts []string
+	// InternalTimeout defaults to DialTimeoutInternal.
+	InternalTimeout time.Duration
+	// ExternalTimeout defaults to DialTimeoutExternal.
+	ExternalTimeout time.Duration
+	// SlowTimeout defaults to DialTimeoutSlow.
+	SlowTimeout time.Duration
+	// KeepAlive is the keep-alive period of connections, defaults to 30s.
+	KeepAlive time.Duration
+}
+
+// Classify returns the endpoint class of the host.
+func (p DialPolicy) Classify(host string) EndpointClass {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if matchesDomain(host, p.SlowHosts) {
+		return EndpointSlow
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		networks := p.InternalNetworks
+		if networks == nil {
+			networks = DefaultInternalNetworks
+		}
+
+		for _, n := range networks {
+			if n.Contains(addr.Unmap()) {
+				return EndpointInternal
+			}
+		}
+
+		return EndpointExternal
+	}
+
+	suffixes := p.InternalSuffixes
+	if suffixes == nil {
+		suffixes = DefaultInternalSuffixes
+	}
+
+	// Single label names are short names of cluster services.
+	if !strings.Contains(host, ".") || matchesDomain(host, suffixes) {
+		return EndpointInternal
+	}
+
+	return EndpointExternal
+}
+
+// Timeout returns the dial timeout for the host.
+func (p DialPolicy) Timeout(host string) time.Duration {
+	switch p.Classify(host) {
+	case EndpointInternal:
+		return durationOr(p.InternalTimeout, DialTimeoutInternal)
+	case EndpointSlow:
+		return durationOr(p.SlowTimeout, DialTimeoutSlow)
+	case EndpointExternal:
+	}
+
+	return durationOr(p.ExternalTimeout, DialTimeoutExternal)
+}
+
+// DialContext connects to the address using the timeout for its host. Can be
+// used as http.Transport.DialContext.
+func (p DialPolicy) DialContext(
+	ctx context.Context, network string, address string,
+) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	dialer := net.Dialer{
+		Timeout:   p.Timeout(host),
+		KeepAlive: durationOr(p.KeepAlive, 30*time.Second),
+	}
+
+	return dialer.DialContext(ctx, network, address) //nolint:wrapcheck
+}
+
+// DialWithPolicy makes the client use the dial policy to pick connection
+// timeouts.
+func DialWithPolicy(policy DialPolicy) HTTPClientOption {
+	return func(conf *httpClientConfig) {
+		conf.dial = policy.DialContext
+	}
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(d, "."))
+
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func durationOr(d time.Duration, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+
+	return d
+}
@@ -0,0 +1,58 @@
+package elephantine_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestDialPolicyClassify(t *testing.T) {
+	policy := elephantine.DialPolicy{
+		SlowHosts: []string{"legacy.example.com"},
+	}
+
+	cases := map[string]elephantine.EndpointClass{
+		"repository":                            elephantine.EndpointInternal,
+		"repository.elephant.svc":               elephantine.EndpointInternal,
+		"repository.elephant.svc.cluster.local": elephantine.EndpointInternal,
+		"localhost":                             elephantine.EndpointInternal,
+		"10.1.2.3":                              elephantine.EndpointInternal,
+		"172.20.0.1":                            elephantine.EndpointInternal,
+		"::1":                                   elephantine.EndpointInternal,
+		"api.example.com":                       elephantine.EndpointExternal,
+		"8.8.8.8":                               elephantine.EndpointExternal,
+		"172.32.0.1":                            elephantine.EndpointExternal,
+		"legacy.example.com":                    elephantine.EndpointSlow,
+		"feed.legacy.example.com":               elephantine.EndpointSlow,
+	}
+
+	for host, class := range cases {
+		test.Equal(t, class, policy.Classify(host), "classify %q", host)
+	}
+
+	test.Equal(t, elephantine.DialTimeoutSlow,
+		policy.Timeout("legacy.example.com"), "use the slow timeout")
+	test.Equal(t, elephantine.DialTimeoutInternal,
+		policy.Timeout("repository"), "use the internal timeout")
+}
+
+func TestDialWithPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		_ http.ResponseWriter, _ *http.Request,
+	) {
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.DialWithPolicy(elephantine.DialPolicy{}))
+
+	res, err := client.Get(server.URL)
+	test.Must(t, err, "perform request")
+
+	_ = res.Body.Close()
+}
@@ -0,0 +1,292 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// DynamicConfig is middleware configuration that can be changed without
+// restarting the service.
+type DynamicConfig struct {
+	// Version of the configuration, must be incremented for every change.
+	// Reloaded configurations that don't have a higher version than the
+	// active configuration are rejected.
+	Version int64 `yaml:"version"`
+	// CORS replaces the CORS options of the API server if set.
+	CORS *DynamicCORS `yaml:"cors"`
+	// MethodScopes is the scope map used by
+	// ServiceOptions.SetDynamicMethodScopes. Required once dynamic method
+	// scopes are in use.
+	MethodScopes map[string][]string `yaml:"method_scopes"`
+}
+
+// DynamicCORS is the YAML representation of CORSOptions.
+type DynamicCORS struct {
+	AllowInsecure          bool     `yaml:"allow_insecure"`
+	AllowInsecureLocalhost bool     `yaml:"allow_insecure_localhost"`
+	Hosts                  []string `yaml:"hosts"`
+	AllowedMethods         []string `yaml:"allowed_methods"`
+	AllowedHeaders         []string `yaml:"allowed_headers"`
+	MaxAgeSeconds          int      `yaml:"max_age_seconds"`
+}
+
+// Options returns the CORS options.
+func (c DynamicCORS) Options() CORSOptions {
+	return CORSOptions(c)
+}
+
+// Validate checks that the configuration is usable.
+func (c *DynamicConfig) Validate() error {
+	if c.Version <= 0 {
+		return errors.New("version must be a positive number")
+	}
+
+	if c.CORS != nil {
+		if len(c.CORS.Hosts) == 0 {
+			return errors.New("cors: at least one host must be allowed")
+		}
+
+		if c.CORS.MaxAgeSeconds < 0 {
+			return errors.New("cors: max age cannot be negative")
+		}
+	}
+
+	for method, scopes := range c.MethodScopes {
+		if method == "" {
+			return errors.New("method_scopes: empty method name")
+		}
+
+		for _, s := range scopes {
+			if s == "" {
+				return fmt.Errorf(
+					"method_scopes: empty scope for %q", method)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ConfigWatcherOptions controls how the configuration file is watched.
+type ConfigWatcherOptions struct {
+	// Interval is how often the file is checked for changes. Defaults to
+	// 10s.
+	Interval time.Duration
+	// Registerer is used to register the config metrics, defaults to
+	// prometheus.DefaultRegisterer.
+	Registerer prometheus.Registerer
+}
+
+// ConfigWatcher reloads a DynamicConfig from a YAML file, like a mounted
+// Kubernetes ConfigMap. Invalid configurations are rejected and logged, and the
+// previous configuration stays active.
+type ConfigWatcher struct {
+	logger   *slog.Logger
+	path     string
+	interval time.Duration
+
+	current atomic.Pointer[DynamicConfig]
+	// needScopes is set when the method scopes are in use, configurations
+	// without method scopes are rejected from then on.
+	needScopes atomic.Bool
+	hash       [sha256.Size]byte
+	// failed is the hash of the last rejected file, so that we only
+	// report a broken file once.
+	failed [sha256.Size]byte
+
+	version prometheus.Gauge
+	errors  prometheus.Counter
+}
+
+// NewConfigWatcher loads the configuration file and creates a watcher for it.
+// Fails if the initial configuration is invalid.
+func NewConfigWatcher(
+	logger *slog.Logger, path string, opts ConfigWatcherOptions,
+) (*ConfigWatcher, error) {
+	if opts.Interval == 0 {
+		opts.Interval = 10 * time.Second
+	}
+
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+
+	w := ConfigWatcher{
+		logger:   logger,
+		path:     path,
+		interval: opts.Interval,
+		version: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dynamic_config_version",
+			Help: "The version of the active dynamic configuration.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dynamic_config_reload_errors_total",
+			Help: "Number of dynamic configuration reloads that failed.",
+		}),
+	}
+
+	collectors := []prometheus.Collector{w.version, w.errors}
+
+	for i, c := range collectors {
+		err := opts.Registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	_, err := w.reload()
+	if err != nil {
+		return nil, fmt.Errorf("load configuration: %w", err)
+	}
+
+	return &w, nil
+}
+
+// Config returns the active configuration.
+func (w *ConfigWatcher) Config() *DynamicConfig {
+	return w.current.Load()
+}
+
+// Run checks the configuration file for changes until the context is
+// cancelled.
+func (w *ConfigWatcher) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(w.interval):
+		}
+
+		changed, err := w.reload()
+		if err != nil {
+			w.errors.Inc()
+
+			w.logger.ErrorContext(ctx, "failed to reload configuration",
+				LogKeyError, err,
+				LogKeyName, w.path)
+
+			continue
+		}
+
+		if changed {
+			w.logger.InfoContext(ctx, "reloaded configuration",
+				LogKeyName, w.path,
+				"version", w.Config().Version)
+		}
+	}
+}
+
+func (w *ConfigWatcher) reload() (bool, error) {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return false, fmt.Errorf("read file: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	if hash == w.hash || hash == w.failed {
+		return false, nil
+	}
+
+	conf, err := parseDynamicConfig(data)
+	if err == nil {
+		err = w.checkReload(conf)
+	}
+
+	if err != nil {
+		w.failed = hash
+
+		return false, err
+	}
+
+	w.current.Store(conf)
+	w.hash = hash
+	w.version.Set(float64(conf.Version))
+
+	return true, nil
+}
+
+// checkReload checks that a new configuration can replace the active one.
+func (w *ConfigWatcher) checkReload(conf *DynamicConfig) error {
+	if w.needScopes.Load() && len(conf.MethodScopes) == 0 {
+		return errors.New(
+			"method_scopes are required when dynamic method scopes are used")
+	}
+
+	current := w.current.Load()
+	if current != nil && conf.Version <= current.Version {
+		return fmt.Errorf(
+			"version must be incremented, %d is not higher than %d",
+			conf.Version, current.Version)
+	}
+
+	return nil
+}
+
+// requireMethodScopes makes the watcher reject configurations without method
+// scopes, fails if the active configuration lacks them.
+func (w *ConfigWatcher) requireMethodScopes() error {
+	w.needScopes.Store(true)
+
+	if len(w.Config().MethodScopes) == 0 {
+		return errors.New("the configuration has no method_scopes")
+	}
+
+	return nil
+}
+
+func parseDynamicConfig(data []byte) (*DynamicConfig, error) {
+	var conf DynamicConfig
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	dec.KnownFields(true)
+
+	err := dec.Decode(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("parse YAML: %w", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &conf, nil
+}
+
+// CORSMiddleware returns a CORS middleware that uses the CORS options of the
+// active configuration, falling back to the given defaults. Requests are passed
+// straight through if neither are set.
+func (w *ConfigWatcher) CORSMiddleware(
+	defaults *CORSOptions, handler http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		opts := defaults
+
+		if c := w.Config().CORS; c != nil {
+			o := c.Options()
+			opts = &o
+		}
+
+		if opts == nil {
+			handler.ServeHTTP(rw, r)
+
+			return
+		}
+
+		CORSMiddleware(*opts, handler).ServeHTTP(rw, r)
+	})
+}
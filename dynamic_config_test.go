@@ -0,0 +1,105 @@
+package elephantine_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	write := func(data string) {
+		err := os.WriteFile(path, []byte(data), 0o600)
+		test.Must(t, err, "write config file")
+	}
+
+	write(`
+version: 1
+method_scopes:
+  GetDocument: [doc_read]
+`)
+
+	watcher, err := elephantine.NewConfigWatcher(slog.Default(), path,
+		elephantine.ConfigWatcherOptions{
+			Interval:   10 * time.Millisecond,
+			Registerer: prometheus.NewRegistry(),
+		})
+	test.Must(t, err, "create watcher")
+
+	var so elephantine.ServiceOptions
+
+	err = so.SetDynamicMethodScopes(watcher, elephantine.UnknownMethodDeny)
+	test.Must(t, err, "use dynamic method scopes")
+
+	ctx, cancel := context.WithCancel(test.Context(t))
+	defer cancel()
+
+	go func() {
+		_ = watcher.Run(ctx)
+	}()
+
+	waitForVersion := func(version int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(time.Second)
+
+		for watcher.Config().Version != version {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for version %d", version)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	write(`
+version: 2
+method_scopes:
+  GetDocument: [doc_read, doc_admin]
+`)
+
+	waitForVersion(2)
+
+	test.EqualDiff(t, []string{"doc_read", "doc_admin"},
+		watcher.Config().MethodScopes["GetDocument"],
+		"load the updated scope map")
+
+	write(`
+version: 3
+unknown_setting: true
+`)
+
+	time.Sleep(50 * time.Millisecond)
+
+	test.Equal(t, int64(2), watcher.Config().Version,
+		"keep the previous config when the new one is invalid")
+
+	write(`
+version: 3
+`)
+
+	time.Sleep(50 * time.Millisecond)
+
+	test.Equal(t, int64(2), watcher.Config().Version,
+		"reject configs without method scopes")
+
+	write(`
+version: 2
+method_scopes:
+  GetDocument: [doc_admin]
+`)
+
+	time.Sleep(50 * time.Millisecond)
+
+	test.EqualDiff(t, []string{"doc_read", "doc_admin"},
+		watcher.Config().MethodScopes["GetDocument"],
+		"reject configs that don't increment the version")
+}
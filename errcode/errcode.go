@@ -0,0 +1,177 @@
+// Package errcode provides a catalog of stable, machine-readable error codes
+// that can be attached to Twirp errors, HTTP errors, and log records. Clients
+// should act on the codes rather than on error messages.
+package errcode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ttab/elephantine"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	// MetaKey is the Twirp error metadata key that holds the error code.
+	MetaKey = "error_code"
+	// HeaderName is the response header that holds the error code of HTTP
+	// error responses.
+	HeaderName = "X-Error-Code"
+)
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z][a-z0-9_]*)*$`)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]Code)
+)
+
+// Code is a registered error code.
+type Code struct {
+	// Name is the stable identifier of the code, like
+	// "repository.document_locked".
+	Name string `json:"name"`
+	// Description is a human-readable description of when the error
+	// occurs.
+	Description string `json:"description"`
+	// TwirpCode is the Twirp error code used for errors with the code.
+	TwirpCode twirp.ErrorCode `json:"twirp_code"`
+	// HTTPStatus is the HTTP status used for errors with the code.
+	HTTPStatus int `json:"http_status"`
+}
+
+// Register adds an error code to the catalog. Intended for package level
+// variables, panics if the name is invalid or already registered. Names are
+// dot-separated snake case, like "repository.document_locked".
+func Register(name string, description string, code twirp.ErrorCode) Code {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("invalid error code name %q", name))
+	}
+
+	if !twirp.IsValidErrorCode(code) {
+		panic(fmt.Sprintf("invalid twirp error code %q for %q", code, name))
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("error code %q is already registered", name))
+	}
+
+	c := Code{
+		Name:        name,
+		Description: description,
+		TwirpCode:   code,
+		HTTPStatus:  twirp.ServerHTTPStatusFromErrorCode(code),
+	}
+
+	registry[name] = c
+
+	return c
+}
+
+// Lookup returns the registered code with the given name.
+func Lookup(name string) (Code, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	c, ok := registry[name]
+
+	return c, ok
+}
+
+// Codes returns all registered codes, ordered by name.
+func Codes() []Code {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	codes := make([]Code, 0, len(registry))
+
+	for _, c := range registry {
+		codes = append(codes, c)
+	}
+
+	slices.SortFunc(codes, func(a, b Code) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return codes
+}
+
+// Error creates a Twirp error with the code attached as metadata.
+func (c Code) Error(msg string) twirp.Error {
+	return twirp.NewError(c.TwirpCode, msg).WithMeta(MetaKey, c.Name)
+}
+
+// Errorf creates a Twirp error with a formatted message and the code attached
+// as metadata.
+func (c Code) Errorf(format string, a ...any) twirp.Error {
+	return c.Error(fmt.Sprintf(format, a...))
+}
+
+// HTTPError creates a HTTPError with the code in the X-Error-Code header.
+func (c Code) HTTPError(msg string) *elephantine.HTTPError {
+	e := elephantine.NewHTTPError(c.HTTPStatus, msg)
+
+	e.Header.Set(HeaderName, c.Name)
+
+	return e
+}
+
+// LogAttr returns a log attribute for the code.
+func (c Code) LogAttr() slog.Attr {
+	return slog.String(elephantine.LogKeyErrorName, c.Name)
+}
+
+// Is checks if the error (or any error in its tree) has the code.
+func (c Code) Is(err error) bool {
+	name, ok := FromError(err)
+
+	return ok && name == c.Name
+}
+
+// FromError returns the error code name of a Twirp error or HTTPError.
+func FromError(err error) (string, bool) {
+	var te twirp.Error
+
+	if errors.As(err, &te) {
+		name := te.Meta(MetaKey)
+
+		return name, name != ""
+	}
+
+	var he *elephantine.HTTPError
+
+	if errors.As(err, &he) && he.Header != nil {
+		name := he.Header.Get(HeaderName)
+
+		return name, name != ""
+	}
+
+	return "", false
+}
+
+// Handler returns a handler that lists all registered codes as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+
+		enc.SetIndent("", "  ")
+
+		_ = enc.Encode(struct {
+			Codes []Code `json:"codes"`
+		}{
+			Codes: Codes(),
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package errcode_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ttab/elephantine/errcode"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+var errDocumentLocked = errcode.Register("test.document_locked",
+	"The document is locked by another client", twirp.FailedPrecondition)
+
+func TestErrorCodes(t *testing.T) {
+	err := fmt.Errorf("update document: %w",
+		errDocumentLocked.Error("document is locked"))
+
+	test.IsTwirpError(t, err, twirp.FailedPrecondition)
+	test.Equal(t, true, errDocumentLocked.Is(err),
+		"identify a wrapped twirp error by code")
+
+	httpErr := errDocumentLocked.HTTPError("document is locked")
+
+	test.Equal(t, http.StatusPreconditionFailed, httpErr.StatusCode,
+		"map the twirp code to a HTTP status")
+	test.Equal(t, true, errDocumentLocked.Is(httpErr),
+		"identify a HTTP error by code")
+
+	rec := httptest.NewRecorder()
+
+	errcode.Handler().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/errors", nil))
+
+	var list struct {
+		Codes []errcode.Code `json:"codes"`
+	}
+
+	err = json.Unmarshal(rec.Body.Bytes(), &list)
+	test.Must(t, err, "parse the registry listing")
+
+	test.EqualDiff(t, []errcode.Code{errDocumentLocked}, list.Codes,
+		"list the registered codes")
+}
@@ -0,0 +1,10 @@
+package elephantine
+
+import "time"
+
+// VaultRenewalDelay exposes the renewal delay calculation to the tests.
+func VaultRenewalDelay(opts SecretSourceOptions, ttl time.Duration) time.Duration {
+	v := Vault{opts: opts.withDefaults()}
+
+	return v.renewalDelay(ttl)
+}
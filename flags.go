@@ -0,0 +1,335 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ParameterFlag returns the "[name]-parameter" flag that is used by
+// ResolveParameter and the typed Resolve...Parameter functions to load a
+// value from a ParameterSource.
+func ParameterFlag(name string, envVar string) cli.Flag {
+	f := cli.StringFlag{
+		Name:  name + "-parameter",
+		Usage: fmt.Sprintf("Name of the parameter to load %s from", name),
+	}
+
+	if envVar != "" {
+		f.EnvVars = []string{envVar + "_PARAMETER"}
+	}
+
+	return &f
+}
+
+// DurationFlag returns a duration flag that validates that the value is within
+// the given bounds. A zero max means that there is no upper bound.
+//
+// Durations must be given with units, "30s" or "500ms", a plain number like
+// "30" is rejected.
+func DurationFlag(
+	name string, value time.Duration,
+	minValue time.Duration, maxValue time.Duration,
+	usage string, envVars ...string,
+) *cli.DurationFlag {
+	return &cli.DurationFlag{
+		Name:    name,
+		Usage:   usage,
+		Value:   value,
+		EnvVars: envVars,
+		Action: func(_ *cli.Context, d time.Duration) error {
+			return validateDuration(name, d, minValue, maxValue)
+		},
+	}
+}
+
+func validateDuration(
+	name string, d time.Duration,
+	minValue time.Duration, maxValue time.Duration,
+) error {
+	if d < minValue {
+		return fmt.Errorf("%s must be at least %s, got %s",
+			name, minValue, d)
+	}
+
+	if maxValue != 0 && d > maxValue {
+		return fmt.Errorf("%s must be at most %s, got %s",
+			name, maxValue, d)
+	}
+
+	return nil
+}
+
+// ResolveDurationParameter is the duration equivalent of ResolveParameter.
+func ResolveDurationParameter(
+	ctx context.Context, c *cli.Context, src ParameterSource, name string,
+) (time.Duration, error) {
+	if c.String(name+"-parameter") == "" {
+		return c.Duration(name), nil
+	}
+
+	value, err := ResolveParameter(ctx, c, src, name)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s duration: %w", name, err)
+	}
+
+	return d, nil
+}
+
+// ByteSize is a size in bytes.
+type ByteSize int64
+
+var byteSizeUnits = map[string]ByteSize{
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// ParseByteSize parses a byte size like "512MB", "1.5GiB", or "100B". Units are
+// case insensitive, and SI and binary units are supported. A plain number
+// without unit is only accepted for zero.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "0" {
+		return 0, nil
+	}
+
+	split := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		return 0, fmt.Errorf("missing unit in byte size %q", s)
+	}
+
+	num, unit := s[:split], strings.ToUpper(strings.TrimSpace(s[split:]))
+
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q in byte size %q", unit, s)
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number in byte size %q: %w", s, err)
+	}
+
+	size := n * float64(multiplier)
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+
+	return ByteSize(size), nil
+}
+
+// String returns the size using the largest binary unit that represents the
+// size without loss of precision.
+func (b ByteSize) String() string {
+	units := []string{"TiB", "GiB", "MiB", "KiB"}
+
+	for i, unit := range units {
+		size := ByteSize(1) << (10 * (len(units) - i))
+
+		if b != 0 && b%size == 0 {
+			return fmt.Sprintf("%d%s", b/size, unit)
+		}
+	}
+
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// ByteSizeValue is a cli.Generic implementation for byte sizes.
+type ByteSizeValue struct {
+	Size ByteSize
+}
+
+// Set implements cli.Generic.
+func (v *ByteSizeValue) Set(value string) error {
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	v.Size = size
+
+	return nil
+}
+
+// String implements cli.Generic.
+func (v *ByteSizeValue) String() string {
+	return v.Size.String()
+}
+
+// ByteSizeFlag returns a flag that accepts sizes like "512MB" or "1GiB", and
+// validates that the value is within the given bounds. A zero max means that
+// there is no upper bound. Use ByteSizeFromCLI to read the value.
+func ByteSizeFlag(
+	name string, value ByteSize,
+	minValue ByteSize, maxValue ByteSize,
+	usage string, envVars ...string,
+) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    name,
+		Usage:   usage,
+		Value:   &ByteSizeValue{Size: value},
+		EnvVars: envVars,
+		Action: func(_ *cli.Context, v any) error {
+			size := v.(*ByteSizeValue).Size
+
+			if size < minValue {
+				return fmt.Errorf("%s must be at least %s, got %s",
+					name, minValue, size)
+			}
+
+			if maxValue != 0 && size > maxValue {
+				return fmt.Errorf("%s must be at most %s, got %s",
+					name, maxValue, size)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ByteSizeFromCLI returns the value of a flag created with ByteSizeFlag.
+func ByteSizeFromCLI(c *cli.Context, name string) ByteSize {
+	v, ok := c.Generic(name).(*ByteSizeValue)
+	if !ok {
+		return 0
+	}
+
+	return v.Size
+}
+
+// ResolveByteSizeParameter is the byte size equivalent of ResolveParameter.
+func ResolveByteSizeParameter(
+	ctx context.Context, c *cli.Context, src ParameterSource, name string,
+) (ByteSize, error) {
+	if c.String(name+"-parameter") == "" {
+		return ByteSizeFromCLI(c, name), nil
+	}
+
+	value, err := ResolveParameter(ctx, c, src, name)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s byte size: %w", name, err)
+	}
+
+	return size, nil
+}
+
+// URLValue is a cli.Generic implementation for absolute URLs.
+type URLValue struct {
+	URL     *url.URL
+	Schemes []string
+}
+
+// Set implements cli.Generic.
+func (v *URLValue) Set(value string) error {
+	u, err := ParseURL(value, v.Schemes...)
+	if err != nil {
+		return err
+	}
+
+	v.URL = u
+
+	return nil
+}
+
+// String implements cli.Generic.
+func (v *URLValue) String() string {
+	if v.URL == nil {
+		return ""
+	}
+
+	return v.URL.String()
+}
+
+// ParseURL parses an absolute URL and validates that it has a host, and that it
+// uses one of the given schemes, if any are given.
+func ParseURL(value string, schemes ...string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute URL", value)
+	}
+
+	if len(schemes) > 0 && !slices.Contains(schemes, u.Scheme) {
+		return nil, fmt.Errorf("%q must use one of the schemes: %s",
+			value, strings.Join(schemes, ", "))
+	}
+
+	return u, nil
+}
+
+// URLFlag returns a flag that only accepts absolute URLs using one of the
+// given schemes. Use URLFromCLI to read the value.
+func URLFlag(
+	name string, schemes []string, usage string, envVars ...string,
+) *cli.GenericFlag {
+	return &cli.GenericFlag{
+		Name:    name,
+		Usage:   usage,
+		Value:   &URLValue{Schemes: schemes},
+		EnvVars: envVars,
+	}
+}
+
+// URLFromCLI returns the value of a flag created with URLFlag, nil is returned
+// if the flag hasn't been set.
+func URLFromCLI(c *cli.Context, name string) *url.URL {
+	v, ok := c.Generic(name).(*URLValue)
+	if !ok {
+		return nil
+	}
+
+	return v.URL
+}
+
+// ResolveURLParameter is the URL equivalent of ResolveParameter.
+func ResolveURLParameter(
+	ctx context.Context, c *cli.Context, src ParameterSource, name string,
+	schemes ...string,
+) (*url.URL, error) {
+	if c.String(name+"-parameter") == "" {
+		u := URLFromCLI(c, name)
+		if u == nil {
+			return nil, errors.New("no URL provided")
+		}
+
+		return u, nil
+	}
+
+	value, err := ResolveParameter(ctx, c, src, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseURL(value, schemes...)
+}
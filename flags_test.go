@@ -0,0 +1,248 @@
+package elephantine_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseByteSize(t *testing.T) {
+	valid := map[string]elephantine.ByteSize{
+		"0":       0,
+		"100B":    100,
+		"512MB":   512 * 1000 * 1000,
+		"512mb":   512 * 1000 * 1000,
+		"1GiB":    1 << 30,
+		"1.5 KiB": 1536,
+	}
+
+	for input, want := range valid {
+		got, err := elephantine.ParseByteSize(input)
+		test.Must(t, err, "parse %q", input)
+
+		test.Equal(t, want, got, "get the expected size for %q", input)
+	}
+
+	invalid := []string{"", "512", "12 parsecs", "MB", "1..2MB"}
+
+	for _, input := range invalid {
+		_, err := elephantine.ParseByteSize(input)
+		test.MustNot(t, err, "reject %q", input)
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	test.Equal(t, "1GiB", elephantine.ByteSize(1<<30).String(),
+		"format whole GiB")
+	test.Equal(t, "1536B", elephantine.ByteSize(1500+36).String(),
+		"format non-binary size as bytes")
+	test.Equal(t, "3KiB", elephantine.ByteSize(3072).String(),
+		"format whole KiB")
+}
+
+// runFlags runs a CLI app with the flags and arguments, and calls fn with the
+// resulting context.
+func runFlags(
+	flags []cli.Flag, args []string, fn func(c *cli.Context) error,
+) error {
+	app := cli.App{
+		Name:      "test",
+		Flags:     flags,
+		Action:    fn,
+		Writer:    io.Discard,
+		ErrWriter: io.Discard,
+		HideHelp:  true,
+	}
+
+	return app.Run(append([]string{"test"}, args...)) //nolint:wrapcheck
+}
+
+func TestDurationFlag(t *testing.T) {
+	flags := []cli.Flag{
+		elephantine.DurationFlag("timeout", 5*time.Second,
+			time.Second, time.Minute, "Request timeout"),
+	}
+
+	valid := map[string]time.Duration{
+		"1s":     time.Second,
+		"30s":    30 * time.Second,
+		"1m":     time.Minute,
+		"1500ms": 1500 * time.Millisecond,
+	}
+
+	for input, want := range valid {
+		var got time.Duration
+
+		err := runFlags(flags, []string{"--timeout", input},
+			func(c *cli.Context) error {
+				got = c.Duration("timeout")
+
+				return nil
+			})
+		test.Must(t, err, "accept %q", input)
+
+		test.Equal(t, want, got, "get the expected duration for %q", input)
+	}
+
+	invalid := []string{"999ms", "61s", "30", "-1s"}
+
+	for _, input := range invalid {
+		err := runFlags(flags, []string{"--timeout", input},
+			func(_ *cli.Context) error { return nil })
+		test.MustNot(t, err, "reject %q", input)
+	}
+
+	var got time.Duration
+
+	err := runFlags(flags, nil, func(c *cli.Context) error {
+		got = c.Duration("timeout")
+
+		return nil
+	})
+	test.Must(t, err, "run without the flag")
+
+	test.Equal(t, 5*time.Second, got, "use the default value")
+}
+
+func TestParseURL(t *testing.T) {
+	u, err := elephantine.ParseURL("https://example.com/path", "https")
+	test.Must(t, err, "parse https URL")
+
+	test.Equal(t, "example.com", u.Host, "get the host")
+
+	_, err = elephantine.ParseURL("http://example.com/path")
+	test.Must(t, err, "accept any scheme when none are given")
+
+	_, err = elephantine.ParseURL("http://example.com", "https")
+	test.MustNot(t, err, "reject a scheme that isn't allowed")
+
+	_, err = elephantine.ParseURL("postgres://db:5432/app", "http", "https")
+	test.MustNot(t, err, "reject a scheme that isn't in the list")
+
+	_, err = elephantine.ParseURL("/just/a/path")
+	test.MustNot(t, err, "reject a relative URL")
+
+	_, err = elephantine.ParseURL("file:///etc/passwd")
+	test.MustNot(t, err, "reject a URL without host")
+
+	_, err = elephantine.ParseURL("https://exa mple.com")
+	test.MustNot(t, err, "reject an unparseable URL")
+}
+
+func TestResolveParameters(t *testing.T) {
+	// Generic flags keep their value between runs, so every run gets
+	// fresh flags.
+	newFlags := func() []cli.Flag {
+		return []cli.Flag{
+			elephantine.DurationFlag("timeout", 5*time.Second,
+				0, 0, "Request timeout"),
+			elephantine.ParameterFlag("timeout", "TIMEOUT"),
+			elephantine.ByteSizeFlag("max-size", 1<<20,
+				0, 0, "Max upload size"),
+			elephantine.ParameterFlag("max-size", ""),
+			elephantine.URLFlag("endpoint", []string{"https"}, "Endpoint"),
+			elephantine.ParameterFlag("endpoint", ""),
+		}
+	}
+
+	type resolved struct {
+		Timeout  time.Duration
+		MaxSize  elephantine.ByteSize
+		Endpoint string
+	}
+
+	resolve := func(
+		params mapParameterSource, args ...string,
+	) (resolved, error) {
+		var r resolved
+
+		err := runFlags(newFlags(), args, func(c *cli.Context) error {
+			ctx := test.Context(t)
+
+			timeout, err := elephantine.ResolveDurationParameter(
+				ctx, c, params, "timeout")
+			if err != nil {
+				return err
+			}
+
+			size, err := elephantine.ResolveByteSizeParameter(
+				ctx, c, params, "max-size")
+			if err != nil {
+				return err
+			}
+
+			endpoint, err := elephantine.ResolveURLParameter(
+				ctx, c, params, "endpoint", "https")
+			if err != nil {
+				return err
+			}
+
+			r = resolved{
+				Timeout:  timeout,
+				MaxSize:  size,
+				Endpoint: endpoint.String(),
+			}
+
+			return nil
+		})
+
+		return r, err
+	}
+
+	got, err := resolve(nil,
+		"--timeout", "10s",
+		"--max-size", "2MiB",
+		"--endpoint", "https://api.example.com")
+	test.Must(t, err, "resolve values from flags")
+
+	test.Equal(t, resolved{
+		Timeout:  10 * time.Second,
+		MaxSize:  2 << 20,
+		Endpoint: "https://api.example.com",
+	}, got, "use the flag values")
+
+	params := mapParameterSource{
+		"/svc/timeout":  "45s",
+		"/svc/max-size": "1GiB",
+		"/svc/endpoint": "https://internal.example.com",
+	}
+
+	got, err = resolve(params,
+		"--timeout-parameter", "/svc/timeout",
+		"--max-size-parameter", "/svc/max-size",
+		"--endpoint-parameter", "/svc/endpoint")
+	test.Must(t, err, "resolve values from parameters")
+
+	test.Equal(t, resolved{
+		Timeout:  45 * time.Second,
+		MaxSize:  1 << 30,
+		Endpoint: "https://internal.example.com",
+	}, got, "use the parameter values")
+
+	_, err = resolve(params, "--max-size", "1MB")
+	test.MustNot(t, err, "fail without an endpoint")
+
+	invalid := mapParameterSource{
+		"/bad/timeout":  "45",
+		"/bad/max-size": "lots",
+		"/bad/endpoint": "http://internal.example.com",
+	}
+
+	for _, name := range []string{"timeout", "max-size", "endpoint"} {
+		args := []string{"--endpoint", "https://api.example.com"}
+
+		args = append(args, "--"+name+"-parameter", "/bad/"+name)
+
+		_, err := resolve(invalid, args...)
+		test.MustNot(t, err, "reject an invalid %s parameter", name)
+	}
+
+	_, err = resolve(params,
+		"--endpoint", "https://api.example.com",
+		"--timeout-parameter", "/svc/missing")
+	test.MustNot(t, err, "fail on an unknown parameter")
+}
@@ -2,14 +2,89 @@ package elephantine
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShutdownReason describes why a shutdown was initiated.
+type ShutdownReason string
+
+const (
+	// ShutdownReasonSignal is used when the process received SIGTERM or
+	// SIGINT.
+	ShutdownReasonSignal ShutdownReason = "signal"
+	// ShutdownReasonPreStop is used when the pre-stop hook was called.
+	ShutdownReasonPreStop ShutdownReason = "pre_stop"
+	// ShutdownReasonOperator is used for stops requested by an operator,
+	// and for calls to Stop().
+	ShutdownReasonOperator ShutdownReason = "operator"
+	// ShutdownReasonHealthFailure is used when the application stops
+	// because it has become unhealthy.
+	ShutdownReasonHealthFailure ShutdownReason = "health_failure"
+	// ShutdownReasonFatalTask is used when a task that the application
+	// can't run without has failed.
+	ShutdownReasonFatalTask ShutdownReason = "fatal_task"
 )
 
+// ExitCode returns the exit code that should be used for a shutdown with the
+// reason. Shutdowns that were requested from the outside exit with 0, and
+// shutdowns caused by failures exit with 1.
+func (r ShutdownReason) ExitCode() int {
+	switch r {
+	case "", ShutdownReasonSignal, ShutdownReasonPreStop,
+		ShutdownReasonOperator:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// ShutdownMetrics counts shutdowns by reason.
+type ShutdownMetrics struct {
+	shutdowns *prometheus.CounterVec
+}
+
+// NewShutdownMetrics registers shutdown metrics with the provided registerer.
+func NewShutdownMetrics(
+	registerer prometheus.Registerer,
+) (*ShutdownMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := ShutdownMetrics{
+		shutdowns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shutdown_total",
+			Help: "Number of initiated shutdowns, by reason.",
+		}, []string{"reason"}),
+	}
+
+	err := registerer.Register(m.shutdowns)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (m *ShutdownMetrics) initiated(reason ShutdownReason) {
+	if m == nil {
+		return
+	}
+
+	m.shutdowns.WithLabelValues(string(reason)).Inc()
+}
+
 // GracefulShutdown is a helper that can be used to listen for SIGINT and
 // SIGTERM to gracefully shut down your application.
 //
@@ -21,6 +96,10 @@ type GracefulShutdown struct {
 	signals chan os.Signal
 	stop    chan struct{}
 	quit    chan struct{}
+	drained chan struct{}
+	metrics *ShutdownMetrics
+	reason  ShutdownReason
+	cause   error
 }
 
 // NewGracefulShutdown creates a new GracefulShutdown that will wait for
@@ -44,6 +123,7 @@ func newGracefulShutdown(
 		signals: make(chan os.Signal, 1),
 		stop:    make(chan struct{}),
 		quit:    make(chan struct{}),
+		drained: make(chan struct{}),
 	}
 
 	if listenToSignals {
@@ -69,12 +149,12 @@ func newGracefulShutdown(
 			return
 		default:
 			logger.Warn("asked to stop, waiting for cleanup",
-				LogKeyDelay, timeout)
+				append(gs.reasonAttrs(), LogKeyDelay, timeout)...)
 		}
 
 		time.Sleep(timeout)
 
-		logger.Warn("shutting down")
+		gs.logShutdown()
 		gs.safeClose(gs.quit)
 	}()
 
@@ -104,18 +184,87 @@ func (gs *GracefulShutdown) safeClose(ch chan struct{}) {
 }
 
 func (gs *GracefulShutdown) handleSignal(sig os.Signal) {
+	cause := fmt.Errorf("received %s", sig)
+
 	switch sig.String() {
 	case syscall.SIGINT.String():
-		gs.logger.Warn("shutting down")
+		gs.setReason(ShutdownReasonSignal, cause)
+		gs.logShutdown()
 		gs.safeClose(gs.quit)
 		gs.safeClose(gs.stop)
 	case syscall.SIGTERM.String():
-		gs.safeClose(gs.stop)
+		gs.StopWithReason(ShutdownReasonSignal, cause)
 	}
 }
 
+func (gs *GracefulShutdown) logShutdown() {
+	gs.logger.Warn("shutting down", gs.reasonAttrs()...)
+}
+
+func (gs *GracefulShutdown) reasonAttrs() []any {
+	reason, cause := gs.Reason()
+
+	attrs := []any{LogKeyShutdownReason, reason}
+
+	if cause != nil {
+		attrs = append(attrs, LogKeyError, cause)
+	}
+
+	return attrs
+}
+
+// SetMetrics makes the shutdown count initiated shutdowns by reason.
+func (gs *GracefulShutdown) SetMetrics(m *ShutdownMetrics) {
+	gs.m.Lock()
+	defer gs.m.Unlock()
+
+	gs.metrics = m
+}
+
+// setReason records the reason for the shutdown, only the first reason is
+// kept.
+func (gs *GracefulShutdown) setReason(reason ShutdownReason, cause error) {
+	gs.m.Lock()
+	defer gs.m.Unlock()
+
+	if gs.reason != "" {
+		return
+	}
+
+	gs.reason = reason
+	gs.cause = cause
+
+	gs.metrics.initiated(reason)
+}
+
+// Reason returns why the shutdown was initiated, and the error that caused it,
+// if any. The reason is empty if no shutdown has been initiated.
+func (gs *GracefulShutdown) Reason() (ShutdownReason, error) {
+	gs.m.Lock()
+	defer gs.m.Unlock()
+
+	return gs.reason, gs.cause
+}
+
+// ExitCode returns the exit code that the application should use, based on the
+// reason for the shutdown, see ShutdownReason.ExitCode.
+func (gs *GracefulShutdown) ExitCode() int {
+	reason, _ := gs.Reason()
+
+	return reason.ExitCode()
+}
+
 // Stop triggers a stop, which will trigger quit after the configured timeout.
+// The shutdown reason is recorded as ShutdownReasonOperator, use
+// StopWithReason to give a more specific reason.
 func (gs *GracefulShutdown) Stop() {
+	gs.StopWithReason(ShutdownReasonOperator, nil)
+}
+
+// StopWithReason triggers a stop and records why. Only the first reason is
+// kept if stop is triggered more than once. The cause is optional.
+func (gs *GracefulShutdown) StopWithReason(reason ShutdownReason, cause error) {
+	gs.setReason(reason, cause)
 	gs.safeClose(gs.stop)
 }
 
@@ -154,3 +303,109 @@ func (gs *GracefulShutdown) CancelOnQuit(ctx context.Context) context.Context {
 
 	return cCtx
 }
+
+// ErrShuttingDown is returned by CheckpointErr when stop has been triggered.
+var ErrShuttingDown = errors.New("shutting down")
+
+type shutdownCtxKey struct{}
+
+// WithShutdown returns a child context for work that should be aborted on
+// shutdown, like long-running transactions. The context is cancelled when quit
+// is triggered, so that open transactions are rolled back before the database
+// pool is closed, and CheckpointErr will return ErrShuttingDown once stop has
+// been triggered.
+func (gs *GracefulShutdown) WithShutdown(
+	ctx context.Context,
+) (context.Context, context.CancelFunc) {
+	cCtx, cancel := context.WithCancel(
+		context.WithValue(ctx, shutdownCtxKey{}, gs))
+
+	go func() {
+		select {
+		case <-gs.quit:
+			cancel()
+		case <-cCtx.Done():
+		}
+	}()
+
+	return cCtx, cancel
+}
+
+// CheckpointErr returns an error if work using the context should be aborted.
+// Call it at safe points in long-running work, like between batches in a
+// transaction. Returns ErrShuttingDown if the context was created by
+// GracefulShutdown.WithShutdown and stop has been triggered, or the context
+// error if the context has been cancelled.
+func CheckpointErr(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	gs, ok := ctx.Value(shutdownCtxKey{}).(*GracefulShutdown)
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-gs.stop:
+		return ErrShuttingDown
+	default:
+		return nil
+	}
+}
+
+// ReadyCheck returns a ReadyFunc that fails once stop has been triggered, so
+// that the service is taken out of rotation while it drains.
+func (gs *GracefulShutdown) ReadyCheck() ReadyFunc {
+	return func(_ context.Context) error {
+		select {
+		case <-gs.stop:
+			return errors.New("shutting down")
+		default:
+			return nil
+		}
+	}
+}
+
+// MarkDrained signals that the application has drained: the servers have been
+// shut down and in-flight work has completed. Releases pending pre-stop hooks,
+// see PreStopHandler.
+func (gs *GracefulShutdown) MarkDrained() {
+	gs.safeClose(gs.drained)
+}
+
+// PreStopHandler returns a handler that can be used as a Kubernetes preStop
+// hook. It triggers a stop and responds once the application has called
+// MarkDrained. If quit is triggered before the application has drained the
+// handler responds with 503 Service Unavailable. Serve it from a server that
+// isn't shut down as part of the drain, like the health server.
+func (gs *GracefulShutdown) PreStopHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gs.logger.Warn("stop requested by pre-stop hook")
+
+		gs.StopWithReason(ShutdownReasonPreStop, nil)
+
+		status := http.StatusOK
+		msg := "drained"
+
+		select {
+		case <-gs.drained:
+		case <-gs.quit:
+			// Prefer drained if both have happened.
+			select {
+			case <-gs.drained:
+			default:
+				status = http.StatusServiceUnavailable
+				msg = "quit before drain completed"
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+
+		_, _ = fmt.Fprintln(w, msg)
+	})
+}
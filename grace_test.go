@@ -0,0 +1,125 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestCheckpointErr(t *testing.T) {
+	// The quit timeout must leave plenty of time to check that the
+	// context is alive after stop, even on a loaded machine.
+	gs := elephantine.NewManualGracefulShutdown(
+		slog.New(slog.NewTextHandler(io.Discard, nil)), time.Second)
+
+	ctx, cancel := gs.WithShutdown(test.Context(t))
+	defer cancel()
+
+	test.Must(t, elephantine.CheckpointErr(ctx),
+		"pass checkpoint before stop")
+	test.Must(t, elephantine.CheckpointErr(context.Background()),
+		"pass checkpoint without shutdown context")
+
+	gs.Stop()
+
+	err := elephantine.CheckpointErr(ctx)
+	if !errors.Is(err, elephantine.ErrShuttingDown) {
+		t.Fatalf("expected ErrShuttingDown after stop, got %v", err)
+	}
+
+	test.Must(t, ctx.Err(), "keep context alive until quit")
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context wasn't cancelled on quit")
+	}
+
+	if !errors.Is(elephantine.CheckpointErr(ctx), context.Canceled) {
+		t.Fatal("expected context error after quit")
+	}
+}
+
+func TestShutdownReason(t *testing.T) {
+	gs := elephantine.NewManualGracefulShutdown(
+		slog.New(slog.NewTextHandler(io.Discard, nil)), time.Millisecond)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewShutdownMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	gs.SetMetrics(metrics)
+
+	test.Equal(t, 0, gs.ExitCode(), "exit with 0 before shutdown")
+
+	taskErr := errors.New("consumer crashed")
+
+	gs.StopWithReason(elephantine.ShutdownReasonFatalTask, taskErr)
+	gs.Stop()
+
+	reason, cause := gs.Reason()
+
+	test.Equal(t, elephantine.ShutdownReasonFatalTask, reason,
+		"keep the first reason")
+	test.Equal(t, true, errors.Is(cause, taskErr), "keep the cause")
+	test.Equal(t, 1, gs.ExitCode(), "exit with 1 after a fatal task error")
+
+	const want = `
+# HELP shutdown_total Number of initiated shutdowns, by reason.
+# TYPE shutdown_total counter
+shutdown_total{reason="fatal_task"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(want))
+	test.Must(t, err, "count the shutdown")
+}
+
+func TestPreStopHandler(t *testing.T) {
+	gs := elephantine.NewManualGracefulShutdown(
+		slog.New(slog.NewTextHandler(io.Discard, nil)), time.Minute)
+
+	handler := gs.PreStopHandler()
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		handler.ServeHTTP(rec, httptest.NewRequest(
+			http.MethodGet, "/health/prestop", nil))
+	}()
+
+	select {
+	case <-gs.ShouldStop():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for stop")
+	}
+
+	select {
+	case <-done:
+		t.Fatal("responded before the application had drained")
+	default:
+	}
+
+	gs.MarkDrained()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the pre-stop response")
+	}
+
+	test.Equal(t, http.StatusOK, rec.Code, "respond once drained")
+}
@@ -6,14 +6,79 @@ import (
 	"expvar"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/pprof" //nolint:gosec
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// ReadySeverity controls how a failing ready function affects the ready
+// probe.
+type ReadySeverity string
+
+const (
+	// ReadySeverityCritical checks fail the ready probe, this is the
+	// default.
+	ReadySeverityCritical ReadySeverity = "critical"
+	// ReadySeverityDegraded checks are for dependencies that the
+	// application can run without, but with reduced functionality.
+	ReadySeverityDegraded ReadySeverity = "degraded"
+	// ReadySeverityInformational checks only report on the state of a
+	// dependency.
+	ReadySeverityInformational ReadySeverity = "informational"
+)
+
+// HealthMetrics are metrics for the results of ready checks.
+type HealthMetrics struct {
+	status *prometheus.GaugeVec
+}
+
+// NewHealthMetrics registers health metrics with the provided registerer.
+func NewHealthMetrics(registerer prometheus.Registerer) (*HealthMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := HealthMetrics{
+		status: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "health_check_ok",
+			Help: "Set to 1 if the last run of a ready check succeeded, 0 if it failed.",
+		}, []string{"check", "severity"}),
+	}
+
+	err := registerer.Register(m.status)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (m *HealthMetrics) observe(name string, severity ReadySeverity, ok bool) {
+	if m == nil {
+		return
+	}
+
+	var value float64
+	if ok {
+		value = 1
+	}
+
+	m.status.WithLabelValues(name, string(severity)).Set(value)
+}
+
+type readyCheck struct {
+	fn       ReadyFunc
+	severity ReadySeverity
+}
+
 // HealthServer exposes health endpoints, metrics, and PPROF endpoints.
 //
 // A HealthServer should never be publicly exposed, as that both could expose
@@ -24,33 +89,52 @@ import (
 //	{
 //	  "api_liveness": {
 //	    "ok": false,
+//	    "severity": "critical",
 //	    "error": "api liveness endpoint returned non-ok status: 404 Not Found"
 //	  },
 //	  "postgres": {
-//	    "ok": true
+//	    "ok": true,
+//	    "severity": "critical"
 //	  },
 //	  "s3": {
-//	    "ok": true
+//	    "ok": true,
+//	    "severity": "degraded"
 //	  }
 //	}
+//
+// Only failing critical checks fail the ready probe, see
+// AddReadyFunctionWithSeverity.
+//
+// Custom admin routes can be added using Handle(), and middleware, like
+// authentication or IP filtering, can be added using Use().
 type HealthServer struct {
 	logger         *slog.Logger
 	testServer     *httptest.Server
 	server         *http.Server
-	readyFunctions map[string]ReadyFunc
+	mux            *http.ServeMux
+	readyFunctions map[string]readyCheck
+
+	m               sync.Mutex
+	metrics         *HealthMetrics
+	snapshotSources map[string]SnapshotFunc
+	middleware      []func(http.Handler) http.Handler
+	handler         atomic.Pointer[http.Handler]
 }
 
 // NewHealthServer creates a new health server that will listen to the provided
 // address.
 func NewHealthServer(logger *slog.Logger, addr string) *HealthServer {
 	s := HealthServer{
-		logger:         logger,
-		readyFunctions: make(map[string]ReadyFunc),
+		logger:          logger,
+		readyFunctions:  make(map[string]readyCheck),
+		snapshotSources: make(map[string]SnapshotFunc),
 	}
 
+	s.setUpMux()
+
 	s.server = &http.Server{
 		Addr:              addr,
-		Handler:           s.setUpMux(),
+		Handler:           http.HandlerFunc(s.serveHTTP),
 		ReadHeaderTimeout: 1 * time.Second,
 	}
 
@@ -59,11 +143,14 @@ func NewHealthServer(logger *slog.Logger, addr string) *HealthServer {
 
 func NewTestHealthServer(logger *slog.Logger) *HealthServer {
 	s := HealthServer{
-		logger:         logger,
-		readyFunctions: make(map[string]ReadyFunc),
+		logger:          logger,
+		readyFunctions:  make(map[string]readyCheck),
+		snapshotSources: make(map[string]SnapshotFunc),
 	}
 
-	s.testServer = httptest.NewServer(s.setUpMux())
+	s.setUpMux()
+
+	s.testServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
 
 	return &s
 }
@@ -76,9 +163,15 @@ func (s *HealthServer) Addr() string {
 	return s.server.Addr
 }
 
-func (s *HealthServer) setUpMux() *http.ServeMux {
+func (s *HealthServer) setUpMux() {
 	mux := http.NewServeMux()
 
+	s.mux = mux
+
+	var handler http.Handler = mux
+
+	s.handler.Store(&handler)
+
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -86,15 +179,60 @@ func (s *HealthServer) setUpMux() *http.ServeMux {
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 
 	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/debug/snapshot", http.HandlerFunc(s.snapshotHandler))
 	mux.Handle("/metrics", promhttp.Handler())
 	mux.Handle("/health/ready", http.HandlerFunc(s.readyHandler))
+}
+
+func (s *HealthServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
 
-	return mux
+// Handle registers a handler for the given pattern, see http.ServeMux for the
+// pattern syntax. Use this to add admin endpoints instead of running a separate
+// HTTP server.
+func (s *HealthServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a handler function for the given pattern.
+func (s *HealthServer) HandleFunc(
+	pattern string, handler func(http.ResponseWriter, *http.Request),
+) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Use adds middleware that will be applied to all requests to the health
+// server, including the built in endpoints. Middleware is applied in the order
+// it was added, the first middleware is the outermost.
+func (s *HealthServer) Use(middleware ...func(http.Handler) http.Handler) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.middleware = append(s.middleware, middleware...)
+
+	var handler http.Handler = s.mux
+
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		handler = s.middleware[i](handler)
+	}
+
+	s.handler.Store(&handler)
+}
+
+// SetMetrics makes the health server report the results of ready checks as
+// metrics.
+func (s *HealthServer) SetMetrics(m *HealthMetrics) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.metrics = m
 }
 
 type readyResult struct {
-	Ok    bool   `json:"ok"`
-	Error string `json:"error,omitempty"`
+	Ok       bool          `json:"ok"`
+	Severity ReadySeverity `json:"severity"`
+	Error    string        `json:"error,omitempty"`
 }
 
 func (s *HealthServer) readyHandler(
@@ -102,27 +240,44 @@ func (s *HealthServer) readyHandler(
 ) {
 	var failed bool
 
+	s.m.Lock()
+	metrics := s.metrics
+	s.m.Unlock()
+
 	result := make(map[string]readyResult)
 
-	for name, fn := range s.readyFunctions {
-		err := fn(req.Context())
+	for name, check := range s.readyFunctions {
+		err := check.fn(req.Context())
+
+		metrics.observe(name, check.severity, err == nil)
+
 		if err != nil {
-			failed = true
+			level := slog.LevelWarn
+
+			if check.severity == ReadySeverityCritical {
+				failed = true
+				level = slog.LevelError
+			}
 
-			s.logger.Error("healthcheck failed",
+			s.logger.Log(req.Context(), level, "healthcheck failed",
 				LogKeyName, name,
 				LogKeyError, err,
+				LogKeySeverity, check.severity,
 			)
 
 			result[name] = readyResult{
-				Ok:    false,
-				Error: err.Error(),
+				Ok:       false,
+				Severity: check.severity,
+				Error:    err.Error(),
 			}
 
 			continue
 		}
 
-		result[name] = readyResult{Ok: true}
+		result[name] = readyResult{
+			Ok:       true,
+			Severity: check.severity,
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -144,10 +299,52 @@ func (s *HealthServer) readyHandler(
 // with debugging if the underlying check fails.
 type ReadyFunc func(ctx context.Context) error
 
-// AddReadyFunction adds a function that will be called when a client requests
-// "/health/ready".
+// AddReadyFunction adds a critical ready function that will be called when a
+// client requests "/health/ready".
 func (s *HealthServer) AddReadyFunction(name string, fn ReadyFunc) {
-	s.readyFunctions[name] = fn
+	s.AddReadyFunctionWithSeverity(name, ReadySeverityCritical, fn)
+}
+
+// AddReadyFunctionWithSeverity adds a ready function with the given severity.
+// Only failing critical functions fail the ready probe, failures of degraded
+// and informational functions are reported in the response and metrics, but
+// don't take the application out of rotation. Unknown severities are treated
+// as critical.
+func (s *HealthServer) AddReadyFunctionWithSeverity(
+	name string, severity ReadySeverity, fn ReadyFunc,
+) {
+	switch severity {
+	case ReadySeverityCritical, ReadySeverityDegraded,
+		ReadySeverityInformational:
+	case "":
+		severity = ReadySeverityCritical
+	default:
+		s.logger.Warn("unknown ready function severity, treating it as critical",
+			LogKeyName, name,
+			LogKeySeverity, severity)
+
+		severity = ReadySeverityCritical
+	}
+
+	s.readyFunctions[name] = readyCheck{
+		fn:       fn,
+		severity: severity,
+	}
+}
+
+// EnablePreStop adds a "/health/prestop" endpoint that can be used as a
+// Kubernetes preStop hook, and a "shutdown" ready function that fails once stop
+// has been triggered. The application must call GracefulShutdown.MarkDrained
+// once it has drained, see GracefulShutdown.PreStopHandler().
+func (s *HealthServer) EnablePreStop(gs *GracefulShutdown) {
+	s.Handle("/health/prestop", gs.PreStopHandler())
+	s.AddReadyFunction("shutdown", gs.ReadyCheck())
+}
+
+// AddWarmup adds a "warmup" ready function that fails until the warm-up has
+// completed.
+func (s *HealthServer) AddWarmup(w *Warmup) {
+	s.AddReadyFunction("warmup", w.ReadyCheck())
 }
 
 // Close stops the health server.
@@ -177,6 +374,18 @@ func (s *HealthServer) ListenAndServe(ctx context.Context) error {
 	return nil
 }
 
+// Serve starts the health server on the provided listener, shutting it down if
+// the context gets cancelled.
+func (s *HealthServer) Serve(ctx context.Context, ln net.Listener) error {
+	if s.server != nil {
+		return ServeContext(ctx, s.server, ln, 5*time.Second)
+	} else {
+		<-ctx.Done()
+	}
+
+	return nil
+}
+
 // LivenessReadyCheck returns a ReadyFunc that verifies that an endpoint aswers
 // to GET requests with 200 OK.
 func LivenessReadyCheck(endpoint string) ReadyFunc {
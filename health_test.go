@@ -0,0 +1,211 @@
+package elephantine_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestHealthServerRoutes(t *testing.T) {
+	health := elephantine.NewTestHealthServer(slog.Default())
+
+	t.Cleanup(func() {
+		_ = health.Close()
+	})
+
+	health.HandleFunc("GET /admin/hello", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	health.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Admin") != "yes" {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	get := func(path string, admin bool) (int, string) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(test.Context(t),
+			http.MethodGet, "http://"+health.Addr()+path, nil)
+		test.Must(t, err, "create request")
+
+		if admin {
+			req.Header.Set("X-Admin", "yes")
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		test.Must(t, err, "perform request")
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		test.Must(t, err, "read response")
+
+		return res.StatusCode, string(body)
+	}
+
+	status, body := get("/admin/hello", true)
+
+	test.Equal(t, http.StatusOK, status, "serve the custom route")
+	test.Equal(t, "hello", body, "get the custom route response")
+
+	status, _ = get("/admin/hello", false)
+
+	test.Equal(t, http.StatusForbidden, status,
+		"apply middleware to custom routes")
+
+	status, _ = get("/health/ready", false)
+
+	test.Equal(t, http.StatusForbidden, status,
+		"apply middleware to built in routes")
+
+	status, _ = get("/health/ready", true)
+
+	test.Equal(t, http.StatusOK, status, "serve the built in routes")
+}
+
+func TestHealthServerSnapshot(t *testing.T) {
+	health := elephantine.NewTestHealthServer(slog.Default())
+
+	t.Cleanup(func() {
+		_ = health.Close()
+	})
+
+	health.AddSnapshotSource("cache", func(_ context.Context) (any, error) {
+		return map[string]int{"entries": 3}, nil
+	})
+
+	health.AddSnapshotSource("broken", func(_ context.Context) (any, error) {
+		return nil, errors.New("unavailable")
+	})
+
+	req, err := http.NewRequestWithContext(test.Context(t),
+		http.MethodGet, "http://"+health.Addr()+"/debug/snapshot", nil)
+	test.Must(t, err, "create request")
+
+	res, err := http.DefaultClient.Do(req)
+	test.Must(t, err, "perform request")
+
+	defer res.Body.Close()
+
+	var snapshot struct {
+		Components map[string]struct {
+			Value map[string]any `json:"value"`
+			Error string         `json:"error"`
+		} `json:"components"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&snapshot)
+	test.Must(t, err, "decode snapshot")
+
+	test.Equal[any](t, float64(3), snapshot.Components["cache"].Value["entries"],
+		"include the registered source")
+	test.Equal(t, "unavailable", snapshot.Components["broken"].Error,
+		"include errors from failing sources")
+
+	_, ok := snapshot.Components["runtime"].Value["goroutines"]
+
+	test.Equal(t, true, ok, "include the runtime snapshot")
+}
+
+func TestHealthServerReadySeverity(t *testing.T) {
+	health := elephantine.NewTestHealthServer(slog.Default())
+
+	t.Cleanup(func() {
+		_ = health.Close()
+	})
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewHealthMetrics(reg)
+	test.Must(t, err, "create health metrics")
+
+	health.SetMetrics(metrics)
+
+	failing := func(_ context.Context) error {
+		return errors.New("unavailable")
+	}
+
+	health.AddReadyFunction("postgres", func(_ context.Context) error {
+		return nil
+	})
+	health.AddReadyFunctionWithSeverity("search",
+		elephantine.ReadySeverityDegraded, failing)
+	health.AddReadyFunctionWithSeverity("cache",
+		elephantine.ReadySeverityInformational, failing)
+
+	check := func() (int, map[string]map[string]any) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(test.Context(t),
+			http.MethodGet, "http://"+health.Addr()+"/health/ready", nil)
+		test.Must(t, err, "create request")
+
+		res, err := http.DefaultClient.Do(req)
+		test.Must(t, err, "perform request")
+
+		defer res.Body.Close()
+
+		var result map[string]map[string]any
+
+		err = json.NewDecoder(res.Body).Decode(&result)
+		test.Must(t, err, "decode ready response")
+
+		return res.StatusCode, result
+	}
+
+	status, result := check()
+
+	test.Equal(t, http.StatusOK, status,
+		"stay ready when only non-critical checks fail")
+	test.Equal[any](t, false, result["search"]["ok"],
+		"report the failing degraded check")
+	test.Equal[any](t, "degraded", result["search"]["severity"],
+		"report the severity of the check")
+
+	health.AddReadyFunction("queue", failing)
+
+	status, result = check()
+
+	test.Equal(t, http.StatusInternalServerError, status,
+		"fail when a critical check fails")
+	test.Equal[any](t, "critical", result["queue"]["severity"],
+		"default to critical checks")
+
+	expected := `
+# HELP health_check_ok Set to 1 if the last run of a ready check succeeded, 0 if it failed.
+# TYPE health_check_ok gauge
+health_check_ok{check="cache",severity="informational"} 0
+health_check_ok{check="postgres",severity="critical"} 1
+health_check_ok{check="queue",severity="critical"} 0
+health_check_ok{check="search",severity="degraded"} 0
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"health_check_ok")
+	test.Must(t, err, "report check results as metrics")
+
+	health.AddReadyFunctionWithSeverity("index", "Critical", failing)
+
+	_, result = check()
+
+	test.Equal[any](t, "critical", result["index"]["severity"],
+		"treat unknown severities as critical")
+}
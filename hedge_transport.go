@@ -0,0 +1,259 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HedgeTransportMetrics are metrics for hedged HTTP client requests.
+type HedgeTransportMetrics struct {
+	hedges *prometheus.CounterVec
+	wins   *prometheus.CounterVec
+}
+
+// NewHedgeTransportMetrics registers hedging metrics with the provided
+// registerer.
+func NewHedgeTransportMetrics(
+	registerer prometheus.Registerer,
+) (*HedgeTransportMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := HedgeTransportMetrics{
+		hedges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_request_hedges_total",
+			Help: "Number of hedged requests sent because the original request was slow.",
+		}, []string{"client"}),
+		wins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_request_hedge_wins_total",
+			Help: "Number of requests where the response to a hedged request was used, by the attempt that won.",
+		}, []string{"client", "attempt"}),
+	}
+
+	collectors := []prometheus.Collector{m.hedges, m.wins}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+func (m *HedgeTransportMetrics) hedged(client string) {
+	if m == nil {
+		return
+	}
+
+	m.hedges.WithLabelValues(client).Inc()
+}
+
+func (m *HedgeTransportMetrics) won(client string, attempt int) {
+	if m == nil {
+		return
+	}
+
+	m.wins.WithLabelValues(client, strconv.Itoa(attempt)).Inc()
+}
+
+// HedgeOptions controls the behaviour of a HedgeTransport.
+type HedgeOptions struct {
+	// Name of the client, used to label metrics.
+	Name string
+	// Delay is how long to wait for a response before sending another
+	// request. Defaults to 100ms.
+	Delay time.Duration
+	// MaxHedges is the maximum number of extra requests that are sent
+	// for a single request. Defaults to 1.
+	MaxHedges int
+	// Metrics is used to count hedged requests if set.
+	Metrics *HedgeTransportMetrics
+}
+
+// HedgeTransport is a http.RoundTripper that sends another copy of a GET or
+// HEAD request if no response has been received after a delay, and uses the
+// first response that arrives. The other requests are cancelled. Any response
+// counts, regardless of its status code, errors are only returned once all
+// requests have failed. Requests with other methods are passed through as-is.
+type HedgeTransport struct {
+	base http.RoundTripper
+	opts HedgeOptions
+}
+
+// NewHedgeTransport creates a hedging transport that wraps the base transport.
+// The base defaults to http.DefaultTransport.
+func NewHedgeTransport(base http.RoundTripper, opts HedgeOptions) *HedgeTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if opts.Delay == 0 {
+		opts.Delay = 100 * time.Millisecond
+	}
+
+	if opts.MaxHedges == 0 {
+		opts.MaxHedges = 1
+	}
+
+	return &HedgeTransport{
+		base: base,
+		opts: opts,
+	}
+}
+
+// Hedging wraps the transport of the client with a HedgeTransport.
+func Hedging(opts HedgeOptions) HTTPClientOption {
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return NewHedgeTransport(base, opts)
+	})
+}
+
+type hedgeResult struct {
+	attempt int
+	res     *http.Response
+	err     error
+	cancel  context.CancelFunc
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HedgeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hedgeableRequest(req) {
+		return t.base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	results := make(chan hedgeResult, t.opts.MaxHedges+1)
+	cancels := make(map[int]context.CancelFunc)
+
+	send := func(attempt int) {
+		ctx, cancel := context.WithCancel(req.Context())
+
+		cancels[attempt] = cancel
+
+		go func() {
+			res, err := t.base.RoundTrip(req.Clone(ctx))
+
+			results <- hedgeResult{
+				attempt: attempt,
+				res:     res,
+				err:     err,
+				cancel:  cancel,
+			}
+		}()
+	}
+
+	send(0)
+
+	timer := time.NewTimer(t.opts.Delay)
+	defer timer.Stop()
+
+	var (
+		sent    = 1
+		pending = 1
+		lastErr error
+	)
+
+	for {
+		select {
+		case <-timer.C:
+			if sent > t.opts.MaxHedges {
+				continue
+			}
+
+			t.opts.Metrics.hedged(t.opts.Name)
+
+			send(sent)
+
+			sent++
+			pending++
+
+			timer.Reset(t.opts.Delay)
+		case r := <-results:
+			pending--
+
+			if r.err != nil {
+				r.cancel()
+
+				lastErr = r.err
+
+				// Keep waiting if other attempts are in
+				// flight.
+				if pending > 0 {
+					continue
+				}
+
+				return nil, lastErr //nolint:wrapcheck
+			}
+
+			for attempt, cancel := range cancels {
+				if attempt != r.attempt {
+					cancel()
+				}
+			}
+
+			if pending > 0 {
+				go discardHedgeResults(results, pending)
+			}
+
+			if r.attempt > 0 {
+				t.opts.Metrics.won(t.opts.Name, r.attempt)
+			}
+
+			// The context of the winning request must live until
+			// the body has been read.
+			r.res.Body = &cancelOnClose{
+				ReadCloser: r.res.Body,
+				cancel:     r.cancel,
+			}
+
+			return r.res, nil
+		}
+	}
+}
+
+// discardHedgeResults closes the responses of cancelled requests.
+func discardHedgeResults(results chan hedgeResult, pending int) {
+	for range pending {
+		r := <-results
+		if r.res != nil {
+			_ = r.res.Body.Close()
+		}
+	}
+}
+
+func hedgeableRequest(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead:
+		return true
+	}
+
+	return false
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+
+	c.cancel()
+
+	return err //nolint:wrapcheck
+}
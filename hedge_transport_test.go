@@ -0,0 +1,90 @@
+package elephantine_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestHedgeTransport(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+
+			_, _ = w.Write([]byte("slow"))
+
+			return
+		}
+
+		_, _ = w.Write([]byte("fast"))
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewHedgeTransportMetrics(reg)
+	test.Must(t, err, "create hedge metrics")
+
+	client := elephantine.NewHTTPClient(10*time.Second,
+		elephantine.Hedging(elephantine.HedgeOptions{
+			Name:    "lookup",
+			Delay:   20 * time.Millisecond,
+			Metrics: metrics,
+		}))
+
+	start := time.Now()
+
+	res, err := client.Get(server.URL)
+	test.Must(t, err, "perform request")
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	_ = res.Body.Close()
+
+	test.Equal(t, "fast", string(body), "use the first response")
+
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("expected the hedged request to win")
+	}
+
+	expected := `
+# HELP client_request_hedge_wins_total Number of requests where the response to a hedged request was used, by the attempt that won.
+# TYPE client_request_hedge_wins_total counter
+client_request_hedge_wins_total{attempt="1",client="lookup"} 1
+# HELP client_request_hedges_total Number of hedged requests sent because the original request was slow.
+# TYPE client_request_hedges_total counter
+client_request_hedges_total{client="lookup"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"client_request_hedges_total", "client_request_hedge_wins_total")
+	test.Must(t, err, "count the hedged request")
+
+	res, err = client.Post(server.URL, "text/plain", strings.NewReader("data"))
+	test.Must(t, err, "perform POST request")
+
+	_ = res.Body.Close()
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"client_request_hedges_total", "client_request_hedge_wins_total")
+	test.Must(t, err, "not hedge POST requests")
+}
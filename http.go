@@ -3,10 +3,14 @@ package elephantine
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -22,11 +26,55 @@ type HTTPError struct {
 	StatusCode int
 	Header     http.Header
 	Body       io.Reader
+	// Details is the structured error payload, if any. Set by
+	// HTTPErrorFromResponse for JSON error responses.
+	Details *HTTPErrorDetails
+	// RetryAfter is the wait requested by the Retry-After header of the
+	// response, zero if the header was missing.
+	RetryAfter time.Duration
+	// Truncated is set by HTTPErrorFromResponseLimited if the body was
+	// larger than the limit.
+	Truncated bool
+}
+
+// HTTPErrorDetails is a structured error payload. Covers both problem details
+// (RFC 9457, "application/problem+json") and the common
+// {"error": ..., "detail": ...} format.
+type HTTPErrorDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Code     string `json:"code,omitempty"`
 }
 
 // Error implements the error interface.
 func (e *HTTPError) Error() string {
-	return e.Status
+	msg := e.Message()
+	if msg == "" {
+		return e.Status
+	}
+
+	return e.Status + ": " + msg
+}
+
+// Message returns the most descriptive message of the error details, or an
+// empty string if the error has no details.
+func (e *HTTPError) Message() string {
+	if e.Details == nil {
+		return ""
+	}
+
+	switch {
+	case e.Details.Detail != "":
+		return e.Details.Detail
+	case e.Details.Error != "":
+		return e.Details.Error
+	}
+
+	return e.Details.Title
 }
 
 // NewHTTPError creates a new HTTPError with the given status code and response
@@ -42,6 +90,30 @@ func NewHTTPError(statusCode int, message string) *HTTPError {
 	}
 }
 
+// NewHTTPErrorWithDetails creates a new HTTPError that responds with the details
+// as a "application/problem+json" body. The status of the details defaults to
+// the status code.
+func NewHTTPErrorWithDetails(
+	statusCode int, details HTTPErrorDetails,
+) *HTTPError {
+	if details.Status == 0 {
+		details.Status = statusCode
+	}
+
+	// Marshalling a struct of strings and ints can't fail.
+	body, _ := json.Marshal(details)
+
+	return &HTTPError{
+		Status:     http.StatusText(statusCode),
+		StatusCode: statusCode,
+		Header: http.Header{
+			"Content-Type": []string{"application/problem+json"},
+		},
+		Body:    bytes.NewReader(body),
+		Details: &details,
+	}
+}
+
 // HTTPErrorf creates a HTTPError using a format string.
 func HTTPErrorf(statusCode int, format string, a ...any) *HTTPError {
 	return NewHTTPError(statusCode, fmt.Sprintf(format, a...))
@@ -63,28 +135,84 @@ func IsHTTPErrorWithStatus(err error, status int) bool {
 // consume and create a copy of the response body, so don't use it in a scenario
 // where you expect really large error response bodies.
 //
+// JSON bodies, like "application/problem+json", are parsed into the Details of
+// the error, and the Retry-After header is parsed into RetryAfter.
+//
 // If we fail to copy the response body the error will be joined with the
 // HTTPError.
 func HTTPErrorFromResponse(res *http.Response) error {
+	return HTTPErrorFromResponseLimited(res, 0)
+}
+
+// httpErrorDrainLimit is how much of an oversized error body we read past the
+// limit to let the connection be reused. Larger bodies are cheaper to deal with
+// by closing the connection.
+const httpErrorDrainLimit = 1 << 16
+
+// HTTPErrorFromResponseLimited works like HTTPErrorFromResponse, but only
+// buffers up to maxBytes of the response body, zero means no limit. Truncated
+// is set on the error if the body was larger than that. The rest of the body
+// is drained, up to 64KiB, so that the connection can be reused, and the body
+// is closed.
+func HTTPErrorFromResponseLimited(res *http.Response, maxBytes int64) error {
 	e := HTTPError{
 		Status:     res.Status,
 		StatusCode: res.StatusCode,
 		Header:     res.Header,
 	}
 
+	e.RetryAfter, _ = parseRetryAfter(res.Header.Get("Retry-After"))
+
 	var buf bytes.Buffer
 
 	e.Body = &buf
 
-	_, err := io.Copy(&buf, res.Body)
+	var body io.Reader = res.Body
+
+	if maxBytes > 0 {
+		body = io.LimitReader(res.Body, maxBytes)
+	}
+
+	_, err := io.Copy(&buf, body)
 	if err != nil {
 		return errors.Join(&e,
 			fmt.Errorf("failed to read response body: %w", err))
 	}
 
+	if maxBytes > 0 {
+		n, _ := io.Copy(io.Discard,
+			io.LimitReader(res.Body, httpErrorDrainLimit))
+
+		e.Truncated = n > 0
+
+		_ = res.Body.Close()
+	}
+
+	// Truncated JSON can't be parsed.
+	if !e.Truncated && isJSONContentType(res.Header.Get("Content-Type")) {
+		var details HTTPErrorDetails
+
+		// Bodies that aren't JSON objects are left for the caller to
+		// deal with.
+		err := json.Unmarshal(buf.Bytes(), &details)
+		if err == nil {
+			e.Details = &details
+		}
+	}
+
 	return &e
 }
 
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
 // ListenAndServeContext will call ListenAndServe() for the provided server and
 // then Shutdown() if the context is cancelled.
 //
@@ -93,6 +221,60 @@ func HTTPErrorFromResponse(res *http.Response) error {
 func ListenAndServeContext(
 	ctx context.Context, server *http.Server,
 	shutdownTimeout time.Duration,
+) error {
+	return serveContext(ctx, server, shutdownTimeout, server.ListenAndServe)
+}
+
+// ListenAndServeTLSContext will call ListenAndServeTLS() for the provided
+// server and then Shutdown() if the context is cancelled. The certificate and
+// key files can be left empty if the server TLSConfig provides the
+// certificates.
+//
+// Check `errors.Is(err, http.ErrServerClosed)` to differentiate between a
+// graceful server close and other errors.
+func ListenAndServeTLSContext(
+	ctx context.Context, server *http.Server,
+	certFile string, keyFile string,
+	shutdownTimeout time.Duration,
+) error {
+	return serveContext(ctx, server, shutdownTimeout, func() error {
+		return server.ListenAndServeTLS(certFile, keyFile)
+	})
+}
+
+// ServeContext will call Serve() for the provided server and listener and then
+// Shutdown() if the context is cancelled.
+//
+// Check `errors.Is(err, http.ErrServerClosed)` to differentiate between a
+// graceful server close and other errors.
+func ServeContext(
+	ctx context.Context, server *http.Server, ln net.Listener,
+	shutdownTimeout time.Duration,
+) error {
+	return serveContext(ctx, server, shutdownTimeout, func() error {
+		return server.Serve(ln)
+	})
+}
+
+// ServeTLSContext will call ServeTLS() for the provided server and listener
+// and then Shutdown() if the context is cancelled. Use it with listeners from
+// socket activation, or with ephemeral ports in tests.
+//
+// Check `errors.Is(err, http.ErrServerClosed)` to differentiate between a
+// graceful server close and other errors.
+func ServeTLSContext(
+	ctx context.Context, server *http.Server, ln net.Listener,
+	certFile string, keyFile string,
+	shutdownTimeout time.Duration,
+) error {
+	return serveContext(ctx, server, shutdownTimeout, func() error {
+		return server.ServeTLS(ln, certFile, keyFile)
+	})
+}
+
+func serveContext(
+	ctx context.Context, server *http.Server,
+	shutdownTimeout time.Duration, serve func() error,
 ) error {
 	closed := make(chan struct{})
 
@@ -111,7 +293,7 @@ func ListenAndServeContext(
 		}
 	}()
 
-	err := server.ListenAndServe()
+	err := serve()
 	if errors.Is(err, http.ErrServerClosed) {
 		// Listens and serve exits immediately when server.Shutdown() is
 		// called, wait for it to actually be closed, gracefully or
@@ -128,21 +310,82 @@ func ListenAndServeContext(
 
 // HTTPClientInstrumentation provides a way to instrument HTTP clients.
 type HTTPClientInstrumentation struct {
-	inFlight *prometheus.GaugeVec
-	counter  *prometheus.CounterVec
-	trace    *promhttp.InstrumentTrace
-	histVec  *prometheus.HistogramVec
+	inFlight  *prometheus.GaugeVec
+	counter   *prometheus.CounterVec
+	trace     *promhttp.InstrumentTrace
+	histVec   *prometheus.HistogramVec
+	reqSize   *prometheus.HistogramVec
+	resSize   *prometheus.HistogramVec
+	hosts     map[string]bool
+	endpoints map[string]bool
+}
+
+// HTTPClientInstrumentationOptions controls the metrics of a
+// HTTPClientInstrumentation.
+type HTTPClientInstrumentationOptions struct {
+	// Hosts enables the "host" label on the request counter, duration, and
+	// size metrics. Only the listed hosts are used as label values, requests
+	// to other hosts are labelled "other" to cap the cardinality. Hosts are
+	// matched without the port.
+	Hosts []string
+	// Endpoints enables the "endpoint" label on the request counter,
+	// duration, and size metrics, see WithClientEndpoint. Only the listed
+	// endpoints are used as label values, requests for other endpoints are
+	// labelled "other", and requests without an endpoint are labelled
+	// "unknown".
+	Endpoints []string
+	// SizeBuckets are the buckets of the request and response size
+	// histograms, in bytes. Defaults to exponential buckets from 256B to
+	// 4MiB.
+	SizeBuckets []float64
 }
 
 // NewHTTPClientIntrumentation registers a set of HTTP client metrics with the
 // provided registerer.
 func NewHTTPClientIntrumentation(
 	registerer prometheus.Registerer,
+) (*HTTPClientInstrumentation, error) {
+	return NewHTTPClientInstrumentationWithOptions(
+		registerer, HTTPClientInstrumentationOptions{})
+}
+
+// NewHTTPClientInstrumentationWithOptions registers a set of HTTP client
+// metrics with the provided registerer.
+func NewHTTPClientInstrumentationWithOptions(
+	registerer prometheus.Registerer, opts HTTPClientInstrumentationOptions,
 ) (*HTTPClientInstrumentation, error) {
 	if registerer == nil {
 		registerer = prometheus.DefaultRegisterer
 	}
 
+	if len(opts.SizeBuckets) == 0 {
+		opts.SizeBuckets = prometheus.ExponentialBuckets(256, 4, 8)
+	}
+
+	labels := []string{"client"}
+
+	var hosts map[string]bool
+
+	if len(opts.Hosts) > 0 {
+		labels = append(labels, "host")
+		hosts = make(map[string]bool, len(opts.Hosts))
+
+		for _, h := range opts.Hosts {
+			hosts[strings.ToLower(h)] = true
+		}
+	}
+
+	var endpoints map[string]bool
+
+	if len(opts.Endpoints) > 0 {
+		labels = append(labels, "endpoint")
+		endpoints = make(map[string]bool, len(opts.Endpoints))
+
+		for _, e := range opts.Endpoints {
+			endpoints[e] = true
+		}
+	}
+
 	inFlightGauge := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "client_in_flight_requests",
@@ -156,7 +399,7 @@ func NewHTTPClientIntrumentation(
 			Name: "client_requests_total",
 			Help: "A counter for requests from the wrapped client.",
 		},
-		[]string{"client", "code", "method"},
+		append(slices.Clone(labels), "code", "method"),
 	)
 
 	// dnsLatencyVec uses custom buckets based on expected dns durations.
@@ -185,19 +428,37 @@ func NewHTTPClientIntrumentation(
 		[]string{"event"},
 	)
 
-	// histVec has no labels, making it a zero-dimensional ObserverVec.
 	histVec := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "client_request_duration_seconds",
 			Help:    "A histogram of request latencies.",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"client"},
+		labels,
+	)
+
+	reqSizeVec := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "client_request_size_bytes",
+			Help:    "A histogram of request body sizes.",
+			Buckets: opts.SizeBuckets,
+		},
+		labels,
+	)
+
+	resSizeVec := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "client_response_size_bytes",
+			Help:    "A histogram of response body sizes.",
+			Buckets: opts.SizeBuckets,
+		},
+		labels,
 	)
 
 	collectors := []prometheus.Collector{
 		inFlightGauge, counter,
 		tlsLatencyVec, dnsLatencyVec, histVec,
+		reqSizeVec, resSizeVec,
 	}
 
 	for i, c := range collectors {
@@ -227,49 +488,127 @@ func NewHTTPClientIntrumentation(
 	}
 
 	ci := HTTPClientInstrumentation{
-		inFlight: inFlightGauge,
-		counter:  counter,
-		trace:    trace,
-		histVec:  histVec,
+		inFlight:  inFlightGauge,
+		counter:   counter,
+		trace:     trace,
+		histVec:   histVec,
+		reqSize:   reqSizeVec,
+		resSize:   resSizeVec,
+		hosts:     hosts,
+		endpoints: endpoints,
 	}
 
 	return &ci, nil
 }
 
 // Client instruments the HTTP client transport with the standard promhttp
-// metrics. The client_requests_total, client_in_flight_requests, and
-// client_request_duration_seconds metrics will be labelled with the client
-// name.
+// metrics. The client_requests_total, client_in_flight_requests,
+// client_request_duration_seconds, client_request_size_bytes, and
+// client_response_size_bytes metrics will be labelled with the client name.
+// Use the Tracing client option to add distributed tracing.
 func (ci *HTTPClientInstrumentation) Client(name string, client *http.Client) error {
 	transport := client.Transport
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
 
-	cCounter, err := ci.counter.CurryWith(prometheus.Labels{
-		"client": name,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to curry request counter: %w", err)
+	if ci.hosts == nil {
+		instrumented, err := ci.instrument(transport, prometheus.Labels{
+			"client": name,
+		})
+		if err != nil {
+			return err
+		}
+
+		client.Transport = ci.instrumentInFlight(name, instrumented)
+
+		return nil
 	}
 
-	cHistVec, err := ci.histVec.CurryWith(prometheus.Labels{
+	// Build a separately curried transport chain for every host label
+	// value up front, so that requests only have to do a map lookup.
+	byHost := make(map[string]http.RoundTripper, len(ci.hosts)+1)
+
+	for host := range ci.hosts {
+		rt, err := ci.instrument(transport, prometheus.Labels{
+			"client": name,
+			"host":   host,
+		})
+		if err != nil {
+			return err
+		}
+
+		byHost[host] = rt
+	}
+
+	other, err := ci.instrument(transport, prometheus.Labels{
 		"client": name,
+		"host":   "other",
 	})
 	if err != nil {
-		return fmt.Errorf("failed to curry duration histogram: %w", err)
+		return err
 	}
 
-	transport = promhttp.InstrumentRoundTripperDuration(cHistVec, transport)
-	transport = promhttp.InstrumentRoundTripperTrace(ci.trace, transport)
-	transport = promhttp.InstrumentRoundTripperCounter(cCounter, transport)
-	transport = ci.instrumentInFlight(name, transport)
+	client.Transport = ci.instrumentInFlight(name, promhttp.RoundTripperFunc(
+		func(r *http.Request) (*http.Response, error) {
+			rt, ok := byHost[strings.ToLower(r.URL.Hostname())]
+			if !ok {
+				rt = other
+			}
 
-	client.Transport = transport
+			return rt.RoundTrip(r) //nolint:wrapcheck
+		}))
 
 	return nil
 }
 
+func (ci *HTTPClientInstrumentation) instrument(
+	transport http.RoundTripper, labels prometheus.Labels,
+) (http.RoundTripper, error) {
+	cCounter, err := ci.counter.CurryWith(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to curry request counter: %w", err)
+	}
+
+	cHistVec, err := ci.histVec.CurryWith(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to curry duration histogram: %w", err)
+	}
+
+	reqSize, err := ci.reqSize.CurryWith(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to curry request size histogram: %w", err)
+	}
+
+	resSize, err := ci.resSize.CurryWith(labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to curry response size histogram: %w", err)
+	}
+
+	var opts []promhttp.Option
+
+	dynamicLabels := func(_ context.Context) prometheus.Labels {
+		return nil
+	}
+
+	if ci.endpoints != nil {
+		opts = append(opts,
+			promhttp.WithLabelFromCtx("endpoint", ci.endpointLabel))
+
+		dynamicLabels = func(ctx context.Context) prometheus.Labels {
+			return prometheus.Labels{"endpoint": ci.endpointLabel(ctx)}
+		}
+	}
+
+	transport = ensureResponseRequest(transport)
+	transport = instrumentSize(reqSize, resSize, dynamicLabels, transport)
+	transport = promhttp.InstrumentRoundTripperDuration(cHistVec, transport, opts...)
+	transport = promhttp.InstrumentRoundTripperTrace(ci.trace, transport)
+	transport = promhttp.InstrumentRoundTripperCounter(cCounter, transport, opts...)
+
+	return transport, nil
+}
+
 func (ci *HTTPClientInstrumentation) instrumentInFlight(client string, next http.RoundTripper) promhttp.RoundTripperFunc {
 	return func(r *http.Request) (*http.Response, error) {
 		ci.inFlight.WithLabelValues(client).Inc()
@@ -278,3 +617,117 @@ func (ci *HTTPClientInstrumentation) instrumentInFlight(client string, next http
 		return next.RoundTrip(r)
 	}
 }
+
+type clientEndpointCtxKey struct{}
+
+// WithClientEndpoint returns a context that annotates outgoing requests with
+// the name of the logical endpoint, or operation, that they are for. The name
+// is used as the "endpoint" label of client metrics, see
+// HTTPClientInstrumentationOptions.Endpoints.
+func WithClientEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, clientEndpointCtxKey{}, endpoint)
+}
+
+// ClientEndpoint returns the endpoint name that the context has been annotated
+// with, if any.
+func ClientEndpoint(ctx context.Context) (string, bool) {
+	endpoint, ok := ctx.Value(clientEndpointCtxKey{}).(string)
+
+	return endpoint, ok && endpoint != ""
+}
+
+func (ci *HTTPClientInstrumentation) endpointLabel(ctx context.Context) string {
+	endpoint, ok := ClientEndpoint(ctx)
+
+	switch {
+	case !ok:
+		return "unknown"
+	case !ci.endpoints[endpoint]:
+		return "other"
+	}
+
+	return endpoint
+}
+
+// ensureResponseRequest sets the request of responses that lack one, the
+// promhttp context labels are resolved from the response request.
+func ensureResponseRequest(next http.RoundTripper) promhttp.RoundTripperFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		res, err := next.RoundTrip(r)
+		if res != nil && res.Request == nil {
+			res.Request = r
+		}
+
+		return res, err //nolint:wrapcheck
+	}
+}
+
+// instrumentSize observes the request and response body sizes. Requests with
+// an unknown content length aren't observed, the response size is observed
+// when the body has been read to the end or closed.
+func instrumentSize(
+	reqSize prometheus.ObserverVec, resSize prometheus.ObserverVec,
+	dynamicLabels func(ctx context.Context) prometheus.Labels,
+	next http.RoundTripper,
+) promhttp.RoundTripperFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		labels := dynamicLabels(r.Context())
+
+		if r.ContentLength >= 0 {
+			reqSize.With(labels).Observe(float64(r.ContentLength))
+		}
+
+		res, err := next.RoundTrip(r)
+		if err != nil {
+			return res, err //nolint:wrapcheck
+		}
+
+		// Upgraded connections get a writable body that we can't wrap.
+		if res.StatusCode == http.StatusSwitchingProtocols {
+			return res, nil
+		}
+
+		res.Body = &sizeObservingBody{
+			ReadCloser: res.Body,
+			observer:   resSize.With(labels),
+		}
+
+		return res, nil
+	}
+}
+
+type sizeObservingBody struct {
+	io.ReadCloser
+
+	observer prometheus.Observer
+	size     int64
+	done     bool
+}
+
+func (b *sizeObservingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.size += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		b.observe()
+	}
+
+	return n, err //nolint:wrapcheck
+}
+
+func (b *sizeObservingBody) Close() error {
+	b.observe()
+
+	return b.ReadCloser.Close() //nolint:wrapcheck
+}
+
+func (b *sizeObservingBody) observe() {
+	if b.done {
+		return
+	}
+
+	b.done = true
+
+	b.observer.Observe(float64(b.size))
+}
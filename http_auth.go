@@ -0,0 +1,194 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor extracts the authorization of a request in the same format as
+// the Authorization header: "Bearer [token]". Returns an empty string if the
+// request lacks authorization.
+type TokenExtractor func(r *http.Request) string
+
+// HeaderTokenExtractor extracts authorization from a request header.
+func HeaderTokenExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// QueryTokenExtractor extracts a bearer token from a query parameter, usually
+// "access_token". Useful for SSE, websockets, and browser downloads where
+// headers can't be set. Tokens in URLs end up in browser history and proxy
+// logs, so only use this for endpoints that need it.
+func QueryTokenExtractor(param string) TokenExtractor {
+	return func(r *http.Request) string {
+		token := r.URL.Query().Get(param)
+		if token == "" {
+			return ""
+		}
+
+		return "Bearer " + token
+	}
+}
+
+// CookieTokenExtractor extracts a bearer token from a named cookie.
+func CookieTokenExtractor(name string) TokenExtractor {
+	return func(r *http.Request) string {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return ""
+		}
+
+		return "Bearer " + c.Value
+	}
+}
+
+// ExtractAuthorization returns the authorization from the first extractor that
+// finds it. Uses the Authorization header if no extractors are given.
+func ExtractAuthorization(r *http.Request, extractors ...TokenExtractor) string {
+	if len(extractors) == 0 {
+		return r.Header.Get("Authorization")
+	}
+
+	for _, extract := range extractors {
+		authorization := extract(r)
+		if authorization != "" {
+			return authorization
+		}
+	}
+
+	return ""
+}
+
+// AuthInfoFromRequest extracts and parses the authorization of a request, see
+// ExtractAuthorization. Returns ErrNoAuthorization if no authorization was
+// found.
+func AuthInfoFromRequest(
+	parser AuthInfoParser, r *http.Request, extractors ...TokenExtractor,
+) (*AuthInfo, error) {
+	//nolint:wrapcheck
+	return parser.AuthInfoFromHeader(ExtractAuthorization(r, extractors...))
+}
+
+// HTTPAuthMiddleware returns a middleware that validates the authorization of
+// incoming requests using the parser and adds the resulting AuthInfo to the
+// request context. The Authorization header is used unless extractors are
+// given, see ExtractAuthorization.
+//
+// Requests with invalid authorization get a 401 response. Requests without
+// authorization get a 401 response if auth is required, and are otherwise let
+// through without AuthInfo in the context.
+func HTTPAuthMiddleware(
+	parser AuthInfoParser, required bool, extractors ...TokenExtractor,
+) func(http.Handler) http.Handler {
+	return NewHTTPAuthMiddleware(parser, HTTPAuthOptions{
+		Required:   required,
+		Extractors: extractors,
+	})
+}
+
+// HTTPAuthOptions controls the behaviour of the HTTP auth middleware.
+type HTTPAuthOptions struct {
+	// Required rejects requests without authorization.
+	Required bool
+	// Extractors are used to find the token, see ExtractAuthorization.
+	Extractors []TokenExtractor
+	// Metrics is used to count rejected requests if set.
+	Metrics *AuthFailureMetrics
+	// Impersonation lets clients act as another subject using the
+	// ImpersonateSubjectHeader if set.
+	Impersonation *ImpersonationPolicy
+}
+
+// NewHTTPAuthMiddleware works like HTTPAuthMiddleware, but takes options
+// instead of positional arguments.
+func NewHTTPAuthMiddleware(
+	parser AuthInfoParser, opts HTTPAuthOptions,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return HTTPErrorHandlerFunc(func(
+			w http.ResponseWriter, r *http.Request,
+		) error {
+			auth, err := AuthInfoFromRequest(parser, r, opts.Extractors...)
+
+			switch {
+			case errors.Is(err, ErrNoAuthorization):
+				if opts.Required {
+					opts.Metrics.Failed(AuthFailureMissing)
+
+					return unauthorizedHTTPError(
+						"", "authentication required")
+				}
+			case err != nil:
+				opts.Metrics.Failed(AuthFailureReason(err))
+
+				return unauthorizedHTTPError(
+					"invalid_token", "invalid authorization: "+err.Error())
+			case auth == nil:
+				return NewHTTPError(http.StatusInternalServerError,
+					"invalid auth info parser response")
+			}
+
+			auth, err = impersonateHTTP(r, opts.Impersonation, auth)
+			if err != nil {
+				return err
+			}
+
+			ctx := withAuthFailureMetrics(r.Context(), opts.Metrics)
+
+			if auth != nil {
+				ctx = SetAuthInfo(ctx, auth)
+
+				SetLogMetadata(ctx,
+					LogKeySubject, auth.Claims.Subject,
+				)
+
+				if auth.Actor != "" {
+					SetLogMetadata(ctx, LogKeyActor, auth.Actor)
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			return nil
+		})
+	}
+}
+
+// RequireAnyScopeHTTP is the HTTPError equivalent of RequireAnyScope, for use
+// in handlers created with HTTPErrorHandlerFunc. Denials are counted by the
+// metrics of the HTTP auth middleware, see HTTPAuthOptions.Metrics.
+func RequireAnyScopeHTTP(ctx context.Context, scopes ...string) (*AuthInfo, error) {
+	auth, ok := GetAuthInfo(ctx)
+	if !ok {
+		authFailed(ctx, AuthFailureMissing)
+
+		return nil, unauthorizedHTTPError("", "no anonymous access allowed")
+	}
+
+	if !auth.Claims.HasAnyScope(scopes...) {
+		authFailed(ctx, AuthFailureInsufficientScope)
+
+		return nil, HTTPErrorf(http.StatusForbidden,
+			"one of the scopes %s is required",
+			strings.Join(scopes, ", "))
+	}
+
+	return auth, nil
+}
+
+func unauthorizedHTTPError(code string, message string) *HTTPError {
+	e := NewHTTPError(http.StatusUnauthorized, message)
+
+	challenge := "Bearer"
+	if code != "" {
+		challenge += ` error="` + code + `"`
+	}
+
+	e.Header.Set("WWW-Authenticate", challenge)
+
+	return e
+}
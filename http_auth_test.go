@@ -0,0 +1,185 @@
+package elephantine_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+type httpAuthTestCase struct {
+	Required      bool
+	Authorization string
+	ExpectStatus  int
+}
+
+func TestHTTPAuthMiddleware(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(
+		jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "someone",
+		},
+		Scope: "doc_read",
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	handler := elephantine.HTTPErrorHandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) error {
+		auth, ok := elephantine.GetAuthInfo(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+
+			return nil
+		}
+
+		_, err := elephantine.RequireAnyScopeHTTP(r.Context(), "doc_read")
+		if err != nil {
+			return err
+		}
+
+		test.Equal(t, "core://user/someone", auth.Claims.Subject,
+			"get the expected subject")
+
+		w.WriteHeader(http.StatusOK)
+
+		return nil
+	})
+
+	cases := map[string]httpAuthTestCase{
+		"valid_token": {
+			Required:      true,
+			Authorization: "Bearer " + ss,
+			ExpectStatus:  http.StatusOK,
+		},
+		"missing_required": {
+			Required:     true,
+			ExpectStatus: http.StatusUnauthorized,
+		},
+		"missing_optional": {
+			Required:     false,
+			ExpectStatus: http.StatusNoContent,
+		},
+		"invalid_token": {
+			Required:      false,
+			Authorization: "Bearer nope",
+			ExpectStatus:  http.StatusUnauthorized,
+		},
+	}
+
+	for name := range cases {
+		tc := cases[name]
+
+		t.Run(name, func(t *testing.T) {
+			mw := elephantine.HTTPAuthMiddleware(parser, tc.Required)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			if tc.Authorization != "" {
+				req.Header.Set("Authorization", tc.Authorization)
+			}
+
+			rec := httptest.NewRecorder()
+
+			mw(handler).ServeHTTP(rec, req)
+
+			test.Equal(t, tc.ExpectStatus, rec.Code,
+				"get correct status code")
+		})
+	}
+}
+
+func TestRequireAnyScopeHTTPForbidden(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(
+		jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "someone",
+		},
+		Scope: "doc_read",
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	handler := elephantine.HTTPErrorHandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) error {
+		_, err := elephantine.RequireAnyScopeHTTP(r.Context(),
+			"doc_write", "doc_admin")
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+		return nil
+	})
+
+	mw := elephantine.HTTPAuthMiddleware(parser, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	req.Header.Set("Authorization", "Bearer "+ss)
+
+	rec := httptest.NewRecorder()
+
+	mw(handler).ServeHTTP(rec, req)
+
+	test.Equal(t, http.StatusForbidden, rec.Code,
+		"respond with 403 when the scopes are missing")
+	test.Equal(t, "one of the scopes doc_write, doc_admin is required",
+		strings.TrimSpace(rec.Body.String()),
+		"list the required scopes in the body")
+	test.Equal(t, "", rec.Header().Get("WWW-Authenticate"),
+		"don't challenge an authenticated client")
+}
+
+func TestTokenExtractors(t *testing.T) {
+	extractors := []elephantine.TokenExtractor{
+		elephantine.HeaderTokenExtractor("Authorization"),
+		elephantine.QueryTokenExtractor("access_token"),
+		elephantine.CookieTokenExtractor("session"),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events?access_token=query", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "cookie"})
+
+	test.Equal(t, "Bearer query",
+		elephantine.ExtractAuthorization(req, extractors...),
+		"prefer the query parameter over the cookie")
+
+	req.Header.Set("Authorization", "Bearer header")
+
+	test.Equal(t, "Bearer header",
+		elephantine.ExtractAuthorization(req, extractors...),
+		"prefer the header over the query parameter")
+
+	req = httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "cookie"})
+
+	test.Equal(t, "Bearer cookie",
+		elephantine.ExtractAuthorization(req, extractors...),
+		"fall back to the cookie")
+	test.Equal(t, "",
+		elephantine.ExtractAuthorization(req),
+		"only use the Authorization header by default")
+}
@@ -0,0 +1,372 @@
+package elephantine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/time/rate"
+)
+
+// HTTPClientOption configures a client created by NewHTTPClient.
+type HTTPClientOption func(conf *httpClientConfig)
+
+type httpClientConfig struct {
+	tls      *tls.Config
+	proxy    func(req *http.Request) (*url.URL, error)
+	proxySet bool
+	dial     func(ctx context.Context, network string, addr string) (net.Conn, error)
+	wrappers []func(base http.RoundTripper) http.RoundTripper
+}
+
+// NewHTTPClient creates a HTTP client with the given timeout, zero means no
+// timeout. Transport options are applied in order, so the first option wraps
+// the default transport and the last option is the outermost:
+//
+//	client := elephantine.NewHTTPClient(30*time.Second,
+//		elephantine.RateLimit(10, 20),
+//		elephantine.WrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+//			return elephantine.NewRetryTransport(rt, "backfill", retryMetrics)
+//		}))
+//
+// TLS, proxy, and dial options configure the underlying transport regardless of their
+// position.
+func NewHTTPClient(
+	timeout time.Duration, opts ...HTTPClientOption,
+) *http.Client {
+	var conf httpClientConfig
+
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	transport := http.DefaultTransport
+
+	if conf.tls != nil || conf.proxySet || conf.dial != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+
+		if conf.tls != nil {
+			t.TLSClientConfig = conf.tls
+		}
+
+		if conf.proxySet {
+			t.Proxy = conf.proxy
+		}
+
+		if conf.dial != nil {
+			t.DialContext = conf.dial
+		}
+
+		transport = t
+	}
+
+	for _, wrap := range conf.wrappers {
+		transport = wrap(transport)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// WrapTransport wraps the transport of the client, f.ex. with a RetryTransport
+// or CircuitBreakerTransport.
+func WrapTransport(
+	wrap func(base http.RoundTripper) http.RoundTripper,
+) HTTPClientOption {
+	return func(conf *httpClientConfig) {
+		conf.wrappers = append(conf.wrappers, wrap)
+	}
+}
+
+func (conf *httpClientConfig) tlsConfig() *tls.Config {
+	if conf.tls == nil {
+		conf.tls = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return conf.tls
+}
+
+// ClientCertificate makes the client present the certificate for mutual TLS.
+func ClientCertificate(cert tls.Certificate) HTTPClientOption {
+	return func(conf *httpClientConfig) {
+		c := conf.tlsConfig()
+
+		c.Certificates = append(c.Certificates, cert)
+	}
+}
+
+// RootCAs replaces the system certificate pool that is used to verify
+// servers.
+func RootCAs(pool *x509.CertPool) HTTPClientOption {
+	return func(conf *httpClientConfig) {
+		conf.tlsConfig().RootCAs = pool
+	}
+}
+
+// LoadClientCertificate reads a PEM encoded certificate and key pair and
+// returns a ClientCertificate option for it.
+func LoadClientCertificate(certFile string, keyFile string) (HTTPClientOption, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load client certificate: %w", err)
+	}
+
+	return ClientCertificate(cert), nil
+}
+
+// LoadRootCAs reads PEM encoded CA certificates from the files and returns a
+// RootCAs option for them. Set includeSystem to trust the system certificate
+// pool as well.
+func LoadRootCAs(includeSystem bool, files ...string) (HTTPClientOption, error) {
+	pool := x509.NewCertPool()
+
+	if includeSystem {
+		sys, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("load system certificate pool: %w", err)
+		}
+
+		pool = sys
+	}
+
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", name)
+		}
+	}
+
+	return RootCAs(pool), nil
+}
+
+// Proxy routes the requests of the client through the proxy instead of the
+// one given by the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+// The proxy URL can use the "http", "https", or "socks5" scheme.
+//
+// Requests to hosts that match the noProxy list are sent directly. The list
+// entries use the same format as NO_PROXY: host names, which also match
+// subdomains, ".domain" for subdomains only, IP addresses, CIDR ranges, and
+// optional ports, f.ex. "internal.example.com", "10.0.0.0/8", or
+// "localhost:8080". Requests to localhost are never proxied.
+func Proxy(proxyURL *url.URL, noProxy ...string) HTTPClientOption {
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    strings.Join(noProxy, ","),
+	}).ProxyFunc()
+
+	return func(conf *httpClientConfig) {
+		conf.proxySet = true
+		conf.proxy = func(req *http.Request) (*url.URL, error) {
+			return proxy(req.URL)
+		}
+	}
+}
+
+// DirectConnection makes the client connect directly to servers, ignoring the
+// proxy environment variables.
+func DirectConnection() HTTPClientOption {
+	return func(conf *httpClientConfig) {
+		conf.proxySet = true
+		conf.proxy = nil
+	}
+}
+
+// UserAgent sets the User-Agent header of outgoing requests to
+// "name/version", or just name if version is empty. Requests that already
+// have a User-Agent header keep it.
+func UserAgent(name string, version string) HTTPClientOption {
+	agent := name
+	if version != "" {
+		agent += "/" + version
+	}
+
+	return DefaultHeaders(http.Header{
+		"User-Agent": []string{agent},
+	})
+}
+
+// DefaultHeaders adds the headers to all outgoing requests that don't already
+// have them set.
+func DefaultHeaders(headers http.Header) HTTPClientOption {
+	canonical := make(http.Header, len(headers))
+
+	for name, values := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return &defaultHeaderTransport{
+			base:    base,
+			headers: canonical,
+		}
+	})
+}
+
+type defaultHeaderTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *defaultHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var clone *http.Request
+
+	for name, values := range t.headers {
+		if _, ok := req.Header[name]; ok {
+			continue
+		}
+
+		// RoundTrippers must not modify the original request.
+		if clone == nil {
+			clone = req.Clone(req.Context())
+
+			if clone.Header == nil {
+				clone.Header = make(http.Header)
+			}
+		}
+
+		clone.Header[name] = values
+	}
+
+	if clone != nil {
+		req = clone
+	}
+
+	return t.base.RoundTrip(req) //nolint:wrapcheck
+}
+
+// Tracing wraps the transport with OpenTelemetry instrumentation, so that
+// requests get client spans and the trace context is propagated to the server
+// in the W3C traceparent and baggage headers. Spans are created by the global
+// tracer provider unless another one is given through the options. Add the
+// option last so that the spans cover retries and rate limit waits.
+func Tracing(opts ...otelhttp.Option) HTTPClientOption {
+	opts = append([]otelhttp.Option{
+		otelhttp.WithPropagators(tracePropagator()),
+	}, opts...)
+
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(base, opts...)
+	})
+}
+
+// tracePropagator propagates the W3C trace context and baggage headers.
+func tracePropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	)
+}
+
+// RateLimit limits the client to rps requests per second, with bursts of up to
+// burst requests. The limit is shared by all hosts.
+func RateLimit(rps float64, burst int) HTTPClientOption {
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return NewRateLimitTransport(base, RateLimitOptions{
+			RPS:   rps,
+			Burst: burst,
+		})
+	})
+}
+
+// RateLimitPerHost works like RateLimit, but keeps a separate limit per host.
+func RateLimitPerHost(rps float64, burst int) HTTPClientOption {
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return NewRateLimitTransport(base, RateLimitOptions{
+			RPS:     rps,
+			Burst:   burst,
+			PerHost: true,
+		})
+	})
+}
+
+// RateLimitOptions controls the behaviour of a RateLimitTransport.
+type RateLimitOptions struct {
+	// RPS is the sustained number of requests per second.
+	RPS float64
+	// Burst is the number of requests that can be made at once. Defaults
+	// to 1.
+	Burst int
+	// PerHost keeps a separate limit per host.
+	PerHost bool
+}
+
+// RateLimitTransport is a http.RoundTripper that delays requests to stay within
+// a token bucket rate limit. Requests wait for their turn until the request
+// context is cancelled.
+type RateLimitTransport struct {
+	base http.RoundTripper
+	opts RateLimitOptions
+
+	m       sync.Mutex
+	limiter *rate.Limiter
+	hosts   map[string]*rate.Limiter
+}
+
+// NewRateLimitTransport creates a rate limiter that wraps the base transport.
+// The base defaults to http.DefaultTransport.
+func NewRateLimitTransport(
+	base http.RoundTripper, opts RateLimitOptions,
+) *RateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if opts.Burst == 0 {
+		opts.Burst = 1
+	}
+
+	return &RateLimitTransport{
+		base:    base,
+		opts:    opts,
+		limiter: rate.NewLimiter(rate.Limit(opts.RPS), opts.Burst),
+		hosts:   make(map[string]*rate.Limiter),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.limiterFor(req.URL.Host).Wait(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("wait for rate limit: %w", err)
+	}
+
+	return t.base.RoundTrip(req) //nolint:wrapcheck
+}
+
+func (t *RateLimitTransport) limiterFor(host string) *rate.Limiter {
+	if !t.opts.PerHost {
+		return t.limiter
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	l, ok := t.hosts[host]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(t.opts.RPS), t.opts.Burst)
+		t.hosts[host] = l
+	}
+
+	return l
+}
@@ -0,0 +1,288 @@
+package elephantine_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		_ http.ResponseWriter, _ *http.Request,
+	) {
+	}))
+
+	t.Cleanup(server.Close)
+
+	cases := map[string]elephantine.HTTPClientOption{
+		"shared":   elephantine.RateLimit(20, 2),
+		"per_host": elephantine.RateLimitPerHost(20, 2),
+	}
+
+	for name, opt := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := elephantine.NewHTTPClient(5*time.Second, opt)
+
+			start := time.Now()
+
+			// Two requests are allowed by the burst, the following
+			// four have to wait 50ms each.
+			for range 6 {
+				res, err := client.Get(server.URL)
+				test.Must(t, err, "perform request")
+
+				_ = res.Body.Close()
+			}
+
+			elapsed := time.Since(start)
+
+			if elapsed < 180*time.Millisecond {
+				t.Fatalf("requests were not rate limited, took %s",
+					elapsed)
+			}
+		})
+	}
+}
+
+func TestTracing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		_, _ = w.Write([]byte(r.Header.Get("Traceparent")))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.Tracing())
+
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	ctx := trace.ContextWithSpanContext(test.Context(t),
+		trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			TraceFlags: trace.FlagsSampled,
+		}))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	test.Must(t, err, "create request")
+
+	res, err := client.Do(req)
+	test.Must(t, err, "perform request")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	if !strings.Contains(string(body), traceID.String()) {
+		t.Fatalf("expected the trace ID to be propagated, got traceparent %q",
+			string(body))
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied atomic.Int32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		proxied.Add(1)
+
+		_, _ = w.Write([]byte("proxied " + r.URL.Host))
+	}))
+
+	t.Cleanup(proxy.Close)
+
+	proxyURL, err := url.Parse(proxy.URL)
+	test.Must(t, err, "parse proxy URL")
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.Proxy(proxyURL, "direct.invalid"))
+
+	res, err := client.Get("http://api.example.com/things")
+	test.Must(t, err, "perform proxied request")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	test.Equal(t, "proxied api.example.com", string(body),
+		"send the request through the proxy")
+
+	_, err = client.Get("http://direct.invalid/things")
+	test.MustNot(t, err, "fail to connect directly to an invalid host")
+
+	test.Equal(t, 1, int(proxied.Load()),
+		"bypass the proxy for hosts in the no proxy list")
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, clientCert := writeClientCertificate(t, dir)
+
+	clientCAs := x509.NewCertPool()
+
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(dir, "ca.pem")
+
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0o600)
+	test.Must(t, err, "write server CA")
+
+	certOpt, err := elephantine.LoadClientCertificate(certFile, keyFile)
+	test.Must(t, err, "load client certificate")
+
+	caOpt, err := elephantine.LoadRootCAs(false, caFile)
+	test.Must(t, err, "load root CAs")
+
+	_, err = elephantine.NewHTTPClient(5*time.Second, caOpt).Get(server.URL)
+	test.MustNot(t, err, "fail without a client certificate")
+
+	client := elephantine.NewHTTPClient(5*time.Second, certOpt, caOpt)
+
+	res, err := client.Get(server.URL)
+	test.Must(t, err, "perform request with a client certificate")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	test.Equal(t, "test-client", string(body),
+		"identify the client by its certificate")
+}
+
+func writeClientCertificate(
+	t *testing.T, dir string,
+) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.Must(t, err, "generate key")
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key)
+	test.Must(t, err, "create certificate")
+
+	cert, err := x509.ParseCertificate(der)
+	test.Must(t, err, "parse certificate")
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	test.Must(t, err, "marshal key")
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), 0o600)
+	test.Must(t, err, "write certificate")
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyDER,
+	}), 0o600)
+	test.Must(t, err, "write key")
+
+	return certFile, keyFile, cert
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		_, _ = w.Write([]byte(r.UserAgent() + " " + r.Header.Get("X-Partner")))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.UserAgent("repository", "v1.2.3"),
+		elephantine.DefaultHeaders(http.Header{
+			"x-partner": []string{"tt"},
+		}))
+
+	get := func(agent string) string {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(
+			test.Context(t), http.MethodGet, server.URL, nil)
+		test.Must(t, err, "create request")
+
+		if agent != "" {
+			req.Header.Set("User-Agent", agent)
+		}
+
+		res, err := client.Do(req)
+		test.Must(t, err, "perform request")
+
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		test.Must(t, err, "read response")
+
+		test.Equal(t, "", req.Header.Get("X-Partner"),
+			"not modify the original request")
+
+		return string(body)
+	}
+
+	test.Equal(t, "repository/v1.2.3 tt", get(""),
+		"add the default headers")
+	test.Equal(t, "custom/1.0 tt", get("custom/1.0"),
+		"keep headers set on the request")
+}
@@ -0,0 +1,333 @@
+package elephantine_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestHTTPErrorDetails(t *testing.T) {
+	server := httptest.NewServer(elephantine.HTTPErrorHandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) error {
+		w.Header().Set("Retry-After", "7")
+
+		return elephantine.NewHTTPErrorWithDetails(
+			http.StatusServiceUnavailable,
+			elephantine.HTTPErrorDetails{
+				Title:  "Index unavailable",
+				Detail: "the search index is being rebuilt",
+				Code:   "index_rebuilding",
+			})
+	}))
+
+	t.Cleanup(server.Close)
+
+	res, err := http.Get(server.URL)
+	test.Must(t, err, "perform request")
+
+	defer res.Body.Close()
+
+	test.Equal(t, "application/problem+json", res.Header.Get("Content-Type"),
+		"respond with problem details")
+
+	err = elephantine.HTTPErrorFromResponse(res)
+
+	var httpErr *elephantine.HTTPError
+
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected a HTTPError, got %v", err)
+	}
+
+	test.Equal(t, 7*time.Second, httpErr.RetryAfter, "parse Retry-After")
+
+	if httpErr.Details == nil {
+		t.Fatal("expected the error details to be parsed")
+	}
+
+	test.Equal(t, "index_rebuilding", httpErr.Details.Code,
+		"parse the error code")
+	test.Equal(t, http.StatusServiceUnavailable, httpErr.Details.Status,
+		"default the details status to the status code")
+	test.Equal(t, "503 Service Unavailable: the search index is being rebuilt",
+		httpErr.Error(), "include the detail in the error message")
+}
+
+func TestHTTPErrorFromResponseLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+
+		_, _ = w.Write([]byte(strings.Repeat("<p>oops</p>", 1000)))
+	}))
+
+	t.Cleanup(server.Close)
+
+	res, err := http.Get(server.URL)
+	test.Must(t, err, "perform request")
+
+	err = elephantine.HTTPErrorFromResponseLimited(res, 16)
+
+	var httpErr *elephantine.HTTPError
+
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("expected a HTTPError, got %v", err)
+	}
+
+	body, err := io.ReadAll(httpErr.Body)
+	test.Must(t, err, "read error body")
+
+	test.Equal(t, "<p>oops</p><p>oo", string(body), "truncate the body")
+	test.Equal(t, true, httpErr.Truncated, "flag the body as truncated")
+	test.Equal(t, http.StatusBadGateway, httpErr.StatusCode,
+		"keep the status code")
+}
+
+func TestHTTPClientInstrumentationHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	instrumentation, err := elephantine.NewHTTPClientInstrumentationWithOptions(
+		reg, elephantine.HTTPClientInstrumentationOptions{
+			Hosts:       []string{"127.0.0.1"},
+			SizeBuckets: []float64{10, 100},
+		})
+	test.Must(t, err, "create instrumentation")
+
+	client := elephantine.NewHTTPClient(5 * time.Second)
+
+	err = instrumentation.Client("upstream", client)
+	test.Must(t, err, "instrument client")
+
+	serverURL, err := url.Parse(server.URL)
+	test.Must(t, err, "parse server URL")
+
+	// Same server, but a host that isn't in the allowlist.
+	otherURL := "http://localhost:" + serverURL.Port()
+
+	for _, u := range []string{server.URL, server.URL, otherURL} {
+		res, err := client.Post(u, "text/plain", strings.NewReader("data"))
+		test.Must(t, err, "perform request")
+
+		_, err = io.Copy(io.Discard, res.Body)
+		test.Must(t, err, "read response")
+
+		_ = res.Body.Close()
+	}
+
+	expected := `
+# HELP client_requests_total A counter for requests from the wrapped client.
+# TYPE client_requests_total counter
+client_requests_total{client="upstream",code="200",host="127.0.0.1",method="post"} 2
+client_requests_total{client="upstream",code="200",host="other",method="post"} 1
+# HELP client_request_size_bytes A histogram of request body sizes.
+# TYPE client_request_size_bytes histogram
+client_request_size_bytes_bucket{client="upstream",host="127.0.0.1",le="10"} 2
+client_request_size_bytes_bucket{client="upstream",host="127.0.0.1",le="100"} 2
+client_request_size_bytes_bucket{client="upstream",host="127.0.0.1",le="+Inf"} 2
+client_request_size_bytes_sum{client="upstream",host="127.0.0.1"} 8
+client_request_size_bytes_count{client="upstream",host="127.0.0.1"} 2
+client_request_size_bytes_bucket{client="upstream",host="other",le="10"} 1
+client_request_size_bytes_bucket{client="upstream",host="other",le="100"} 1
+client_request_size_bytes_bucket{client="upstream",host="other",le="+Inf"} 1
+client_request_size_bytes_sum{client="upstream",host="other"} 4
+client_request_size_bytes_count{client="upstream",host="other"} 1
+# HELP client_response_size_bytes A histogram of response body sizes.
+# TYPE client_response_size_bytes histogram
+client_response_size_bytes_bucket{client="upstream",host="127.0.0.1",le="10"} 0
+client_response_size_bytes_bucket{client="upstream",host="127.0.0.1",le="100"} 2
+client_response_size_bytes_bucket{client="upstream",host="127.0.0.1",le="+Inf"} 2
+client_response_size_bytes_sum{client="upstream",host="127.0.0.1"} 22
+client_response_size_bytes_count{client="upstream",host="127.0.0.1"} 2
+client_response_size_bytes_bucket{client="upstream",host="other",le="10"} 0
+client_response_size_bytes_bucket{client="upstream",host="other",le="100"} 1
+client_response_size_bytes_bucket{client="upstream",host="other",le="+Inf"} 1
+client_response_size_bytes_sum{client="upstream",host="other"} 11
+client_response_size_bytes_count{client="upstream",host="other"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"client_requests_total", "client_request_size_bytes",
+		"client_response_size_bytes")
+	test.Must(t, err, "label the metrics by host")
+}
+
+func TestServeTLSContext(t *testing.T) {
+	certFile, keyFile, cert := writeServerCertificate(t, t.TempDir())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	test.Must(t, err, "listen on an ephemeral port")
+
+	server := http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(test.Context(t))
+	served := make(chan error, 1)
+
+	go func() {
+		served <- elephantine.ServeTLSContext(
+			ctx, &server, ln, certFile, keyFile, time.Second)
+	}()
+
+	pool := x509.NewCertPool()
+
+	pool.AddCert(cert)
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.RootCAs(pool))
+
+	res, err := client.Get("https://" + ln.Addr().String())
+	test.Must(t, err, "perform request")
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	_ = res.Body.Close()
+
+	test.Equal(t, "hello", string(body), "get a response over TLS")
+
+	cancel()
+
+	err = <-served
+	if !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("expected a graceful server close, got: %v", err)
+	}
+}
+
+func writeServerCertificate(
+	t *testing.T, dir string,
+) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.Must(t, err, "generate key")
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-server"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key)
+	test.Must(t, err, "create certificate")
+
+	cert, err := x509.ParseCertificate(der)
+	test.Must(t, err, "parse certificate")
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	test.Must(t, err, "marshal key")
+
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), 0o600)
+	test.Must(t, err, "write certificate")
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyDER,
+	}), 0o600)
+	test.Must(t, err, "write key")
+
+	return certFile, keyFile, cert
+}
+
+func TestHTTPClientInstrumentationEndpoints(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		_ http.ResponseWriter, _ *http.Request,
+	) {
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	instrumentation, err := elephantine.NewHTTPClientInstrumentationWithOptions(
+		reg, elephantine.HTTPClientInstrumentationOptions{
+			Endpoints: []string{"get_document"},
+		})
+	test.Must(t, err, "create instrumentation")
+
+	client := elephantine.NewHTTPClient(5 * time.Second)
+
+	err = instrumentation.Client("repository", client)
+	test.Must(t, err, "instrument client")
+
+	contexts := []context.Context{
+		elephantine.WithClientEndpoint(test.Context(t), "get_document"),
+		elephantine.WithClientEndpoint(test.Context(t), "get_document"),
+		elephantine.WithClientEndpoint(test.Context(t), "list_documents"),
+		test.Context(t),
+	}
+
+	for _, ctx := range contexts {
+		req, err := http.NewRequestWithContext(
+			ctx, http.MethodGet, server.URL, nil)
+		test.Must(t, err, "create request")
+
+		res, err := client.Do(req)
+		test.Must(t, err, "perform request")
+
+		_ = res.Body.Close()
+	}
+
+	expected := `
+# HELP client_requests_total A counter for requests from the wrapped client.
+# TYPE client_requests_total counter
+client_requests_total{client="repository",code="200",endpoint="get_document",method="get"} 2
+client_requests_total{client="repository",code="200",endpoint="other",method="get"} 1
+client_requests_total{client="repository",code="200",endpoint="unknown",method="get"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"client_requests_total")
+	test.Must(t, err, "label the metrics by endpoint")
+
+	count := testutil.CollectAndCount(reg, "client_response_size_bytes")
+	test.Equal(t, 3, count, "label the size metrics by endpoint")
+}
@@ -0,0 +1,208 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/twitchtv/twirp"
+)
+
+// ImpersonateSubjectHeader is the header that is used to request that a call
+// should be made as another subject.
+const ImpersonateSubjectHeader = "X-Impersonate-Subject"
+
+// DefaultImpersonationScope is the scope that is required to impersonate
+// other subjects unless the policy says otherwise.
+const DefaultImpersonationScope = "impersonate"
+
+// ErrImpersonationDenied is used to communicate that the client isn't allowed
+// to impersonate the requested subject.
+var ErrImpersonationDenied = errors.New("impersonation denied")
+
+// ImpersonationPolicy controls who can act as another subject, intended for
+// support tooling that needs to reproduce issues as a specific user.
+type ImpersonationPolicy struct {
+	// Scope is the scope that is required to impersonate other subjects.
+	// Defaults to DefaultImpersonationScope.
+	Scope string
+	// SubjectPrefixes limits which subjects can be impersonated. Defaults
+	// to "core://user/", so that only users can be impersonated.
+	SubjectPrefixes []string
+	// Allow can be used to add additional checks, return an error to deny
+	// the impersonation.
+	Allow func(auth *AuthInfo, subject string) error
+	// Logger is used to write an audit log entry for every impersonated
+	// request. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Impersonate returns a copy of the auth info that acts as the given subject.
+// The subject of the original auth info becomes the actor. The impersonation
+// scope is dropped, and the units and session of the original subject aren't
+// carried over. The token is still the token of the original subject, so it
+// must not be forwarded to other services as the impersonated subject.
+//
+// Returns an error wrapping ErrImpersonationDenied if the policy doesn't allow
+// the impersonation.
+func (p *ImpersonationPolicy) Impersonate(
+	ctx context.Context, auth *AuthInfo, subject string,
+) (*AuthInfo, error) {
+	scope := p.Scope
+	if scope == "" {
+		scope = DefaultImpersonationScope
+	}
+
+	prefixes := p.SubjectPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{userURI.String() + "/"}
+	}
+
+	logger := p.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	switch {
+	case auth.Actor != "":
+		return nil, fmt.Errorf(
+			"%w: the client is already acting on behalf of %q",
+			ErrImpersonationDenied, auth.Claims.Subject)
+	case !auth.Claims.HasScope(scope):
+		return nil, fmt.Errorf("%w: the %q scope is required",
+			ErrImpersonationDenied, scope)
+	case subject == auth.Claims.Subject:
+		return nil, fmt.Errorf("%w: cannot impersonate self",
+			ErrImpersonationDenied)
+	}
+
+	var allowedSubject bool
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(subject, prefix) && len(subject) > len(prefix) {
+			allowedSubject = true
+
+			break
+		}
+	}
+
+	if !allowedSubject {
+		return nil, fmt.Errorf("%w: the subject %q cannot be impersonated",
+			ErrImpersonationDenied, subject)
+	}
+
+	if p.Allow != nil {
+		err := p.Allow(auth, subject)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrImpersonationDenied, err)
+		}
+	}
+
+	var scopes []string
+
+	for _, s := range strings.Fields(auth.Claims.Scope) {
+		if s != scope {
+			scopes = append(scopes, s)
+		}
+	}
+
+	claims := auth.Claims
+
+	claims.Subject = subject
+	claims.OriginalSub = subject
+	claims.Name = ""
+	claims.SessionID = ""
+	claims.Units = nil
+	claims.Scope = strings.Join(scopes, " ")
+	claims.Actor = &ActorClaim{
+		Subject:  auth.Claims.Subject,
+		Issuer:   auth.Claims.Issuer,
+		ClientID: auth.Claims.ClientID,
+	}
+
+	logger.InfoContext(ctx, "impersonating subject",
+		LogKeySubject, subject,
+		LogKeyActor, auth.Claims.Subject)
+
+	return &AuthInfo{
+		Token:  auth.Token,
+		Claims: claims,
+		Actor:  auth.Claims.Subject,
+	}, nil
+}
+
+type impersonateCtxKey struct{}
+
+// withImpersonateSubject stores the requested impersonation subject in the
+// context. It's kept out of the Twirp request headers so that downstream calls
+// made with the context don't impersonate the subject without a policy
+// decision of their own.
+func withImpersonateSubject(ctx context.Context, subject string) context.Context {
+	if subject == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, impersonateCtxKey{}, subject)
+}
+
+// SetImpersonation lets clients act as another subject by sending the
+// ImpersonateSubjectHeader, if allowed by the policy. Must be set after
+// SetAuthInfoValidation so that the auth info is available, and before
+// SetMethodScopes.
+func (so *ServiceOptions) SetImpersonation(policy *ImpersonationPolicy) {
+	hooks := twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			subject, _ := ctx.Value(impersonateCtxKey{}).(string)
+			if subject == "" {
+				return ctx, nil
+			}
+
+			auth, ok := GetAuthInfo(ctx)
+			if !ok {
+				return withAuthFailure(ctx, AuthFailureMissing),
+					twirp.Unauthenticated.Error(
+						"authentication required to impersonate")
+			}
+
+			imp, err := policy.Impersonate(ctx, auth, subject)
+			if err != nil {
+				return ctx, twirp.PermissionDenied.Error(err.Error())
+			}
+
+			ctx = SetAuthInfo(ctx, imp)
+
+			SetLogMetadata(ctx, LogKeySubject, imp.Claims.Subject)
+			SetLogMetadata(ctx, LogKeyActor, imp.Actor)
+
+			return ctx, nil
+		},
+	}
+
+	so.Hooks = twirp.ChainHooks(so.Hooks, &hooks)
+}
+
+// impersonateHTTP applies the impersonation header of a request to the auth
+// info.
+func impersonateHTTP(
+	r *http.Request, policy *ImpersonationPolicy, auth *AuthInfo,
+) (*AuthInfo, error) {
+	subject := r.Header.Get(ImpersonateSubjectHeader)
+	if policy == nil || subject == "" {
+		return auth, nil
+	}
+
+	if auth == nil {
+		return nil, unauthorizedHTTPError(
+			"", "authentication required to impersonate")
+	}
+
+	imp, err := policy.Impersonate(r.Context(), auth, subject)
+	if err != nil {
+		return nil, NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	return imp, nil
+}
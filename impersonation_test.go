@@ -0,0 +1,162 @@
+package elephantine_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+type impersonationTestCase struct {
+	Scope         string
+	Subject       string
+	ExpectStatus  int
+	ExpectSubject string
+	ExpectActor   string
+	ExpectScope   string
+}
+
+func TestImpersonation(t *testing.T) {
+	key := test.NewSigningKey(t)
+	parser := key.Parser(elephantine.JWTAuthInfoParserOptions{})
+
+	mw := elephantine.NewHTTPAuthMiddleware(parser, elephantine.HTTPAuthOptions{
+		Required: true,
+		Impersonation: &elephantine.ImpersonationPolicy{
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		},
+	})
+
+	cases := map[string]impersonationTestCase{
+		"no_impersonation": {
+			Scope:         "doc_read impersonate",
+			ExpectStatus:  http.StatusOK,
+			ExpectSubject: "core://user/support",
+			ExpectScope:   "doc_read impersonate",
+		},
+		"impersonate_user": {
+			Scope:         "doc_read impersonate",
+			Subject:       "core://user/someone",
+			ExpectStatus:  http.StatusOK,
+			ExpectSubject: "core://user/someone",
+			ExpectActor:   "core://user/support",
+			ExpectScope:   "doc_read",
+		},
+		"missing_scope": {
+			Scope:        "doc_read",
+			Subject:      "core://user/someone",
+			ExpectStatus: http.StatusForbidden,
+		},
+		"impersonate_application": {
+			Scope:        "impersonate",
+			Subject:      "core://application/repository",
+			ExpectStatus: http.StatusForbidden,
+		},
+		"impersonate_self": {
+			Scope:        "impersonate",
+			Subject:      "core://user/support",
+			ExpectStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got *elephantine.AuthInfo
+
+			handler := mw(http.HandlerFunc(func(
+				w http.ResponseWriter, r *http.Request,
+			) {
+				got, _ = elephantine.GetAuthInfo(r.Context())
+
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			req.Header.Set("Authorization", key.AccessKey(t,
+				elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: "support",
+					},
+					Scope: tc.Scope,
+				}))
+
+			if tc.Subject != "" {
+				req.Header.Set(elephantine.ImpersonateSubjectHeader,
+					tc.Subject)
+			}
+
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			test.Equal(t, tc.ExpectStatus, rec.Code,
+				"get correct status code")
+
+			if tc.ExpectStatus != http.StatusOK {
+				return
+			}
+
+			test.Equal(t, tc.ExpectSubject, got.Claims.Subject,
+				"get the expected subject")
+			test.Equal(t, tc.ExpectActor, got.Actor,
+				"get the expected actor")
+			test.Equal(t, tc.ExpectScope, got.Claims.Scope,
+				"get the expected scopes")
+		})
+	}
+}
+
+func TestServiceImpersonation(t *testing.T) {
+	key := test.NewSigningKey(t)
+	parser := key.Parser(elephantine.JWTAuthInfoParserOptions{})
+
+	var so elephantine.ServiceOptions
+
+	so.SetAuthInfoValidation(parser, elephantine.ServiceAuthRequired)
+	so.SetImpersonation(&elephantine.ImpersonationPolicy{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	req.Header.Set("Authorization", key.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "support",
+			},
+			Scope: "impersonate",
+		}))
+	req.Header.Set(elephantine.ImpersonateSubjectHeader, "core://user/someone")
+
+	var ctx context.Context
+
+	err := so.AuthMiddleware(httptest.NewRecorder(), req,
+		http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			ctx = r.Context()
+		}))
+	test.Must(t, err, "run auth middleware")
+
+	headers, _ := twirp.HTTPRequestHeaders(ctx)
+
+	test.Equal(t, "", headers.Get(elephantine.ImpersonateSubjectHeader),
+		"don't forward the impersonation header")
+
+	ctx, err = so.Hooks.RequestRouted(ctx)
+	test.Must(t, err, "route request")
+
+	auth, ok := elephantine.GetAuthInfo(ctx)
+
+	test.Equal(t, true, ok, "have auth info")
+	test.Equal(t, "core://user/someone", auth.Claims.Subject,
+		"act as the impersonated subject")
+	test.Equal(t, "core://user/support", auth.Actor,
+		"keep the original subject as actor")
+}
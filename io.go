@@ -1,13 +1,19 @@
 package elephantine
 
 import (
+	"bufio"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 // UnmarshalFile is a utility function for reading and unmarshalling a file
@@ -65,12 +71,285 @@ func MarshalFile(path string, o interface{}) (outErr error) {
 	return nil
 }
 
+// EncodeJSONStream writes the items as a JSON array, one item at a time, so
+// that the full data set never has to be held in memory. Stops and returns the
+// error if the sequence yields an error.
+func EncodeJSONStream[T any](w io.Writer, items iter.Seq2[T, error]) error {
+	_, err := io.WriteString(w, "[")
+	if err != nil {
+		return fmt.Errorf("failed to write array start: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+
+	var n int
+
+	for item, err := range items {
+		if err != nil {
+			return fmt.Errorf("failed to get item %d: %w", n, err)
+		}
+
+		if n > 0 {
+			_, err := io.WriteString(w, ",")
+			if err != nil {
+				return fmt.Errorf("failed to write separator: %w", err)
+			}
+		}
+
+		err = enc.Encode(item)
+		if err != nil {
+			return fmt.Errorf("failed to marshal item %d: %w", n, err)
+		}
+
+		n++
+	}
+
+	_, err = io.WriteString(w, "]\n")
+	if err != nil {
+		return fmt.Errorf("failed to write array end: %w", err)
+	}
+
+	return nil
+}
+
+// DecodeJSONStream reads a JSON array one element at a time and calls fn for
+// each element. Decoding stops if fn returns an error.
+func DecodeJSONStream[T any](r io.Reader, fn func(item T) error) error {
+	return decodeJSONArray(json.NewDecoder(r), fn)
+}
+
+func decodeJSONArray[T any](dec *json.Decoder, fn func(item T) error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read array start: %w", err)
+	}
+
+	if tok != json.Delim('[') {
+		return fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+
+	for i := 0; dec.More(); i++ {
+		var item T
+
+		err := dec.Decode(&item)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal item %d: %w", i, err)
+		}
+
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read array end: %w", err)
+	}
+
+	return nil
+}
+
+// MarshalFileStream is the streaming variant of MarshalFile, it writes the
+// items as a JSON array using EncodeJSONStream. The file is gzip compressed if
+// the path ends with ".gz".
+func MarshalFileStream[T any](path string, items iter.Seq2[T, error]) (outErr error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			outErr = errors.Join(outErr, fmt.Errorf(
+				"failed to close file: %w", err))
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+
+	var w io.Writer = bw
+
+	var gz *gzip.Writer
+
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(bw)
+		w = gz
+	}
+
+	err = EncodeJSONStream(w, items)
+	if err != nil {
+		return err
+	}
+
+	if gz != nil {
+		err := gz.Close()
+		if err != nil {
+			return fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// UnmarshalFileStream is the streaming variant of UnmarshalFile, it reads a
+// file containing a JSON array and calls fn for each element. The parsing will
+// be strict and disallow unknown fields. The file is gzip decompressed if the
+// path ends with ".gz".
+func UnmarshalFileStream[T any](path string, fn func(item T) error) (outErr error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	defer func() {
+		err := f.Close()
+		if err != nil {
+			outErr = errors.Join(outErr, fmt.Errorf(
+				"failed to close file: %w", err))
+		}
+	}()
+
+	var r io.Reader = bufio.NewReader(f)
+
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	dec := json.NewDecoder(r)
+
+	dec.DisallowUnknownFields()
+
+	return decodeJSONArray(dec, fn)
+}
+
 // UnmarshalHTTPResource is a utility function for reading and unmarshalling a
 // HTTP resource. Uses the default HTTP client.
-func UnmarshalHTTPResource(resURL string, o interface{}) (outErr error) {
-	res, err := http.Get(resURL) //nolint:gosec
+//
+// Deprecated: use UnmarshalHTTPResourceContext, which supports cancellation,
+// custom clients, authorization, and retries.
+func UnmarshalHTTPResource(resURL string, o interface{}) error {
+	return UnmarshalHTTPResourceContext(context.Background(), nil, resURL, o)
+}
+
+// ErrNotModified is returned by UnmarshalHTTPResourceContext when the
+// resource hasn't changed since it was fetched with the given ETag.
+var ErrNotModified = errors.New("resource not modified")
+
+// HTTPResourceOption configures UnmarshalHTTPResourceContext.
+type HTTPResourceOption func(conf *httpResourceConfig)
+
+type httpResourceConfig struct {
+	authorization string
+	etag          *string
+	maxAttempts   int
+	backoff       BackoffFunction
+}
+
+// WithResourceAuthorization sets the Authorization header of the request, f.ex.
+// "Bearer " followed by an access token.
+func WithResourceAuthorization(value string) HTTPResourceOption {
+	return func(conf *httpResourceConfig) {
+		conf.authorization = value
+	}
+}
+
+// WithResourceETag revalidates the resource using the ETag. If the ETag is set
+// it's sent in a If-None-Match header, and ErrNotModified is returned if the
+// resource hasn't changed. The ETag is updated from the response when the
+// resource is fetched.
+func WithResourceETag(etag *string) HTTPResourceOption {
+	return func(conf *httpResourceConfig) {
+		conf.etag = etag
+	}
+}
+
+// WithResourceRetries makes up to maxAttempts attempts to fetch the resource
+// if the request fails with a connection error, 429 Too Many Requests, or a
+// 5xx response. The wait between attempts is controlled by the backoff, which
+// defaults to an exponential backoff starting at 100ms, up to 5s.
+func WithResourceRetries(maxAttempts int, backoff BackoffFunction) HTTPResourceOption {
+	return func(conf *httpResourceConfig) {
+		conf.maxAttempts = maxAttempts
+		conf.backoff = backoff
+	}
+}
+
+// UnmarshalHTTPResourceContext is a utility function for reading and
+// unmarshalling a HTTP resource. The client defaults to http.DefaultClient.
+func UnmarshalHTTPResourceContext(
+	ctx context.Context, client *http.Client, resURL string, o any,
+	opts ...HTTPResourceOption,
+) error {
+	conf := httpResourceConfig{
+		maxAttempts: 1,
+	}
+
+	for _, opt := range opts {
+		opt(&conf)
+	}
+
+	if conf.backoff == nil {
+		conf.backoff = ExponentialBackoff(100*time.Millisecond, 5*time.Second)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := unmarshalHTTPResource(ctx, client, resURL, o, conf)
+		if !retry || attempt >= conf.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(conf.backoff(attempt)):
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		}
+	}
+}
+
+// unmarshalHTTPResource makes a single attempt at fetching the resource, and
+// returns true if the attempt can be retried.
+func unmarshalHTTPResource(
+	ctx context.Context, client *http.Client, resURL string, o any,
+	conf httpResourceConfig,
+) (_ bool, outErr error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resURL, nil)
 	if err != nil {
-		return fmt.Errorf("failed to perform request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if conf.authorization != "" {
+		req.Header.Set("Authorization", conf.authorization)
+	}
+
+	if conf.etag != nil && *conf.etag != "" {
+		req.Header.Set("If-None-Match", *conf.etag)
+	}
+
+	res, err := client.Do(req)
+
+	_, retry := retryReason(res, err)
+
+	if err != nil {
+		return retry && ctx.Err() == nil,
+			fmt.Errorf("failed to perform request: %w", err)
 	}
 
 	defer func() {
@@ -81,18 +360,25 @@ func UnmarshalHTTPResource(resURL string, o interface{}) (outErr error) {
 		}
 	}()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server responded with: %q", res.Status)
+	switch {
+	case res.StatusCode == http.StatusNotModified && conf.etag != nil:
+		return false, ErrNotModified
+	case res.StatusCode != http.StatusOK:
+		return retry, fmt.Errorf("server responded with: %q", res.Status)
 	}
 
 	dec := json.NewDecoder(res.Body)
 
 	err = dec.Decode(o)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON: %w", err)
+		return false, fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
 
-	return nil
+	if conf.etag != nil {
+		*conf.etag = res.Header.Get("ETag")
+	}
+
+	return false, nil
 }
 
 // SafeClose can be used with defer to defer the Close of a resource without
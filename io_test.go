@@ -0,0 +1,122 @@
+package elephantine_test
+
+import (
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+type streamItem struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+func TestFileStream(t *testing.T) {
+	items := func(yield func(streamItem, error) bool) {
+		for i := range 1000 {
+			if !yield(streamItem{ID: i, Title: "item"}, nil) {
+				return
+			}
+		}
+	}
+
+	for _, name := range []string{"export.json", "export.json.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+
+			err := elephantine.MarshalFileStream(path,
+				iter.Seq2[streamItem, error](items))
+			test.Must(t, err, "write stream")
+
+			var n int
+
+			err = elephantine.UnmarshalFileStream(path,
+				func(item streamItem) error {
+					test.Equal(t, n, item.ID, "read items in order")
+
+					n++
+
+					return nil
+				})
+			test.Must(t, err, "read stream")
+
+			test.Equal(t, 1000, n, "read all items")
+
+			var all []streamItem
+
+			if name == "export.json" {
+				err = elephantine.UnmarshalFile(path, &all)
+				test.Must(t, err, "read stream as a regular JSON file")
+
+				test.Equal(t, 1000, len(all), "read all items")
+			}
+		})
+	}
+}
+
+func TestUnmarshalHTTPResourceContext(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+
+		_, _ = w.Write([]byte(`{"id":1,"title":"first"}`))
+	}))
+
+	t.Cleanup(server.Close)
+
+	ctx := test.Context(t)
+
+	var (
+		etag string
+		item streamItem
+	)
+
+	opts := []elephantine.HTTPResourceOption{
+		elephantine.WithResourceAuthorization("Bearer secret"),
+		elephantine.WithResourceETag(&etag),
+		elephantine.WithResourceRetries(3,
+			elephantine.StaticBackoff(time.Millisecond)),
+	}
+
+	err := elephantine.UnmarshalHTTPResourceContext(ctx, nil,
+		server.URL, &item, opts...)
+	test.Must(t, err, "fetch the resource after a retry")
+
+	test.Equal(t, "first", item.Title, "decode the resource")
+	test.Equal(t, `"v1"`, etag, "store the ETag")
+
+	err = elephantine.UnmarshalHTTPResourceContext(ctx, nil,
+		server.URL, &item, opts...)
+
+	test.Equal(t, true, errors.Is(err, elephantine.ErrNotModified),
+		"revalidate using the ETag")
+}
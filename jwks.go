@@ -0,0 +1,486 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// JWKSOptions controls how JWKS key sets are fetched and refreshed.
+type JWKSOptions struct {
+	// RefreshInterval is how often the key sets are refreshed. Defaults
+	// to 1h.
+	RefreshInterval time.Duration
+	// Timeout for JWKS requests. Defaults to 10s.
+	Timeout time.Duration
+	// UnknownKIDInterval is the minimum interval between refreshes
+	// triggered by tokens with unknown key IDs. Defaults to 5m.
+	UnknownKIDInterval time.Duration
+	// Client is the HTTP client used to fetch key sets. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+	// Logger is used to log refresh failures. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Metrics is used to track refresh failures and degradation if set.
+	Metrics *JWKSMetrics
+	// Cache persists the last fetched key sets, and lets the parser use
+	// them at startup if the key sets can't be fetched.
+	Cache ProviderCacheOptions
+	// MaxStaleness bounds how long the last known keys are trusted when
+	// refreshes fail. The source is reported as degraded while it's
+	// within the bound, and tokens are rejected once the keys are older
+	// than that. Zero keeps using the last known keys indefinitely.
+	MaxStaleness time.Duration
+}
+
+// JWKSMetrics are metrics for JWKS refreshes.
+type JWKSMetrics struct {
+	failures    *prometheus.CounterVec
+	degraded    *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+// NewJWKSMetrics registers JWKS metrics with the provided registerer.
+func NewJWKSMetrics(registerer prometheus.Registerer) (*JWKSMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := JWKSMetrics{
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jwks_refresh_failures_total",
+			Help: "Number of failed JWKS refreshes.",
+		}, []string{"url"}),
+		degraded: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "jwks_degraded",
+			Help: "Set to 1 when the last JWKS refresh failed and the last known keys are used.",
+		}, []string{"url"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "jwks_last_refresh_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful JWKS refresh.",
+		}, []string{"url"}),
+	}
+
+	collectors := []prometheus.Collector{m.failures, m.degraded, m.lastSuccess}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+func (m *JWKSMetrics) refreshFailed(url string) {
+	if m == nil {
+		return
+	}
+
+	m.failures.WithLabelValues(url).Inc()
+	m.degraded.WithLabelValues(url).Set(1)
+}
+
+func (m *JWKSMetrics) refreshed(url string, t time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.degraded.WithLabelValues(url).Set(0)
+	m.lastSuccess.WithLabelValues(url).Set(float64(t.Unix()))
+}
+
+// jwksSource is a remote key set and the state of its refreshes.
+type jwksSource struct {
+	url          string
+	storage      jwkset.Storage
+	keyfunc      keyfunc.Keyfunc
+	maxStaleness time.Duration
+	metrics      *JWKSMetrics
+	logger       *slog.Logger
+	policy       *TokenPolicy
+
+	m           sync.Mutex
+	lastErr     error
+	lastSuccess time.Time
+	// degraded is the state last reported by ready(), so that we only log
+	// when it changes.
+	degraded bool
+}
+
+func (s *jwksSource) setError(err error) {
+	s.m.Lock()
+	s.lastErr = err
+	s.m.Unlock()
+
+	s.metrics.refreshFailed(s.url)
+}
+
+func (s *jwksSource) setRefreshed() {
+	now := time.Now()
+
+	s.m.Lock()
+	s.lastErr = nil
+	s.lastSuccess = now
+	s.m.Unlock()
+
+	s.metrics.refreshed(s.url, now)
+}
+
+// state returns the age of the keys and the last refresh error.
+func (s *jwksSource) state() (time.Duration, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return time.Since(s.lastSuccess), s.lastErr
+}
+
+// stale returns an error if the keys are older than the staleness bound.
+func (s *jwksSource) stale() error {
+	if s.maxStaleness == 0 {
+		return nil
+	}
+
+	age, lastErr := s.state()
+	if lastErr == nil || age <= s.maxStaleness {
+		return nil
+	}
+
+	return fmt.Errorf("keys from %q have not been refreshed for %s: %w",
+		s.url, age.Round(time.Second), lastErr)
+}
+
+// Keyfunc implements jwt.Keyfunc, and refuses to use keys that are older than
+// the staleness bound, or that don't satisfy the key requirements of the
+// token policy.
+func (s *jwksSource) Keyfunc(token *jwt.Token) (any, error) {
+	err := s.stale()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.keyfunc.Keyfunc(token)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if s.policy != nil && s.policy.MaxKeyAge > 0 {
+		kid, _ := token.Header["kid"].(string)
+
+		// The key has just been resolved, so this is a local lookup.
+		jwk, err := s.storage.KeyRead(context.Background(), kid)
+		if err != nil {
+			return nil, fmt.Errorf("read key %q: %w", kid, err)
+		}
+
+		err = s.policy.CheckKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (s *jwksSource) ready(ctx context.Context) error {
+	keys, err := s.storage.KeyReadAll(ctx)
+	if err != nil {
+		return fmt.Errorf("read keys from %q: %w", s.url, err)
+	}
+
+	age, lastErr := s.state()
+
+	if len(keys) == 0 {
+		if lastErr != nil {
+			return fmt.Errorf("no keys loaded from %q: %w",
+				s.url, lastErr)
+		}
+
+		return fmt.Errorf("no keys loaded from %q", s.url)
+	}
+
+	err = s.stale()
+	if err != nil {
+		return err
+	}
+
+	degraded := lastErr != nil
+
+	s.m.Lock()
+	changed := s.degraded != degraded
+	s.degraded = degraded
+	s.m.Unlock()
+
+	switch {
+	case changed && degraded:
+		s.logger.WarnContext(ctx, "using the last known JWKS keys",
+			LogKeyError, lastErr,
+			"url", s.url,
+			"age", age.Round(time.Second))
+	case changed:
+		s.logger.InfoContext(ctx, "JWKS keys refreshed",
+			"url", s.url)
+	}
+
+	return nil
+}
+
+// jwksRefreshTransport marks the source as refreshed when a key set has been
+// fetched successfully, as the JWKS storage only reports failures.
+type jwksRefreshTransport struct {
+	next   http.RoundTripper
+	source *jwksSource
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *jwksRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK ||
+		req.URL.String() != t.source.url {
+		return res, err //nolint:wrapcheck
+	}
+
+	data, err := io.ReadAll(res.Body)
+
+	_ = res.Body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS response: %w", err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(data))
+
+	var jwks jwkset.JWKSMarshal
+
+	err = json.Unmarshal(data, &jwks)
+	if err == nil && len(jwks.Keys) > 0 {
+		t.source.setRefreshed()
+	}
+
+	return res, nil
+}
+
+// newJWKSSource creates a source that fetches and refreshes a remote key set.
+// Use its Keyfunc to verify tokens.
+func newJWKSSource(
+	ctx context.Context, jwksURL string, opts JWKSOptions,
+) (*jwksSource, error) {
+	if opts.RefreshInterval == 0 {
+		opts.RefreshInterval = time.Hour
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	if opts.UnknownKIDInterval == 0 {
+		opts.UnknownKIDInterval = 5 * time.Minute
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	u, err := url.ParseRequestURI(jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS URL: %w", err)
+	}
+
+	source := jwksSource{
+		url:          u.String(),
+		maxStaleness: opts.MaxStaleness,
+		metrics:      opts.Metrics,
+		logger:       opts.Logger,
+		// Count the staleness of keys from startup unless they were
+		// loaded from the cache.
+		lastSuccess: time.Now(),
+	}
+
+	if opts.Cache.enabled() {
+		opts.Client = cachingJWKSClient(
+			opts.Client, source.url, opts.Cache, opts.Logger)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	next := opts.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	refreshClient := *opts.Client
+
+	refreshClient.Transport = &jwksRefreshTransport{
+		next:   next,
+		source: &source,
+	}
+
+	storage, err := jwkset.NewStorageFromHTTP(u, jwkset.HTTPClientStorageOptions{
+		Client:                    &refreshClient,
+		Ctx:                       ctx,
+		HTTPTimeout:               opts.Timeout,
+		NoErrorReturnFirstHTTPReq: true,
+		RefreshInterval:           opts.RefreshInterval,
+		RefreshErrorHandler: func(ctx context.Context, err error) {
+			source.setError(err)
+
+			opts.Logger.ErrorContext(ctx, "failed to refresh JWKS",
+				LogKeyError, err,
+				"url", source.url)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create JWKS storage: %w", err)
+	}
+
+	if opts.Cache.enabled() {
+		modified, err := seedJWKSStorage(ctx, storage, source.url,
+			opts.Cache, opts.Logger)
+		if !modified.IsZero() {
+			source.m.Lock()
+			source.lastSuccess = modified
+			source.m.Unlock()
+		}
+
+		if err != nil {
+			opts.Logger.WarnContext(ctx, "failed to load cached JWKS",
+				LogKeyError, err,
+				"url", source.url)
+		}
+	}
+
+	client, err := jwkset.NewHTTPClient(jwkset.HTTPClientOptions{
+		HTTPURLs: map[string]jwkset.Storage{
+			source.url: storage,
+		},
+		RateLimitWaitMax: time.Minute,
+		RefreshUnknownKID: rate.NewLimiter(
+			rate.Every(opts.UnknownKIDInterval), 1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create JWKS client: %w", err)
+	}
+
+	source.storage = client
+
+	k, err := keyfunc.New(keyfunc.Options{
+		Ctx:     ctx,
+		Storage: client,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create keyfunc: %w", err)
+	}
+
+	source.keyfunc = k
+
+	return &source, nil
+}
+
+// Ready returns an error if the parser doesn't have any keys for one of its
+// JWKS sources, or if the keys are older than JWKSOptions.MaxStaleness. A
+// warning is logged for sources that are degraded but still within the bound.
+// Suitable for HealthServer.AddReadyFunction.
+func (p *JWTAuthInfoParser) Ready(ctx context.Context) error {
+	var errs []error
+
+	for _, s := range p.jwks {
+		err := s.ready(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Degraded returns true if the last refresh of one of the JWKS sources of the
+// parser failed, so that the last known keys are used.
+func (p *JWTAuthInfoParser) Degraded() bool {
+	for _, s := range p.jwks {
+		_, lastErr := s.state()
+		if lastErr != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JWKSKey is a public key that should be published in a JWKS document.
+type JWKSKey struct {
+	// KeyID is used as the "kid" of the key.
+	KeyID string
+	// Algorithm is the JWT "alg" that the key is used with.
+	Algorithm string
+	// Key is the public key, like a *ecdsa.PublicKey or
+	// ed25519.PublicKey.
+	Key crypto.PublicKey
+	// Certificates is an optional certificate chain for the key, published
+	// as "x5c". The first certificate must contain the key.
+	Certificates []*x509.Certificate
+}
+
+// NewJWKSHandler creates a handler that serves the public keys as a JWKS
+// document. Together with a TokenMinter this lets a service act as a local
+// issuer that other services can verify tokens against using
+// NewJWKSAuthInfoParser.
+func NewJWKSHandler(keys ...JWKSKey) (http.Handler, error) {
+	ctx := context.Background()
+	store := jwkset.NewMemoryStorage()
+
+	for _, k := range keys {
+		jwk, err := jwkset.NewJWKFromKey(k.Key, jwkset.JWKOptions{
+			Metadata: jwkset.JWKMetadataOptions{
+				ALG: jwkset.ALG(k.Algorithm),
+				KID: k.KeyID,
+				USE: jwkset.UseSig,
+			},
+			X509: jwkset.JWKX509Options{
+				X5C: k.Certificates,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create JWK for key %q: %w",
+				k.KeyID, err)
+		}
+
+		err = store.KeyWrite(ctx, jwk)
+		if err != nil {
+			return nil, fmt.Errorf("store JWK for key %q: %w",
+				k.KeyID, err)
+		}
+	}
+
+	doc, err := store.JSONPublic(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("marshal JWKS document: %w", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "max-age=300")
+
+		_, _ = w.Write(doc)
+	}), nil
+}
@@ -2,18 +2,24 @@ package elephantine
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/MicahParks/keyfunc/v3"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jellydator/ttlcache/v3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/twitchtv/twirp"
+	"golang.org/x/sync/errgroup"
 )
 
 // JWTClaims defines the claims that the elephant services understand.
@@ -23,10 +29,29 @@ type JWTClaims struct {
 	OriginalSub string `json:"-"`
 
 	Name            string   `json:"sub_name"`
+	Email           string   `json:"email,omitempty"`
 	Scope           string   `json:"scope"`
 	AuthorizedParty string   `json:"azp"`
 	ClientID        string   `json:"client_id"`
+	SessionID       string   `json:"sid,omitempty"`
 	Units           []string `json:"units,omitempty"`
+
+	// Actor identifies the party that is acting on behalf of the subject
+	// for impersonated requests.
+	Actor *ActorClaim `json:"act,omitempty"`
+
+	// RawClaims contains all the claims of the token, only populated
+	// when a ClaimsTransform is used.
+	RawClaims map[string]any `json:"-"`
+}
+
+// ActorClaim is the RFC 8693 "act" claim. Nested actors describe a delegation
+// chain, the outermost actor is the current one.
+type ActorClaim struct {
+	Subject  string      `json:"sub"`
+	Issuer   string      `json:"iss,omitempty"`
+	ClientID string      `json:"client_id,omitempty"`
+	Actor    *ActorClaim `json:"act,omitempty"`
 }
 
 // HasScope returns true if the Scope claim contains the named scope.
@@ -63,6 +88,21 @@ const authInfoCtxKey ctxKey = 1
 type AuthInfo struct {
 	Token  string
 	Claims JWTClaims
+	// Actor is the normalized subject URI of the acting party if the
+	// request is made on behalf of the subject, see JWTClaims.Actor.
+	Actor string
+}
+
+// ErrTokenRevoked is used to communicate that a token has been revoked.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// RevocationChecker can be implemented to reject tokens that have been revoked
+// before they expire, f.ex. through backchannel logout.
+type RevocationChecker interface {
+	// CheckRevocation returns an error wrapping ErrTokenRevoked if the
+	// token has been revoked. The claims have been validated, and the
+	// subject has been normalised.
+	CheckRevocation(claims JWTClaims) error
 }
 
 // ErrNoAuthorization is used to communicate that authorization was completely
@@ -77,16 +117,214 @@ type AuthInfoParser interface {
 }
 
 type JWTAuthInfoParser struct {
-	keyfunc     jwt.Keyfunc
-	validator   *jwt.Validator
-	cache       *ttlcache.Cache[string, AuthInfo]
-	scopePrefix *regexp.Regexp
+	issuers      map[string]jwtIssuerValidation
+	validMethods []string
+	cache        *ttlcache.Cache[string, AuthInfo]
+	failures     *ttlcache.Cache[[sha256.Size]byte, error]
+	failureTTL   time.Duration
+	cacheMargin  time.Duration
+	cacheMaxTTL  time.Duration
+	cacheMetrics *AuthInfoCacheMetrics
+	scopePrefix  *regexp.Regexp
+	revocation   RevocationChecker
+	transform    func(claims *JWTClaims) error
+	enrich       func(auth *AuthInfo) error
+	jwks         []*jwksSource
+	policy       *TokenPolicy
+}
+
+type jwtIssuerValidation struct {
+	keyfunc          jwt.Keyfunc
+	validator        *jwt.Validator
+	audiences        []string
+	audienceOptional bool
 }
 
 type JWTAuthInfoParserOptions struct {
 	Audience    string
 	Issuer      string
 	ScopePrefix string
+
+	// Audiences is a list of additional acceptable audiences, a token is
+	// accepted if its aud claim contains any of the audiences.
+	Audiences []string
+	// AudienceOptional allows tokens without an aud claim. Tokens that
+	// have an aud claim must still match one of the audiences.
+	AudienceOptional bool
+
+	// Issuers is a list of additional trusted issuers. Tokens are validated
+	// against the issuer that matches their iss claim. Only supported by
+	// NewJWKSAuthInfoParser.
+	Issuers []JWTIssuer
+	// JWKS controls how key sets are refreshed. Only used by
+	// NewJWKSAuthInfoParser.
+	JWKS JWKSOptions
+
+	// ValidMethods is the list of accepted signing methods, like "ES256"
+	// or "EdDSA" for providers that issue P-256 or Ed25519 signed tokens.
+	// Defaults to DefaultJWTSigningMethods.
+	ValidMethods []string
+	// Leeway is the clock skew tolerance used when validating time based
+	// claims. Defaults to 5 seconds.
+	Leeway time.Duration
+
+	// CacheSize is the maximum number of parsed tokens to cache, the least
+	// recently used token will be evicted when the cache is full. Defaults
+	// to DefaultAuthInfoCacheSize.
+	CacheSize uint64
+	// CacheMetrics is used to instrument the token cache if set.
+	CacheMetrics *AuthInfoCacheMetrics
+	// CacheExpiryMargin makes cached tokens expire from the cache this
+	// long before the token itself expires, so that a token isn't served
+	// from the cache after a clock-skewed expiry.
+	CacheExpiryMargin time.Duration
+	// CacheMaxTTL caps how long a token is cached for, so that
+	// long-lived tokens are validated again periodically. Zero means
+	// that tokens are cached until they expire.
+	CacheMaxTTL time.Duration
+	// FailureCacheTTL is how long a token that failed validation is
+	// remembered as invalid, so that clients that repeatedly present the
+	// same expired or malformed token don't trigger a full parse and key
+	// lookup for every request. Defaults to DefaultAuthFailureCacheTTL, a
+	// negative value disables the failure cache.
+	FailureCacheTTL time.Duration
+
+	// RevocationChecker is used to reject revoked tokens if set.
+	RevocationChecker RevocationChecker
+
+	// ClaimsTransform is called after the claims have been validated but
+	// before they are normalized and cached. Can be used to map
+	// tenant-specific claims from JWTClaims.RawClaims, like groups or
+	// organisation IDs, into units and scopes. Returning an error rejects
+	// the token.
+	ClaimsTransform func(claims *JWTClaims) error
+
+	// Policy is enforced in addition to the other options if set.
+	Policy *TokenPolicy
+
+	// Enrich is called with the AuthInfo of validated tokens before they
+	// are cached, and can f.ex. be used to add profile data from an
+	// external source. Returning an error rejects the token. See
+	// AuthenticationConfig.EnrichFromUserinfo.
+	Enrich func(auth *AuthInfo) error
+}
+
+// DefaultAuthInfoCacheSize is the default maximum number of cached tokens.
+const DefaultAuthInfoCacheSize = 10000
+
+// DefaultAuthFailureCacheTTL is the default time that validation failures are
+// cached for. Only failures that are caused by the token itself, like a bad
+// signature or an expired token, are cached.
+const DefaultAuthFailureCacheTTL = 10 * time.Second
+
+// AuthInfoCacheMetrics are metrics for the token cache of a JWTAuthInfoParser.
+type AuthInfoCacheMetrics struct {
+	hits        prometheus.Counter
+	misses      prometheus.Counter
+	failureHits prometheus.Counter
+	evictions   *prometheus.CounterVec
+}
+
+// NewAuthInfoCacheMetrics registers token cache metrics with the provided
+// registerer.
+func NewAuthInfoCacheMetrics(
+	registerer prometheus.Registerer,
+) (*AuthInfoCacheMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := AuthInfoCacheMetrics{
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "auth_info_cache_hits_total",
+			Help: "Number of tokens that were found in the auth info cache.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "auth_info_cache_misses_total",
+			Help: "Number of tokens that weren't found in the auth info cache.",
+		}),
+		failureHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "auth_info_cache_failure_hits_total",
+			Help: "Number of tokens that were rejected because of a cached validation failure.",
+		}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_info_cache_evictions_total",
+			Help: "Number of tokens that were evicted from the auth info cache.",
+		}, []string{"reason"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.hits, m.misses, m.failureHits, m.evictions,
+	}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+func (m *AuthInfoCacheMetrics) hit() {
+	if m == nil {
+		return
+	}
+
+	m.hits.Inc()
+}
+
+func (m *AuthInfoCacheMetrics) miss() {
+	if m == nil {
+		return
+	}
+
+	m.misses.Inc()
+}
+
+func (m *AuthInfoCacheMetrics) failureHit() {
+	if m == nil {
+		return
+	}
+
+	m.failureHits.Inc()
+}
+
+func (m *AuthInfoCacheMetrics) evicted(reason ttlcache.EvictionReason) {
+	if m == nil {
+		return
+	}
+
+	var label string
+
+	switch reason {
+	case ttlcache.EvictionReasonCapacityReached:
+		label = "capacity"
+	case ttlcache.EvictionReasonExpired:
+		label = "expired"
+	case ttlcache.EvictionReasonDeleted:
+		label = "deleted"
+	default:
+		label = "unknown"
+	}
+
+	m.evictions.WithLabelValues(label).Inc()
+}
+
+// DefaultJWTSigningMethods are the signing methods that are accepted by
+// default.
+var DefaultJWTSigningMethods = []string{
+	jwt.SigningMethodRS256.Name,
+	jwt.SigningMethodES384.Name,
+}
+
+// JWTIssuer is a trusted token issuer.
+type JWTIssuer struct {
+	Issuer  string
+	JWKSURL string
 }
 
 func ScopePrefixRegexp(prefix string) *regexp.Regexp {
@@ -96,33 +334,208 @@ func ScopePrefixRegexp(prefix string) *regexp.Regexp {
 	return regexp.MustCompile(fmt.Sprintf("\\b%s", regexp.QuoteMeta(prefix)))
 }
 
-func newJWTAuthInfoParser(keyfunc jwt.Keyfunc, opts JWTAuthInfoParserOptions) *JWTAuthInfoParser {
-	return &JWTAuthInfoParser{
+func newJWTIssuerValidation(
+	keyfunc jwt.Keyfunc, issuer string, opts JWTAuthInfoParserOptions,
+) jwtIssuerValidation {
+	leeway := opts.Leeway
+	if leeway == 0 {
+		leeway = 5 * time.Second
+	}
+
+	var audiences []string
+
+	if opts.Audience != "" {
+		audiences = append(audiences, opts.Audience)
+	}
+
+	audiences = append(audiences, opts.Audiences...)
+
+	// Audience validation is done by validateAudience, as the validator
+	// only supports a single required audience.
+	return jwtIssuerValidation{
 		keyfunc: keyfunc,
 		validator: jwt.NewValidator(
-			jwt.WithLeeway(5*time.Second),
-			jwt.WithIssuer(opts.Issuer),
-			jwt.WithAudience(opts.Audience),
+			jwt.WithLeeway(leeway),
+			jwt.WithIssuer(issuer),
 		),
-		cache:       ttlcache.New[string, AuthInfo](),
-		scopePrefix: ScopePrefixRegexp(opts.ScopePrefix),
+		audiences:        audiences,
+		audienceOptional: opts.AudienceOptional,
+	}
+}
+
+func (v jwtIssuerValidation) validateAudience(aud jwt.ClaimStrings) error {
+	if len(v.audiences) == 0 {
+		return nil
+	}
+
+	if len(aud) == 0 {
+		if v.audienceOptional {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %w: aud",
+			jwt.ErrTokenInvalidAudience, jwt.ErrTokenRequiredClaimMissing)
+	}
+
+	for _, want := range v.audiences {
+		if slices.Contains(aud, want) {
+			return nil
+		}
+	}
+
+	return jwt.ErrTokenInvalidAudience
+}
+
+func newJWTAuthInfoParser(
+	issuers map[string]jwtIssuerValidation, opts JWTAuthInfoParserOptions,
+) *JWTAuthInfoParser {
+	validMethods := opts.ValidMethods
+	if len(validMethods) == 0 {
+		validMethods = DefaultJWTSigningMethods
+	}
+
+	cacheSize := opts.CacheSize
+	if cacheSize == 0 {
+		cacheSize = DefaultAuthInfoCacheSize
+	}
+
+	cache := ttlcache.New(
+		ttlcache.WithCapacity[string, AuthInfo](cacheSize),
+	)
+
+	if opts.CacheMetrics != nil {
+		cache.OnEviction(func(
+			_ context.Context, reason ttlcache.EvictionReason,
+			_ *ttlcache.Item[string, AuthInfo],
+		) {
+			opts.CacheMetrics.evicted(reason)
+		})
+	}
+
+	failureTTL := opts.FailureCacheTTL
+	if failureTTL == 0 {
+		failureTTL = DefaultAuthFailureCacheTTL
+	}
+
+	var failures *ttlcache.Cache[[sha256.Size]byte, error]
+
+	if failureTTL > 0 {
+		failures = ttlcache.New(
+			ttlcache.WithCapacity[[sha256.Size]byte, error](cacheSize),
+		)
+	}
+
+	return &JWTAuthInfoParser{
+		issuers:      issuers,
+		validMethods: validMethods,
+		cache:        cache,
+		failures:     failures,
+		failureTTL:   failureTTL,
+		cacheMargin:  opts.CacheExpiryMargin,
+		cacheMaxTTL:  opts.CacheMaxTTL,
+		cacheMetrics: opts.CacheMetrics,
+		scopePrefix:  ScopePrefixRegexp(opts.ScopePrefix),
+		revocation:   opts.RevocationChecker,
+		transform:    opts.ClaimsTransform,
+		enrich:       opts.Enrich,
+		policy:       opts.Policy,
 	}
 }
 
 func NewJWKSAuthInfoParser(ctx context.Context, jwksUrl string, opts JWTAuthInfoParserOptions) (*JWTAuthInfoParser, error) {
-	k, err := keyfunc.NewDefaultCtx(ctx, []string{jwksUrl})
+	source, err := newJWKSSource(ctx, jwksUrl, opts.JWKS)
 	if err != nil {
 		return nil, fmt.Errorf("could not create keyfunc: %w", err)
 	}
-	return newJWTAuthInfoParser(k.Keyfunc, opts), nil
+
+	source.policy = opts.Policy
+
+	sources := []*jwksSource{source}
+
+	issuers := map[string]jwtIssuerValidation{
+		opts.Issuer: newJWTIssuerValidation(source.Keyfunc, opts.Issuer, opts),
+	}
+
+	for _, iss := range opts.Issuers {
+		if _, exists := issuers[iss.Issuer]; exists {
+			return nil, fmt.Errorf("duplicate issuer %q", iss.Issuer)
+		}
+
+		source, err := newJWKSSource(ctx, iss.JWKSURL, opts.JWKS)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not create keyfunc for issuer %q: %w",
+				iss.Issuer, err)
+		}
+
+		source.policy = opts.Policy
+
+		sources = append(sources, source)
+
+		issuers[iss.Issuer] = newJWTIssuerValidation(
+			source.Keyfunc, iss.Issuer, opts)
+	}
+
+	p := newJWTAuthInfoParser(issuers, opts)
+
+	p.jwks = sources
+
+	return p, nil
 }
 
 func NewStaticAuthInfoParser(key ecdsa.PublicKey, opts JWTAuthInfoParserOptions) *JWTAuthInfoParser {
-	return newJWTAuthInfoParser(func(t *jwt.Token) (interface{}, error) {
-		return &key, nil
+	return NewStaticKeyAuthInfoParser(&key, opts)
+}
+
+// NewStaticKeyAuthInfoParser creates a parser that validates tokens using a
+// single public key, like a *ecdsa.PublicKey, ed25519.PublicKey, or
+// *rsa.PublicKey. Remember to set ValidMethods if the key is used with other
+// signing methods than DefaultJWTSigningMethods, f.ex. "ES256" or "EdDSA".
+func NewStaticKeyAuthInfoParser(key crypto.PublicKey, opts JWTAuthInfoParserOptions) *JWTAuthInfoParser {
+	kf := func(t *jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+
+	return newJWTAuthInfoParser(map[string]jwtIssuerValidation{
+		opts.Issuer: newJWTIssuerValidation(kf, opts.Issuer, opts),
 	}, opts)
 }
 
+// issuerValidation returns the validation that should be used for tokens from
+// the given issuer.
+func (p *JWTAuthInfoParser) issuerValidation(issuer string) (jwtIssuerValidation, error) {
+	// Single issuer parsers leave issuer validation to the validator, which
+	// also allows for not validating the issuer at all.
+	if len(p.issuers) == 1 {
+		for _, v := range p.issuers {
+			return v, nil
+		}
+	}
+
+	v, ok := p.issuers[issuer]
+	if !ok {
+		return jwtIssuerValidation{}, fmt.Errorf(
+			"untrusted issuer %q", issuer)
+	}
+
+	return v, nil
+}
+
+// keyfunc dispatches key lookups to the keyfunc of the token issuer.
+func (p *JWTAuthInfoParser) keyfunc(t *jwt.Token) (interface{}, error) {
+	issuer, err := t.Claims.GetIssuer()
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer: %w", err)
+	}
+
+	v, err := p.issuerValidation(issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.keyfunc(t)
+}
+
 func (p *JWTAuthInfoParser) AuthInfoFromHeader(authorization string) (*AuthInfo, error) {
 	if authorization == "" {
 		return nil, ErrNoAuthorization
@@ -135,20 +548,181 @@ func (p *JWTAuthInfoParser) AuthInfoFromHeader(authorization string) (*AuthInfo,
 		return nil, errors.New("only bearer tokens are supported")
 	}
 
+	return p.authInfoFromToken(token, p.keyfunc)
+}
+
+// TokenResult is the outcome of validating one of the tokens passed to
+// AuthInfoFromTokens.
+type TokenResult struct {
+	AuthInfo *AuthInfo
+	Err      error
+}
+
+// AuthInfoFromTokens validates a batch of bearer tokens, without the "Bearer "
+// prefix, in parallel. Key lookups are shared by the tokens in the batch, so a
+// key is only looked up once per issuer and key ID. The results are returned
+// in the same order as the tokens, empty tokens get ErrNoAuthorization.
+func (p *JWTAuthInfoParser) AuthInfoFromTokens(tokens []string) []TokenResult {
+	results := make([]TokenResult, len(tokens))
+	keys := newBatchKeyfunc(p.keyfunc)
+
+	var grp errgroup.Group
+
+	grp.SetLimit(runtime.GOMAXPROCS(0))
+
+	for i, token := range tokens {
+		grp.Go(func() error {
+			if token == "" {
+				results[i].Err = ErrNoAuthorization
+
+				return nil
+			}
+
+			auth, err := p.authInfoFromToken(token, keys.Keyfunc)
+
+			results[i] = TokenResult{
+				AuthInfo: auth,
+				Err:      err,
+			}
+
+			return nil
+		})
+	}
+
+	_ = grp.Wait()
+
+	return results
+}
+
+// batchKeyfunc memoizes key lookups by issuer, key ID, and algorithm.
+type batchKeyfunc struct {
+	keyfunc jwt.Keyfunc
+
+	m       sync.Mutex
+	lookups map[[3]string]*keyLookup
+}
+
+type keyLookup struct {
+	once sync.Once
+	key  any
+	err  error
+}
+
+func newBatchKeyfunc(keyfunc jwt.Keyfunc) *batchKeyfunc {
+	return &batchKeyfunc{
+		keyfunc: keyfunc,
+		lookups: make(map[[3]string]*keyLookup),
+	}
+}
+
+// Keyfunc implements jwt.Keyfunc.
+func (b *batchKeyfunc) Keyfunc(t *jwt.Token) (any, error) {
+	issuer, _ := t.Claims.GetIssuer()
+	kid, _ := t.Header["kid"].(string)
+
+	id := [3]string{issuer, kid, t.Method.Alg()}
+
+	b.m.Lock()
+
+	l, ok := b.lookups[id]
+	if !ok {
+		l = &keyLookup{}
+		b.lookups[id] = l
+	}
+
+	b.m.Unlock()
+
+	l.once.Do(func() {
+		l.key, l.err = b.keyfunc(t)
+	})
+
+	return l.key, l.err
+}
+
+// authInfoFromToken validates a token, using the token and failure caches.
+func (p *JWTAuthInfoParser) authInfoFromToken(
+	token string, keyfunc jwt.Keyfunc,
+) (*AuthInfo, error) {
 	item := p.cache.Get(token)
 	if item != nil && !item.IsExpired() {
+		p.cacheMetrics.hit()
+
 		value := item.Value()
 
+		// Tokens can be revoked after they have been cached.
+		err := p.checkRevocation(value.Claims)
+		if err != nil {
+			p.cache.Delete(token)
+
+			return nil, err
+		}
+
 		return &value, nil
 	}
 
+	p.cacheMetrics.miss()
+
+	if p.failures == nil {
+		return p.parseToken(token, keyfunc)
+	}
+
+	// Failures are keyed by a hash so that we don't keep invalid, but
+	// possibly sensitive, tokens around.
+	key := sha256.Sum256([]byte(token))
+
+	failure := p.failures.Get(key)
+	if failure != nil && !failure.IsExpired() {
+		p.cacheMetrics.failureHit()
+
+		return nil, failure.Value()
+	}
+
+	auth, err := p.parseToken(token, keyfunc)
+	if err != nil {
+		if isDefinitiveTokenError(err) {
+			p.failures.Set(key, err, p.failureTTL)
+		}
+
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+// isDefinitiveTokenError returns true for errors that are caused by the token
+// itself, and that won't go away if the token is validated again. Errors that
+// can be transient, like failing to read the keys or to check for revocation,
+// or unknown key IDs during a key rotation, are not definitive.
+func isDefinitiveTokenError(err error) bool {
+	for _, target := range []error{
+		jwt.ErrTokenMalformed,
+		jwt.ErrTokenSignatureInvalid,
+		jwt.ErrTokenExpired,
+		jwt.ErrTokenInvalidAudience,
+		jwt.ErrTokenInvalidIssuer,
+		jwt.ErrTokenRequiredClaimMissing,
+		ErrTokenRevoked,
+		ErrTokenPolicy,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseToken parses and validates a token, and caches the resulting AuthInfo.
+func (p *JWTAuthInfoParser) parseToken(
+	token string, keyfunc jwt.Keyfunc,
+) (*AuthInfo, error) {
 	var claims JWTClaims
 
-	_, err := jwt.ParseWithClaims(token, &claims, p.keyfunc,
-		jwt.WithValidMethods([]string{
-			jwt.SigningMethodRS256.Name,
-			jwt.SigningMethodES384.Name,
-		}))
+	// Claims are validated separately below, using the validator for
+	// the issuer.
+	_, err := jwt.ParseWithClaims(token, &claims, keyfunc,
+		jwt.WithValidMethods(p.validMethods),
+		jwt.WithoutClaimsValidation())
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
@@ -158,21 +732,38 @@ func (p *JWTAuthInfoParser) AuthInfoFromHeader(authorization string) (*AuthInfo,
 		return nil, fmt.Errorf("invalid claims: %w", err)
 	}
 
-	unitBase := &url.URL{
-		Scheme: "core",
-		Host:   "unit",
+	if p.policy != nil {
+		err := p.policy.Check(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.transform != nil {
+		raw := jwt.MapClaims{}
+
+		// The token has already been verified above.
+		_, _, err := jwt.NewParser().ParseUnverified(token, raw)
+		if err != nil {
+			return nil, fmt.Errorf("read raw claims: %w", err)
+		}
+
+		claims.RawClaims = raw
+
+		err = p.transform(&claims)
+		if err != nil {
+			return nil, fmt.Errorf("transform claims: %w", err)
+		}
 	}
 
 	for i, u := range claims.Units {
-		parsed, err := url.Parse(u)
+		normalized, err := NormalizeUnitURI(u)
 		if err != nil {
 			return nil, fmt.Errorf("invalid unit claim %q: %w",
 				u, err)
 		}
 
-		if parsed.Scheme == "" {
-			claims.Units[i] = unitBase.ResolveReference(parsed).String()
-		}
+		claims.Units[i] = normalized
 	}
 
 	if p.scopePrefix != nil {
@@ -187,47 +778,197 @@ func (p *JWTAuthInfoParser) AuthInfoFromHeader(authorization string) (*AuthInfo,
 	claims.OriginalSub = claims.Subject
 	claims.Subject = sub
 
+	var actor string
+
+	if claims.Actor != nil {
+		if claims.Actor.Subject == "" && claims.Actor.ClientID == "" {
+			return nil, errors.New("invalid act claim: missing sub")
+		}
+
+		a, err := subjectURI(claims.Actor.Subject, claims.Actor.ClientID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid act claim: %w", err)
+		}
+
+		actor = a
+	}
+
+	err = p.checkRevocation(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	auth := AuthInfo{
 		Token:  token,
 		Claims: claims,
+		Actor:  actor,
+	}
+
+	if p.enrich != nil {
+		err := p.enrich(&auth)
+		if err != nil {
+			return nil, fmt.Errorf("enrich auth info: %w", err)
+		}
 	}
 
 	if auth.Claims.ExpiresAt != nil {
-		p.cache.Set(token, auth, time.Until(auth.Claims.ExpiresAt.Time))
+		expires := auth.Claims.ExpiresAt.Time
+
+		// Don't let the cache outlive the max age of the token.
+		if p.policy != nil && p.policy.MaxTokenAge > 0 {
+			maxAge := auth.Claims.IssuedAt.Add(p.policy.MaxTokenAge)
+			if maxAge.Before(expires) {
+				expires = maxAge
+			}
+		}
+
+		ttl := time.Until(expires) - p.cacheMargin
+
+		if p.cacheMaxTTL > 0 {
+			ttl = min(ttl, p.cacheMaxTTL)
+		}
+
+		// Tokens that are accepted because of the leeway would
+		// otherwise be cached without a TTL.
+		if ttl > 0 {
+			p.cache.Set(token, auth, ttl)
+		}
 	}
 
 	return &auth, nil
 }
 
+// InvalidateRevoked checks the cached tokens against the revocation checker and
+// drops the revoked tokens from the cache. Returns the number of dropped
+// tokens.
+func (p *JWTAuthInfoParser) InvalidateRevoked() int {
+	if p.revocation == nil {
+		return 0
+	}
+
+	var revoked []string
+
+	p.cache.Range(func(item *ttlcache.Item[string, AuthInfo]) bool {
+		err := p.checkRevocation(item.Value().Claims)
+		if err != nil {
+			revoked = append(revoked, item.Key())
+		}
+
+		return true
+	})
+
+	for _, token := range revoked {
+		p.cache.Delete(token)
+	}
+
+	return len(revoked)
+}
+
+// SweepCache drops expired and revoked tokens from the caches. Expired tokens
+// are otherwise only dropped when they are looked up again, or when the cache
+// is full.
+func (p *JWTAuthInfoParser) SweepCache() {
+	p.cache.DeleteExpired()
+
+	if p.failures != nil {
+		p.failures.DeleteExpired()
+	}
+
+	p.InvalidateRevoked()
+}
+
+// RunCacheSweep calls SweepCache with the given interval until the context is
+// cancelled.
+func (p *JWTAuthInfoParser) RunCacheSweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.SweepCache()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// AuthInfoCacheSnapshot describes the state of the token caches.
+type AuthInfoCacheSnapshot struct {
+	Tokens   int `json:"tokens"`
+	Failures int `json:"failures"`
+}
+
+// CacheSnapshot returns the number of cached tokens and validation failures.
+// Can be used as an elephantine.SnapshotFunc.
+func (p *JWTAuthInfoParser) CacheSnapshot(_ context.Context) (any, error) {
+	snap := AuthInfoCacheSnapshot{
+		Tokens: p.cache.Len(),
+	}
+
+	if p.failures != nil {
+		snap.Failures = p.failures.Len()
+	}
+
+	return snap, nil
+}
+
+func (p *JWTAuthInfoParser) checkRevocation(claims JWTClaims) error {
+	if p.revocation == nil {
+		return nil
+	}
+
+	err := p.revocation.CheckRevocation(claims)
+	if err != nil {
+		return fmt.Errorf("revocation check: %w", err)
+	}
+
+	return nil
+}
+
 var (
 	appURI  = url.URL{Scheme: "core", Host: "application"}
 	userURI = url.URL{Scheme: "core", Host: "user"}
 )
 
 func claimsToSubject(claims JWTClaims) (string, error) {
-	parsedSub, err := url.Parse(claims.Subject)
+	return subjectURI(claims.Subject, claims.ClientID)
+}
+
+func subjectURI(subject string, clientID string) (string, error) {
+	parsedSub, err := url.Parse(subject)
 	if err != nil {
 		return "", fmt.Errorf("invalid sub claim: %w", err)
 	}
 
 	// This is a fully qualified subject URI, return it as-is.
 	if parsedSub.Scheme != "" {
-		return claims.Subject, nil
+		return subject, nil
 	}
 
 	// This is an application token, return
 	// "core://application/{.AuthorizedParty}".
-	if claims.ClientID != "" {
-		return appURI.JoinPath(claims.ClientID).String(), nil
+	if clientID != "" {
+		return appURI.JoinPath(clientID).String(), nil
 	}
 
 	// Assume user URI and return "core://user/{.Subject}".
-	return userURI.JoinPath(claims.Subject).String(), nil
+	return userURI.JoinPath(subject).String(), nil
 }
 
 // Valid validates the jwt.RegisteredClaims.
 func (p *JWTAuthInfoParser) Valid(c JWTClaims) error {
-	return p.validator.Validate(c.RegisteredClaims)
+	v, err := p.issuerValidation(c.Issuer)
+	if err != nil {
+		return err
+	}
+
+	err = v.validator.Validate(c.RegisteredClaims)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return v.validateAudience(c.Audience)
 }
 
 // SetAuthInfo creates a child context with the given authentication
@@ -251,9 +992,9 @@ func RequireAnyScope(ctx context.Context, scopes ...string) (*AuthInfo, error) {
 	}
 
 	if !auth.Claims.HasAnyScope(scopes...) {
-		return nil, twirp.PermissionDenied.Errorf(
+		return nil, twirp.WrapError(twirp.PermissionDenied.Errorf(
 			"one of the the scopes %s is required",
-			strings.Join(scopes, ", "))
+			strings.Join(scopes, ", ")), errInsufficientScope)
 	}
 
 	return auth, nil
@@ -4,11 +4,20 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/ttab/elephantine"
 	"github.com/ttab/elephantine/test"
 )
@@ -190,3 +199,736 @@ func TestAuthInfoSubjectMapping(t *testing.T) {
 			"preserve original sub")
 	}
 }
+
+func TestAuthInfoActor(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(
+		jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{},
+	)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "7b328bf3-a53b-4024-a895-c68cb14fdd97",
+		},
+		Actor: &elephantine.ActorClaim{
+			Subject:  "de5c1a4c-5f8a-4c8e-8a5f-3b1d3f7c0e11",
+			ClientID: "support-tool",
+		},
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	info, err := parser.AuthInfoFromHeader(fmt.Sprintf("Bearer %s", ss))
+	test.Must(t, err, "parse token")
+
+	test.Equal(t, "core://user/7b328bf3-a53b-4024-a895-c68cb14fdd97",
+		info.Claims.Subject, "keep the impersonated user as the subject")
+	test.Equal(t, "core://application/support-tool", info.Actor,
+		"expose the acting application")
+}
+
+func testJWKSServer(t *testing.T, kid string, key *ecdsa.PrivateKey) string {
+	t.Helper()
+
+	ecdh, err := key.PublicKey.ECDH()
+	test.Must(t, err, "convert public key")
+
+	// Uncompressed point: 0x04 || X || Y.
+	point := ecdh.Bytes()[1:]
+	size := len(point) / 2
+
+	jwks := map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "EC",
+				"crv": "P-384",
+				"alg": "ES384",
+				"use": "sig",
+				"kid": kid,
+				"x":   base64.RawURLEncoding.EncodeToString(point[:size]),
+				"y":   base64.RawURLEncoding.EncodeToString(point[size:]),
+			},
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		w.Header().Set("Content-Type", "application/json")
+
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestMultipleIssuers(t *testing.T) {
+	ctx := test.Context(t)
+
+	keyA, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key A")
+
+	keyB, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key B")
+
+	parser, err := elephantine.NewJWKSAuthInfoParser(ctx,
+		testJWKSServer(t, "a", keyA),
+		elephantine.JWTAuthInfoParserOptions{
+			Issuer: "realm-a",
+			Issuers: []elephantine.JWTIssuer{
+				{
+					Issuer:  "realm-b",
+					JWKSURL: testJWKSServer(t, "b", keyB),
+				},
+			},
+		})
+	test.Must(t, err, "create parser")
+
+	sign := func(kid string, key *ecdsa.PrivateKey, issuer string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    issuer,
+				Subject:   "someone",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+
+		token.Header["kid"] = kid
+
+		ss, err := token.SignedString(key)
+		test.Must(t, err, "sign JWT token")
+
+		return "Bearer " + ss
+	}
+
+	_, err = parser.AuthInfoFromHeader(sign("a", keyA, "realm-a"))
+	test.Must(t, err, "accept token from primary issuer")
+
+	_, err = parser.AuthInfoFromHeader(sign("b", keyB, "realm-b"))
+	test.Must(t, err, "accept token from additional issuer")
+
+	_, err = parser.AuthInfoFromHeader(sign("a", keyA, "realm-b"))
+	test.MustNot(t, err, "reject token signed by the wrong issuer")
+
+	_, err = parser.AuthInfoFromHeader(sign("a", keyA, "realm-c"))
+	test.MustNot(t, err, "reject token from unknown issuer")
+}
+
+func TestConfigureValidMethods(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		ValidMethods: []string{jwt.SigningMethodRS256.Name},
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	_, err = parser.AuthInfoFromHeader(fmt.Sprintf("Bearer %s", ss))
+	test.MustNot(t, err, "reject token with disallowed signing method")
+}
+
+func TestConfigureLeeway(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		Leeway: time.Minute,
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-30 * time.Second)),
+		},
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	_, err = parser.AuthInfoFromHeader(fmt.Sprintf("Bearer %s", ss))
+	test.Must(t, err, "accept recently expired token within leeway")
+}
+
+type revokeSubjects map[string]bool
+
+func (r revokeSubjects) CheckRevocation(claims elephantine.JWTClaims) error {
+	if r[claims.Subject] {
+		return elephantine.ErrTokenRevoked
+	}
+
+	return nil
+}
+
+func TestRevocationChecker(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	revoked := revokeSubjects{}
+
+	parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		RevocationChecker: revoked,
+	})
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "someone",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	_, err = parser.AuthInfoFromHeader(fmt.Sprintf("Bearer %s", ss))
+	test.Must(t, err, "accept token before revocation")
+
+	revoked["core://user/someone"] = true
+
+	_, err = parser.AuthInfoFromHeader(fmt.Sprintf("Bearer %s", ss))
+	test.MustNot(t, err, "reject cached token after revocation")
+}
+
+func TestAuthInfoCacheExpiry(t *testing.T) {
+	key := test.NewSigningKey(t)
+	revoked := revokeSubjects{}
+
+	parser := key.Parser(elephantine.JWTAuthInfoParserOptions{
+		RevocationChecker: revoked,
+		CacheExpiryMargin: 10 * time.Second,
+		CacheMaxTTL:       50 * time.Millisecond,
+	})
+
+	tokenFor := func(sub string, ttl time.Duration) string {
+		return key.AccessKey(t, elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   sub,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			},
+		})
+	}
+
+	cachedTokens := func() int {
+		snap, err := parser.CacheSnapshot(test.Context(t))
+		test.Must(t, err, "get cache snapshot")
+
+		return snap.(elephantine.AuthInfoCacheSnapshot).Tokens
+	}
+
+	_, err := parser.AuthInfoFromHeader(tokenFor("short-lived", 5*time.Second))
+	test.Must(t, err, "accept the short-lived token")
+
+	test.Equal(t, 0, cachedTokens(),
+		"don't cache tokens that expire within the margin")
+
+	_, err = parser.AuthInfoFromHeader(tokenFor("revoked", time.Hour))
+	test.Must(t, err, "accept the token before revocation")
+
+	test.Equal(t, 1, cachedTokens(), "cache the token")
+
+	revoked["core://user/revoked"] = true
+
+	test.Equal(t, 1, parser.InvalidateRevoked(),
+		"drop the revoked token")
+	test.Equal(t, 0, cachedTokens(), "remove the revoked token")
+
+	_, err = parser.AuthInfoFromHeader(tokenFor("service", time.Hour))
+	test.Must(t, err, "accept the long-lived token")
+
+	time.Sleep(100 * time.Millisecond)
+
+	parser.SweepCache()
+
+	test.Equal(t, 0, cachedTokens(),
+		"sweep tokens that have been cached for longer than the max TTL")
+}
+
+func TestUnitHelpers(t *testing.T) {
+	claims := elephantine.JWTClaims{
+		Units: []string{
+			"core://unit/editorial",
+			"/news/*",
+			"external://resource/thing",
+		},
+	}
+
+	hasUnit := map[string]bool{
+		"core://unit/editorial":        true,
+		"editorial":                    true,
+		"/editorial":                   true,
+		"core://unit/editorial/sports": false,
+		"core://unit/news":             true,
+		"news/sports":                  true,
+		"core://unit/news/sports/golf": true,
+		"core://unit/newsroom":         false,
+		"external://resource/thing":    true,
+		"core://unit/thing":            false,
+	}
+
+	for uri, want := range hasUnit {
+		test.Equal(t, want, claims.HasUnit(uri),
+			"check membership in %q", uri)
+	}
+
+	hasPrefix := map[string]bool{
+		"core://unit/":         true,
+		"core://unit/edit":     false,
+		"editorial":            true,
+		"news/sports":          true,
+		"external://resource":  true,
+		"external://resources": false,
+		"core://unit/other":    false,
+	}
+
+	for prefix, want := range hasPrefix {
+		test.Equal(t, want, claims.HasAnyUnitPrefix(prefix),
+			"check units under %q", prefix)
+	}
+}
+
+func TestAudienceValidation(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	sign := func(aud ...string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Audience: aud,
+			},
+		})
+
+		ss, err := token.SignedString(jwtKey)
+		test.Must(t, err, "sign JWT token")
+
+		return "Bearer " + ss
+	}
+
+	strict := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		Audience:  "repository",
+		Audiences: []string{"index"},
+	})
+
+	optional := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		Audiences:        []string{"repository"},
+		AudienceOptional: true,
+	})
+
+	_, err = strict.AuthInfoFromHeader(sign("repository"))
+	test.Must(t, err, "accept the primary audience")
+
+	_, err = strict.AuthInfoFromHeader(sign("other", "index"))
+	test.Must(t, err, "accept an additional audience in an aud list")
+
+	_, err = strict.AuthInfoFromHeader(sign("other"))
+	test.MustNot(t, err, "reject an unknown audience")
+
+	_, err = strict.AuthInfoFromHeader(sign())
+	test.MustNot(t, err, "reject a missing audience")
+
+	_, err = optional.AuthInfoFromHeader(sign())
+	test.Must(t, err, "accept a missing audience when optional")
+
+	_, err = optional.AuthInfoFromHeader(sign("other"))
+	test.MustNot(t, err, "reject an unknown audience when optional")
+}
+
+type groupClaims struct {
+	elephantine.JWTClaims
+
+	Groups []string `json:"groups"`
+}
+
+func TestClaimsTransform(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+		ClaimsTransform: func(claims *elephantine.JWTClaims) error {
+			groups, _ := claims.RawClaims["groups"].([]any)
+
+			for _, g := range groups {
+				name, _ := g.(string)
+
+				switch name {
+				case "editors":
+					claims.Scope += " doc_write"
+				case "banned":
+					return errors.New("banned")
+				default:
+					claims.Units = append(claims.Units, name)
+				}
+			}
+
+			return nil
+		},
+	})
+
+	sign := func(groups ...string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES384, groupClaims{
+			JWTClaims: elephantine.JWTClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "someone",
+				},
+				Scope: "doc_read",
+			},
+			Groups: groups,
+		})
+
+		ss, err := token.SignedString(jwtKey)
+		test.Must(t, err, "sign JWT token")
+
+		return "Bearer " + ss
+	}
+
+	info, err := parser.AuthInfoFromHeader(sign("editors", "sports"))
+	test.Must(t, err, "parse token")
+
+	test.Equal(t, "doc_read doc_write", info.Claims.Scope,
+		"map groups to scopes")
+	test.EqualDiff(t, []string{"core://unit/sports"}, info.Claims.Units,
+		"map groups to normalized units")
+
+	_, err = parser.AuthInfoFromHeader(sign("banned"))
+	test.MustNot(t, err, "reject token when the transform fails")
+}
+
+func TestFailureCache(t *testing.T) {
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES384, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "someone",
+		},
+	})
+
+	ss, err := token.SignedString(jwtKey)
+	test.Must(t, err, "sign JWT token")
+
+	cases := map[string]struct {
+		TTL   time.Duration
+		Err   error
+		Calls int
+	}{
+		"cached": {
+			Err:   fmt.Errorf("%w: rejected", elephantine.ErrTokenRevoked),
+			Calls: 1,
+		},
+		"disabled": {
+			TTL:   -1,
+			Err:   fmt.Errorf("%w: rejected", elephantine.ErrTokenRevoked),
+			Calls: 3,
+		},
+		"transient": {
+			Err:   errors.New("database unavailable"),
+			Calls: 3,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var calls int
+
+			reg := prometheus.NewRegistry()
+
+			metrics, err := elephantine.NewAuthInfoCacheMetrics(reg)
+			test.Must(t, err, "create cache metrics")
+
+			parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey, elephantine.JWTAuthInfoParserOptions{
+				FailureCacheTTL: tc.TTL,
+				CacheMetrics:    metrics,
+				ClaimsTransform: func(_ *elephantine.JWTClaims) error {
+					calls++
+
+					return tc.Err
+				},
+			})
+
+			for range 3 {
+				_, err = parser.AuthInfoFromHeader("Bearer " + ss)
+				test.MustNot(t, err, "reject the token")
+			}
+
+			test.Equal(t, tc.Calls, calls,
+				"validate the token the expected number of times")
+
+			expected := fmt.Sprintf(`
+# HELP auth_info_cache_failure_hits_total Number of tokens that were rejected because of a cached validation failure.
+# TYPE auth_info_cache_failure_hits_total counter
+auth_info_cache_failure_hits_total %d
+`, 3-tc.Calls)
+
+			err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+				"auth_info_cache_failure_hits_total")
+			test.Must(t, err, "count failure cache hits")
+		})
+	}
+}
+
+func TestJWKSReady(t *testing.T) {
+	ctx := test.Context(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	broken := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(broken.Close)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewJWKSMetrics(reg)
+	test.Must(t, err, "create JWKS metrics")
+
+	parser, err := elephantine.NewJWKSAuthInfoParser(ctx,
+		testJWKSServer(t, "a", key),
+		elephantine.JWTAuthInfoParserOptions{
+			Issuer: "realm-a",
+			JWKS: elephantine.JWKSOptions{
+				Metrics: metrics,
+			},
+		})
+	test.Must(t, err, "create parser")
+
+	test.Must(t, parser.Ready(ctx), "be ready with a loaded key set")
+
+	parser, err = elephantine.NewJWKSAuthInfoParser(ctx, broken.URL,
+		elephantine.JWTAuthInfoParserOptions{
+			Issuer: "realm-a",
+			JWKS: elephantine.JWKSOptions{
+				Metrics: metrics,
+			},
+		})
+	test.Must(t, err, "create parser for a broken JWKS endpoint")
+
+	test.MustNot(t, parser.Ready(ctx), "not be ready without keys")
+
+	expected := fmt.Sprintf(`
+# HELP jwks_refresh_failures_total Number of failed JWKS refreshes.
+# TYPE jwks_refresh_failures_total counter
+jwks_refresh_failures_total{url=%q} 1
+`, broken.URL)
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"jwks_refresh_failures_total")
+	test.Must(t, err, "count the failed refresh")
+}
+
+func TestJWKSMaxStaleness(t *testing.T) {
+	ctx := test.Context(t)
+
+	key := test.NewSigningKey(t, test.KeyTypeES256)
+
+	handler, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     key.KeyID,
+		Algorithm: key.Method.Alg(),
+		Key:       key.Public(),
+	})
+	test.Must(t, err, "create JWKS handler")
+
+	var outage atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		if outage.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewJWKSMetrics(reg)
+	test.Must(t, err, "create JWKS metrics")
+
+	parser, err := elephantine.NewJWKSAuthInfoParser(ctx, server.URL,
+		elephantine.JWTAuthInfoParserOptions{
+			Issuer:       "local",
+			ValidMethods: []string{key.Method.Alg()},
+			JWKS: elephantine.JWKSOptions{
+				RefreshInterval: 50 * time.Millisecond,
+				MaxStaleness:    500 * time.Millisecond,
+				Metrics:         metrics,
+			},
+		})
+	test.Must(t, err, "create parser")
+
+	token := key.AccessKey(t, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  "local",
+			Subject: "someone",
+		},
+	})
+
+	test.Must(t, parser.Ready(ctx), "be ready with a loaded key set")
+	test.Equal(t, false, parser.Degraded(), "not be degraded")
+
+	outage.Store(true)
+
+	time.Sleep(150 * time.Millisecond)
+
+	test.Equal(t, true, parser.Degraded(), "be degraded during the outage")
+	test.Must(t, parser.Ready(ctx), "stay ready within the staleness bound")
+
+	_, err = parser.AuthInfoFromHeader(token)
+	test.Must(t, err, "accept tokens within the staleness bound")
+
+	expected := fmt.Sprintf(`
+# HELP jwks_degraded Set to 1 when the last JWKS refresh failed and the last known keys are used.
+# TYPE jwks_degraded gauge
+jwks_degraded{url=%q} 1
+`, server.URL)
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"jwks_degraded")
+	test.Must(t, err, "report the source as degraded")
+
+	time.Sleep(500 * time.Millisecond)
+
+	test.MustNot(t, parser.Ready(ctx), "fail when the keys are too old")
+
+	// Use a new token, as validated tokens are cached by the parser.
+	_, err = parser.AuthInfoFromHeader(key.AccessKey(t, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  "local",
+			Subject: "someone-else",
+		},
+	}))
+	test.MustNot(t, err, "reject tokens when the keys are too old")
+
+	outage.Store(false)
+
+	time.Sleep(150 * time.Millisecond)
+
+	test.Equal(t, false, parser.Degraded(), "recover after the outage")
+	test.Must(t, parser.Ready(ctx), "be ready after the outage")
+
+	_, err = parser.AuthInfoFromHeader(token)
+	test.Must(t, err, "accept tokens after the outage")
+}
+
+func TestAuthInfoFromTokens(t *testing.T) {
+	key := test.NewSigningKey(t)
+	other := test.NewSigningKey(t)
+
+	parser := key.Parser(elephantine.JWTAuthInfoParserOptions{})
+
+	token := func(k *test.SigningKey, sub string) string {
+		return strings.TrimPrefix(k.AccessKey(t, elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: sub,
+			},
+		}), "Bearer ")
+	}
+
+	var tokens []string
+
+	for i := range 20 {
+		tokens = append(tokens, token(key, fmt.Sprintf("user-%d", i)))
+	}
+
+	tokens = append(tokens, "", "garbage", token(other, "intruder"))
+
+	results := parser.AuthInfoFromTokens(tokens)
+
+	test.Equal(t, len(tokens), len(results), "get a result per token")
+
+	for i := range 20 {
+		test.Must(t, results[i].Err, "validate token %d", i)
+		test.Equal(t, fmt.Sprintf("core://user/user-%d", i),
+			results[i].AuthInfo.Claims.Subject,
+			"return the results in token order")
+	}
+
+	test.Equal(t, true, errors.Is(results[20].Err, elephantine.ErrNoAuthorization),
+		"reject empty tokens as missing authorization")
+	test.MustNot(t, results[21].Err, "reject malformed tokens")
+	test.MustNot(t, results[22].Err, "reject tokens signed with another key")
+}
+
+func TestSigningKeyTypes(t *testing.T) {
+	keyTypes := []test.KeyType{
+		test.KeyTypeES384,
+		test.KeyTypeES256,
+		test.KeyTypeEd25519,
+	}
+
+	for _, kt := range keyTypes {
+		t.Run(string(kt), func(t *testing.T) {
+			key := test.NewSigningKey(t, kt)
+			parser := key.Parser(elephantine.JWTAuthInfoParserOptions{})
+
+			info, err := parser.AuthInfoFromHeader(key.AccessKey(t,
+				elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: "someone",
+					},
+					Scope: "doc_read",
+				}))
+			test.Must(t, err, "parse token")
+
+			test.Equal(t, "core://user/someone", info.Claims.Subject,
+				"get the expected subject")
+
+			defaultParser := elephantine.NewStaticKeyAuthInfoParser(
+				key.Public(), elephantine.JWTAuthInfoParserOptions{})
+
+			_, err = defaultParser.AuthInfoFromHeader(key.AccessKey(t,
+				elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: "someone",
+					},
+				}))
+
+			if kt == test.KeyTypeES384 {
+				test.Must(t, err, "accept ES384 by default")
+			} else {
+				test.MustNot(t, err, "only accept %s when enabled", kt)
+			}
+		})
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	ctx := test.Context(t)
+
+	for _, kt := range []test.KeyType{test.KeyTypeES256, test.KeyTypeEd25519} {
+		t.Run(string(kt), func(t *testing.T) {
+			key := test.NewSigningKey(t, kt)
+
+			parser, err := elephantine.NewJWKSAuthInfoParser(ctx,
+				key.JWKSServer(t),
+				elephantine.JWTAuthInfoParserOptions{
+					Issuer:       "local",
+					ValidMethods: []string{key.Method.Alg()},
+				})
+			test.Must(t, err, "create parser")
+
+			test.Must(t, parser.Ready(ctx), "load the published key")
+
+			info, err := parser.AuthInfoFromHeader(key.AccessKey(t,
+				elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Issuer:  "local",
+						Subject: "someone",
+					},
+				}))
+			test.Must(t, err, "verify a token against the JWKS endpoint")
+
+			test.Equal(t, "core://user/someone", info.Claims.Subject,
+				"get the expected subject")
+		})
+	}
+}
@@ -0,0 +1,531 @@
+// Package k8s implements coordination through the Kubernetes API for
+// deployments that don't have access to Postgres.
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ttab/elephantine"
+)
+
+// Default in-cluster service account paths.
+const (
+	DefaultTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultCAPath        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	DefaultNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// MicroTime is the time format used for the lease acquire and renew times.
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+var _ elephantine.LeaderElector = &LeaseElector{}
+
+// LeaseOptions controls how a lease elector should behave.
+type LeaseOptions struct {
+	// Namespace of the lease, defaults to the namespace of the service
+	// account.
+	Namespace string
+	// Identity of the holder, defaults to the hostname, which is the pod
+	// name in Kubernetes.
+	Identity string
+	// LeaseDuration is the time that other candidates wait before taking
+	// over a lease that hasn't been renewed. Defaults to 15s.
+	LeaseDuration time.Duration
+	// RenewInterval controls how often a held lease is renewed. Must be
+	// shorter than the lease duration. Defaults to a third of the lease
+	// duration.
+	RenewInterval time.Duration
+	// RetryInterval controls how often we attempt to acquire the lease.
+	// Defaults to the renew interval.
+	RetryInterval time.Duration
+	// APIServer is the base URL of the Kubernetes API. Defaults to the
+	// in-cluster API server.
+	APIServer string
+	// TokenPath is the path to the service account token, the token is
+	// re-read for every request as it is rotated. Defaults to
+	// DefaultTokenPath.
+	TokenPath string
+	// Client is the HTTP client to use, defaults to a client that trusts
+	// the in-cluster CA.
+	Client *http.Client
+}
+
+// LeaseElector is a LeaderElector backed by a coordination.k8s.io/v1 Lease. The
+// service account needs permission to get, create, and update leases in the
+// namespace.
+type LeaseElector struct {
+	logger *slog.Logger
+	name   string
+	opts   LeaseOptions
+
+	abort    chan struct{}
+	acquired chan struct{}
+	lost     chan struct{}
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewLeaseElector creates a new elector for the named lease.
+func NewLeaseElector(
+	logger *slog.Logger, name string, opts LeaseOptions,
+) (*LeaseElector, error) {
+	if opts.LeaseDuration == 0 {
+		opts.LeaseDuration = 15 * time.Second
+	}
+
+	if opts.RenewInterval == 0 {
+		opts.RenewInterval = opts.LeaseDuration / 3
+	}
+
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = opts.RenewInterval
+	}
+
+	if opts.RenewInterval >= opts.LeaseDuration {
+		return nil, fmt.Errorf(
+			"the renew interval must be shorter than the lease duration, lease duration: %s, renew interval %s",
+			opts.LeaseDuration, opts.RenewInterval)
+	}
+
+	if opts.TokenPath == "" {
+		opts.TokenPath = DefaultTokenPath
+	}
+
+	if opts.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+
+		opts.Identity = hostname
+	}
+
+	if opts.Namespace == "" {
+		ns, err := os.ReadFile(DefaultNamespacePath)
+		if err != nil {
+			return nil, fmt.Errorf("read service account namespace: %w", err)
+		}
+
+		opts.Namespace = strings.TrimSpace(string(ns))
+	}
+
+	if opts.APIServer == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+
+		if host == "" || port == "" {
+			return nil, errors.New("not running in a cluster, an API server must be configured")
+		}
+
+		opts.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	if opts.Client == nil {
+		client, err := inClusterClient()
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Client = client
+	}
+
+	l := LeaseElector{
+		logger: logger.With(
+			elephantine.LogKeyJobLock, name,
+			elephantine.LogKeyJobLockID, opts.Identity),
+		name:     name,
+		opts:     opts,
+		abort:    make(chan struct{}),
+		acquired: make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+
+	return &l, nil
+}
+
+func inClusterClient() (*http.Client, error) {
+	caData, err := os.ReadFile(DefaultCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("read cluster CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, errors.New("no certificates in cluster CA file")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}, nil
+}
+
+// Identity implements elephantine.LeaderElector.
+func (l *LeaseElector) Identity() string {
+	return l.opts.Identity
+}
+
+// Acquire implements elephantine.LeaderElector.
+func (l *LeaseElector) Acquire(ctx context.Context) error {
+	l.startOnce.Do(func() {
+		go l.loop()
+	})
+
+	select {
+	case <-l.acquired:
+		return nil
+	case <-l.lost:
+		return elephantine.ErrLeadershipLost
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
+	}
+}
+
+// Watch implements elephantine.LeaderElector.
+func (l *LeaseElector) Watch() <-chan struct{} {
+	return l.lost
+}
+
+// Yield implements elephantine.LeaderElector.
+func (l *LeaseElector) Yield() {
+	l.stopOnce.Do(func() {
+		close(l.abort)
+	})
+
+	started := true
+
+	l.startOnce.Do(func() {
+		started = false
+
+		close(l.lost)
+	})
+
+	if !started {
+		return
+	}
+
+	select {
+	case <-l.lost:
+	case <-time.After(l.opts.RenewInterval):
+	}
+}
+
+func (l *LeaseElector) loop() {
+	defer close(l.lost)
+
+	for {
+		ok, err := l.tryAcquireOrRenew()
+		if err != nil {
+			l.logger.Error("failed to acquire lease",
+				elephantine.LogKeyError, err)
+		}
+
+		if ok {
+			break
+		}
+
+		select {
+		case <-l.abort:
+			return
+		case <-time.After(l.opts.RetryInterval):
+		}
+	}
+
+	l.logger.Debug("acquired lease")
+
+	close(l.acquired)
+
+	lastRenew := time.Now()
+
+	for {
+		select {
+		case <-l.abort:
+			l.release()
+
+			return
+		case <-time.After(l.opts.RenewInterval):
+		}
+
+		ok, err := l.tryAcquireOrRenew()
+
+		switch {
+		case err != nil:
+			l.logger.Error("failed to renew lease",
+				elephantine.LogKeyError, err)
+
+			// Give up before others can take over the lease.
+			if time.Since(lastRenew)+l.opts.RenewInterval >= l.opts.LeaseDuration {
+				return
+			}
+		case !ok:
+			l.logger.Error("lease was taken over by another holder")
+
+			return
+		default:
+			lastRenew = time.Now()
+		}
+	}
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+// leaseMetadata is the part of the object metadata that we manage, plus the
+// fields that would be lost when we update the lease.
+type leaseMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	OwnerReferences json.RawMessage   `json:"ownerReferences,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32  `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32  `json:"leaseTransitions,omitempty"`
+}
+
+func (l *LeaseElector) leaseURL(withName bool) string {
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+		strings.TrimSuffix(l.opts.APIServer, "/"), l.opts.Namespace)
+
+	if withName {
+		u += "/" + l.name
+	}
+
+	return u
+}
+
+func (l *LeaseElector) tryAcquireOrRenew() (bool, error) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), l.opts.RenewInterval)
+	defer cancel()
+
+	now := time.Now()
+	nowStr := now.UTC().Format(microTimeFormat)
+	durationSeconds := int32(l.opts.LeaseDuration / time.Second)
+	identity := l.opts.Identity
+
+	var current lease
+
+	found, err := l.do(ctx, http.MethodGet, l.leaseURL(true), nil, &current)
+	if err != nil {
+		return false, fmt.Errorf("get lease: %w", err)
+	}
+
+	if !found {
+		var zero int32
+
+		created := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata: leaseMetadata{
+				Name:      l.name,
+				Namespace: l.opts.Namespace,
+			},
+			Spec: leaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &nowStr,
+				RenewTime:            &nowStr,
+				LeaseTransitions:     &zero,
+			},
+		}
+
+		ok, err := l.do(ctx, http.MethodPost, l.leaseURL(false), created, nil)
+		if err != nil {
+			return false, fmt.Errorf("create lease: %w", err)
+		}
+
+		return ok, nil
+	}
+
+	isHolder := current.Spec.HolderIdentity != nil &&
+		*current.Spec.HolderIdentity == identity
+
+	if !isHolder && !leaseExpired(current.Spec, now) {
+		return false, nil
+	}
+
+	spec := current.Spec
+
+	if !isHolder {
+		var transitions int32
+
+		if spec.LeaseTransitions != nil {
+			transitions = *spec.LeaseTransitions + 1
+		}
+
+		spec.HolderIdentity = &identity
+		spec.AcquireTime = &nowStr
+		spec.LeaseTransitions = &transitions
+	}
+
+	spec.RenewTime = &nowStr
+	spec.LeaseDurationSeconds = &durationSeconds
+
+	current.Spec = spec
+
+	// The update uses the resource version for optimistic concurrency,
+	// and fails with a conflict if someone else got there first.
+	ok, err := l.do(ctx, http.MethodPut, l.leaseURL(true), current, nil)
+	if err != nil {
+		return false, fmt.Errorf("update lease: %w", err)
+	}
+
+	return ok, nil
+}
+
+func leaseExpired(spec leaseSpec, now time.Time) bool {
+	// Released or never held.
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" {
+		return true
+	}
+
+	if spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	renewed, err := time.Parse(microTimeFormat, *spec.RenewTime)
+	if err != nil {
+		return true
+	}
+
+	duration := time.Duration(*spec.LeaseDurationSeconds) * time.Second
+
+	return now.After(renewed.Add(duration))
+}
+
+func (l *LeaseElector) release() {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), l.opts.RenewInterval)
+	defer cancel()
+
+	l.logger.Debug("releasing lease")
+
+	var current lease
+
+	found, err := l.do(ctx, http.MethodGet, l.leaseURL(true), nil, &current)
+	if err != nil || !found {
+		l.logger.Error("failed to get lease for release",
+			elephantine.LogKeyError, err)
+
+		return
+	}
+
+	if current.Spec.HolderIdentity == nil ||
+		*current.Spec.HolderIdentity != l.opts.Identity {
+		l.logger.Error("out of sync: lease isn't held by us")
+
+		return
+	}
+
+	current.Spec.HolderIdentity = nil
+	current.Spec.AcquireTime = nil
+	current.Spec.RenewTime = nil
+
+	_, err = l.do(ctx, http.MethodPut, l.leaseURL(true), current, nil)
+	if err != nil {
+		l.logger.Error("failed to release lease",
+			elephantine.LogKeyError, err)
+	}
+}
+
+// do performs an API request. Returns false without an error for not found and
+// conflict responses.
+func (l *LeaseElector) do(
+	ctx context.Context, method string, url string,
+	body any, out any,
+) (_ bool, outErr error) {
+	var reqBody *bytes.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return false, fmt.Errorf("marshal request body: %w", err)
+		}
+
+		reqBody = bytes.NewReader(data)
+	} else {
+		reqBody = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+
+	token, err := os.ReadFile(l.opts.TokenPath)
+	if err != nil {
+		return false, fmt.Errorf("read service account token: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := l.opts.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("perform request: %w", err)
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			outErr = errors.Join(outErr, fmt.Errorf(
+				"close response body: %w", err))
+		}
+	}()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound,
+		res.StatusCode == http.StatusConflict:
+		return false, nil
+	case res.StatusCode >= 300:
+		return false, elephantine.HTTPErrorFromResponse(res)
+	}
+
+	if out == nil {
+		return true, nil
+	}
+
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		return false, fmt.Errorf("decode response: %w", err)
+	}
+
+	return true, nil
+}
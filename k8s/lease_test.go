@@ -0,0 +1,187 @@
+package k8s_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine/k8s"
+	"github.com/ttab/elephantine/test"
+)
+
+// fakeLeaseAPI is a minimal coordination.k8s.io/v1 lease API that stores
+// leases as generic objects, so that fields the elector doesn't know about are
+// kept.
+type fakeLeaseAPI struct {
+	m       sync.Mutex
+	version int
+	leases  map[string]map[string]any
+}
+
+func (f *fakeLeaseAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	name := r.PathValue("name")
+
+	switch r.Method {
+	case http.MethodGet:
+		obj, ok := f.leases[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(obj)
+
+		return
+	case http.MethodPost, http.MethodPut:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	var obj map[string]any
+
+	err := json.NewDecoder(r.Body).Decode(&obj)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	meta, _ := obj["metadata"].(map[string]any)
+	if meta == nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if name == "" {
+		name, _ = meta["name"].(string)
+	}
+
+	current, exists := f.leases[name]
+
+	switch {
+	case r.Method == http.MethodPost && exists:
+		w.WriteHeader(http.StatusConflict)
+
+		return
+	case r.Method == http.MethodPut && !exists:
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	case r.Method == http.MethodPut:
+		currentMeta, _ := current["metadata"].(map[string]any)
+
+		if meta["resourceVersion"] != currentMeta["resourceVersion"] {
+			w.WriteHeader(http.StatusConflict)
+
+			return
+		}
+	}
+
+	f.version++
+	meta["resourceVersion"] = strconv.Itoa(f.version)
+
+	f.leases[name] = obj
+
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func (f *fakeLeaseAPI) get(name string) map[string]any {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.leases[name]
+}
+
+func TestLeaseElectorUpdate(t *testing.T) {
+	api := fakeLeaseAPI{
+		leases: map[string]map[string]any{
+			"indexer": {
+				"apiVersion": "coordination.k8s.io/v1",
+				"kind":       "Lease",
+				"metadata": map[string]any{
+					"name":            "indexer",
+					"namespace":       "default",
+					"resourceVersion": "0",
+					"labels": map[string]any{
+						"app": "indexer",
+					},
+					"annotations": map[string]any{
+						"owner": "team-a",
+					},
+				},
+				"spec": map[string]any{
+					"holderIdentity":       "old-pod",
+					"leaseDurationSeconds": 15,
+					"renewTime":            "2020-01-01T00:00:00.000000Z",
+					"leaseTransitions":     2,
+				},
+			},
+		},
+	}
+
+	mux := http.NewServeMux()
+
+	mux.Handle("/apis/coordination.k8s.io/v1/namespaces/default/leases", &api)
+	mux.Handle("/apis/coordination.k8s.io/v1/namespaces/default/leases/{name}", &api)
+
+	server := httptest.NewServer(mux)
+
+	t.Cleanup(server.Close)
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+
+	err := os.WriteFile(tokenPath, []byte("token\n"), 0o600)
+	test.Must(t, err, "write service account token")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	elector, err := k8s.NewLeaseElector(logger, "indexer", k8s.LeaseOptions{
+		Namespace:     "default",
+		Identity:      "new-pod",
+		LeaseDuration: 3 * time.Second,
+		RenewInterval: 100 * time.Millisecond,
+		APIServer:     server.URL,
+		TokenPath:     tokenPath,
+		Client:        server.Client(),
+	})
+	test.Must(t, err, "create elector")
+
+	err = elector.Acquire(test.Context(t))
+	test.Must(t, err, "take over the expired lease")
+
+	obj := api.get("indexer")
+	meta, _ := obj["metadata"].(map[string]any)
+	spec, _ := obj["spec"].(map[string]any)
+
+	test.Equal[any](t, "new-pod", spec["holderIdentity"],
+		"hold the lease")
+	test.Equal[any](t, float64(3), spec["leaseTransitions"],
+		"count the transition")
+	test.EqualDiff(t, map[string]any{"app": "indexer"}, meta["labels"],
+		"keep the labels of the lease")
+	test.EqualDiff(t, map[string]any{"owner": "team-a"}, meta["annotations"],
+		"keep the annotations of the lease")
+
+	elector.Yield()
+
+	spec, _ = api.get("indexer")["spec"].(map[string]any)
+
+	if _, held := spec["holderIdentity"]; held {
+		t.Fatal("expected the lease to be released")
+	}
+}
@@ -11,19 +11,33 @@ import (
 
 // NewLazySSM creates a new SSM ParameterSource.
 func NewLazySSM() *LazySSM {
-	return &LazySSM{}
+	return NewLazySSMWithOptions(SecretSourceOptions{})
+}
+
+// NewLazySSMWithOptions creates a new SSM ParameterSource where all
+// operations, including config loading, are bounded by the configured
+// timeout.
+func NewLazySSMWithOptions(opts SecretSourceOptions) *LazySSM {
+	return &LazySSM{
+		opts: opts.withDefaults(),
+	}
 }
 
 // NewLazySSM is a SSM-backed ParameterSource implementation for
 // ResolveParameter().
 type LazySSM struct {
-	ssm *ssm.Client
+	ssm  *ssm.Client
+	opts SecretSourceOptions
 }
 
 // GetParameterValue implements ParameterSource.
 func (l *LazySSM) GetParameterValue(ctx context.Context, name string) (string, error) {
 	if l.ssm == nil {
-		cfg, err := config.LoadDefaultConfig(ctx)
+		cfg, err := secretOperation(ctx, l.opts, "ssm", "load_config",
+			func(ctx context.Context) (aws.Config, error) {
+				//nolint:wrapcheck
+				return config.LoadDefaultConfig(ctx)
+			})
 		if err != nil {
 			return "", fmt.Errorf("failed to load AWS SDK config: %w", err)
 		}
@@ -31,10 +45,14 @@ func (l *LazySSM) GetParameterValue(ctx context.Context, name string) (string, e
 		l.ssm = ssm.NewFromConfig(cfg)
 	}
 
-	param, err := l.ssm.GetParameter(ctx, &ssm.GetParameterInput{
-		Name:           aws.String(name),
-		WithDecryption: aws.Bool(true),
-	})
+	param, err := secretOperation(ctx, l.opts, "ssm", "read",
+		func(ctx context.Context) (*ssm.GetParameterOutput, error) {
+			//nolint:wrapcheck
+			return l.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+				Name:           aws.String(name),
+				WithDecryption: aws.Bool(true),
+			})
+		})
 	if err != nil {
 		return "", fmt.Errorf("error response from AWS SSM: %w", err)
 	}
@@ -0,0 +1,65 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrLeadershipLost is returned by LeaderElector.Acquire if leadership was lost
+// or given up before it was acquired.
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// LeaderElector coordinates which of a set of processes should be performing a
+// (background) task. Implemented by pg.JobLock, pg.AdvisoryLock, and
+// k8s.LeaseElector.
+//
+// Electors are single use, once leadership has been lost or given up a new
+// elector has to be created to compete for leadership again.
+type LeaderElector interface {
+	// Acquire blocks until leadership has been acquired or the context is
+	// cancelled.
+	Acquire(ctx context.Context) error
+	// Watch returns a channel that will be closed when leadership is lost
+	// or given up.
+	Watch() <-chan struct{}
+	// Yield gives up leadership if held and stops all coordination.
+	Yield()
+	// Identity returns the identity that the elector uses when acting as
+	// the leader.
+	Identity() string
+}
+
+// RunWithLeadership runs the provided function once leadership has been
+// acquired. The context provided to the function will be cancelled if
+// leadership is lost. Leadership is yielded when the function returns.
+//
+// Returns without calling the function if the context is cancelled before
+// leadership has been acquired.
+func RunWithLeadership(
+	ctx context.Context, elector LeaderElector,
+	fn func(ctx context.Context) error,
+) error {
+	defer elector.Yield()
+
+	err := elector.Acquire(ctx)
+
+	switch {
+	case ctx.Err() != nil:
+		return nil
+	case err != nil:
+		return err //nolint:wrapcheck
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-elector.Watch():
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+
+	return fn(runCtx)
+}
@@ -0,0 +1,32 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestFakeLeaderElector(t *testing.T) {
+	test.WithHeldJobLock(t, func(ctx context.Context, lock *test.FakeLeaderElector) {
+		var ran bool
+
+		err := elephantine.RunWithLeadership(ctx, lock, func(ctx context.Context) error {
+			ran = true
+
+			lock.Revoke()
+
+			<-ctx.Done()
+
+			return ctx.Err()
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the context to be cancelled on revoke, got: %v", err)
+		}
+
+		test.Equal(t, true, ran, "run the function while holding the lock")
+		test.Equal(t, false, lock.Held(), "not hold the lock after revoke")
+	})
+}
@@ -0,0 +1,231 @@
+package elephantine
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Default page sizes used by ParseListParams.
+const (
+	DefaultPageSize    = 50
+	DefaultMaxPageSize = 500
+)
+
+// FilterOp is a filter operator.
+type FilterOp string
+
+// Supported filter operators.
+const (
+	FilterEq     FilterOp = "eq"
+	FilterNe     FilterOp = "ne"
+	FilterLt     FilterOp = "lt"
+	FilterLte    FilterOp = "lte"
+	FilterGt     FilterOp = "gt"
+	FilterGte    FilterOp = "gte"
+	FilterPrefix FilterOp = "prefix"
+)
+
+var filterOps = []FilterOp{
+	FilterEq, FilterNe, FilterLt, FilterLte, FilterGt, FilterGte,
+	FilterPrefix,
+}
+
+// ListParamsSpec describes the list parameters that an endpoint accepts.
+type ListParamsSpec struct {
+	// DefaultPageSize is used when no page size is given. Defaults to
+	// DefaultPageSize.
+	DefaultPageSize int
+	// MaxPageSize is the largest allowed page size. Defaults to
+	// DefaultMaxPageSize.
+	MaxPageSize int
+	// SortFields are the fields that can be used in "order_by".
+	SortFields []string
+	// DefaultOrder is used when no "order_by" is given.
+	DefaultOrder []SortField
+	// FilterFields are the fields that can be filtered on, and the allowed
+	// operators for each field. An empty list of operators allows all
+	// operators.
+	FilterFields map[string][]FilterOp
+}
+
+// SortField is a field to sort by.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// Filter is a filter expression.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+// ListParams are the parsed pagination, sorting, and filtering parameters of
+// a list request.
+type ListParams struct {
+	PageSize  int
+	PageToken string
+	OrderBy   []SortField
+	Filters   []Filter
+}
+
+// ParseListParams parses the standard list query parameters:
+//
+//   - "page_size": the number of items to return.
+//   - "page_token": an opaque token for the next page, see DecodePageToken.
+//     The token is returned as is, applying it is up to the caller.
+//   - "order_by": a comma separated list of fields, optionally followed by
+//     " desc" or " asc", f.ex. "created desc,name".
+//   - "filter": can be repeated, filter expressions on the form
+//     "field:op:value", f.ex. "status:eq:draft".
+//
+// Invalid parameters result in a HTTPError with status 400.
+func ParseListParams(q url.Values, spec ListParamsSpec) (ListParams, error) {
+	if spec.DefaultPageSize == 0 {
+		spec.DefaultPageSize = DefaultPageSize
+	}
+
+	if spec.MaxPageSize == 0 {
+		spec.MaxPageSize = DefaultMaxPageSize
+	}
+
+	params := ListParams{
+		PageSize:  spec.DefaultPageSize,
+		PageToken: q.Get("page_token"),
+		OrderBy:   spec.DefaultOrder,
+	}
+
+	if v := q.Get("page_size"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return ListParams{}, HTTPErrorf(http.StatusBadRequest,
+				"invalid page_size %q: must be a positive integer", v)
+		}
+
+		if size > spec.MaxPageSize {
+			return ListParams{}, HTTPErrorf(http.StatusBadRequest,
+				"invalid page_size %d: must not be larger than %d",
+				size, spec.MaxPageSize)
+		}
+
+		params.PageSize = size
+	}
+
+	if v := q.Get("order_by"); v != "" {
+		order, err := parseOrderBy(v, spec.SortFields)
+		if err != nil {
+			return ListParams{}, err
+		}
+
+		params.OrderBy = order
+	}
+
+	for _, v := range q["filter"] {
+		f, err := parseFilter(v, spec.FilterFields)
+		if err != nil {
+			return ListParams{}, err
+		}
+
+		params.Filters = append(params.Filters, f)
+	}
+
+	return params, nil
+}
+
+func parseOrderBy(v string, allowed []string) ([]SortField, error) {
+	var order []SortField
+
+	for _, part := range strings.Split(v, ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(part), " ")
+
+		direction = strings.ToLower(strings.TrimSpace(direction))
+
+		if !slices.Contains(allowed, field) {
+			return nil, HTTPErrorf(http.StatusBadRequest,
+				"invalid order_by: cannot sort by %q", field)
+		}
+
+		sf := SortField{Field: field}
+
+		switch direction {
+		case "", "asc":
+		case "desc":
+			sf.Descending = true
+		default:
+			return nil, HTTPErrorf(http.StatusBadRequest,
+				"invalid order_by: unknown direction %q for %q",
+				direction, field)
+		}
+
+		order = append(order, sf)
+	}
+
+	return order, nil
+}
+
+func parseFilter(v string, allowed map[string][]FilterOp) (Filter, error) {
+	parts := strings.SplitN(v, ":", 3)
+	if len(parts) != 3 {
+		return Filter{}, HTTPErrorf(http.StatusBadRequest,
+			"invalid filter %q: expected field:op:value", v)
+	}
+
+	f := Filter{
+		Field: parts[0],
+		Op:    FilterOp(parts[1]),
+		Value: parts[2],
+	}
+
+	ops, ok := allowed[f.Field]
+	if !ok {
+		return Filter{}, HTTPErrorf(http.StatusBadRequest,
+			"invalid filter: cannot filter on %q", f.Field)
+	}
+
+	if !slices.Contains(filterOps, f.Op) {
+		return Filter{}, HTTPErrorf(http.StatusBadRequest,
+			"invalid filter: unknown operator %q", f.Op)
+	}
+
+	if len(ops) > 0 && !slices.Contains(ops, f.Op) {
+		return Filter{}, HTTPErrorf(http.StatusBadRequest,
+			"invalid filter: operator %q is not allowed for %q",
+			f.Op, f.Field)
+	}
+
+	return f, nil
+}
+
+// EncodePageToken encodes a pagination cursor, like the sort key of the last
+// item on a page, as an opaque page token.
+func EncodePageToken(cursor any) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("marshal cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodePageToken decodes a page token created by EncodePageToken. Invalid
+// tokens result in a HTTPError with status 400.
+func DecodePageToken(token string, cursor any) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return HTTPErrorf(http.StatusBadRequest, "invalid page_token")
+	}
+
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return HTTPErrorf(http.StatusBadRequest, "invalid page_token")
+	}
+
+	return nil
+}
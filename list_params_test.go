@@ -0,0 +1,99 @@
+package elephantine_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestParseListParams(t *testing.T) {
+	spec := elephantine.ListParamsSpec{
+		MaxPageSize: 100,
+		SortFields:  []string{"created", "name"},
+		DefaultOrder: []elephantine.SortField{
+			{Field: "created", Descending: true},
+		},
+		FilterFields: map[string][]elephantine.FilterOp{
+			"status":  {elephantine.FilterEq, elephantine.FilterNe},
+			"created": nil,
+		},
+	}
+
+	params, err := elephantine.ParseListParams(url.Values{}, spec)
+	test.Must(t, err, "parse empty parameters")
+
+	test.EqualDiff(t, elephantine.ListParams{
+		PageSize: elephantine.DefaultPageSize,
+		OrderBy:  spec.DefaultOrder,
+	}, params, "get defaults")
+
+	params, err = elephantine.ParseListParams(url.Values{
+		"page_size":  {"10"},
+		"page_token": {"abc"},
+		"order_by":   {"name, created desc"},
+		"filter":     {"status:eq:draft", "created:gte:2025-01-01T00:00:00Z"},
+	}, spec)
+	test.Must(t, err, "parse parameters")
+
+	test.EqualDiff(t, elephantine.ListParams{
+		PageSize:  10,
+		PageToken: "abc",
+		OrderBy: []elephantine.SortField{
+			{Field: "name"},
+			{Field: "created", Descending: true},
+		},
+		Filters: []elephantine.Filter{
+			{Field: "status", Op: elephantine.FilterEq, Value: "draft"},
+			{
+				Field: "created", Op: elephantine.FilterGte,
+				Value: "2025-01-01T00:00:00Z",
+			},
+		},
+	}, params, "get parsed parameters")
+
+	invalid := map[string]url.Values{
+		"negative_page_size": {"page_size": {"-1"}},
+		"too_large_page":     {"page_size": {"101"}},
+		"unknown_sort_field": {"order_by": {"uuid"}},
+		"bad_direction":      {"order_by": {"name sideways"}},
+		"injected_sort":      {"order_by": {"name; DROP TABLE document"}},
+		"malformed_filter":   {"filter": {"status=draft"}},
+		"unknown_filter":     {"filter": {"uuid:eq:x"}},
+		"unknown_operator":   {"filter": {"status:like:x"}},
+		"disallowed_op":      {"filter": {"status:prefix:dr"}},
+	}
+
+	for name, q := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := elephantine.ParseListParams(q, spec)
+			if !elephantine.IsHTTPErrorWithStatus(err, http.StatusBadRequest) {
+				t.Fatalf("expected a bad request error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	type cursor struct {
+		Created string `json:"c"`
+		ID      int    `json:"id"`
+	}
+
+	token, err := elephantine.EncodePageToken(cursor{Created: "x", ID: 12})
+	test.Must(t, err, "encode page token")
+
+	var got cursor
+
+	err = elephantine.DecodePageToken(token, &got)
+	test.Must(t, err, "decode page token")
+
+	test.Equal(t, cursor{Created: "x", ID: 12}, got, "round trip cursor")
+
+	err = elephantine.DecodePageToken("!!!", &got)
+	if !elephantine.IsHTTPErrorWithStatus(err, http.StatusBadRequest) {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+}
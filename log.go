@@ -17,10 +17,13 @@ const (
 	LogKeyError = "err"
 	// LogKeyErrorCode is an error code.
 	LogKeyErrorCode = "err_code"
+	// LogKeyErrorName is a stable machine-readable error code from the
+	// errcode catalog.
+	LogKeyErrorName = "err_name"
 	// LogKeyErrorMeta is a JSON object with error metadata.
 	LogKeyErrorMeta = "err_meta"
-	// LogKeyCountMetric was planned to be used to increment a given metric
-	// when used. TODO: not implemented yet, should it be removed?
+	// LogKeyCountMetric names a counter that should be incremented when
+	// the record is logged, see LogCountMetrics.
 	LogKeyCountMetric = "count_metric"
 	// LogKeyDocumentUUID is the UUID of a document.
 	LogKeyDocumentUUID = "document_uuid"
@@ -43,6 +46,8 @@ const (
 	LogKeyChannel = "channel"
 	// LogKeyMessage can be used to log a unexpected message.
 	LogKeyMessage = "message"
+	// LogKeyShutdownReason is the reason that a shutdown was initiated.
+	LogKeyShutdownReason = "shutdown_reason"
 	// LogKeyDelay can be used to communicate the delay when logging
 	// information about retry attempts and backoff delays.
 	LogKeyDelay = "delay"
@@ -77,12 +82,32 @@ const (
 	LogKeyMethod = "method"
 	// LogKeySubject is the sub of an authenticated client.
 	LogKeySubject = "sub"
+	// LogKeyActor is the party acting on behalf of the subject for
+	// impersonated requests.
+	LogKeyActor = "act"
 	// LogKeyScopes are the scopes of the authenticated client.
 	LogKeyScopes = "scopes"
 	// LogKeyStatusCode is the HTTP status code used for a response.
 	LogKeyStatusCode = "status_code"
 	// LogKeyName is the name of a resource.
 	LogKeyName = "name"
+	// LogKeyDuration is the duration of an operation, like a request.
+	LogKeyDuration = "duration"
+	// LogKeyHTTPMethod is the method of a HTTP request.
+	LogKeyHTTPMethod = "http_method"
+	// LogKeyURL is the URL of a HTTP request.
+	LogKeyURL = "url"
+	// LogKeyRequestBody is the (possibly truncated) body of a HTTP request.
+	LogKeyRequestBody = "request_body"
+	// LogKeyResponseBody is the (possibly truncated) body of a HTTP
+	// response.
+	LogKeyResponseBody = "response_body"
+	// LogKeyPID is the ID of a process.
+	LogKeyPID = "pid"
+	// LogKeyAddress is a network address, like a listen address.
+	LogKeyAddress = "addr"
+	// LogKeySeverity is the severity of a health check.
+	LogKeySeverity = "severity"
 )
 
 // SetUpLogger creates a default JSON logger and sets it as the global logger.
@@ -115,6 +140,16 @@ func SetUpLogger(logLevel string, w io.Writer) *slog.Logger {
 	return logger
 }
 
+// RedactedLogValue returns a log value that shows if a secret has been set
+// without revealing it.
+func RedactedLogValue(secret string) slog.Value {
+	if secret == "" {
+		return slog.StringValue("")
+	}
+
+	return slog.StringValue("[REDACTED]")
+}
+
 type ctxKey int
 
 const logCtxKey ctxKey = 1
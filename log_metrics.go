@@ -0,0 +1,121 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LogCountMetrics counts log records that carry the LogKeyCountMetric
+// attribute, so that ad hoc occurrences can be counted without registering
+// dedicated metrics:
+//
+//	logger.WarnContext(ctx, "missing document type",
+//		elephantine.LogKeyCountMetric, "missing_document_type")
+//
+// The records are counted in log_count_metric_total, labelled with the metric
+// name and the log level.
+type LogCountMetrics struct {
+	counter *prometheus.CounterVec
+}
+
+// NewLogCountMetrics registers the log count metric with the provided
+// registerer.
+func NewLogCountMetrics(
+	registerer prometheus.Registerer,
+) (*LogCountMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := LogCountMetrics{
+		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "log_count_metric_total",
+			Help: "Number of log records that carried a count metric name.",
+		}, []string{"metric", "level"}),
+	}
+
+	err := registerer.Register(m.counter)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+// Handler wraps a log handler so that records with a count metric attribute
+// increment the counter. Only records that are enabled by the wrapped handler
+// are counted.
+//
+//	slog.SetDefault(slog.New(metrics.Handler(logger.Handler())))
+func (m *LogCountMetrics) Handler(next slog.Handler) slog.Handler {
+	return &countMetricHandler{
+		h: next,
+		m: m,
+	}
+}
+
+type countMetricHandler struct {
+	h slog.Handler
+	m *LogCountMetrics
+	// metric is set when the count metric attribute was added through
+	// WithAttrs.
+	metric string
+	// grouped is true when attributes end up in a group, and no longer
+	// can be the top level count metric attribute.
+	grouped bool
+}
+
+func (h *countMetricHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *countMetricHandler) Handle(ctx context.Context, r slog.Record) error {
+	metric := h.metric
+
+	if !h.grouped {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == LogKeyCountMetric {
+				metric = a.Value.String()
+
+				return false
+			}
+
+			return true
+		})
+	}
+
+	if metric != "" {
+		h.m.counter.WithLabelValues(metric, r.Level.String()).Inc()
+	}
+
+	return h.h.Handle(ctx, r) //nolint:wrapcheck
+}
+
+func (h *countMetricHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+
+	c.h = h.h.WithAttrs(attrs)
+
+	if !h.grouped {
+		for _, a := range attrs {
+			if a.Key == LogKeyCountMetric {
+				c.metric = a.Value.String()
+			}
+		}
+	}
+
+	return &c
+}
+
+func (h *countMetricHandler) WithGroup(name string) slog.Handler {
+	c := *h
+
+	c.h = h.h.WithGroup(name)
+	c.grouped = true
+
+	return &c
+}
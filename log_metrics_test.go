@@ -0,0 +1,48 @@
+package elephantine_test
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestLogCountMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewLogCountMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	logger := slog.New(metrics.Handler(
+		slog.NewTextHandler(io.Discard, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		})))
+
+	logger.Warn("missing type",
+		elephantine.LogKeyCountMetric, "missing_type")
+	logger.Debug("missing type, but disabled",
+		elephantine.LogKeyCountMetric, "missing_type")
+	logger.Info("not counted")
+	logger.WithGroup("sub").Info("grouped, not counted",
+		elephantine.LogKeyCountMetric, "grouped")
+
+	preset := logger.With(elephantine.LogKeyCountMetric, "preset")
+
+	preset.Error("first")
+	preset.Error("second")
+
+	const want = `
+# HELP log_count_metric_total Number of log records that carried a count metric name.
+# TYPE log_count_metric_total counter
+log_count_metric_total{level="ERROR",metric="preset"} 2
+log_count_metric_total{level="WARN",metric="missing_type"} 1
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(want))
+	test.Must(t, err, "count the records")
+}
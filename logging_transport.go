@@ -0,0 +1,212 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// LoggingOption configures the logging done by LoggingTransport.
+type LoggingOption func(t *loggingTransport)
+
+// LogBodies makes LoggingTransport log request and response bodies, truncated
+// to limit bytes. Credential fields, like "client_secret" and "password", are
+// redacted in form-encoded request bodies, and the response bodies of token
+// requests aren't logged.
+func LogBodies(limit int) LoggingOption {
+	return func(t *loggingTransport) {
+		t.bodyLimit = limit
+	}
+}
+
+// LoggingTransport logs the method, URL, status code, and duration of every
+// request made by the client at debug level. Nothing is logged unless the
+// logger has debug logging enabled, which makes it cheap to leave in place for
+// use during incidents:
+//
+//	client := elephantine.NewHTTPClient(30*time.Second,
+//		elephantine.LoggingTransport(logger, elephantine.LogBodies(1024)))
+//
+// Bodies are streamed as before, only the logged prefix is buffered. The
+// logger defaults to slog.Default().
+func LoggingTransport(logger *slog.Logger, opts ...LoggingOption) HTTPClientOption {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		t := loggingTransport{
+			base:   base,
+			logger: logger,
+		}
+
+		for _, opt := range opts {
+			opt(&t)
+		}
+
+		return &t
+	})
+}
+
+type loggingTransport struct {
+	base      http.RoundTripper
+	logger    *slog.Logger
+	bodyLimit int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if !t.logger.Enabled(ctx, slog.LevelDebug) {
+		return t.base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	attrs := []any{
+		LogKeyHTTPMethod, req.Method,
+		LogKeyURL, req.URL.Redacted(),
+	}
+
+	// tokenRequest is set for requests that carry credentials, the
+	// responses of which are likely to contain tokens.
+	var tokenRequest bool
+
+	if t.bodyLimit > 0 && req.Body != nil && req.Body != http.NoBody {
+		prefix, body, err := peekBody(req.Body, t.bodyLimit)
+		if err != nil {
+			_ = req.Body.Close()
+
+			t.logFailure(ctx, attrs, err)
+
+			return nil, fmt.Errorf("read request body: %w", err)
+		}
+
+		// RoundTrippers must not modify the original request.
+		req = req.Clone(ctx)
+		req.Body = body
+
+		if isFormRequest(req) {
+			prefix, tokenRequest = redactFormBody(prefix)
+		}
+
+		attrs = append(attrs, LogKeyRequestBody, prefix)
+	}
+
+	start := time.Now()
+
+	res, err := t.base.RoundTrip(req)
+
+	attrs = append(attrs, LogKeyDuration, time.Since(start))
+
+	if err != nil {
+		t.logFailure(ctx, attrs, err)
+
+		return nil, err //nolint:wrapcheck
+	}
+
+	attrs = append(attrs, LogKeyStatusCode, res.StatusCode)
+
+	if t.bodyLimit > 0 && res.Body != nil && res.Body != http.NoBody {
+		prefix, body, err := peekBody(res.Body, t.bodyLimit)
+		if err != nil {
+			_ = res.Body.Close()
+
+			t.logFailure(ctx, attrs, err)
+
+			return nil, fmt.Errorf("read response body: %w", err)
+		}
+
+		res.Body = body
+
+		if tokenRequest {
+			prefix = RedactedLogValue(prefix).String()
+		}
+
+		attrs = append(attrs, LogKeyResponseBody, prefix)
+	}
+
+	t.logger.DebugContext(ctx, "http client request", attrs...)
+
+	return res, nil
+}
+
+func (t *loggingTransport) logFailure(ctx context.Context, attrs []any, err error) {
+	t.logger.DebugContext(ctx, "http client request failed",
+		append(attrs, LogKeyError, err)...)
+}
+
+// credentialFields are form fields that are redacted when logging request
+// bodies.
+var credentialFields = []string{
+	"access_token",
+	"actor_token",
+	"assertion",
+	"client_assertion",
+	"client_secret",
+	"code",
+	"code_verifier",
+	"id_token",
+	"password",
+	"refresh_token",
+	"subject_token",
+	"token",
+}
+
+func isFormRequest(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// redactFormBody redacts the values of credential fields in a (possibly
+// truncated) form-encoded body. Returns true if the body was a token request,
+// that is, if it had a grant type or any credentials.
+func redactFormBody(body string) (string, bool) {
+	var tokenRequest bool
+
+	pairs := strings.Split(body, "&")
+
+	for i, pair := range pairs {
+		key, value, _ := strings.Cut(pair, "=")
+
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+
+		switch {
+		case name == "grant_type":
+			tokenRequest = true
+		case slices.Contains(credentialFields, name):
+			tokenRequest = true
+			pairs[i] = key + "=" + RedactedLogValue(value).String()
+		}
+	}
+
+	return strings.Join(pairs, "&"), tokenRequest
+}
+
+// peekBody reads up to limit bytes from the body and returns them together
+// with a body that yields the full contents.
+func peekBody(body io.ReadCloser, limit int) (string, io.ReadCloser, error) {
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(limit)))
+	if err != nil {
+		return "", nil, err //nolint:wrapcheck
+	}
+
+	return string(prefix), struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(prefix), body),
+		Closer: body,
+	}, nil
+}
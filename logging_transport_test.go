@@ -0,0 +1,120 @@
+package elephantine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestLoggingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.WriteHeader(http.StatusAccepted)
+
+		_, _ = w.Write([]byte("echo: " + string(body)))
+	}))
+
+	t.Cleanup(server.Close)
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.LoggingTransport(logger, elephantine.LogBodies(8)))
+
+	res, err := client.Post(server.URL+"/things", "text/plain",
+		strings.NewReader("a rather long request body"))
+	test.Must(t, err, "perform request")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	test.Equal(t, "echo: a rather long request body", string(body),
+		"pass the full bodies through")
+
+	var entry map[string]any
+
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	test.Must(t, err, "decode log entry")
+
+	test.Equal[any](t, "POST", entry[elephantine.LogKeyHTTPMethod],
+		"log the method")
+	test.Equal[any](t, server.URL+"/things", entry[elephantine.LogKeyURL],
+		"log the URL")
+	test.Equal[any](t, float64(http.StatusAccepted), entry[elephantine.LogKeyStatusCode],
+		"log the status code")
+	test.Equal[any](t, "a rather", entry[elephantine.LogKeyRequestBody],
+		"log the truncated request body")
+	test.Equal[any](t, "echo: a ", entry[elephantine.LogKeyResponseBody],
+		"log the truncated response body")
+
+	if _, ok := entry[elephantine.LogKeyDuration]; !ok {
+		t.Fatal("expected the duration to be logged")
+	}
+}
+
+func TestLoggingTransportRedaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		_, _ = w.Write([]byte(`{"access_token":"secret-token"}`))
+	}))
+
+	t.Cleanup(server.Close)
+
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.LoggingTransport(logger, elephantine.LogBodies(1024)))
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"app"},
+		"client_secret": {"hunter2"},
+	}
+
+	res, err := client.PostForm(server.URL+"/token", form)
+	test.Must(t, err, "perform request")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	test.Equal(t, `{"access_token":"secret-token"}`, string(body),
+		"pass the full response through")
+
+	var entry map[string]any
+
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	test.Must(t, err, "decode log entry")
+
+	test.Equal[any](t,
+		"client_id=app&client_secret=[REDACTED]&grant_type=client_credentials",
+		entry[elephantine.LogKeyRequestBody],
+		"redact credentials in the request body")
+	test.Equal[any](t, "[REDACTED]", entry[elephantine.LogKeyResponseBody],
+		"don't log the response to token requests")
+}
@@ -0,0 +1,170 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// InteractiveLoginOptions controls the authorization code login flow.
+type InteractiveLoginOptions struct {
+	// Port that the local callback listener should use. Defaults to a
+	// random free port, set a port if the identity provider requires an
+	// exact redirect URI match.
+	Port int
+	// Open is called with the authorization URL that the user should
+	// visit. Defaults to PrintLoginPrompt.
+	Open func(authURL string) error
+}
+
+// PrintLoginPrompt prints the authorization URL to stderr.
+func PrintLoginPrompt(authURL string) error {
+	_, err := fmt.Fprintf(os.Stderr,
+		"To log in, open %s in your browser\n", authURL)
+	if err != nil {
+		return fmt.Errorf("print prompt: %w", err)
+	}
+
+	return nil
+}
+
+// NewInteractiveTokenSource authenticates the user using the authorization
+// code flow with PKCE, for CLI tools that act on behalf of a user. A listener
+// on the loopback interface receives the callback from the identity provider.
+// Blocks until the login has been completed or the context is cancelled. The
+// client secret is optional, as CLI tools usually are public clients.
+func (conf *AuthenticationConfig) NewInteractiveTokenSource(
+	ctx context.Context, scopes []string, opts InteractiveLoginOptions,
+) (oauth2.TokenSource, error) {
+	if conf.OIDCConfig.AuthorizationEndpoint == "" {
+		return nil, errors.New(
+			"the identity provider has no authorization endpoint")
+	}
+
+	oc, err := conf.publicClientConfig(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewInteractiveTokenSource(ctx, oc, opts)
+}
+
+// NewInteractiveTokenSource performs the authorization code flow with PKCE
+// using the given client configuration, see
+// AuthenticationConfig.NewInteractiveTokenSource. The redirect URL of the
+// configuration is set to the local callback listener. The returned token
+// source refreshes the token using the refresh token if one was issued.
+func NewInteractiveTokenSource(
+	ctx context.Context, oc oauth2.Config, opts InteractiveLoginOptions,
+) (oauth2.TokenSource, error) {
+	if opts.Open == nil {
+		opts.Open = PrintLoginPrompt
+	}
+
+	var lc net.ListenConfig
+
+	listener, err := lc.Listen(ctx, "tcp",
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(opts.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("open callback listener: %w", err)
+	}
+
+	oc.RedirectURL = "http://" + listener.Addr().String() + "/callback"
+
+	state := oauth2.GenerateVerifier()
+	verifier := oauth2.GenerateVerifier()
+
+	type callbackResult struct {
+		code string
+		err  error
+	}
+
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		var res callbackResult
+
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "invalid login state", http.StatusBadRequest)
+
+			return
+		case q.Get("error") != "":
+			res.err = fmt.Errorf("login failed: %s: %s",
+				q.Get("error"), q.Get("error_description"))
+
+			http.Error(w, "login failed, return to the terminal for details",
+				http.StatusBadRequest)
+		case q.Get("code") == "":
+			res.err = errors.New("no authorization code in callback")
+
+			http.Error(w, "missing authorization code",
+				http.StatusBadRequest)
+		default:
+			res.code = q.Get("code")
+
+			w.Header().Set("Content-Type", "text/plain")
+
+			_, _ = fmt.Fprintln(w,
+				"Login complete, you can close this window.")
+		}
+
+		select {
+		case results <- res:
+		default:
+		}
+	})
+
+	server := http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	defer func() {
+		sCtx, cancel := context.WithTimeout(
+			context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(sCtx)
+	}()
+
+	err = opts.Open(oc.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier)))
+	if err != nil {
+		return nil, err
+	}
+
+	var res callbackResult
+
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for login: %w", ctx.Err())
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	token, err := oc.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange authorization code: %w", err)
+	}
+
+	return oc.TokenSource(ctx, token), nil
+}
@@ -0,0 +1,133 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// LogoutOptions are the parameters of a RP-initiated logout request.
+type LogoutOptions struct {
+	// IDTokenHint is the ID token of the session that should be ended.
+	IDTokenHint string
+	// PostLogoutRedirectURI is where the user should be sent after the
+	// logout. Must be registered with the identity provider.
+	PostLogoutRedirectURI string
+	// State is passed back to the post logout redirect URI.
+	State string
+	// ClientID identifies the client, recommended when there is no ID
+	// token hint.
+	ClientID string
+	// RefreshToken is revoked by Logout if set and the identity provider
+	// has a revocation endpoint.
+	RefreshToken string
+}
+
+// EndSessionURL builds the URL that the user agent should be redirected to
+// for a OpenID Connect RP-initiated logout.
+func (conf *AuthenticationConfig) EndSessionURL(opts LogoutOptions) (string, error) {
+	endpoint := conf.OIDCConfig.EndSessionEndpoint
+	if endpoint == "" {
+		return "", errors.New("the identity provider has no end session endpoint")
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid end session endpoint: %w", err)
+	}
+
+	q := u.Query()
+
+	setIfNotEmpty := func(name string, value string) {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+
+	setIfNotEmpty("id_token_hint", opts.IDTokenHint)
+	setIfNotEmpty("post_logout_redirect_uri", opts.PostLogoutRedirectURI)
+	setIfNotEmpty("state", opts.State)
+	setIfNotEmpty("client_id", opts.ClientID)
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// RevokeToken revokes a token using the revocation endpoint of the identity
+// provider (RFC 7009), authenticating with the client credentials of the
+// config. The token type hint is optional, f.ex. "refresh_token".
+func (conf *AuthenticationConfig) RevokeToken(
+	ctx context.Context, token string, tokenTypeHint string,
+) error {
+	endpoint := conf.OIDCConfig.RevocationEndpoint
+	if endpoint == "" {
+		return errors.New("the identity provider has no revocation endpoint")
+	}
+
+	err := conf.ensureCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"token": []string{token},
+	}
+
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(
+		url.QueryEscape(conf.clientID),
+		url.QueryEscape(conf.clientSecret))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("perform request: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	// The server responds with 200 even if the token was invalid or
+	// already revoked.
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint responded with: %q", res.Status)
+	}
+
+	return nil
+}
+
+// Logout revokes the refresh token, if one is given and the identity provider
+// supports revocation, and returns the end session URL that the user agent
+// should be redirected to. A failed revocation is returned as an error
+// together with the URL, so that the caller still can end the session.
+func (conf *AuthenticationConfig) Logout(
+	ctx context.Context, opts LogoutOptions,
+) (string, error) {
+	endSession, err := conf.EndSessionURL(opts)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.RefreshToken == "" || conf.OIDCConfig.RevocationEndpoint == "" {
+		return endSession, nil
+	}
+
+	err = conf.RevokeToken(ctx, opts.RefreshToken, "refresh_token")
+	if err != nil {
+		return endSession, fmt.Errorf("revoke refresh token: %w", err)
+	}
+
+	return endSession, nil
+}
@@ -0,0 +1,54 @@
+package elephantine_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestLogout(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		ctx, server.Settings(), nil, nil)
+	test.Must(t, err, "create authentication config")
+
+	endSession, err := conf.Logout(ctx, elephantine.LogoutOptions{
+		IDTokenHint:           "id-token",
+		PostLogoutRedirectURI: "https://app.example.com/",
+		State:                 "abc",
+		RefreshToken:          "refresh-token",
+	})
+	test.Must(t, err, "log out")
+
+	u, err := url.Parse(endSession)
+	test.Must(t, err, "parse end session URL")
+
+	test.Equal(t, server.URL+"/logout", u.Scheme+"://"+u.Host+u.Path,
+		"use the end session endpoint")
+	test.EqualDiff(t, url.Values{
+		"id_token_hint":            []string{"id-token"},
+		"post_logout_redirect_uri": []string{"https://app.example.com/"},
+		"state":                    []string{"abc"},
+	}, u.Query(), "set the logout parameters")
+
+	test.Equal(t, true, server.IsRevoked("refresh-token"),
+		"revoke the refresh token")
+
+	settings := server.Settings()
+
+	settings.ClientSecret = "wrong"
+
+	bad, err := elephantine.NewAuthenticationConfig(ctx, settings, nil, nil)
+	test.Must(t, err, "create authentication config with a bad secret")
+
+	endSession, err = bad.Logout(ctx, elephantine.LogoutOptions{
+		RefreshToken: "other-token",
+	})
+	test.MustNot(t, err, "fail to revoke with a bad secret")
+	test.Equal(t, true, endSession != "",
+		"return the end session URL when revocation fails")
+}
@@ -2,10 +2,18 @@ package elephantine
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jellydator/ttlcache/v3"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
@@ -68,12 +76,45 @@ type OpenIDConnectConfig struct {
 	AuthorizationResponseIssParameterSupported                bool              `json:"authorization_response_iss_parameter_supported"`
 }
 
+// UnsupportedScopes returns the scopes that aren't listed in scopes_supported.
+// Listing scopes is optional for identity providers, so nothing is returned if
+// the list is empty.
+func (c *OpenIDConnectConfig) UnsupportedScopes(scopes []string) []string {
+	if len(c.ScopesSupported) == 0 {
+		return nil
+	}
+
+	var unsupported []string
+
+	for _, s := range scopes {
+		if !slices.Contains(c.ScopesSupported, s) {
+			unsupported = append(unsupported, s)
+		}
+	}
+
+	return unsupported
+}
+
 func OpenIDConnectConfigFromURL(
 	wellKnown string,
 ) (*OpenIDConnectConfig, error) {
 	var conf OpenIDConnectConfig
 
-	err := UnmarshalHTTPResource(wellKnown, &conf)
+	err := UnmarshalHTTPResourceContext(
+		context.Background(), nil, wellKnown, &conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// OpenIDConnectConfigFromFile reads a OpenID Connect discovery document from a
+// local file.
+func OpenIDConnectConfigFromFile(path string) (*OpenIDConnectConfig, error) {
+	var conf OpenIDConnectConfig
+
+	err := UnmarshalFile(path, &conf)
 	if err != nil {
 		return nil, err
 	}
@@ -90,83 +131,405 @@ func OpenIDConnectParameters() []cli.Flag {
 
 // AuthenticationCLIFlags returns all the CLI flags that are needed to later
 // call AuthenticationConfigFromCLI with the resulting cli.Context.
+// Applications that don't use urfave/cli can use AuthenticationSettingsFromEnv
+// and NewAuthenticationConfig instead.
 func AuthenticationCLIFlags() []cli.Flag {
+	return append(providerCLIFlags(""), providerCacheCLIFlags()...)
+}
+
+func providerCacheCLIFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "auth-cache-dir",
+			Usage:   "Directory used to cache the OIDC config and JWKS for cold starts during identity provider outages",
+			EnvVars: []string{"AUTH_CACHE_DIR"},
+		},
+		DurationFlag("auth-cache-max-staleness",
+			DefaultProviderCacheMaxStaleness, 0, 0,
+			"Max age of cached OIDC config and JWKS",
+			"AUTH_CACHE_MAX_STALENESS"),
+	}
+}
+
+// providerCLIFlags returns the provider specific flags, with the flag names
+// and environment variables suffixed with the provider name, if given.
+func providerCLIFlags(provider string) []cli.Flag {
+	flag := providerFlagName(provider)
+	env := providerEnvName(provider)
+
 	return []cli.Flag{
 		&cli.StringFlag{
-			Name:    "oidc-config",
-			EnvVars: []string{"OIDC_CONFIG"},
+			Name:    flag("oidc-config"),
+			Usage:   "URL of, or path to, the OpenID Connect discovery document",
+			EnvVars: []string{env("OIDC_CONFIG")},
+		},
+		&cli.StringFlag{
+			Name:    flag("oidc-config-parameter"),
+			Usage:   "Name of the parameter to load the OIDC config URL from, using the parameter source",
+			EnvVars: []string{env("OIDC_CONFIG_PARAMETER")},
 		},
 		&cli.StringFlag{
-			Name:    "oidc-config-parameter",
-			EnvVars: []string{"OIDC_CONFIG_PARAMETER"},
+			Name:    flag("jwt-audience"),
+			Usage:   "Comma separated list of acceptable aud claim values",
+			EnvVars: []string{env("JWT_AUDIENCE")},
+		},
+		&cli.BoolFlag{
+			Name:    flag("jwt-audience-optional"),
+			Usage:   "Accept tokens without an aud claim",
+			EnvVars: []string{env("JWT_AUDIENCE_OPTIONAL")},
 		},
 		&cli.StringFlag{
-			Name:    "jwt-audience",
-			Usage:   "String to validate the aud claim against",
-			EnvVars: []string{"JWT_AUDIENCE"},
+			Name:    flag("jwt-policy"),
+			Usage:   "Path to a YAML token policy file",
+			EnvVars: []string{env("JWT_POLICY")},
 		},
 		&cli.StringFlag{
-			Name:    "jwt-scope-prefix",
+			Name:    flag("jwt-scope-prefix"),
 			Usage:   "Prefix to strip from JWT scopes",
-			EnvVars: []string{"JWT_SCOPE_PREFIX"},
+			EnvVars: []string{env("JWT_SCOPE_PREFIX")},
+		},
+		&cli.BoolFlag{
+			Name:    flag("userinfo-enrich"),
+			Usage:   "Add name and email from the userinfo endpoint to tokens that lack them",
+			EnvVars: []string{env("USERINFO_ENRICH")},
+		},
+		&cli.BoolFlag{
+			Name:    flag("strict-scopes"),
+			Usage:   "Fail instead of warning when requesting scopes that the identity provider doesn't list as supported",
+			EnvVars: []string{env("STRICT_SCOPES")},
 		},
 		&cli.StringFlag{
-			Name:    "client-id",
-			EnvVars: []string{"CLIENT_ID"},
+			Name:    flag("client-id"),
+			EnvVars: []string{env("CLIENT_ID")},
 		},
 		&cli.StringFlag{
-			Name:    "client-id-parameter",
-			EnvVars: []string{"CLIENT_ID_PARAMETER"},
+			Name:    flag("client-id-parameter"),
+			Usage:   "Name of the parameter to load the client ID from, using the parameter source",
+			EnvVars: []string{env("CLIENT_ID_PARAMETER")},
 		},
 		&cli.StringFlag{
-			Name:    "client-secret",
-			EnvVars: []string{"CLIENT_SECRET"},
+			Name:    flag("client-secret"),
+			EnvVars: []string{env("CLIENT_SECRET")},
 		},
 		&cli.StringFlag{
-			Name:    "client-secret-parameter",
-			EnvVars: []string{"CLIENT_SECRET_PARAMETER"},
+			Name:    flag("client-secret-parameter"),
+			Usage:   "Name of the parameter to load the client secret from, using the parameter source",
+			EnvVars: []string{env("CLIENT_SECRET_PARAMETER")},
 		},
 	}
 }
 
+func providerFlagName(provider string) func(name string) string {
+	return func(name string) string {
+		if provider == "" {
+			return name
+		}
+
+		return name + "-" + strings.ToLower(provider)
+	}
+}
+
+func providerEnvName(provider string) func(name string) string {
+	return func(name string) string {
+		if provider == "" {
+			return name
+		}
+
+		return name + "_" + strings.ToUpper(
+			strings.ReplaceAll(provider, "-", "_"))
+	}
+}
+
 type AuthenticationConfig struct {
 	OIDCConfig  *OpenIDConnectConfig
 	TokenSource oauth2.TokenSource
 	AuthParser  *JWTAuthInfoParser
+	// UserinfoMetrics is used to instrument Userinfo() if set.
+	UserinfoMetrics *UserinfoMetrics
 
-	c           *cli.Context
+	settings    AuthenticationSettings
 	paramSource ParameterSource
 
-	m            sync.Mutex
-	credErr      error
-	clientID     string
-	clientSecret string
+	m             sync.Mutex
+	credErr       error
+	clientID      string
+	clientSecret  string
+	userinfoCache *ttlcache.Cache[[sha256.Size]byte, UserinfoClaims]
+}
+
+// LogValue implements slog.LogValuer, the client secret is redacted.
+func (conf *AuthenticationConfig) LogValue() slog.Value {
+	conf.m.Lock()
+	defer conf.m.Unlock()
+
+	attrs := []slog.Attr{
+		slog.String("client_id", conf.clientID),
+		slog.Any("client_secret", RedactedLogValue(conf.clientSecret)),
+		slog.Bool("token_source", conf.TokenSource != nil),
+	}
+
+	if conf.OIDCConfig != nil {
+		attrs = append(attrs,
+			slog.String("issuer", conf.OIDCConfig.Issuer),
+			slog.String("token_endpoint", conf.OIDCConfig.TokenEndpoint),
+			slog.String("jwks_uri", conf.OIDCConfig.JwksURI),
+		)
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// AuthenticationSettings are the settings used to create an
+// AuthenticationConfig. Each value can be loaded from a parameter source by
+// setting the corresponding parameter name.
+type AuthenticationSettings struct {
+	// OIDCConfig is the URL of the OpenID Connect discovery document, or
+	// a "file://" URL or path to a local copy of it.
+	OIDCConfig          string
+	OIDCConfigParameter string
+	// JWTAudiences are the acceptable aud claim values.
+	JWTAudiences []string
+	// JWTAudienceOptional accepts tokens without an aud claim.
+	JWTAudienceOptional bool
+	// JWTPolicy is the path to a YAML token policy file.
+	JWTPolicy string
+	// JWTScopePrefix is a prefix to strip from JWT scopes.
+	JWTScopePrefix        string
+	ClientID              string
+	ClientIDParameter     string
+	ClientSecret          string
+	ClientSecretParameter string
+	// Cache controls caching of the OIDC config and JWKS for cold starts.
+	Cache ProviderCacheOptions
+	// EnrichFromUserinfo adds name and email claims from the userinfo
+	// endpoint to tokens that lack them, see EnrichFromUserinfo.
+	EnrichFromUserinfo bool
+	// UserinfoCacheTTL is how long userinfo responses are cached.
+	// Defaults to DefaultUserinfoCacheTTL.
+	UserinfoCacheTTL time.Duration
+	// StrictScopes makes NewAuthenticationConfig fail instead of logging
+	// a warning when the requested scopes aren't listed as supported by
+	// the identity provider.
+	StrictScopes bool
+}
+
+// LogValue implements slog.LogValuer, the client secret is redacted.
+func (s AuthenticationSettings) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("oidc_config", s.OIDCConfig),
+		slog.String("oidc_config_parameter", s.OIDCConfigParameter),
+		slog.Any("jwt_audiences", s.JWTAudiences),
+		slog.Bool("jwt_audience_optional", s.JWTAudienceOptional),
+		slog.String("jwt_policy", s.JWTPolicy),
+		slog.String("jwt_scope_prefix", s.JWTScopePrefix),
+		slog.String("client_id", s.ClientID),
+		slog.String("client_id_parameter", s.ClientIDParameter),
+		slog.Any("client_secret", RedactedLogValue(s.ClientSecret)),
+		slog.String("client_secret_parameter", s.ClientSecretParameter),
+		slog.String("cache_dir", s.Cache.Dir),
+		slog.Duration("cache_max_staleness", s.Cache.MaxStaleness),
+		slog.Bool("enrich_from_userinfo", s.EnrichFromUserinfo),
+		slog.Duration("userinfo_cache_ttl", s.UserinfoCacheTTL),
+		slog.Bool("strict_scopes", s.StrictScopes),
+	)
+}
+
+// AuthenticationSettingsFromCLI reads the settings from the flags created by
+// AuthenticationCLIFlags.
+func AuthenticationSettingsFromCLI(c *cli.Context) AuthenticationSettings {
+	return providerSettingsFromCLI(c, "")
+}
+
+func providerSettingsFromCLI(
+	c *cli.Context, provider string,
+) AuthenticationSettings {
+	flag := providerFlagName(provider)
+
+	return AuthenticationSettings{
+		OIDCConfig:            c.String(flag("oidc-config")),
+		OIDCConfigParameter:   c.String(flag("oidc-config-parameter")),
+		JWTAudiences:          splitList(c.String(flag("jwt-audience"))),
+		JWTAudienceOptional:   c.Bool(flag("jwt-audience-optional")),
+		JWTPolicy:             c.String(flag("jwt-policy")),
+		JWTScopePrefix:        c.String(flag("jwt-scope-prefix")),
+		EnrichFromUserinfo:    c.Bool(flag("userinfo-enrich")),
+		StrictScopes:          c.Bool(flag("strict-scopes")),
+		ClientID:              c.String(flag("client-id")),
+		ClientIDParameter:     c.String(flag("client-id-parameter")),
+		ClientSecret:          c.String(flag("client-secret")),
+		ClientSecretParameter: c.String(flag("client-secret-parameter")),
+		Cache: ProviderCacheOptions{
+			Dir:          c.String("auth-cache-dir"),
+			MaxStaleness: c.Duration("auth-cache-max-staleness"),
+		},
+	}
+}
+
+// AuthenticationSettingsFromEnv reads the settings from the same environment
+// variables as AuthenticationCLIFlags uses, for applications that don't use
+// urfave/cli.
+func AuthenticationSettingsFromEnv() (AuthenticationSettings, error) {
+	return providerSettingsFromEnv("")
+}
+
+func providerSettingsFromEnv(provider string) (AuthenticationSettings, error) {
+	env := providerEnvName(provider)
+
+	settings := AuthenticationSettings{
+		OIDCConfig:            os.Getenv(env("OIDC_CONFIG")),
+		OIDCConfigParameter:   os.Getenv(env("OIDC_CONFIG_PARAMETER")),
+		JWTAudiences:          splitList(os.Getenv(env("JWT_AUDIENCE"))),
+		JWTPolicy:             os.Getenv(env("JWT_POLICY")),
+		JWTScopePrefix:        os.Getenv(env("JWT_SCOPE_PREFIX")),
+		ClientID:              os.Getenv(env("CLIENT_ID")),
+		ClientIDParameter:     os.Getenv(env("CLIENT_ID_PARAMETER")),
+		ClientSecret:          os.Getenv(env("CLIENT_SECRET")),
+		ClientSecretParameter: os.Getenv(env("CLIENT_SECRET_PARAMETER")),
+		Cache: ProviderCacheOptions{
+			Dir: os.Getenv("AUTH_CACHE_DIR"),
+		},
+	}
+
+	if name := env("JWT_AUDIENCE_OPTIONAL"); os.Getenv(name) != "" {
+		optional, err := strconv.ParseBool(os.Getenv(name))
+		if err != nil {
+			return AuthenticationSettings{}, fmt.Errorf(
+				"invalid %s: %w", name, err)
+		}
+
+		settings.JWTAudienceOptional = optional
+	}
+
+	if name := env("USERINFO_ENRICH"); os.Getenv(name) != "" {
+		enrich, err := strconv.ParseBool(os.Getenv(name))
+		if err != nil {
+			return AuthenticationSettings{}, fmt.Errorf(
+				"invalid %s: %w", name, err)
+		}
+
+		settings.EnrichFromUserinfo = enrich
+	}
+
+	if name := env("STRICT_SCOPES"); os.Getenv(name) != "" {
+		strict, err := strconv.ParseBool(os.Getenv(name))
+		if err != nil {
+			return AuthenticationSettings{}, fmt.Errorf(
+				"invalid %s: %w", name, err)
+		}
+
+		settings.StrictScopes = strict
+	}
+
+	if v := os.Getenv("AUTH_CACHE_MAX_STALENESS"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return AuthenticationSettings{}, fmt.Errorf(
+				"invalid AUTH_CACHE_MAX_STALENESS: %w", err)
+		}
+
+		settings.Cache.MaxStaleness = d
+	}
+
+	return settings, nil
+}
+
+func splitList(v string) []string {
+	var list []string
+
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// resolveSetting loads the value from the parameter source if a parameter
+// name has been given, otherwise the value is returned as-is.
+func resolveSetting(
+	ctx context.Context, src ParameterSource,
+	name string, value string, paramName string,
+) (string, error) {
+	if paramName == "" {
+		return value, nil
+	}
+
+	if src == nil {
+		return "", fmt.Errorf(
+			"no parameter source for the %q (%s) parameter",
+			paramName, name)
+	}
+
+	value, err := src.GetParameterValue(ctx, paramName)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %q (%s) parameter value: %w",
+			paramName, name, err)
+	}
+
+	return value, nil
 }
 
+// AuthenticationConfigFromCLI creates an AuthenticationConfig from the flags
+// created by AuthenticationCLIFlags, see NewAuthenticationConfig.
 func AuthenticationConfigFromCLI(
 	c *cli.Context, paramSource ParameterSource,
 	scopes []string,
+) (*AuthenticationConfig, error) {
+	return NewAuthenticationConfig(c.Context,
+		AuthenticationSettingsFromCLI(c), paramSource, scopes)
+}
+
+// NewAuthenticationConfig loads the OIDC configuration and creates an auth
+// info parser. A client credentials token source is created if scopes are
+// given. The parameter source can be nil if no parameter names are set.
+//
+// Requested scopes are checked against the scopes_supported list of the
+// discovery document, unknown scopes are logged as a warning, or treated as an
+// error if settings.StrictScopes is set.
+func NewAuthenticationConfig(
+	ctx context.Context, settings AuthenticationSettings,
+	paramSource ParameterSource, scopes []string,
 ) (*AuthenticationConfig, error) {
 	conf := AuthenticationConfig{
-		c:           c,
+		settings:    settings,
 		paramSource: paramSource,
 	}
 
-	oidcConfigURL, err := ResolveParameter(
-		c.Context, c, paramSource, "oidc-config")
+	oidcConfigURL, err := resolveSetting(ctx, paramSource, "oidc-config",
+		settings.OIDCConfig, settings.OIDCConfigParameter)
 	if err != nil {
 		return nil, fmt.Errorf("resolve OIDC config parameter: %w", err)
 	}
 
-	oidcConfig, err := OpenIDConnectConfigFromURL(oidcConfigURL)
+	if oidcConfigURL == "" {
+		return nil, errors.New("missing OIDC config URL")
+	}
+
+	oidcConfig, err := LoadOpenIDConnectConfig(
+		ctx, slog.Default(), oidcConfigURL, settings.Cache)
 	if err != nil {
 		return nil, fmt.Errorf("load OIDC config from %q: %w", oidcConfigURL, err)
 	}
 
 	conf.OIDCConfig = oidcConfig
 
+	unsupported := oidcConfig.UnsupportedScopes(scopes)
+
+	switch {
+	case len(unsupported) > 0 && settings.StrictScopes:
+		return nil, fmt.Errorf(
+			"the identity provider doesn't support the scopes: %s",
+			strings.Join(unsupported, ", "))
+	case len(unsupported) > 0:
+		slog.WarnContext(ctx,
+			"requesting scopes that the identity provider doesn't list as supported",
+			"scopes", unsupported)
+	}
+
 	if len(scopes) != 0 {
-		ts, err := conf.NewTokenSource(c.Context, scopes)
+		ts, err := conf.NewTokenSource(ctx, scopes)
 		if err != nil {
 			return nil, fmt.Errorf("create token source: %w", err)
 		}
@@ -174,16 +537,43 @@ func AuthenticationConfigFromCLI(
 		conf.TokenSource = ts
 	}
 
-	audience := c.String("jwt-audience")
-	prefix := c.String("jwt-scope-prefix")
+	var policy *TokenPolicy
+
+	if settings.JWTPolicy != "" {
+		policy, err = LoadTokenPolicy(settings.JWTPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("load token policy: %w", err)
+		}
+
+		err = policy.CheckProvider(oidcConfig)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"identity provider doesn't satisfy the token policy: %w", err)
+		}
+	}
+
+	parserOpts := JWTAuthInfoParserOptions{
+		Issuer:           oidcConfig.Issuer,
+		Audiences:        settings.JWTAudiences,
+		AudienceOptional: settings.JWTAudienceOptional,
+		ScopePrefix:      settings.JWTScopePrefix,
+		Policy:           policy,
+		JWKS: JWKSOptions{
+			Cache: settings.Cache,
+		},
+	}
+
+	if settings.EnrichFromUserinfo {
+		if oidcConfig.UserinfoEndpoint == "" {
+			return nil, errors.New(
+				"userinfo enrichment is enabled, but the identity provider has no userinfo endpoint")
+		}
+
+		parserOpts.Enrich = conf.EnrichFromUserinfo
+	}
 
 	authInfoParser, err := NewJWKSAuthInfoParser(
-		c.Context, oidcConfig.JwksURI,
-		JWTAuthInfoParserOptions{
-			Issuer:      oidcConfig.Issuer,
-			Audience:    audience,
-			ScopePrefix: prefix,
-		})
+		ctx, oidcConfig.JwksURI, parserOpts)
 	if err != nil {
 		return nil, fmt.Errorf("retrieve JWKS: %w", err)
 	}
@@ -211,6 +601,186 @@ func (conf *AuthenticationConfig) NewTokenSource(
 	return clientCredentialsConf.TokenSource(ctx), nil
 }
 
+// NewCachedTokenSource works like NewTokenSource, but shares tokens through the
+// cache, see NewCachedTokenSource.
+func (conf *AuthenticationConfig) NewCachedTokenSource(
+	ctx context.Context, scopes []string, cache TokenCache,
+	opts CachedTokenSourceOptions,
+) (oauth2.TokenSource, error) {
+	ts, err := conf.NewTokenSource(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := TokenCacheKey(
+		conf.OIDCConfig.TokenEndpoint, conf.clientID, scopes)
+
+	return NewCachedTokenSource(ctx, cache, key, ts, opts), nil
+}
+
+// DeviceFlowPrompt is used to show the user where to authorize the device,
+// and the code to enter.
+type DeviceFlowPrompt func(auth *oauth2.DeviceAuthResponse) error
+
+// PrintDeviceFlowPrompt is a DeviceFlowPrompt that prints the instructions to
+// stderr.
+func PrintDeviceFlowPrompt(auth *oauth2.DeviceAuthResponse) error {
+	uri := auth.VerificationURIComplete
+	if uri == "" {
+		uri = auth.VerificationURI
+	}
+
+	_, err := fmt.Fprintf(os.Stderr,
+		"To log in, open %s and enter the code %s\n",
+		uri, auth.UserCode)
+	if err != nil {
+		return fmt.Errorf("print prompt: %w", err)
+	}
+
+	return nil
+}
+
+// NewDeviceFlowTokenSource authenticates the user using the OAuth 2.0 device
+// authorization grant, for CLI tools that act on behalf of a user. Blocks until
+// the user has authorized the device or the code expires. The client secret is
+// optional, as device flow clients usually are public clients. The prompt
+// defaults to PrintDeviceFlowPrompt.
+func (conf *AuthenticationConfig) NewDeviceFlowTokenSource(
+	ctx context.Context, scopes []string, prompt DeviceFlowPrompt,
+) (oauth2.TokenSource, error) {
+	if conf.OIDCConfig.DeviceAuthorizationEndpoint == "" {
+		return nil, errors.New(
+			"the identity provider doesn't support device authorization")
+	}
+
+	oc, err := conf.publicClientConfig(ctx, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDeviceFlowTokenSource(ctx, oc, prompt)
+}
+
+// NewDeviceFlowTokenSource performs the device authorization grant using the
+// given client configuration, see
+// AuthenticationConfig.NewDeviceFlowTokenSource. The returned token source
+// refreshes the token using the refresh token if one was issued.
+func NewDeviceFlowTokenSource(
+	ctx context.Context, oc oauth2.Config, prompt DeviceFlowPrompt,
+) (oauth2.TokenSource, error) {
+	if prompt == nil {
+		prompt = PrintDeviceFlowPrompt
+	}
+
+	auth, err := oc.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("start device authorization: %w", err)
+	}
+
+	err = prompt(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oc.DeviceAccessToken(ctx, auth)
+	if err != nil {
+		return nil, fmt.Errorf("wait for device authorization: %w", err)
+	}
+
+	return oc.TokenSource(ctx, token), nil
+}
+
+// publicClientConfig creates a client configuration for user facing flows,
+// where the client secret is optional.
+func (conf *AuthenticationConfig) publicClientConfig(
+	ctx context.Context, scopes []string,
+) (oauth2.Config, error) {
+	clientID, err := resolveSetting(ctx, conf.paramSource, "client-id",
+		conf.settings.ClientID, conf.settings.ClientIDParameter)
+	if err != nil {
+		return oauth2.Config{}, fmt.Errorf(
+			"resolve client id parameter: %w", err)
+	}
+
+	if clientID == "" {
+		return oauth2.Config{}, errors.New("missing client ID")
+	}
+
+	clientSecret, err := resolveSetting(ctx, conf.paramSource, "client-secret",
+		conf.settings.ClientSecret, conf.settings.ClientSecretParameter)
+	if err != nil {
+		return oauth2.Config{}, fmt.Errorf(
+			"resolve client secret parameter: %w", err)
+	}
+
+	return oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       conf.OIDCConfig.AuthorizationEndpoint,
+			DeviceAuthURL: conf.OIDCConfig.DeviceAuthorizationEndpoint,
+			TokenURL:      conf.OIDCConfig.TokenEndpoint,
+		},
+		Scopes: scopes,
+	}, nil
+}
+
+// DefaultReadyCheckTokenTTL is how long ReadyCheck trusts a successful token
+// acquisition.
+const DefaultReadyCheckTokenTTL = time.Minute
+
+// ReadyCheck returns a ReadyFunc that verifies that the JWKS has been loaded,
+// and that a token can be acquired if the config has a token source. A
+// successful token acquisition is remembered for DefaultReadyCheckTokenTTL, so
+// that readiness probes don't hit the identity provider every time.
+func (conf *AuthenticationConfig) ReadyCheck() ReadyFunc {
+	var (
+		m         sync.Mutex
+		lastToken time.Time
+	)
+
+	return func(ctx context.Context) error {
+		err := conf.AuthParser.Ready(ctx)
+		if err != nil {
+			return fmt.Errorf("JWKS: %w", err)
+		}
+
+		if conf.TokenSource == nil {
+			return nil
+		}
+
+		m.Lock()
+		defer m.Unlock()
+
+		if time.Since(lastToken) < DefaultReadyCheckTokenTTL {
+			return nil
+		}
+
+		// Token() doesn't take a context, so run it in a goroutine
+		// to respect the deadline of the check.
+		result := make(chan error, 1)
+
+		go func() {
+			_, err := conf.TokenSource.Token()
+
+			result <- err
+		}()
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("acquire token: %w", ctx.Err())
+		case err := <-result:
+			if err != nil {
+				return fmt.Errorf("acquire token: %w", err)
+			}
+		}
+
+		lastToken = time.Now()
+
+		return nil
+	}
+}
+
 func (conf *AuthenticationConfig) ensureCredentials(ctx context.Context) error {
 	conf.m.Lock()
 	defer conf.m.Unlock()
@@ -230,9 +800,8 @@ func (conf *AuthenticationConfig) ensureCredentials(ctx context.Context) error {
 }
 
 func (conf *AuthenticationConfig) resolveCredentials(ctx context.Context) error {
-	clientID, err := ResolveParameter(
-		ctx, conf.c, conf.paramSource, "client-id",
-	)
+	clientID, err := resolveSetting(ctx, conf.paramSource, "client-id",
+		conf.settings.ClientID, conf.settings.ClientIDParameter)
 	if err != nil {
 		return fmt.Errorf("resolve client id parameter: %w", err)
 	}
@@ -241,9 +810,8 @@ func (conf *AuthenticationConfig) resolveCredentials(ctx context.Context) error
 		return errors.New("missing client ID")
 	}
 
-	clientSecret, err := ResolveParameter(
-		ctx, conf.c, conf.paramSource, "client-secret",
-	)
+	clientSecret, err := resolveSetting(ctx, conf.paramSource, "client-secret",
+		conf.settings.ClientSecret, conf.settings.ClientSecretParameter)
 	if err != nil {
 		return fmt.Errorf("resolve client secret parameter: %w", err)
 	}
@@ -0,0 +1,497 @@
+package elephantine_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/oauth2"
+)
+
+func TestDeviceFlowTokenSource(t *testing.T) {
+	var polls atomic.Int32
+
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "cli" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"error": "invalid_client",
+			})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"device_code":      "device-123",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": "https://login.example.com/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("device_code") != "device-123" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid_grant",
+			})
+
+			return
+		}
+
+		if polls.Add(1) == 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "authorization_pending",
+			})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  "user-token",
+			"token_type":    "Bearer",
+			"refresh_token": "refresh-token",
+			"expires_in":    300,
+		})
+	})
+
+	server := httptest.NewServer(mux)
+
+	t.Cleanup(server.Close)
+
+	var prompted *oauth2.DeviceAuthResponse
+
+	ts, err := elephantine.NewDeviceFlowTokenSource(test.Context(t),
+		oauth2.Config{
+			ClientID: "cli",
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: server.URL + "/device",
+				TokenURL:      server.URL + "/token",
+			},
+		}, func(auth *oauth2.DeviceAuthResponse) error {
+			prompted = auth
+
+			return nil
+		})
+	test.Must(t, err, "complete device flow")
+
+	test.Equal(t, "ABCD-EFGH", prompted.UserCode, "prompt with user code")
+	test.Equal(t, 2, int(polls.Load()), "poll until authorized")
+
+	token, err := ts.Token()
+	test.Must(t, err, "get token")
+
+	test.Equal(t, "user-token", token.AccessToken, "get access token")
+	test.Equal(t, "refresh-token", token.RefreshToken, "get refresh token")
+}
+
+func TestInteractiveTokenSource(t *testing.T) {
+	const clientID = "cli"
+
+	var (
+		challenge   string
+		redirectURI string
+	)
+
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if q.Get("client_id") != clientID ||
+			q.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+
+			return
+		}
+
+		challenge = q.Get("code_challenge")
+		redirectURI = q.Get("redirect_uri")
+
+		http.Redirect(w, r, redirectURI+"?"+url.Values{
+			"code":  {"code-123"},
+			"state": {q.Get("state")},
+		}.Encode(), http.StatusFound)
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+
+		if r.FormValue("code") != "code-123" ||
+			r.FormValue("redirect_uri") != redirectURI ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "invalid_grant",
+			})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  "user-token",
+			"token_type":    "Bearer",
+			"refresh_token": "refresh-token",
+			"expires_in":    300,
+		})
+	})
+
+	server := httptest.NewServer(mux)
+
+	t.Cleanup(server.Close)
+
+	ctx := test.Context(t)
+
+	ts, err := elephantine.NewInteractiveTokenSource(ctx,
+		oauth2.Config{
+			ClientID: clientID,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  server.URL + "/authorize",
+				TokenURL: server.URL + "/token",
+			},
+		}, elephantine.InteractiveLoginOptions{
+			// Act as the browser and follow the redirect back
+			// to the callback listener.
+			Open: func(authURL string) error {
+				req, err := http.NewRequestWithContext(
+					ctx, http.MethodGet, authURL, nil)
+				if err != nil {
+					return err
+				}
+
+				res, err := http.DefaultClient.Do(req)
+				if err != nil {
+					return err
+				}
+
+				_ = res.Body.Close()
+
+				if res.StatusCode != http.StatusOK {
+					return fmt.Errorf("login status %s", res.Status)
+				}
+
+				return nil
+			},
+		})
+	test.Must(t, err, "complete interactive login")
+
+	token, err := ts.Token()
+	test.Must(t, err, "get token")
+
+	test.Equal(t, "user-token", token.AccessToken, "get access token")
+	test.Equal(t, "refresh-token", token.RefreshToken, "get refresh token")
+}
+
+func TestAuthenticationSettingsFromEnv(t *testing.T) {
+	key := test.NewSigningKey(t)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	t.Cleanup(server.Close)
+
+	jwksHandler, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     key.KeyID,
+		Algorithm: key.Method.Alg(),
+		Key:       key.Public(),
+	})
+	test.Must(t, err, "create JWKS handler")
+
+	mux.Handle("GET /jwks", jwksHandler)
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		_ = json.NewEncoder(w).Encode(elephantine.OpenIDConnectConfig{
+			Issuer:        "test",
+			JwksURI:       server.URL + "/jwks",
+			TokenEndpoint: server.URL + "/token",
+		})
+	})
+
+	t.Setenv("OIDC_CONFIG", server.URL+"/.well-known/openid-configuration")
+	t.Setenv("JWT_AUDIENCE", "repository, index")
+	t.Setenv("JWT_AUDIENCE_OPTIONAL", "true")
+	t.Setenv("AUTH_CACHE_MAX_STALENESS", "1h")
+
+	settings, err := elephantine.AuthenticationSettingsFromEnv()
+	test.Must(t, err, "read settings from environment")
+
+	test.EqualDiff(t, []string{"repository", "index"}, settings.JWTAudiences,
+		"split the audience list")
+	test.Equal(t, true, settings.JWTAudienceOptional,
+		"parse the audience optional flag")
+	test.Equal(t, time.Hour, settings.Cache.MaxStaleness,
+		"parse the max staleness")
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		test.Context(t), settings, nil, nil)
+	test.Must(t, err, "create authentication config without a CLI context")
+
+	auth, err := conf.AuthParser.AuthInfoFromHeader(key.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:  "test",
+				Subject: "someone",
+			},
+		}))
+	test.Must(t, err, "validate token")
+
+	test.Equal(t, "core://user/someone", auth.Claims.Subject,
+		"get the expected subject")
+
+	t.Setenv("JWT_AUDIENCE_OPTIONAL", "maybe")
+
+	_, err = elephantine.AuthenticationSettingsFromEnv()
+	test.MustNot(t, err, "reject invalid boolean")
+}
+
+func TestAuthenticationSettingsLogValue(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	logger.Info("settings", "auth", elephantine.AuthenticationSettings{
+		ClientID:     "elephant",
+		ClientSecret: "hunter2",
+	})
+
+	test.Equal(t, false, strings.Contains(buf.String(), "hunter2"),
+		"don't log the client secret")
+	test.Equal(t, true, strings.Contains(buf.String(), `"client_id":"elephant"`),
+		"log the client ID")
+}
+
+func TestOIDCServer(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		ctx, server.Settings(), nil, []string{"doc_read"})
+	test.Must(t, err, "create authentication config")
+
+	token, err := conf.TokenSource.Token()
+	test.Must(t, err, "get client credentials token")
+
+	auth, err := conf.AuthParser.AuthInfoFromHeader(
+		"Bearer " + token.AccessToken)
+	test.Must(t, err, "accept the client credentials token")
+
+	test.Equal(t, "core://application/test-client", auth.Claims.Subject,
+		"get the client subject")
+	test.Equal(t, true, auth.Claims.HasScope("doc_read"),
+		"get the requested scope")
+
+	auth, err = conf.AuthParser.AuthInfoFromHeader(server.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "core://user/someone",
+			},
+		}))
+	test.Must(t, err, "accept a minted token")
+
+	test.Equal(t, "core://user/someone", auth.Claims.Subject,
+		"get the minted subject")
+
+	server.AddClient("limited", test.OIDCClient{
+		Secret: "secret",
+		Scopes: []string{"doc_read"},
+	})
+
+	settings := server.Settings()
+
+	settings.ClientID = "limited"
+	settings.ClientSecret = "secret"
+
+	limited, err := elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, []string{"doc_write"})
+	test.Must(t, err, "create authentication config for the limited client")
+
+	_, err = limited.TokenSource.Token()
+	test.MustNot(t, err, "get a token with a scope that isn't allowed")
+}
+
+type mapParameterSource map[string]string
+
+func (m mapParameterSource) GetParameterValue(
+	_ context.Context, name string,
+) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", fmt.Errorf("unknown parameter %q", name)
+	}
+
+	return v, nil
+}
+
+func TestAuthenticationConfigFromCLIParameters(t *testing.T) {
+	server := test.NewOIDCServer(t)
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	for _, f := range elephantine.AuthenticationCLIFlags() {
+		test.Must(t, f.Apply(set), "apply flag %v", f.Names())
+	}
+
+	err := set.Parse([]string{
+		"--oidc-config", server.WellKnownURL(),
+		"--client-id", server.ClientID,
+		"--client-secret-parameter", "/auth/client-secret",
+	})
+	test.Must(t, err, "parse flags")
+
+	c := cli.NewContext(cli.NewApp(), set, nil)
+
+	c.Context = test.Context(t)
+
+	params := mapParameterSource{
+		"/auth/client-secret": server.ClientSecret,
+	}
+
+	conf, err := elephantine.AuthenticationConfigFromCLI(
+		c, params, []string{"doc_read"})
+	test.Must(t, err, "create authentication config")
+
+	_, err = conf.TokenSource.Token()
+	test.Must(t, err, "get a token using the client secret parameter")
+
+	secret, err := elephantine.ResolveParameter(
+		c.Context, c, params, "client-secret")
+	test.Must(t, err, "resolve the client secret parameter")
+
+	test.Equal(t, server.ClientSecret, secret,
+		"resolve the secret through the parameter source")
+}
+
+func TestAuthenticationConfigReadyCheck(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		ctx, server.Settings(), nil, []string{"doc_read"})
+	test.Must(t, err, "create authentication config")
+
+	check := conf.ReadyCheck()
+
+	test.Must(t, check(ctx), "be ready")
+
+	server.AddClient(server.ClientID, test.OIDCClient{
+		Secret: "rotated",
+	})
+
+	test.Must(t, check(ctx), "remember the successful token acquisition")
+
+	settings := server.Settings()
+
+	settings.ClientSecret = "wrong"
+
+	broken, err := elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, []string{"doc_read"})
+	test.Must(t, err, "create authentication config with a bad secret")
+
+	test.MustNot(t, broken.ReadyCheck()(ctx),
+		"not be ready when tokens can't be acquired")
+}
+
+func TestOIDCConfigFromFile(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	docPath := filepath.Join(t.TempDir(), "openid-configuration.json")
+
+	err := elephantine.MarshalFile(docPath, elephantine.OpenIDConnectConfig{
+		Issuer:        server.URL,
+		JwksURI:       server.URL + "/jwks",
+		TokenEndpoint: server.URL + "/token",
+	})
+	test.Must(t, err, "write discovery document")
+
+	for name, location := range map[string]string{
+		"plain_path": docPath,
+		"file_url":   "file://" + docPath,
+	} {
+		t.Run(name, func(t *testing.T) {
+			settings := server.Settings()
+
+			settings.OIDCConfig = location
+
+			conf, err := elephantine.NewAuthenticationConfig(
+				ctx, settings, nil, nil)
+			test.Must(t, err, "create authentication config")
+
+			test.Equal(t, server.URL, conf.OIDCConfig.Issuer,
+				"read the issuer from the file")
+
+			_, err = conf.AuthParser.AuthInfoFromHeader(server.AccessKey(t,
+				elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: "core://user/someone",
+					},
+				}))
+			test.Must(t, err, "accept a token")
+		})
+	}
+}
+
+func TestAuthenticationConfigScopesSupported(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	docPath := filepath.Join(t.TempDir(), "openid-configuration.json")
+
+	err := elephantine.MarshalFile(docPath, elephantine.OpenIDConnectConfig{
+		Issuer:          server.URL,
+		JwksURI:         server.URL + "/jwks",
+		TokenEndpoint:   server.URL + "/token",
+		ScopesSupported: []string{"doc_read", "doc_write"},
+	})
+	test.Must(t, err, "write discovery document")
+
+	settings := server.Settings()
+
+	settings.OIDCConfig = docPath
+
+	_, err = elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, []string{"doc_read", "doc_raed"})
+	test.Must(t, err, "only warn about unsupported scopes by default")
+
+	settings.StrictScopes = true
+
+	_, err = elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, []string{"doc_read", "doc_write"})
+	test.Must(t, err, "accept supported scopes in strict mode")
+
+	_, err = elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, []string{"doc_read", "doc_raed"})
+	test.MustNot(t, err, "fail on unsupported scopes in strict mode")
+}
@@ -0,0 +1,238 @@
+// Package ops provides a CLI command with standard maintenance subcommands
+// for elephantine based services.
+package ops
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg"
+	"github.com/urfave/cli/v2"
+)
+
+// CommandOptions controls which subcommands are available and how they
+// resolve their settings.
+type CommandOptions struct {
+	// Flags should be the same flags as the ones used by the serve
+	// command, so that settings are resolved in the same way.
+	Flags []cli.Flag
+	// ParameterSource creates the parameter source used to resolve
+	// parameters. Parameters are read from flags only if nil.
+	ParameterSource func(c *cli.Context) (elephantine.ParameterSource, error)
+	// Parameters are the names of the parameters that "verify-config"
+	// should resolve, see elephantine.ResolveParameter.
+	Parameters []string
+	// Secrets are the names of parameters that shouldn't have their
+	// values printed.
+	Secrets []string
+	// ConnectDB creates the database pool used by the "job-locks"
+	// subcommand. The subcommand is left out if nil.
+	ConnectDB func(c *cli.Context) (*pgxpool.Pool, error)
+	// HealthAddrFlag is the name of the flag that holds the health server
+	// address. Defaults to "profile-addr".
+	HealthAddrFlag string
+	// Commands are service specific subcommands, like requeuing
+	// dead-letter jobs or flushing caches.
+	Commands []*cli.Command
+}
+
+// Command creates an "ops" command with subcommands for operational tasks.
+func Command(opts CommandOptions) *cli.Command {
+	if opts.HealthAddrFlag == "" {
+		opts.HealthAddrFlag = "profile-addr"
+	}
+
+	subcommands := []*cli.Command{
+		{
+			Name:   "verify-config",
+			Usage:  "Resolve all configuration parameters and secrets",
+			Flags:  opts.Flags,
+			Action: opts.verifyConfig,
+		},
+		{
+			Name:   "health",
+			Usage:  "Check the ready endpoint of a running instance",
+			Flags:  opts.Flags,
+			Action: opts.health,
+		},
+	}
+
+	if opts.ConnectDB != nil {
+		subcommands = append(subcommands, &cli.Command{
+			Name:   "job-locks",
+			Usage:  "List the currently held job locks",
+			Flags:  opts.Flags,
+			Action: opts.jobLocks,
+		})
+	}
+
+	subcommands = append(subcommands, opts.Commands...)
+
+	return &cli.Command{
+		Name:        "ops",
+		Usage:       "Operational and maintenance tasks",
+		Subcommands: subcommands,
+	}
+}
+
+func (opts CommandOptions) verifyConfig(c *cli.Context) error {
+	var src elephantine.ParameterSource
+
+	if opts.ParameterSource != nil {
+		s, err := opts.ParameterSource(c)
+		if err != nil {
+			return fmt.Errorf("create parameter source: %w", err)
+		}
+
+		src = s
+	} else {
+		s, err := elephantine.GetParameterSource("")
+		if err != nil {
+			return fmt.Errorf("create parameter source: %w", err)
+		}
+
+		src = s
+	}
+
+	secrets := make(map[string]bool)
+
+	for _, name := range opts.Secrets {
+		secrets[name] = true
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+
+	var failed []string
+
+	for _, name := range opts.Parameters {
+		value, err := elephantine.ResolveParameter(
+			c.Context, c, src, name)
+
+		var status string
+
+		switch {
+		case err != nil:
+			failed = append(failed, name)
+			status = "error: " + err.Error()
+		case value == "":
+			status = "empty"
+		case secrets[name]:
+			status = elephantine.RedactedLogValue(value).String()
+		default:
+			status = value
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", name, status)
+	}
+
+	err := w.Flush()
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to resolve: %s",
+			strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func (opts CommandOptions) health(c *cli.Context) (outErr error) {
+	addr := c.String(opts.HealthAddrFlag)
+	if addr == "" {
+		return fmt.Errorf("no health server address in %q",
+			opts.HealthAddrFlag)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid health server address: %w", err)
+	}
+
+	if host == "" {
+		host = "localhost"
+	}
+
+	endpoint := fmt.Sprintf("http://%s/health/ready",
+		net.JoinHostPort(host, port))
+
+	client := http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	req, err := http.NewRequestWithContext(
+		c.Context, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("perform request: %w", err)
+	}
+
+	defer func() {
+		err := res.Body.Close()
+		if err != nil {
+			outErr = errors.Join(outErr, fmt.Errorf(
+				"close response body: %w", err))
+		}
+	}()
+
+	_, err = io.Copy(c.App.Writer, res.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("not ready: %s", res.Status)
+	}
+
+	return nil
+}
+
+func (opts CommandOptions) jobLocks(c *cli.Context) error {
+	pool, err := opts.ConnectDB(c)
+	if err != nil {
+		return fmt.Errorf("connect to database: %w", err)
+	}
+
+	defer pool.Close()
+
+	locks, err := pg.ListJobLocks(c.Context, pool)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(w, "NAME\tHOLDER\tTOUCHED\tITERATION\tMETADATA")
+
+	for _, l := range locks {
+		meta, err := json.Marshal(l.Metadata)
+		if err != nil {
+			return fmt.Errorf("marshal metadata: %w", err)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+			l.Name, l.Holder, l.Touched.Format(time.RFC3339),
+			l.Iteration, meta)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return fmt.Errorf("write output: %w", err)
+	}
+
+	return nil
+}
@@ -24,13 +24,22 @@ func (noParameterSource) GetParameterValue(_ context.Context, _ string) (string,
 
 // GetParameterSource returns a named parameter source.
 func GetParameterSource(name string) (ParameterSource, error) {
+	return GetParameterSourceWithOptions(
+		context.Background(), name, SecretSourceOptions{})
+}
+
+// GetParameterSourceWithOptions returns a named parameter source that uses the
+// given timeout and metrics for secret store operations.
+func GetParameterSourceWithOptions(
+	ctx context.Context, name string, opts SecretSourceOptions,
+) (ParameterSource, error) {
 	switch name {
 	case "":
 		return noParameterSource{}, nil
 	case "ssm":
-		return NewLazySSM(), nil
+		return NewLazySSMWithOptions(opts), nil
 	case "vault":
-		return NewVault()
+		return NewVaultWithOptions(ctx, opts)
 	default:
 		return nil, fmt.Errorf("unknown parameter source %q", name)
 	}
@@ -38,20 +47,12 @@ func GetParameterSource(name string) (ParameterSource, error) {
 
 // ResolveParameter loads the parameter from the parameter source if
 // "[name]-parameter" has been set for the cli.Context, otherwise the value of
-// "[name]" will be returned.
+// "[name]" will be returned. The authentication flags, like
+// "client-secret-parameter", are resolved the same way by
+// AuthenticationConfigFromCLI.
 func ResolveParameter(
 	ctx context.Context, c *cli.Context, src ParameterSource, name string,
 ) (string, error) {
-	paramName := c.String(name + "-parameter")
-	if paramName == "" {
-		return c.String(name), nil
-	}
-
-	value, err := src.GetParameterValue(ctx, paramName)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch %q (%s) parameter value: %w",
-			paramName, name, err)
-	}
-
-	return value, nil
+	return resolveSetting(ctx, src, name,
+		c.String(name), c.String(name+"-parameter"))
 }
@@ -0,0 +1,262 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+var _ elephantine.LeaderElector = &AdvisoryLock{}
+
+// AdvisoryLockOptions controls how an advisory lock should behave.
+type AdvisoryLockOptions struct {
+	// CheckInterval controls how often we should attempt to acquire the
+	// lock. Defaults to 10s.
+	CheckInterval time.Duration
+	// PingInterval controls how often the connection that holds the lock
+	// should be pinged. Defaults to 10s.
+	PingInterval time.Duration
+	// Timeout is the timeout that should be used for all lock
+	// operations. Defaults to 5s.
+	Timeout time.Duration
+	// Identity overrides the randomly generated identity.
+	Identity string
+}
+
+// AdvisoryLock is a LeaderElector that uses a session level Postgres advisory
+// lock. Unlike JobLock it doesn't need a table, but it holds on to a pool
+// connection for as long as the lock is held.
+//
+// As the lock is tied to the database session, it's released by the server as
+// soon as the connection is lost. This also means that it can't be used with a
+// pool that connects through a transaction pooler, see PoolModeTransaction.
+type AdvisoryLock struct {
+	logger   *slog.Logger
+	pool     *pgxpool.Pool
+	key      int64
+	identity string
+	opts     AdvisoryLockOptions
+
+	abort    chan struct{}
+	acquired chan struct{}
+	lost     chan struct{}
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewAdvisoryLock creates a new advisory lock, the lock key is derived from
+// the name.
+func NewAdvisoryLock(
+	pool *pgxpool.Pool, logger *slog.Logger, name string,
+	opts AdvisoryLockOptions,
+) (*AdvisoryLock, error) {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = 10 * time.Second
+	}
+
+	if opts.PingInterval == 0 {
+		opts.PingInterval = 10 * time.Second
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	identity := opts.Identity
+
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+
+		identity = fmt.Sprintf("%s.%s", uuid.New(), hostname)
+	}
+
+	h := fnv.New64a()
+
+	_, _ = h.Write([]byte(name))
+
+	l := AdvisoryLock{
+		logger: logger.With(
+			elephantine.LogKeyJobLock, name,
+			elephantine.LogKeyJobLockID, identity),
+		pool:     pool,
+		key:      int64(h.Sum64()), //nolint:gosec
+		identity: identity,
+		opts:     opts,
+		abort:    make(chan struct{}),
+		acquired: make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+
+	return &l, nil
+}
+
+// Identity implements elephantine.LeaderElector.
+func (l *AdvisoryLock) Identity() string {
+	return l.identity
+}
+
+// Acquire implements elephantine.LeaderElector.
+func (l *AdvisoryLock) Acquire(ctx context.Context) error {
+	l.startOnce.Do(func() {
+		go l.loop()
+	})
+
+	select {
+	case <-l.acquired:
+		return nil
+	case <-l.lost:
+		return elephantine.ErrLeadershipLost
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
+	}
+}
+
+// Watch implements elephantine.LeaderElector.
+func (l *AdvisoryLock) Watch() <-chan struct{} {
+	return l.lost
+}
+
+// Yield implements elephantine.LeaderElector.
+func (l *AdvisoryLock) Yield() {
+	l.stopOnce.Do(func() {
+		close(l.abort)
+	})
+
+	// Only wait for the lock to be released if the loop has been started.
+	started := true
+
+	l.startOnce.Do(func() {
+		started = false
+
+		close(l.lost)
+	})
+
+	if !started {
+		return
+	}
+
+	select {
+	case <-l.lost:
+	case <-time.After(l.opts.Timeout):
+	}
+}
+
+func (l *AdvisoryLock) loop() {
+	defer close(l.lost)
+
+	var conn *pgxpool.Conn
+
+	for conn == nil {
+		conn = l.tryAcquire()
+		if conn != nil {
+			break
+		}
+
+		select {
+		case <-l.abort:
+			return
+		case <-time.After(l.opts.CheckInterval):
+		}
+	}
+
+	l.logger.Debug("acquired advisory lock")
+
+	close(l.acquired)
+
+	for {
+		select {
+		case <-l.abort:
+			l.release(conn)
+
+			return
+		case <-time.After(l.opts.PingInterval):
+		}
+
+		err := l.ping(conn)
+		if err != nil {
+			l.logger.Error("lost advisory lock connection",
+				elephantine.LogKeyError, err)
+
+			// Make sure that the broken connection doesn't go
+			// back into the pool.
+			_ = conn.Conn().Close(context.Background())
+			conn.Release()
+
+			return
+		}
+	}
+}
+
+func (l *AdvisoryLock) tryAcquire() *pgxpool.Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.Timeout)
+	defer cancel()
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		l.logger.Error("failed to acquire connection",
+			elephantine.LogKeyError, err)
+
+		return nil
+	}
+
+	ok, err := postgres.New(conn).TryAdvisoryLock(ctx, l.key)
+	if err != nil {
+		l.logger.Error("failed to acquire advisory lock",
+			elephantine.LogKeyError, err)
+	}
+
+	if !ok || err != nil {
+		conn.Release()
+
+		return nil
+	}
+
+	return conn
+}
+
+func (l *AdvisoryLock) ping(conn *pgxpool.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.Timeout)
+	defer cancel()
+
+	err := conn.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("ping connection: %w", err)
+	}
+
+	return nil
+}
+
+func (l *AdvisoryLock) release(conn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.Timeout)
+	defer cancel()
+
+	l.logger.Debug("releasing advisory lock")
+
+	released, err := postgres.New(conn).AdvisoryUnlock(ctx, l.key)
+
+	switch {
+	case err != nil:
+		l.logger.Error("failed to release advisory lock",
+			elephantine.LogKeyError, err)
+
+		// Closing the connection releases the lock.
+		_ = conn.Conn().Close(context.Background())
+	case !released:
+		l.logger.Error("out of sync: advisory lock wasn't held")
+	}
+
+	conn.Release()
+}
@@ -0,0 +1,65 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// Dedup keeps track of the message keys that a consumer has processed, so that
+// redelivered messages can be skipped. Combined with at-least-once delivery
+// this gives effectively exactly-once processing.
+//
+// Keys are remembered for the TTL, which should be longer than the time window
+// in which the producer can redeliver a message.
+type Dedup struct {
+	consumer string
+	ttl      time.Duration
+}
+
+// NewDedup creates a deduplicator for the named consumer. Consumers have
+// separate key spaces, so the same message can be processed once by every
+// consumer.
+func NewDedup(consumer string, ttl time.Duration) *Dedup {
+	return &Dedup{
+		consumer: consumer,
+		ttl:      ttl,
+	}
+}
+
+// CheckAndMark marks the key as seen and returns true if it hadn't been seen
+// before, and false if the message is a duplicate that should be skipped.
+//
+// Call CheckAndMark in the same transaction as the side effects of processing
+// the message. If the transaction is rolled back the key is unmarked, and the
+// message will be processed again when it's redelivered. Concurrent calls for
+// the same key will block until the first transaction has completed.
+func (d *Dedup) CheckAndMark(
+	ctx context.Context, tx postgres.DBTX, key string,
+) (bool, error) {
+	n, err := postgres.New(tx).MarkDedupKey(ctx, postgres.MarkDedupKeyParams{
+		Consumer: d.consumer,
+		Key:      key,
+		Expires:  Time(time.Now().Add(d.ttl)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("mark dedup key: %w", err)
+	}
+
+	return n == 1, nil
+}
+
+// DeleteExpired removes the keys that have outlived the TTL. Should be run
+// periodically, f.ex. by a job that holds a job lock.
+func (d *Dedup) DeleteExpired(
+	ctx context.Context, db postgres.DBTX,
+) (int64, error) {
+	n, err := postgres.New(db).DeleteExpiredDedupKeys(ctx, d.consumer)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired dedup keys: %w", err)
+	}
+
+	return n, nil
+}
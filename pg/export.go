@@ -0,0 +1,166 @@
+package pg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// ElephantineTables are the tables that are owned by elephantine.
+var ElephantineTables = []string{"job_lock", "token_revocation", "dedup_key"}
+
+// ExportRecord is a line in a NDJSON table export.
+type ExportRecord struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// ExportTables writes the rows of the given tables as NDJSON export records.
+// Defaults to ElephantineTables if no tables are given. Run the export in a
+// repeatable read transaction to get a consistent snapshot.
+//
+// The writer can be anything, like a file or a S3 upload stream, which makes it
+// possible to move background state between clusters.
+func ExportTables(
+	ctx context.Context, db postgres.DBTX, w io.Writer, tables ...string,
+) error {
+	if len(tables) == 0 {
+		tables = ElephantineTables
+	}
+
+	enc := json.NewEncoder(w)
+
+	for _, table := range tables {
+		err := exportTable(ctx, db, enc, table)
+		if err != nil {
+			return fmt.Errorf("export %q: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func exportTable(
+	ctx context.Context, db postgres.DBTX, enc *json.Encoder, table string,
+) error {
+	ident := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+
+	//nolint:gosec
+	rows, err := db.Query(ctx,
+		fmt.Sprintf("SELECT row_to_json(t) FROM %s AS t", ident))
+	if err != nil {
+		return fmt.Errorf("query rows: %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var row []byte
+
+		err := rows.Scan(&row)
+		if err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+
+		err = enc.Encode(ExportRecord{
+			Table: table,
+			Row:   row,
+		})
+		if err != nil {
+			return fmt.Errorf("write record: %w", err)
+		}
+	}
+
+	if rows.Err() != nil {
+		return fmt.Errorf("read rows: %w", rows.Err())
+	}
+
+	return nil
+}
+
+// ImportOptions controls how exported rows are restored.
+type ImportOptions struct {
+	// Tables are the tables that will be restored. Defaults to
+	// ElephantineTables.
+	Tables []string
+	// Truncate empties the tables before the rows are restored.
+	Truncate bool
+}
+
+// ImportTables restores rows from a NDJSON export created by ExportTables.
+// Rows that conflict with existing rows are skipped. Run the import in a
+// transaction so that a failed import doesn't leave partial state behind.
+//
+// Records for elephantine tables that weren't selected are skipped, records
+// for any other table fail the import, as table names in the export can't be
+// trusted.
+//
+// Returns the number of restored rows per table.
+func ImportTables(
+	ctx context.Context, tx pgx.Tx, r io.Reader, opts ImportOptions,
+) (map[string]int64, error) {
+	tables := opts.Tables
+	if len(tables) == 0 {
+		tables = ElephantineTables
+	}
+
+	include := make(map[string]string, len(tables))
+
+	for _, t := range tables {
+		include[t] = pgx.Identifier(strings.Split(t, ".")).Sanitize()
+	}
+
+	if opts.Truncate {
+		for _, t := range tables {
+			_, err := tx.Exec(ctx, "TRUNCATE "+include[t])
+			if err != nil {
+				return nil, fmt.Errorf("truncate %q: %w", t, err)
+			}
+		}
+	}
+
+	counts := make(map[string]int64)
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for {
+		var rec ExportRecord
+
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read record: %w", err)
+		}
+
+		ident, ok := include[rec.Table]
+		if !ok {
+			if slices.Contains(ElephantineTables, rec.Table) {
+				continue
+			}
+
+			return nil, fmt.Errorf("unknown table %q in export", rec.Table)
+		}
+
+		//nolint:gosec
+		tag, err := tx.Exec(ctx, fmt.Sprintf(
+			`INSERT INTO %[1]s
+			 SELECT * FROM json_populate_record(NULL::%[1]s, $1::json)
+			 ON CONFLICT DO NOTHING`, ident), string(rec.Row))
+		if err != nil {
+			return nil, fmt.Errorf("restore row in %q: %w", rec.Table, err)
+		}
+
+		counts[rec.Table] += tag.RowsAffected()
+	}
+
+	return counts, nil
+}
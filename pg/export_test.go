@@ -0,0 +1,15 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// AcquireWith makes a single attempt to acquire the job lock using the
+// provided database.
+func (jl *JobLock) AcquireWith(ctx context.Context, db postgres.DBTX) (bool, error) {
+	change, err := jl.acquire(ctx, postgres.New(db))
+
+	return change.Ok, err
+}
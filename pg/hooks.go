@@ -0,0 +1,141 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNoTxHooks is returned when registering a hook on a transaction that
+// wasn't started by WithTX or WithSerializableRetry.
+var ErrNoTxHooks = errors.New("the transaction doesn't support hooks")
+
+// TxWithHooks is a transaction that runs registered callbacks when it
+// completes. WithTX and WithSerializableRetry pass a TxWithHooks to the
+// transaction function, use BeforeCommit, AfterCommit, and OnRollback to
+// register the callbacks:
+//
+//	err := pg.WithTX(ctx, pool, func(tx pgx.Tx) error {
+//		// ...update the document...
+//
+//		return pg.AfterCommit(tx, func(ctx context.Context) {
+//			cache.Invalidate(docUUID)
+//		})
+//	})
+type TxWithHooks struct {
+	pgx.Tx
+
+	beforeCommit []func(ctx context.Context, tx pgx.Tx) error
+	afterCommit  []func(ctx context.Context)
+	onRollback   []func(ctx context.Context)
+}
+
+// NewTxWithHooks wraps a transaction so that hooks can be registered on it.
+// Use Commit on the returned TxWithHooks to run the hooks.
+func NewTxWithHooks(tx pgx.Tx) *TxWithHooks {
+	return &TxWithHooks{Tx: tx}
+}
+
+// BeforeCommit registers a function that runs in the transaction right before
+// it's committed. The transaction is rolled back if the function fails.
+func BeforeCommit(tx pgx.Tx, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	htx, ok := tx.(*TxWithHooks)
+	if !ok {
+		return ErrNoTxHooks
+	}
+
+	htx.beforeCommit = append(htx.beforeCommit, fn)
+
+	return nil
+}
+
+// AfterCommit registers a function that runs once the transaction has been
+// committed, f.ex. to invalidate caches or publish notifications. The
+// functions run in registration order.
+func AfterCommit(tx pgx.Tx, fn func(ctx context.Context)) error {
+	htx, ok := tx.(*TxWithHooks)
+	if !ok {
+		return ErrNoTxHooks
+	}
+
+	htx.afterCommit = append(htx.afterCommit, fn)
+
+	return nil
+}
+
+// OnRollback registers a function that runs if the transaction is rolled back,
+// or fails to commit.
+func OnRollback(tx pgx.Tx, fn func(ctx context.Context)) error {
+	htx, ok := tx.(*TxWithHooks)
+	if !ok {
+		return ErrNoTxHooks
+	}
+
+	htx.onRollback = append(htx.onRollback, fn)
+
+	return nil
+}
+
+// Commit runs the before commit hooks, commits the transaction, and runs the
+// after commit hooks.
+func (tx *TxWithHooks) Commit(ctx context.Context) error {
+	err := tx.runBeforeCommit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Tx.Commit(ctx)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	hooks := tx.afterCommit
+
+	tx.clearHooks()
+
+	for _, fn := range hooks {
+		fn(ctx)
+	}
+
+	return nil
+}
+
+// Rollback rolls back the transaction and runs the rollback hooks. Calling
+// rollback on a committed transaction is a no-op.
+func (tx *TxWithHooks) Rollback(ctx context.Context) error {
+	err := tx.Tx.Rollback(ctx)
+
+	hooks := tx.onRollback
+
+	tx.clearHooks()
+
+	for _, fn := range hooks {
+		fn(ctx)
+	}
+
+	return err //nolint:wrapcheck
+}
+
+// runBeforeCommit runs the before commit hooks, they are only run once.
+func (tx *TxWithHooks) runBeforeCommit(ctx context.Context) error {
+	hooks := tx.beforeCommit
+
+	tx.beforeCommit = nil
+
+	for i, fn := range hooks {
+		err := fn(ctx, tx.Tx)
+		if err != nil {
+			return fmt.Errorf("before commit hook %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (tx *TxWithHooks) clearHooks() {
+	tx.beforeCommit = nil
+	tx.afterCommit = nil
+	tx.onRollback = nil
+}
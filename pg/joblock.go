@@ -2,9 +2,11 @@ package pg
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -42,10 +44,26 @@ type JobLockOptions struct {
 	// operations. Must be shorter than the ping interval. Defaults to half
 	// the ping interval.
 	Timeout time.Duration
+	// Identity overrides the randomly generated holder identity, f.ex. with
+	// the name of the pod. The identity must be unique among the processes
+	// competing for the lock.
+	Identity string
+	// Metadata is stored alongside the lock while it's held, f.ex. the
+	// version and region of the holder.
+	Metadata map[string]string
+	// Metrics is used to instrument lock operations if set.
+	Metrics *JobLockMetrics
 }
 
+var _ elephantine.LeaderElector = &JobLock{}
+
 // JobLock helps separate processes coordinate who should be performing a
 // (background) task through postgres.
+//
+// The lock is stored in the job_lock table, see schema.sql. Tables that were
+// created before holder metadata was added need to be migrated with:
+//
+//	ALTER TABLE job_lock ADD COLUMN metadata jsonb;
 type JobLock struct {
 	logger        *slog.Logger
 	db            *pgxpool.Pool
@@ -54,15 +72,21 @@ type JobLock struct {
 	out           chan JobLockState
 	abort         chan struct{}
 	cleanedUp     chan struct{}
+	acquired      chan struct{}
+	lost          chan struct{}
 	name          string
 	identity      string
+	metadata      []byte
 	iteration     int64
 	pingInterval  time.Duration
 	staleAfter    time.Duration
 	checkInterval time.Duration
 	timeout       time.Duration
+	metrics       *JobLockMetrics
 
-	once sync.Once
+	once      sync.Once
+	startOnce sync.Once
+	stopOnce  sync.Once
 }
 
 // NewJobLock creates a new job lock.
@@ -98,14 +122,29 @@ func NewJobLock(
 			opts.Timeout, opts.PingInterval)
 	}
 
-	id := uuid.New()
+	identity := opts.Identity
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	if identity == "" {
+		id := uuid.New()
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+
+		identity = fmt.Sprintf("%s.%s", id, hostname)
 	}
 
-	identity := fmt.Sprintf("%s.%s", id, hostname)
+	var metadata []byte
+
+	if opts.Metadata != nil {
+		m, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+		}
+
+		metadata = m
+	}
 
 	logger = logger.With(
 		elephantine.LogKeyJobLock, name,
@@ -116,25 +155,32 @@ func NewJobLock(
 		db:            db,
 		name:          name,
 		identity:      identity,
+		metadata:      metadata,
 		pingInterval:  opts.PingInterval,
 		staleAfter:    opts.StaleAfter,
 		checkInterval: opts.CheckInterval,
 		timeout:       opts.Timeout,
+		metrics:       opts.Metrics,
 		out:           make(chan JobLockState, 1),
 		abort:         make(chan struct{}),
 		cleanedUp:     make(chan struct{}),
+		acquired:      make(chan struct{}),
+		lost:          make(chan struct{}),
 	}
 
 	return &jl, nil
 }
 
+// Identity returns the holder identity of the job lock.
 func (jl *JobLock) Identity() string {
 	return jl.identity
 }
 
 // Stop releases the job lock if held and stops all polling.
 func (jl *JobLock) Stop() {
-	close(jl.abort)
+	jl.stopOnce.Do(func() {
+		close(jl.abort)
+	})
 
 	select {
 	case <-jl.cleanedUp:
@@ -146,50 +192,68 @@ func (jl *JobLock) run() {
 	jl.once.Do(jl.loop)
 }
 
-// RunWithContext runs the provided function once the job lock has been
-// acquired. The context provided to the function will be cancelled if the job
-// lock is lost.
-func (jl *JobLock) RunWithContext(
-	ctx context.Context,
-	fn func(ctx context.Context) error,
-) error {
-	waitCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// start the lock loop and the dispatching of state changes.
+func (jl *JobLock) start() {
+	jl.startOnce.Do(func() {
+		go jl.run()
+		go jl.dispatch()
+	})
+}
 
-	acquiredLock := make(chan struct{})
+func (jl *JobLock) dispatch() {
+	defer close(jl.lost)
 
-	go func() {
-		go jl.run()
+	var held bool
 
-		defer jl.Stop()
-		defer cancel()
+	for state := range jl.out {
+		switch state {
+		case JobLockStateNone:
+		case JobLockStateLost, JobLockStateReleased:
+			return
+		case JobLockStateHeld:
+			if !held {
+				held = true
 
-		for {
-			select {
-			case <-jl.abort:
-				return
-			case state := <-jl.out:
-				switch state {
-				case JobLockStateNone:
-				case JobLockStateLost, JobLockStateReleased:
-					return
-				case JobLockStateHeld:
-					close(acquiredLock)
-				}
-			case <-waitCtx.Done():
-				return
+				close(jl.acquired)
 			}
 		}
-	}()
+	}
+}
+
+// Acquire implements elephantine.LeaderElector.
+func (jl *JobLock) Acquire(ctx context.Context) error {
+	jl.start()
 
 	select {
-	case <-acquiredLock:
-		return fn(waitCtx)
-	case <-waitCtx.Done():
+	case <-jl.acquired:
 		return nil
+	case <-jl.lost:
+		return elephantine.ErrLeadershipLost
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck
 	}
 }
 
+// Watch implements elephantine.LeaderElector.
+func (jl *JobLock) Watch() <-chan struct{} {
+	return jl.lost
+}
+
+// Yield implements elephantine.LeaderElector.
+func (jl *JobLock) Yield() {
+	jl.Stop()
+}
+
+// RunWithContext runs the provided function once the job lock has been
+// acquired. The context provided to the function will be cancelled if the job
+// lock is lost.
+func (jl *JobLock) RunWithContext(
+	ctx context.Context,
+	fn func(ctx context.Context) error,
+) error {
+	return elephantine.RunWithLeadership(ctx, jl, fn)
+}
+
 func (jl *JobLock) loop() {
 	var nextState JobLockState
 
@@ -261,40 +325,50 @@ type acquireChange struct {
 }
 
 func (jl *JobLock) attemptAcquire() acquireChange {
-	ctx, cancel := context.WithTimeout(context.Background(), jl.timeout)
-	defer cancel()
+	start := time.Now()
+
+	change, err := jl.tryAcquire()
+
+	jl.metrics.observe(jl.name, "acquire", start, err != nil)
 
-	tx, err := jl.db.Begin(ctx)
 	if err != nil {
-		jl.logger.Error("failed to begin transaction",
+		jl.logger.Error("failed to acquire job lock",
 			elephantine.LogKeyError, err.Error())
 
 		return acquireChange{}
 	}
 
+	return change
+}
+
+func (jl *JobLock) tryAcquire() (acquireChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jl.timeout)
+	defer cancel()
+
+	tx, err := jl.db.Begin(ctx)
+	if err != nil {
+		return acquireChange{}, fmt.Errorf(
+			"failed to begin transaction: %w", err)
+	}
+
 	defer SafeRollback(ctx, jl.logger, tx, "acquire")
 
 	change, err := jl.acquire(ctx, postgres.New(tx))
 	if err != nil {
-		jl.logger.Error("failed to acquire job lock",
-			elephantine.LogKeyError, err.Error())
-
-		return acquireChange{}
+		return acquireChange{}, err
 	}
 
 	if !change.Ok {
-		return acquireChange{}
+		return acquireChange{}, nil
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		jl.logger.Error("failed to commit transaction",
-			elephantine.LogKeyError, err.Error())
-
-		return acquireChange{}
+		return acquireChange{}, fmt.Errorf(
+			"failed to commit transaction: %w", err)
 	}
 
-	return change
+	return change, nil
 }
 
 func (jl *JobLock) acquire(ctx context.Context, q *postgres.Queries) (acquireChange, error) {
@@ -314,8 +388,9 @@ func (jl *JobLock) acquire(ctx context.Context, q *postgres.Queries) (acquireCha
 	}
 
 	iteration, err := q.InsertJobLock(ctx, postgres.InsertJobLockParams{
-		Name:   jl.name,
-		Holder: jl.identity,
+		Name:     jl.name,
+		Holder:   jl.identity,
+		Metadata: jl.metadata,
 	})
 	if IsConstraintError(err, "job_lock_pkey") {
 		return acquireChange{}, nil
@@ -335,12 +410,18 @@ func (jl *JobLock) steal(
 ) (acquireChange, error) {
 	jl.logger.Debug("attempt to steal job lock")
 
+	start := time.Now()
+
 	affected, err := q.StealJobLock(ctx, postgres.StealJobLockParams{
 		Name:           jl.name,
 		NewHolder:      jl.identity,
+		Metadata:       jl.metadata,
 		PreviousHolder: state.Holder,
 		Iteration:      state.Iteration,
 	})
+
+	jl.metrics.observe(jl.name, "steal", start, err != nil || affected == 0)
+
 	if err != nil {
 		return acquireChange{}, fmt.Errorf("failed to steal job lock: %w", err)
 	}
@@ -368,12 +449,16 @@ func (jl *JobLock) release() {
 	ctx, cancel := context.WithTimeout(context.Background(), jl.timeout)
 	defer cancel()
 
+	start := time.Now()
+
 	updated, err := postgres.New(jl.db).ReleaseJobLock(ctx,
 		postgres.ReleaseJobLockParams{
 			Name:   jl.name,
 			Holder: jl.identity,
 		})
 
+	jl.metrics.observe(jl.name, "release", start, err != nil || updated == 0)
+
 	switch {
 	case err != nil:
 		jl.logger.Error("failed to release job lock",
@@ -392,6 +477,8 @@ func (jl *JobLock) ping() JobLockState {
 	ctx, cancel := context.WithTimeout(context.Background(), jl.timeout)
 	defer cancel()
 
+	start := time.Now()
+
 	updated, err := postgres.New(jl.db).PingJobLock(ctx,
 		postgres.PingJobLockParams{
 			Name:      jl.name,
@@ -399,6 +486,8 @@ func (jl *JobLock) ping() JobLockState {
 			Iteration: jl.iteration,
 		})
 
+	jl.metrics.observe(jl.name, "ping", start, err != nil || updated == 0)
+
 	switch {
 	case err != nil:
 		jl.logger.Error("failed to ping job lock",
@@ -421,3 +510,65 @@ func (jl *JobLock) ping() JobLockState {
 
 	return JobLockStateHeld
 }
+
+// JobLockInfo describes a job lock and its current holder.
+type JobLockInfo struct {
+	Name      string            `json:"name"`
+	Holder    string            `json:"holder"`
+	Touched   time.Time         `json:"touched"`
+	Iteration int64             `json:"iteration"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// ListJobLocks returns information about all currently held job locks.
+func ListJobLocks(ctx context.Context, db postgres.DBTX) ([]JobLockInfo, error) {
+	rows, err := postgres.New(db).ListJobLocks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list job locks: %w", err)
+	}
+
+	locks := make([]JobLockInfo, len(rows))
+
+	for i, row := range rows {
+		locks[i] = JobLockInfo{
+			Name:      row.Name,
+			Holder:    row.Holder,
+			Touched:   row.Touched.Time,
+			Iteration: row.Iteration,
+		}
+
+		if len(row.Metadata) == 0 {
+			continue
+		}
+
+		err := json.Unmarshal(row.Metadata, &locks[i].Metadata)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"invalid metadata for job lock %q: %w", row.Name, err)
+		}
+	}
+
+	return locks, nil
+}
+
+// JobLocksHandler returns a HTTP handler that responds with a JSON list of the
+// currently held job locks. Suitable for registration on an internal server,
+// f.ex. the health server.
+func JobLocksHandler(db postgres.DBTX) http.Handler {
+	return elephantine.HTTPErrorHandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) error {
+		locks, err := ListJobLocks(r.Context(), db)
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(locks) //nolint:wrapcheck
+	})
+}
@@ -0,0 +1,292 @@
+package pg_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/ttab/elephantine/pg"
+	"github.com/ttab/elephantine/test"
+)
+
+type dbCall struct {
+	Query string
+	Args  []any
+}
+
+// fakeDB is a postgres.DBTX that records all calls and answers the job lock
+// queries from canned rows.
+type fakeDB struct {
+	calls []dbCall
+	// held is the row returned by GetJobLock, the lock isn't held if nil.
+	held []any
+	// locks are the rows returned by ListJobLocks.
+	locks [][]any
+}
+
+func (db *fakeDB) record(query string, args []any) string {
+	db.calls = append(db.calls, dbCall{Query: query, Args: args})
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(query, "-- name: "), " ")
+
+	return name
+}
+
+func (db *fakeDB) Exec(
+	_ context.Context, query string, args ...any,
+) (pgconn.CommandTag, error) {
+	switch db.record(query, args) {
+	case "StealJobLock":
+		return pgconn.NewCommandTag("UPDATE 1"), nil
+	default:
+		return pgconn.CommandTag{}, nil
+	}
+}
+
+func (db *fakeDB) Query(
+	_ context.Context, query string, args ...any,
+) (pgx.Rows, error) {
+	db.record(query, args)
+
+	return &fakeRows{rows: db.locks, idx: -1}, nil
+}
+
+func (db *fakeDB) QueryRow(
+	_ context.Context, query string, args ...any,
+) pgx.Row {
+	switch db.record(query, args) {
+	case "GetJobLock":
+		if db.held == nil {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+
+		return fakeRow{values: db.held}
+	case "InsertJobLock":
+		return fakeRow{values: []any{int64(1)}}
+	default:
+		return fakeRow{err: pgx.ErrNoRows}
+	}
+}
+
+// lastCall returns the last call that was made with the named query.
+func (db *fakeDB) lastCall(t *testing.T, name string) dbCall {
+	t.Helper()
+
+	for i := len(db.calls) - 1; i >= 0; i-- {
+		if strings.HasPrefix(db.calls[i].Query, "-- name: "+name+" ") {
+			return db.calls[i]
+		}
+	}
+
+	t.Fatalf("no %s query was made", name)
+
+	return dbCall{}
+}
+
+type fakeRow struct {
+	values []any
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return scanValues(r.values, dest)
+}
+
+type fakeRows struct {
+	rows [][]any
+	idx  int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+func (r *fakeRows) Next() bool {
+	r.idx++
+
+	return r.idx < len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return scanValues(r.rows[r.idx], dest)
+}
+
+func (r *fakeRows) Values() ([]any, error) {
+	return r.rows[r.idx], nil
+}
+
+func scanValues(values []any, dest []any) error {
+	for i, v := range values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+
+	return nil
+}
+
+func newTestJobLock(t *testing.T, opts pg.JobLockOptions) *pg.JobLock {
+	t.Helper()
+
+	jl, err := pg.NewJobLock(nil,
+		slog.New(test.NewLogHandler(t, slog.LevelInfo)), "indexer", opts)
+	test.Must(t, err, "create job lock")
+
+	return jl
+}
+
+func TestJobLockIdentity(t *testing.T) {
+	jl := newTestJobLock(t, pg.JobLockOptions{
+		Identity: "indexer-7d9f-x2k",
+	})
+
+	test.Equal(t, "indexer-7d9f-x2k", jl.Identity(), "use the identity override")
+
+	generated := newTestJobLock(t, pg.JobLockOptions{})
+	other := newTestJobLock(t, pg.JobLockOptions{})
+
+	test.Equal(t, true, generated.Identity() != "",
+		"generate an identity by default")
+	test.Equal(t, true, generated.Identity() != other.Identity(),
+		"generate unique identities")
+}
+
+func TestJobLockMetadata(t *testing.T) {
+	ctx := test.Context(t)
+
+	jl := newTestJobLock(t, pg.JobLockOptions{
+		Identity: "indexer-1",
+		Metadata: map[string]string{"version": "v1.2.0"},
+	})
+
+	wantMetadata := `{"version":"v1.2.0"}`
+
+	var db fakeDB
+
+	ok, err := jl.AcquireWith(ctx, &db)
+	test.Must(t, err, "acquire free lock")
+	test.Equal(t, true, ok, "acquire the free lock")
+
+	insert := db.lastCall(t, "InsertJobLock")
+
+	test.EqualDiff(t, []any{"indexer", "indexer-1", []byte(wantMetadata)},
+		insert.Args, "insert the lock with metadata")
+
+	stale := newTestJobLock(t, pg.JobLockOptions{
+		Identity: "indexer-2",
+		Metadata: map[string]string{"version": "v1.2.0"},
+	})
+
+	db = fakeDB{
+		held: []any{
+			"indexer-1",
+			pgtype.Timestamptz{
+				Time:  time.Now().Add(-time.Hour),
+				Valid: true,
+			},
+			int64(12),
+		},
+	}
+
+	ok, err = stale.AcquireWith(ctx, &db)
+	test.Must(t, err, "steal stale lock")
+	test.Equal(t, true, ok, "steal the stale lock")
+
+	steal := db.lastCall(t, "StealJobLock")
+
+	test.EqualDiff(t, []any{
+		"indexer-2", []byte(wantMetadata),
+		"indexer", "indexer-1", int64(12),
+	}, steal.Args, "steal the lock with metadata")
+
+	plain := newTestJobLock(t, pg.JobLockOptions{Identity: "indexer-3"})
+
+	db = fakeDB{}
+
+	_, err = plain.AcquireWith(ctx, &db)
+	test.Must(t, err, "acquire without metadata")
+
+	insert = db.lastCall(t, "InsertJobLock")
+
+	test.Equal(t, true, insert.Args[2].([]byte) == nil,
+		"store NULL metadata when none is set")
+}
+
+func TestListJobLocks(t *testing.T) {
+	touched := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	db := fakeDB{
+		locks: [][]any{
+			{
+				"indexer", "indexer-1",
+				pgtype.Timestamptz{Time: touched, Valid: true},
+				int64(4), []byte(`{"version":"v1.2.0"}`),
+			},
+			{
+				"archiver", "archiver-1",
+				pgtype.Timestamptz{Time: touched, Valid: true},
+				int64(1), []byte(nil),
+			},
+		},
+	}
+
+	want := []pg.JobLockInfo{
+		{
+			Name:      "indexer",
+			Holder:    "indexer-1",
+			Touched:   touched,
+			Iteration: 4,
+			Metadata:  map[string]string{"version": "v1.2.0"},
+		},
+		{
+			Name:      "archiver",
+			Holder:    "archiver-1",
+			Touched:   touched,
+			Iteration: 1,
+		},
+	}
+
+	locks, err := pg.ListJobLocks(test.Context(t), &db)
+	test.Must(t, err, "list job locks")
+
+	test.EqualDiff(t, want, locks, "list the job locks")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/job-locks", nil)
+
+	pg.JobLocksHandler(&db).ServeHTTP(rec, req)
+
+	test.Equal(t, http.StatusOK, rec.Code, "respond with 200")
+	test.Equal(t, "application/json", rec.Header().Get("Content-Type"),
+		"respond with JSON")
+
+	var got []pg.JobLockInfo
+
+	err = json.Unmarshal(rec.Body.Bytes(), &got)
+	test.Must(t, err, "decode response")
+
+	test.EqualDiff(t, want, got, "serve the job locks")
+
+	db.locks = [][]any{{
+		"broken", "broken-1",
+		pgtype.Timestamptz{Time: touched, Valid: true},
+		int64(1), []byte(`["not", "a", "map"]`),
+	}}
+
+	_, err = pg.ListJobLocks(test.Context(t), &db)
+	test.MustNot(t, err, "fail on invalid metadata")
+}
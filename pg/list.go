@@ -0,0 +1,132 @@
+package pg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ttab/elephantine"
+)
+
+// ListQuery is the filtering, sorting and paging parts of a list query built
+// from elephantine.ListParams.
+type ListQuery struct {
+	// Where is a condition that can be combined with the rest of the
+	// query using AND. It's "TRUE" if there are no filters.
+	Where string
+	// OrderBy is the list of sort expressions, without the ORDER BY
+	// keyword. Empty if no order was given.
+	OrderBy string
+	// Limit is the page size.
+	Limit int
+	// Args are the query arguments, starting with the arguments that were
+	// passed to BuildListQuery.
+	Args []any
+}
+
+// BuildListQuery builds the parts of a list query. The columns map the
+// field names used in the list parameters to SQL column expressions, and must
+// never contain user input. Filter values are passed as query arguments,
+// numbered after the arguments that the query already uses.
+//
+// The page token is not applied, as the cursor depends on the sort order of
+// the query. Decode it with elephantine.DecodePageToken and add the keyset
+// condition to the query, passing its arguments after Args.
+//
+// Example:
+//
+//	lq, err := pg.BuildListQuery(params, map[string]string{
+//		"created": "d.created",
+//		"status":  "d.status",
+//	}, unit)
+//
+//	sql := `SELECT d.uuid FROM document AS d
+//	WHERE d.unit = $1 AND ` + lq.Where
+//
+//	if lq.OrderBy != "" {
+//		sql += " ORDER BY " + lq.OrderBy
+//	}
+//
+//	sql += " LIMIT " + strconv.Itoa(lq.Limit)
+//
+//	rows, err := pool.Query(ctx, sql, lq.Args...)
+func BuildListQuery(
+	params elephantine.ListParams, columns map[string]string, args ...any,
+) (ListQuery, error) {
+	q := ListQuery{
+		Limit: params.PageSize,
+		Args:  args,
+	}
+
+	conditions := make([]string, 0, len(params.Filters))
+
+	for _, f := range params.Filters {
+		col, ok := columns[f.Field]
+		if !ok {
+			return ListQuery{}, fmt.Errorf(
+				"no column for filter field %q", f.Field)
+		}
+
+		value := any(f.Value)
+
+		var op string
+
+		switch f.Op {
+		case elephantine.FilterEq:
+			op = "="
+		case elephantine.FilterNe:
+			op = "<>"
+		case elephantine.FilterLt:
+			op = "<"
+		case elephantine.FilterLte:
+			op = "<="
+		case elephantine.FilterGt:
+			op = ">"
+		case elephantine.FilterGte:
+			op = ">="
+		case elephantine.FilterPrefix:
+			op = "LIKE"
+			value = escapeLike(f.Value) + "%"
+		default:
+			return ListQuery{}, fmt.Errorf(
+				"unknown filter operator %q", f.Op)
+		}
+
+		q.Args = append(q.Args, value)
+
+		conditions = append(conditions,
+			col+" "+op+" $"+strconv.Itoa(len(q.Args)))
+	}
+
+	q.Where = "TRUE"
+
+	if len(conditions) > 0 {
+		q.Where = strings.Join(conditions, " AND ")
+	}
+
+	order := make([]string, 0, len(params.OrderBy))
+
+	for _, sf := range params.OrderBy {
+		col, ok := columns[sf.Field]
+		if !ok {
+			return ListQuery{}, fmt.Errorf(
+				"no column for sort field %q", sf.Field)
+		}
+
+		if sf.Descending {
+			col += " DESC"
+		}
+
+		order = append(order, col)
+	}
+
+	q.OrderBy = strings.Join(order, ", ")
+
+	return q, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,155 @@
+package pg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Settings for the native histograms. Classic buckets are exposed as well, for
+// scrapers that don't support native histograms.
+const (
+	nativeHistogramBucketFactor = 1.1
+	nativeHistogramMaxBuckets   = 100
+	nativeHistogramResetAfter   = time.Hour
+)
+
+func operationHistogram(name string, help string, labels ...string) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                            name,
+		Help:                            help,
+		Buckets:                         []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		NativeHistogramBucketFactor:     nativeHistogramBucketFactor,
+		NativeHistogramMaxBucketNumber:  nativeHistogramMaxBuckets,
+		NativeHistogramMinResetDuration: nativeHistogramResetAfter,
+	}, labels)
+}
+
+func registerCollectors(
+	registerer prometheus.Registerer, collectors ...prometheus.Collector,
+) error {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return nil
+}
+
+// JobLockMetrics are metrics for job lock operations.
+type JobLockMetrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewJobLockMetrics registers job lock metrics with the provided registerer.
+func NewJobLockMetrics(registerer prometheus.Registerer) (*JobLockMetrics, error) {
+	m := JobLockMetrics{
+		duration: operationHistogram(
+			"job_lock_operation_duration_seconds",
+			"Duration of job lock acquire, ping, steal, and release operations.",
+			"lock", "operation"),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "job_lock_operation_errors_total",
+			Help: "Number of failed job lock operations.",
+		}, []string{"lock", "operation"}),
+	}
+
+	err := registerCollectors(registerer, m.duration, m.errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// observe records the duration and outcome of an operation. Safe to call on a
+// nil JobLockMetrics.
+func (m *JobLockMetrics) observe(
+	lock string, operation string, start time.Time, failed bool,
+) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(lock, operation).
+		Observe(time.Since(start).Seconds())
+
+	if failed {
+		m.errors.WithLabelValues(lock, operation).Inc()
+	}
+}
+
+// PubSubMetrics are metrics for notification publishing and dispatch.
+type PubSubMetrics struct {
+	publishDuration  *prometheus.HistogramVec
+	publishErrors    *prometheus.CounterVec
+	dispatchDuration *prometheus.HistogramVec
+	dispatchErrors   *prometheus.CounterVec
+}
+
+// NewPubSubMetrics registers pubsub metrics with the provided registerer.
+func NewPubSubMetrics(registerer prometheus.Registerer) (*PubSubMetrics, error) {
+	m := PubSubMetrics{
+		publishDuration: operationHistogram(
+			"pubsub_publish_duration_seconds",
+			"Duration of notification publishing.",
+			"channel"),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pubsub_publish_errors_total",
+			Help: "Number of notifications that failed to publish.",
+		}, []string{"channel"}),
+		dispatchDuration: operationHistogram(
+			"pubsub_dispatch_duration_seconds",
+			"Duration of notification dispatch to subscriptions.",
+			"channel"),
+		dispatchErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pubsub_dispatch_errors_total",
+			Help: "Number of notifications that subscriptions failed to handle.",
+		}, []string{"channel"}),
+	}
+
+	err := registerCollectors(registerer,
+		m.publishDuration, m.publishErrors,
+		m.dispatchDuration, m.dispatchErrors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func (m *PubSubMetrics) published(channel string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.publishDuration.WithLabelValues(channel).
+		Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.publishErrors.WithLabelValues(channel).Inc()
+	}
+}
+
+func (m *PubSubMetrics) dispatched(channel string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.dispatchDuration.WithLabelValues(channel).
+		Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.dispatchErrors.WithLabelValues(channel).Inc()
+	}
+}
@@ -246,15 +246,30 @@ type TransactionBeginner interface {
 
 // WithTX starts a transaction and calls the given function with it. If the
 // function returns an error or panics the transaction will be rolled back.
+//
+// The transaction is a TxWithHooks, so BeforeCommit, AfterCommit, and
+// OnRollback can be used to register side effects that depend on the outcome.
+//
+// No transaction will be started if elephantine.CheckpointErr(ctx) returns an
+// error. Use a context from GracefulShutdown.WithShutdown and call
+// elephantine.CheckpointErr(ctx) at safe points in the function to abort work
+// when the application is asked to stop.
 func WithTX(
 	ctx context.Context, pool TransactionBeginner,
 	fn func(tx pgx.Tx) error,
 ) (outErr error) {
-	tx, err := pool.Begin(ctx)
+	err := elephantine.CheckpointErr(ctx)
+	if err != nil {
+		return fmt.Errorf("refusing to begin transaction: %w", err)
+	}
+
+	btx, err := pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
+	tx := NewTxWithHooks(btx)
+
 	// We defer a rollback, rollback after commit won't be treated as an
 	// error.
 	defer Rollback(tx, &outErr)
@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolMode describes how connections reach the database.
+type PoolMode string
+
+const (
+	// PoolModeSession is used for direct connections, or poolers that
+	// assign a server connection for the lifetime of the client
+	// connection.
+	PoolModeSession PoolMode = "session"
+	// PoolModeTransaction is used for poolers like pgbouncer in
+	// transaction pooling mode, where consecutive transactions can be
+	// executed on different server connections.
+	PoolModeTransaction PoolMode = "transaction"
+)
+
+// ParsePoolMode parses a pool mode, an empty string is treated as
+// PoolModeSession.
+func ParsePoolMode(s string) (PoolMode, error) {
+	switch PoolMode(s) {
+	case "", PoolModeSession:
+		return PoolModeSession, nil
+	case PoolModeTransaction:
+		return PoolModeTransaction, nil
+	default:
+		return "", fmt.Errorf("unknown pool mode %q", s)
+	}
+}
+
+// PoolConstraints describes the features that can be used with pool
+// connections.
+type PoolConstraints struct {
+	// PreparedStatements is true if named prepared statements can be
+	// cached on the connections.
+	PreparedStatements bool
+	// Listen is true if LISTEN can be used on pool connections. Use
+	// SubscribeOptions.ListenConnString to listen through a direct
+	// connection otherwise.
+	Listen bool
+	// SessionLocks is true if session level advisory locks can be used,
+	// which is required by AdvisoryLock. Use JobLock instead otherwise.
+	SessionLocks bool
+}
+
+// Constraints returns the constraints of the pool mode.
+func (m PoolMode) Constraints() PoolConstraints {
+	if m == PoolModeTransaction {
+		return PoolConstraints{}
+	}
+
+	return PoolConstraints{
+		PreparedStatements: true,
+		Listen:             true,
+		SessionLocks:       true,
+	}
+}
+
+// ConfigurePool adjusts the pool configuration for the pool mode. In
+// transaction mode queries are executed without named prepared statements,
+// and the statement and description caches are disabled.
+func ConfigurePool(config *pgxpool.Config, mode PoolMode) {
+	if mode.Constraints().PreparedStatements {
+		return
+	}
+
+	config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeExec
+	config.ConnConfig.StatementCacheCapacity = 0
+	config.ConnConfig.DescriptionCacheCapacity = 0
+}
+
+// NewPool creates a connection pool that is configured for the pool mode.
+func NewPool(
+	ctx context.Context, connString string, mode PoolMode,
+) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("parse connection string: %w", err)
+	}
+
+	ConfigurePool(config, mode)
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("create connection pool: %w", err)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,267 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// Pools is a registry of named connection pools, for services that use more
+// than one database, f.ex. "main", "analytics", and "archive".
+type Pools struct {
+	m     sync.RWMutex
+	pools map[string]*pgxpool.Pool
+}
+
+// NewPools creates an empty pool registry.
+func NewPools() *Pools {
+	return &Pools{
+		pools: make(map[string]*pgxpool.Pool),
+	}
+}
+
+// Open creates a connection pool for the pool mode, see NewPool, and adds it to
+// the registry under the given name.
+func (p *Pools) Open(
+	ctx context.Context, name string, connString string, mode PoolMode,
+) error {
+	pool, err := NewPool(ctx, connString, mode)
+	if err != nil {
+		return fmt.Errorf("open %q pool: %w", name, err)
+	}
+
+	err = p.Add(name, pool)
+	if err != nil {
+		pool.Close()
+
+		return err
+	}
+
+	return nil
+}
+
+// Add adds an existing connection pool to the registry. The pool will be
+// closed by Close.
+func (p *Pools) Add(name string, pool *pgxpool.Pool) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, exists := p.pools[name]; exists {
+		return fmt.Errorf("a pool named %q has already been added", name)
+	}
+
+	p.pools[name] = pool
+
+	return nil
+}
+
+// Get returns the named pool.
+func (p *Pools) Get(name string) (*pgxpool.Pool, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	pool, ok := p.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown pool %q", name)
+	}
+
+	return pool, nil
+}
+
+// Names returns the sorted names of the registered pools.
+func (p *Pools) Names() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	names := mapKeys(p.pools)
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Queries returns a query set that runs against the named pool.
+func (p *Pools) Queries(name string) (*postgres.Queries, error) {
+	pool, err := p.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return postgres.New(pool), nil
+}
+
+// WithTX runs the function in a transaction in the named pool, see WithTX.
+func (p *Pools) WithTX(
+	ctx context.Context, name string, fn func(tx pgx.Tx) error,
+) error {
+	pool, err := p.Get(name)
+	if err != nil {
+		return err
+	}
+
+	return WithTX(ctx, pool, fn)
+}
+
+// NewJobLock creates a job lock that is held in the named pool, see
+// NewJobLock.
+func (p *Pools) NewJobLock(
+	pool string, logger *slog.Logger, name string, opts JobLockOptions,
+) (*JobLock, error) {
+	db, err := p.Get(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJobLock(db, logger, name, opts)
+}
+
+// ReadyFunc returns a ready function that pings the named pool.
+func (p *Pools) ReadyFunc(name string) elephantine.ReadyFunc {
+	return func(ctx context.Context) error {
+		pool, err := p.Get(name)
+		if err != nil {
+			return err
+		}
+
+		err = pool.Ping(ctx)
+		if err != nil {
+			return fmt.Errorf("ping %q pool: %w", name, err)
+		}
+
+		return nil
+	}
+}
+
+// AddToHealthServer adds a ready function and a snapshot source for each of
+// the registered pools, named "postgres-{name}".
+func (p *Pools) AddToHealthServer(server *elephantine.HealthServer) {
+	for _, name := range p.Names() {
+		pool, err := p.Get(name)
+		if err != nil {
+			continue
+		}
+
+		server.AddReadyFunction("postgres-"+name, p.ReadyFunc(name))
+		server.AddSnapshotSource("postgres-"+name, PoolSnapshotSource(pool))
+	}
+}
+
+// Close closes all the registered pools.
+func (p *Pools) Close() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for _, pool := range p.pools {
+		pool.Close()
+	}
+}
+
+// PingAll pings all the registered pools and returns the joined errors.
+func (p *Pools) PingAll(ctx context.Context) error {
+	var errs []error
+
+	for _, name := range p.Names() {
+		errs = append(errs, p.ReadyFunc(name)(ctx))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PoolMetrics exposes the connection statistics of the registered pools,
+// labelled by pool name.
+type PoolMetrics struct {
+	pools *Pools
+
+	totalConns    *prometheus.Desc
+	idleConns     *prometheus.Desc
+	acquiredConns *prometheus.Desc
+	maxConns      *prometheus.Desc
+	acquireCount  *prometheus.Desc
+	emptyAcquires *prometheus.Desc
+	acquireTime   *prometheus.Desc
+}
+
+// NewPoolMetrics registers pool metrics with the provided registerer. Pools
+// that are added to the registry later are included as well.
+func NewPoolMetrics(
+	registerer prometheus.Registerer, pools *Pools,
+) (*PoolMetrics, error) {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"pool"}, nil)
+	}
+
+	m := PoolMetrics{
+		pools: pools,
+		totalConns: desc("pg_pool_total_conns",
+			"Number of connections in the pool."),
+		idleConns: desc("pg_pool_idle_conns",
+			"Number of idle connections in the pool."),
+		acquiredConns: desc("pg_pool_acquired_conns",
+			"Number of connections that currently are acquired."),
+		maxConns: desc("pg_pool_max_conns",
+			"Maximum size of the pool."),
+		acquireCount: desc("pg_pool_acquires_total",
+			"Number of successful connection acquires."),
+		emptyAcquires: desc("pg_pool_empty_acquires_total",
+			"Number of acquires that had to wait for a connection."),
+		acquireTime: desc("pg_pool_acquire_duration_seconds_total",
+			"Total time spent waiting for connections."),
+	}
+
+	err := registerCollectors(registerer, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Describe implements prometheus.Collector.
+func (m *PoolMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.totalConns
+	ch <- m.idleConns
+	ch <- m.acquiredConns
+	ch <- m.maxConns
+	ch <- m.acquireCount
+	ch <- m.emptyAcquires
+	ch <- m.acquireTime
+}
+
+// Collect implements prometheus.Collector.
+func (m *PoolMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range m.pools.Names() {
+		pool, err := m.pools.Get(name)
+		if err != nil {
+			continue
+		}
+
+		stat := pool.Stat()
+
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(
+				desc, prometheus.GaugeValue, v, name)
+		}
+
+		counter := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(
+				desc, prometheus.CounterValue, v, name)
+		}
+
+		gauge(m.totalConns, float64(stat.TotalConns()))
+		gauge(m.idleConns, float64(stat.IdleConns()))
+		gauge(m.acquiredConns, float64(stat.AcquiredConns()))
+		gauge(m.maxConns, float64(stat.MaxConns()))
+		counter(m.acquireCount, float64(stat.AcquireCount()))
+		counter(m.emptyAcquires, float64(stat.EmptyAcquireCount()))
+		counter(m.acquireTime, stat.AcquireDuration().Seconds())
+	}
+}
@@ -0,0 +1,94 @@
+package pg_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine/pg"
+	"github.com/ttab/elephantine/test"
+)
+
+// newLazyPool creates a pool that never connects, as pools only open
+// connections when they are acquired.
+func newLazyPool(t *testing.T, maxConns string) *pgxpool.Pool {
+	t.Helper()
+
+	pool, err := pgxpool.New(context.Background(),
+		"postgres://user@127.0.0.1:1/db?pool_max_conns="+maxConns)
+	test.Must(t, err, "create pool")
+
+	return pool
+}
+
+func TestPools(t *testing.T) {
+	pools := pg.NewPools()
+
+	t.Cleanup(pools.Close)
+
+	main := newLazyPool(t, "4")
+
+	err := pools.Add("main", main)
+	test.Must(t, err, "add pool")
+
+	err = pools.Add("archive", newLazyPool(t, "2"))
+	test.Must(t, err, "add second pool")
+
+	duplicate := newLazyPool(t, "1")
+
+	t.Cleanup(duplicate.Close)
+
+	err = pools.Add("main", duplicate)
+	test.MustNot(t, err, "add a pool with a name that's already used")
+
+	got, err := pools.Get("main")
+	test.Must(t, err, "get pool")
+
+	if got != main {
+		t.Fatal("expected the first pool added as main to be kept")
+	}
+
+	_, err = pools.Get("analytics")
+	test.MustNot(t, err, "get unknown pool")
+
+	_, err = pools.Queries("analytics")
+	test.MustNot(t, err, "get queries for unknown pool")
+
+	test.EqualDiff(t, []string{"archive", "main"}, pools.Names(),
+		"list the pool names in order")
+}
+
+func TestPoolMetrics(t *testing.T) {
+	pools := pg.NewPools()
+
+	t.Cleanup(pools.Close)
+
+	reg := prometheus.NewRegistry()
+
+	_, err := pg.NewPoolMetrics(reg, pools)
+	test.Must(t, err, "create pool metrics")
+
+	err = pools.Add("main", newLazyPool(t, "4"))
+	test.Must(t, err, "add pool")
+
+	err = pools.Add("archive", newLazyPool(t, "2"))
+	test.Must(t, err, "add second pool")
+
+	expected := `
+# HELP pg_pool_max_conns Maximum size of the pool.
+# TYPE pg_pool_max_conns gauge
+pg_pool_max_conns{pool="archive"} 2
+pg_pool_max_conns{pool="main"} 4
+# HELP pg_pool_total_conns Number of connections in the pool.
+# TYPE pg_pool_total_conns gauge
+pg_pool_total_conns{pool="archive"} 0
+pg_pool_total_conns{pool="main"} 0
+`
+
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"pg_pool_max_conns", "pg_pool_total_conns")
+	test.Must(t, err, "label metrics by pool name, including pools added later")
+}
@@ -8,9 +8,37 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type DedupKey struct {
+	Consumer string
+	Key      string
+	Seen     pgtype.Timestamptz
+	Expires  pgtype.Timestamptz
+}
+
 type JobLock struct {
 	Name      string
 	Holder    string
 	Touched   pgtype.Timestamptz
 	Iteration int64
+	Metadata  []byte
+}
+
+type RequestLimit struct {
+	Key         string
+	WindowStart pgtype.Timestamptz
+	Count       int64
+	Expires     pgtype.Timestamptz
+}
+
+type TokenCache struct {
+	Key     string
+	Token   []byte
+	Expires pgtype.Timestamptz
+}
+
+type TokenRevocation struct {
+	Kind    string
+	Value   string
+	Revoked pgtype.Timestamptz
+	Expires pgtype.Timestamptz
 }
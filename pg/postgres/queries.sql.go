@@ -20,6 +20,102 @@ func (q *Queries) AcquireTXLock(ctx context.Context, id int64) error {
 	return err
 }
 
+const advisoryUnlock = `-- name: AdvisoryUnlock :one
+SELECT pg_advisory_unlock($1::bigint)::bool
+`
+
+func (q *Queries) AdvisoryUnlock(ctx context.Context, id int64) (bool, error) {
+	row := q.db.QueryRow(ctx, advisoryUnlock, id)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const deleteExpiredDedupKeys = `-- name: DeleteExpiredDedupKeys :execrows
+DELETE FROM dedup_key
+WHERE consumer = $1
+      AND expires <= now()
+`
+
+func (q *Queries) DeleteExpiredDedupKeys(ctx context.Context, consumer string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredDedupKeys, consumer)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredRequestLimits = `-- name: DeleteExpiredRequestLimits :execrows
+DELETE FROM request_limit
+WHERE expires <= now()
+`
+
+func (q *Queries) DeleteExpiredRequestLimits(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredRequestLimits)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredTokenRevocations = `-- name: DeleteExpiredTokenRevocations :execrows
+DELETE FROM token_revocation
+WHERE expires <= now()
+`
+
+func (q *Queries) DeleteExpiredTokenRevocations(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredTokenRevocations)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getActiveTokenRevocations = `-- name: GetActiveTokenRevocations :many
+SELECT kind, value, revoked, expires
+FROM token_revocation
+WHERE expires > now()
+`
+
+func (q *Queries) GetActiveTokenRevocations(ctx context.Context) ([]TokenRevocation, error) {
+	rows, err := q.db.Query(ctx, getActiveTokenRevocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TokenRevocation
+	for rows.Next() {
+		var i TokenRevocation
+		if err := rows.Scan(
+			&i.Kind,
+			&i.Value,
+			&i.Revoked,
+			&i.Expires,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCachedToken = `-- name: GetCachedToken :one
+SELECT token
+FROM token_cache
+WHERE key = $1
+      AND expires > now()
+`
+
+func (q *Queries) GetCachedToken(ctx context.Context, key string) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getCachedToken, key)
+	var token []byte
+	err := row.Scan(&token)
+	return token, err
+}
+
 const getJobLock = `-- name: GetJobLock :one
 SELECT holder, touched, iteration
 FROM job_lock
@@ -40,24 +136,126 @@ func (q *Queries) GetJobLock(ctx context.Context, name string) (GetJobLockRow, e
 	return i, err
 }
 
+const incrementRequestLimit = `-- name: IncrementRequestLimit :one
+INSERT INTO request_limit(key, window_start, count, expires)
+VALUES ($1, $2, 1, $3)
+ON CONFLICT (key, window_start) DO UPDATE
+SET count = request_limit.count + 1
+RETURNING count
+`
+
+type IncrementRequestLimitParams struct {
+	Key         string
+	WindowStart pgtype.Timestamptz
+	Expires     pgtype.Timestamptz
+}
+
+func (q *Queries) IncrementRequestLimit(ctx context.Context, arg IncrementRequestLimitParams) (int64, error) {
+	row := q.db.QueryRow(ctx, incrementRequestLimit, arg.Key, arg.WindowStart, arg.Expires)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const insertJobLock = `-- name: InsertJobLock :one
-INSERT INTO job_lock(name, holder, touched, iteration)
-VALUES ($1, $2, now(), 1)
+INSERT INTO job_lock(name, holder, touched, iteration, metadata)
+VALUES ($1, $2, now(), 1, $3)
 RETURNING iteration
 `
 
 type InsertJobLockParams struct {
-	Name   string
-	Holder string
+	Name     string
+	Holder   string
+	Metadata []byte
 }
 
 func (q *Queries) InsertJobLock(ctx context.Context, arg InsertJobLockParams) (int64, error) {
-	row := q.db.QueryRow(ctx, insertJobLock, arg.Name, arg.Holder)
+	row := q.db.QueryRow(ctx, insertJobLock, arg.Name, arg.Holder, arg.Metadata)
 	var iteration int64
 	err := row.Scan(&iteration)
 	return iteration, err
 }
 
+const insertTokenRevocation = `-- name: InsertTokenRevocation :exec
+INSERT INTO token_revocation(kind, value, revoked, expires)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (kind, value) DO UPDATE
+SET revoked = excluded.revoked,
+    expires = excluded.expires
+`
+
+type InsertTokenRevocationParams struct {
+	Kind    string
+	Value   string
+	Revoked pgtype.Timestamptz
+	Expires pgtype.Timestamptz
+}
+
+func (q *Queries) InsertTokenRevocation(ctx context.Context, arg InsertTokenRevocationParams) error {
+	_, err := q.db.Exec(ctx, insertTokenRevocation,
+		arg.Kind,
+		arg.Value,
+		arg.Revoked,
+		arg.Expires,
+	)
+	return err
+}
+
+const listJobLocks = `-- name: ListJobLocks :many
+SELECT name, holder, touched, iteration, metadata
+FROM job_lock
+ORDER BY name
+`
+
+func (q *Queries) ListJobLocks(ctx context.Context) ([]JobLock, error) {
+	rows, err := q.db.Query(ctx, listJobLocks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []JobLock
+	for rows.Next() {
+		var i JobLock
+		if err := rows.Scan(
+			&i.Name,
+			&i.Holder,
+			&i.Touched,
+			&i.Iteration,
+			&i.Metadata,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markDedupKey = `-- name: MarkDedupKey :execrows
+INSERT INTO dedup_key(consumer, key, seen, expires)
+VALUES ($1, $2, now(), $3)
+ON CONFLICT (consumer, key) DO UPDATE
+SET seen = excluded.seen,
+    expires = excluded.expires
+WHERE dedup_key.expires <= now()
+`
+
+type MarkDedupKeyParams struct {
+	Consumer string
+	Key      string
+	Expires  pgtype.Timestamptz
+}
+
+func (q *Queries) MarkDedupKey(ctx context.Context, arg MarkDedupKeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markDedupKey, arg.Consumer, arg.Key, arg.Expires)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const notify = `-- name: Notify :exec
 SELECT pg_notify($1::text, $2::text)
 `
@@ -114,18 +312,40 @@ func (q *Queries) ReleaseJobLock(ctx context.Context, arg ReleaseJobLockParams)
 	return result.RowsAffected(), nil
 }
 
+const setCachedToken = `-- name: SetCachedToken :exec
+INSERT INTO token_cache(key, token, expires)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO UPDATE
+SET token = excluded.token,
+    expires = excluded.expires
+WHERE token_cache.expires < excluded.expires
+`
+
+type SetCachedTokenParams struct {
+	Key     string
+	Token   []byte
+	Expires pgtype.Timestamptz
+}
+
+func (q *Queries) SetCachedToken(ctx context.Context, arg SetCachedTokenParams) error {
+	_, err := q.db.Exec(ctx, setCachedToken, arg.Key, arg.Token, arg.Expires)
+	return err
+}
+
 const stealJobLock = `-- name: StealJobLock :execrows
 UPDATE job_lock
 SET holder = $1,
     touched = now(),
-    iteration = iteration + 1
-WHERE name = $2
-      AND holder = $3
-      AND iteration = $4
+    iteration = iteration + 1,
+    metadata = $2
+WHERE name = $3
+      AND holder = $4
+      AND iteration = $5
 `
 
 type StealJobLockParams struct {
 	NewHolder      string
+	Metadata       []byte
 	Name           string
 	PreviousHolder string
 	Iteration      int64
@@ -134,6 +354,7 @@ type StealJobLockParams struct {
 func (q *Queries) StealJobLock(ctx context.Context, arg StealJobLockParams) (int64, error) {
 	result, err := q.db.Exec(ctx, stealJobLock,
 		arg.NewHolder,
+		arg.Metadata,
 		arg.Name,
 		arg.PreviousHolder,
 		arg.Iteration,
@@ -143,3 +364,14 @@ func (q *Queries) StealJobLock(ctx context.Context, arg StealJobLockParams) (int
 	}
 	return result.RowsAffected(), nil
 }
+
+const tryAdvisoryLock = `-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock($1::bigint)::bool
+`
+
+func (q *Queries) TryAdvisoryLock(ctx context.Context, id int64) (bool, error) {
+	row := q.db.QueryRow(ctx, tryAdvisoryLock, id)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
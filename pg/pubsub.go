@@ -0,0 +1,520 @@
+package pg
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// KeepaliveChannel is the notification channel that listeners use to verify
+// that notifications still are delivered to their connection.
+const KeepaliveChannel = "elephantine_listener_keepalive"
+
+// ChannelSubscription is a subscription to a notification channel.
+type ChannelSubscription interface {
+	// ChannelName returns the name of the channel to listen to.
+	ChannelName() string
+	// NotifyWithPayload is called for every notification received on the
+	// channel.
+	NotifyWithPayload(data []byte) error
+}
+
+// SubscribeOptions controls how a notification listener should behave.
+type SubscribeOptions struct {
+	// KeepaliveInterval controls how long the listener connection can be
+	// idle before we ping it. Defaults to 30s.
+	KeepaliveInterval time.Duration
+	// KeepaliveTimeout controls how long we wait for a keepalive
+	// notification to be delivered before we consider the connection to be
+	// dead. Defaults to 10s.
+	KeepaliveTimeout time.Duration
+	// TCPKeepalive is the TCP keepalive idle time and probe interval that
+	// will be set on the listener connection. Defaults to 15s.
+	TCPKeepalive time.Duration
+	// RetryDelay is the time to wait before reconnecting after a listener
+	// failure. Defaults to 5s.
+	RetryDelay time.Duration
+	// Metrics is used to instrument notification dispatch if set.
+	Metrics *PubSubMetrics
+	// ListenConnString is used to connect the listener directly to the
+	// database instead of taking a connection from the pool. Required
+	// when the pool connects through a transaction pooler, see
+	// PoolModeTransaction, as LISTEN doesn't work on those connections.
+	ListenConnString string
+	// Stop makes the listener drain and stop when closed, use it with
+	// GracefulShutdown.ShouldStop(). No new notifications are accepted
+	// once stop has been closed, and the notification that is being
+	// handled is allowed to complete before the listener connection is
+	// closed. Cancelling the context stops the listener immediately.
+	Stop <-chan struct{}
+	// DrainTimeout is how long to wait for the notification that is being
+	// handled when the listener is stopped. Defaults to 10s.
+	DrainTimeout time.Duration
+}
+
+// errListenerStopped is returned by runListener when the listener has been
+// stopped through SubscribeOptions.Stop.
+var errListenerStopped = errors.New("listener stopped")
+
+// Publish a JSON encoded message on a notification channel.
+func Publish(
+	ctx context.Context, db postgres.DBTX, channel string, message any,
+) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	err = postgres.New(db).Notify(ctx, postgres.NotifyParams{
+		Channel: channel,
+		Message: string(payload),
+	})
+	if err != nil {
+		return fmt.Errorf("send notification: %w", err)
+	}
+
+	return nil
+}
+
+// Publish works like the package level Publish, but records the duration and
+// outcome of the publish. Safe to call on a nil PubSubMetrics.
+func (m *PubSubMetrics) Publish(
+	ctx context.Context, db postgres.DBTX, channel string, message any,
+) error {
+	start := time.Now()
+
+	err := Publish(ctx, db, channel, message)
+
+	m.published(channel, start, err)
+
+	return err
+}
+
+// Subscribe listens to the notification channels and dispatches notifications
+// to the subscriptions. The listener will reconnect on failure. Blocks until
+// the context is cancelled, or until the listener has been drained after
+// opts.Stop has been closed:
+//
+//	go pg.Subscribe(gs.CancelOnQuit(ctx), logger, pool, pg.SubscribeOptions{
+//		Stop: gs.ShouldStop(),
+//	}, documentEvents)
+func Subscribe(
+	ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool,
+	opts SubscribeOptions, channels ...ChannelSubscription,
+) {
+	if opts.KeepaliveInterval == 0 {
+		opts.KeepaliveInterval = 30 * time.Second
+	}
+
+	if opts.KeepaliveTimeout == 0 {
+		opts.KeepaliveTimeout = 10 * time.Second
+	}
+
+	if opts.TCPKeepalive == 0 {
+		opts.TCPKeepalive = 15 * time.Second
+	}
+
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = 5 * time.Second
+	}
+
+	if opts.DrainTimeout == 0 {
+		opts.DrainTimeout = 10 * time.Second
+	}
+
+	for {
+		err := runListener(ctx, logger, pool, opts, channels)
+		if ctx.Err() != nil || errors.Is(err, errListenerStopped) {
+			return
+		}
+
+		logger.ErrorContext(ctx, "notification listener failure",
+			elephantine.LogKeyError, err,
+			elephantine.LogKeyDelay, slog.DurationValue(opts.RetryDelay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-opts.Stop:
+			return
+		case <-time.After(opts.RetryDelay):
+		}
+	}
+}
+
+func runListener(
+	ctx context.Context, logger *slog.Logger, pool *pgxpool.Pool,
+	opts SubscribeOptions, channels []ChannelSubscription,
+) error {
+	// listenCtx is cancelled when the listener should stop accepting
+	// notifications.
+	listenCtx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
+
+	if opts.Stop != nil {
+		go func() {
+			select {
+			case <-opts.Stop:
+				stopListening()
+			case <-listenCtx.Done():
+			}
+		}()
+	}
+
+	conn, err := listenerConn(listenCtx, pool, opts)
+	if err != nil {
+		return stoppedOr(ctx, listenCtx, err)
+	}
+
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(
+			context.Background(), opts.KeepaliveTimeout)
+		defer cancel()
+
+		_ = conn.Close(closeCtx)
+	}()
+
+	err = setTCPKeepalive(conn.PgConn().Conn(), opts.TCPKeepalive)
+	if err != nil {
+		return fmt.Errorf("configure TCP keepalive: %w", err)
+	}
+
+	subs := make(map[string]ChannelSubscription, len(channels))
+
+	for _, sub := range channels {
+		subs[sub.ChannelName()] = sub
+	}
+
+	listen := append([]string{KeepaliveChannel}, mapKeys(subs)...)
+
+	for _, name := range listen {
+		ident := pgx.Identifier{name}
+
+		_, err := conn.Exec(listenCtx, "LISTEN "+ident.Sanitize())
+		if err != nil {
+			return stoppedOr(ctx, listenCtx, fmt.Errorf(
+				"start listening to %q: %w", name, err))
+		}
+	}
+
+	// Notifications are handled one at a time, in order, by the
+	// dispatcher. This lets us keep waiting for stop while a handler is
+	// running.
+	queue := make(chan *pgconn.Notification)
+	dispatched := make(chan struct{})
+
+	go func() {
+		defer close(dispatched)
+
+		for notification := range queue {
+			dispatch(ctx, logger, opts, subs, notification)
+		}
+	}()
+
+	var closeQueue sync.Once
+
+	stopDispatch := func() {
+		closeQueue.Do(func() { close(queue) })
+	}
+
+	// Wait for the running handler on all paths, so that no handler
+	// outlives runListener and overlaps with the next connection's.
+	defer func() {
+		stopDispatch()
+		<-dispatched
+	}()
+
+	// Keepalive notifications are sent through the pool, so receiving our
+	// own notification verifies that the server still delivers
+	// notifications to this connection.
+	listenerID := uuid.NewString()
+
+	nextPing := time.Now().Add(opts.KeepaliveInterval)
+
+	var pingDeadline time.Time
+
+	for {
+		deadline := nextPing
+		if !pingDeadline.IsZero() && pingDeadline.Before(deadline) {
+			deadline = pingDeadline
+		}
+
+		waitCtx, cancel := context.WithDeadline(listenCtx, deadline)
+
+		notification, err := conn.WaitForNotification(waitCtx)
+
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err() //nolint:wrapcheck
+		case listenCtx.Err() != nil:
+			return drainListener(ctx, logger, opts, stopDispatch, dispatched)
+		case pgconn.Timeout(err):
+		case err != nil:
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+
+		now := time.Now()
+
+		if !pingDeadline.IsZero() && now.After(pingDeadline) {
+			return errors.New("keepalive notification was not delivered in time")
+		}
+
+		if now.After(nextPing) {
+			err := keepalive(ctx, conn, pool, listenerID, opts.KeepaliveTimeout)
+			if err != nil {
+				return err
+			}
+
+			nextPing = now.Add(opts.KeepaliveInterval)
+
+			if pingDeadline.IsZero() {
+				pingDeadline = now.Add(opts.KeepaliveTimeout)
+			}
+		}
+
+		if notification == nil {
+			continue
+		}
+
+		if notification.Channel == KeepaliveChannel {
+			if notification.Payload == listenerID {
+				pingDeadline = time.Time{}
+			}
+
+			continue
+		}
+
+		select {
+		case queue <- notification:
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck
+		case <-listenCtx.Done():
+			// Notifications that arrive after stop are not
+			// accepted.
+			return drainListener(ctx, logger, opts, stopDispatch, dispatched)
+		}
+	}
+}
+
+func dispatch(
+	ctx context.Context, logger *slog.Logger, opts SubscribeOptions,
+	subs map[string]ChannelSubscription, notification *pgconn.Notification,
+) {
+	sub, ok := subs[notification.Channel]
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+
+	err := sub.NotifyWithPayload([]byte(notification.Payload))
+
+	opts.Metrics.dispatched(notification.Channel, start, err)
+
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to handle notification",
+			elephantine.LogKeyChannel, notification.Channel,
+			elephantine.LogKeyError, err)
+	}
+}
+
+// drainListener stops the dispatcher and waits for it to finish handling the
+// current notification, or for the drain timeout.
+func drainListener(
+	ctx context.Context, logger *slog.Logger, opts SubscribeOptions,
+	stopDispatch func(), dispatched <-chan struct{},
+) error {
+	logger.InfoContext(ctx, "draining notification listener")
+
+	stopDispatch()
+
+	timer := time.NewTimer(opts.DrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-dispatched:
+	case <-timer.C:
+		logger.WarnContext(ctx, "notification handler did not complete before the drain timeout",
+			elephantine.LogKeyDelay, slog.DurationValue(opts.DrainTimeout))
+	case <-ctx.Done():
+	}
+
+	return errListenerStopped
+}
+
+// stoppedOr returns errListenerStopped if the listener was stopped, and err
+// otherwise.
+func stoppedOr(ctx context.Context, listenCtx context.Context, err error) error {
+	if ctx.Err() == nil && listenCtx.Err() != nil {
+		return errListenerStopped
+	}
+
+	return err
+}
+
+// listenerConn connects to the database using the listen connection string
+// if it's set, or takes a connection from the pool.
+func listenerConn(
+	ctx context.Context, pool *pgxpool.Pool, opts SubscribeOptions,
+) (*pgx.Conn, error) {
+	if opts.ListenConnString != "" {
+		conn, err := pgx.Connect(ctx, opts.ListenConnString)
+		if err != nil {
+			return nil, fmt.Errorf("connect listener: %w", err)
+		}
+
+		return conn, nil
+	}
+
+	poolConn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acquire connection: %w", err)
+	}
+
+	// We hijack the connection as we don't want it to go back into the
+	// pool with active subscriptions.
+	return poolConn.Hijack(), nil
+}
+
+// keepalive pings the listener connection and sends a keepalive notification
+// through the pool.
+func keepalive(
+	ctx context.Context, conn *pgx.Conn, pool *pgxpool.Pool,
+	listenerID string, timeout time.Duration,
+) error {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := conn.Exec(pingCtx, "SELECT 1")
+	if err != nil {
+		return fmt.Errorf("ping listener connection: %w", err)
+	}
+
+	err = postgres.New(pool).Notify(pingCtx, postgres.NotifyParams{
+		Channel: KeepaliveChannel,
+		Message: listenerID,
+	})
+	if err != nil {
+		return fmt.Errorf("send keepalive notification: %w", err)
+	}
+
+	return nil
+}
+
+func setTCPKeepalive(conn net.Conn, period time.Duration) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		// Unix sockets and the like.
+		return nil
+	}
+
+	//nolint:wrapcheck
+	return tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     period,
+		Interval: period,
+		Count:    3,
+	})
+}
+
+func mapKeys[K comparable, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// NewFanOut creates a new FanOut for the named notification channel.
+func NewFanOut[T any](channel string) *FanOut[T] {
+	return &FanOut[T]{
+		channel:   channel,
+		listeners: make(map[chan T]func(v T) bool),
+	}
+}
+
+// FanOut is a ChannelSubscription that decodes JSON notifications and
+// distributes them to any number of listeners.
+type FanOut[T any] struct {
+	channel string
+
+	m         sync.RWMutex
+	listeners map[chan T]func(v T) bool
+}
+
+// ChannelName implements ChannelSubscription.
+func (f *FanOut[T]) ChannelName() string {
+	return f.channel
+}
+
+// NotifyWithPayload implements ChannelSubscription.
+func (f *FanOut[T]) NotifyWithPayload(data []byte) error {
+	var msg T
+
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		return fmt.Errorf("unmarshal notification payload: %w", err)
+	}
+
+	f.Notify(msg)
+
+	return nil
+}
+
+// Notify all listeners of a message. Listeners that aren't ready to receive
+// the message will miss it.
+func (f *FanOut[T]) Notify(msg T) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	for ch, test := range f.listeners {
+		if test != nil && !test(msg) {
+			continue
+		}
+
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// Publish a message to the channel.
+func (f *FanOut[T]) Publish(ctx context.Context, db postgres.DBTX, msg T) error {
+	return Publish(ctx, db, f.channel, msg)
+}
+
+// Listen sends all messages that pass the test function to the channel l until
+// the context is cancelled. A nil test function lets all messages through.
+func (f *FanOut[T]) Listen(ctx context.Context, l chan T, test func(v T) bool) {
+	f.m.Lock()
+	f.listeners[l] = test
+	f.m.Unlock()
+
+	<-ctx.Done()
+
+	f.m.Lock()
+	delete(f.listeners, l)
+	f.m.Unlock()
+}
@@ -5,8 +5,8 @@ WHERE name = $1
 FOR UPDATE;
 
 -- name: InsertJobLock :one
-INSERT INTO job_lock(name, holder, touched, iteration)
-VALUES (@name, @holder, now(), 1)
+INSERT INTO job_lock(name, holder, touched, iteration, metadata)
+VALUES (@name, @holder, now(), 1, @metadata)
 RETURNING iteration;
 
 -- name: PingJobLock :execrows
@@ -21,7 +21,8 @@ WHERE name = @name
 UPDATE job_lock
 SET holder = @new_holder,
     touched = now(),
-    iteration = iteration + 1
+    iteration = iteration + 1,
+    metadata = @metadata
 WHERE name = @name
       AND holder = @previous_holder
       AND iteration = @iteration;
@@ -31,8 +32,73 @@ DELETE FROM job_lock
 WHERE name = @name
       AND holder = @holder;
 
+-- name: ListJobLocks :many
+SELECT name, holder, touched, iteration, metadata
+FROM job_lock
+ORDER BY name;
+
 -- name: AcquireTXLock :exec
 SELECT pg_advisory_xact_lock(@id::bigint);
 
 -- name: Notify :exec
 SELECT pg_notify(@channel::text, @message::text);
+
+-- name: InsertTokenRevocation :exec
+INSERT INTO token_revocation(kind, value, revoked, expires)
+VALUES (@kind, @value, @revoked, @expires)
+ON CONFLICT (kind, value) DO UPDATE
+SET revoked = excluded.revoked,
+    expires = excluded.expires;
+
+-- name: GetActiveTokenRevocations :many
+SELECT kind, value, revoked, expires
+FROM token_revocation
+WHERE expires > now();
+
+-- name: DeleteExpiredTokenRevocations :execrows
+DELETE FROM token_revocation
+WHERE expires <= now();
+
+-- name: TryAdvisoryLock :one
+SELECT pg_try_advisory_lock(@id::bigint)::bool;
+
+-- name: AdvisoryUnlock :one
+SELECT pg_advisory_unlock(@id::bigint)::bool;
+
+-- name: MarkDedupKey :execrows
+INSERT INTO dedup_key(consumer, key, seen, expires)
+VALUES (@consumer, @key, now(), @expires)
+ON CONFLICT (consumer, key) DO UPDATE
+SET seen = excluded.seen,
+    expires = excluded.expires
+WHERE dedup_key.expires <= now();
+
+-- name: DeleteExpiredDedupKeys :execrows
+DELETE FROM dedup_key
+WHERE consumer = @consumer
+      AND expires <= now();
+
+-- name: GetCachedToken :one
+SELECT token
+FROM token_cache
+WHERE key = @key
+      AND expires > now();
+
+-- name: SetCachedToken :exec
+INSERT INTO token_cache(key, token, expires)
+VALUES (@key, @token, @expires)
+ON CONFLICT (key) DO UPDATE
+SET token = excluded.token,
+    expires = excluded.expires
+WHERE token_cache.expires < excluded.expires;
+
+-- name: IncrementRequestLimit :one
+INSERT INTO request_limit(key, window_start, count, expires)
+VALUES (@key, @window_start, 1, @expires)
+ON CONFLICT (key, window_start) DO UPDATE
+SET count = request_limit.count + 1
+RETURNING count;
+
+-- name: DeleteExpiredRequestLimits :execrows
+DELETE FROM request_limit
+WHERE expires <= now();
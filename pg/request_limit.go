@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+var _ elephantine.RequestLimitStore = &RequestLimitStore{}
+
+// RequestLimitStore is a elephantine.RequestLimitStore that counts requests in
+// postgres, so that the limits are shared between the replicas of a service.
+// Requests are counted in fixed windows of the limit period.
+type RequestLimitStore struct {
+	db postgres.DBTX
+}
+
+// NewRequestLimitStore creates a postgres backed request limit store.
+func NewRequestLimitStore(db postgres.DBTX) *RequestLimitStore {
+	return &RequestLimitStore{db: db}
+}
+
+// Take implements elephantine.RequestLimitStore.
+func (s *RequestLimitStore) Take(
+	ctx context.Context, key string, limit elephantine.RequestLimit,
+) (bool, time.Duration, error) {
+	now := time.Now()
+	windowStart := now.Truncate(limit.Per)
+	windowEnd := windowStart.Add(limit.Per)
+
+	count, err := postgres.New(s.db).IncrementRequestLimit(ctx,
+		postgres.IncrementRequestLimitParams{
+			Key:         key,
+			WindowStart: Time(windowStart),
+			Expires:     Time(windowEnd),
+		})
+	if err != nil {
+		return false, 0, fmt.Errorf("increment request count: %w", err)
+	}
+
+	if count > int64(limit.Requests) {
+		return false, windowEnd.Sub(now), nil
+	}
+
+	return true, 0, nil
+}
+
+// DeleteExpired removes the counts for windows that have ended. Should be run
+// periodically, f.ex. by a job that holds a job lock.
+func (s *RequestLimitStore) DeleteExpired(ctx context.Context) (int64, error) {
+	n, err := postgres.New(s.db).DeleteExpiredRequestLimits(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired request counts: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,182 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ttab/elephantine"
+)
+
+// ErrAlreadyCommitted is returned by WithSerializableRetry when the idempotency
+// key of the operation shows that it already has been committed.
+var ErrAlreadyCommitted = errors.New("the operation has already been committed")
+
+// TxOptionsBeginner is the interface for something that can start a pgx
+// transaction with options for use with WithSerializableRetry().
+type TxOptionsBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// RetryOptions controls the behaviour of WithSerializableRetry.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of times that the operation is
+	// attempted. Defaults to 5.
+	MaxAttempts int
+	// Backoff controls the wait between attempts. Defaults to an
+	// exponential backoff starting at 50ms, up to 2s.
+	Backoff elephantine.BackoffFunction
+	// Idempotency enables recording of the idempotency key from the
+	// context, see WithIdempotencyKey. The key is marked as seen in the
+	// same transaction as the operation, which makes it safe to retry
+	// after an ambiguous commit error, like a lost connection, where we
+	// can't know if the commit went through or not.
+	Idempotency *Dedup
+}
+
+type idempotencyCtxKey struct{}
+
+// WithIdempotencyKey returns a context that carries an idempotency key for the
+// business operation that is performed with it.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyCtxKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key of the context, if any.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyCtxKey{}).(string)
+
+	return key, ok && key != ""
+}
+
+// WithSerializableRetry runs the function in a serializable transaction, and
+// retries it if the transaction fails with a serialization failure or
+// deadlock. The function must not have side effects outside of the
+// transaction, as it can be called more than once.
+//
+// If opts.Idempotency is set and the context has an idempotency key the key is
+// recorded in the transaction. Commit errors where the outcome is unknown are
+// then retried as well, and ErrAlreadyCommitted is returned if a previous
+// attempt, or an earlier call with the same key, already has committed.
+// Without an idempotency key ambiguous commit errors are returned as-is, as
+// retrying non-idempotent operations isn't safe.
+//
+// The transaction is a TxWithHooks, see WithTX. Rollback hooks run for every
+// failed attempt.
+func WithSerializableRetry(
+	ctx context.Context, pool TxOptionsBeginner, opts RetryOptions,
+	fn func(tx pgx.Tx) error,
+) error {
+	if opts.MaxAttempts == 0 {
+		opts.MaxAttempts = 5
+	}
+
+	if opts.Backoff == nil {
+		opts.Backoff = elephantine.ExponentialBackoff(
+			50*time.Millisecond, 2*time.Second)
+	}
+
+	var key string
+
+	if opts.Idempotency != nil {
+		key, _ = IdempotencyKey(ctx)
+	}
+
+	for attempt := 1; ; attempt++ {
+		ambiguous, err := serializableAttempt(ctx, pool, opts, key, fn)
+		if err == nil {
+			return nil
+		}
+
+		retry := isSerializationFailure(err) || (ambiguous && key != "")
+		if !retry {
+			return err
+		}
+
+		if attempt >= opts.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w",
+				attempt, err)
+		}
+
+		select {
+		case <-time.After(opts.Backoff(attempt)):
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		}
+	}
+}
+
+// serializableAttempt runs the function in a serializable transaction. The
+// returned ambiguous flag is true if the commit failed in a way that leaves
+// the outcome unknown.
+func serializableAttempt(
+	ctx context.Context, pool TxOptionsBeginner, opts RetryOptions,
+	key string, fn func(tx pgx.Tx) error,
+) (_ bool, outErr error) {
+	err := elephantine.CheckpointErr(ctx)
+	if err != nil {
+		return false, fmt.Errorf("refusing to begin transaction: %w", err)
+	}
+
+	btx, err := pool.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel: pgx.Serializable,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	tx := NewTxWithHooks(btx)
+
+	defer Rollback(tx, &outErr)
+
+	if key != "" {
+		fresh, err := opts.Idempotency.CheckAndMark(ctx, tx, key)
+		if err != nil {
+			return false, fmt.Errorf("record idempotency key: %w", err)
+		}
+
+		if !fresh {
+			return false, ErrAlreadyCommitted
+		}
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return false, err
+	}
+
+	// Run the before commit hooks separately, so that their errors
+	// aren't mistaken for ambiguous commit errors.
+	err = tx.runBeforeCommit(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		var pgerr *pgconn.PgError
+
+		// An error from the server means that the transaction was
+		// rolled back, anything else could have happened after the
+		// commit went through.
+		ambiguous := !errors.As(err, &pgerr)
+
+		return ambiguous, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	return false, nil
+}
+
+func isSerializationFailure(err error) bool {
+	var pgerr *pgconn.PgError
+
+	if !errors.As(err, &pgerr) {
+		return false
+	}
+
+	// serialization_failure and deadlock_detected.
+	return pgerr.Code == "40001" || pgerr.Code == "40P01"
+}
@@ -0,0 +1,205 @@
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// TokenRevocationChannel is the notification channel that token revocations
+// are published on.
+const TokenRevocationChannel = "token_revocation"
+
+// RevocationKind controls what claim a revocation matches.
+type RevocationKind string
+
+const (
+	// RevokeTokenID revokes the token with the given jti.
+	RevokeTokenID RevocationKind = "jti"
+	// RevokeSession revokes all tokens with the given sid.
+	RevokeSession RevocationKind = "sid"
+	// RevokeSubject revokes all tokens for the given (normalised) subject
+	// that were issued before the revocation.
+	RevokeSubject RevocationKind = "sub"
+)
+
+// TokenRevocation describes a revocation of one or more tokens.
+type TokenRevocation struct {
+	Kind  RevocationKind `json:"kind"`
+	Value string         `json:"value"`
+	// Revoked is the time of the revocation.
+	Revoked time.Time `json:"revoked"`
+	// Expires is the time after which the revocation no longer is
+	// relevant, should be at least as late as the expiry of the revoked
+	// tokens.
+	Expires time.Time `json:"expires"`
+}
+
+type revocationKey struct {
+	Kind  RevocationKind
+	Value string
+}
+
+// RevokeTokens stores the revocation and notifies all TokenRevocations
+// listeners. Use a transaction if the revocation should be made together with
+// other changes, the notification will be sent on commit.
+func RevokeTokens(
+	ctx context.Context, db postgres.DBTX, rev TokenRevocation,
+) error {
+	if rev.Revoked.IsZero() {
+		rev.Revoked = time.Now()
+	}
+
+	err := postgres.New(db).InsertTokenRevocation(ctx,
+		postgres.InsertTokenRevocationParams{
+			Kind:    string(rev.Kind),
+			Value:   rev.Value,
+			Revoked: Time(rev.Revoked),
+			Expires: Time(rev.Expires),
+		})
+	if err != nil {
+		return fmt.Errorf("store revocation: %w", err)
+	}
+
+	err = Publish(ctx, db, TokenRevocationChannel, rev)
+	if err != nil {
+		return fmt.Errorf("publish revocation: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredTokenRevocations removes revocations that no longer are
+// relevant.
+func DeleteExpiredTokenRevocations(
+	ctx context.Context, db postgres.DBTX,
+) (int64, error) {
+	n, err := postgres.New(db).DeleteExpiredTokenRevocations(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired revocations: %w", err)
+	}
+
+	return n, nil
+}
+
+// NewTokenRevocations creates an empty revocation list.
+func NewTokenRevocations() *TokenRevocations {
+	return &TokenRevocations{
+		entries: make(map[revocationKey]TokenRevocation),
+	}
+}
+
+// TokenRevocations is a Postgres-backed elephantine.RevocationChecker. Load the
+// current revocations with Load() and keep the list up to date by passing it
+// to Subscribe().
+//
+// Revocations that are made while the listener is reconnecting will be missed,
+// so Load() should be called periodically as well.
+type TokenRevocations struct {
+	m       sync.RWMutex
+	entries map[revocationKey]TokenRevocation
+}
+
+// Load all active revocations from the database.
+func (r *TokenRevocations) Load(ctx context.Context, db postgres.DBTX) error {
+	rows, err := postgres.New(db).GetActiveTokenRevocations(ctx)
+	if err != nil {
+		return fmt.Errorf("load revocations: %w", err)
+	}
+
+	entries := make(map[revocationKey]TokenRevocation, len(rows))
+
+	for _, row := range rows {
+		rev := TokenRevocation{
+			Kind:    RevocationKind(row.Kind),
+			Value:   row.Value,
+			Revoked: row.Revoked.Time,
+			Expires: row.Expires.Time,
+		}
+
+		entries[revocationKey{Kind: rev.Kind, Value: rev.Value}] = rev
+	}
+
+	r.m.Lock()
+	r.entries = entries
+	r.m.Unlock()
+
+	return nil
+}
+
+// Add a revocation to the list.
+func (r *TokenRevocations) Add(rev TokenRevocation) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.entries[revocationKey{Kind: rev.Kind, Value: rev.Value}] = rev
+
+	// Use additions as an opportunity to drop expired entries.
+	now := time.Now()
+
+	for k, e := range r.entries {
+		if e.Expires.Before(now) {
+			delete(r.entries, k)
+		}
+	}
+}
+
+// ChannelName implements ChannelSubscription.
+func (r *TokenRevocations) ChannelName() string {
+	return TokenRevocationChannel
+}
+
+// NotifyWithPayload implements ChannelSubscription.
+func (r *TokenRevocations) NotifyWithPayload(data []byte) error {
+	var rev TokenRevocation
+
+	err := json.Unmarshal(data, &rev)
+	if err != nil {
+		return fmt.Errorf("unmarshal revocation: %w", err)
+	}
+
+	r.Add(rev)
+
+	return nil
+}
+
+// CheckRevocation implements elephantine.RevocationChecker.
+func (r *TokenRevocations) CheckRevocation(claims elephantine.JWTClaims) error {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	if claims.ID != "" {
+		_, revoked := r.entries[revocationKey{
+			Kind: RevokeTokenID, Value: claims.ID,
+		}]
+		if revoked {
+			return fmt.Errorf("token ID %q: %w",
+				claims.ID, elephantine.ErrTokenRevoked)
+		}
+	}
+
+	if claims.SessionID != "" {
+		_, revoked := r.entries[revocationKey{
+			Kind: RevokeSession, Value: claims.SessionID,
+		}]
+		if revoked {
+			return fmt.Errorf("session %q: %w",
+				claims.SessionID, elephantine.ErrTokenRevoked)
+		}
+	}
+
+	rev, ok := r.entries[revocationKey{
+		Kind: RevokeSubject, Value: claims.Subject,
+	}]
+	if ok && (claims.IssuedAt == nil || !claims.IssuedAt.After(rev.Revoked)) {
+		return fmt.Errorf("subject %q: %w",
+			claims.Subject, elephantine.ErrTokenRevoked)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// Scan runs the query and scans the first row into a struct of type T. Columns
+// are mapped to fields by name, using the "db" struct tag if set, see
+// pgx.RowToStructByName. Every column must have a matching field, and every
+// field must have a matching column. Returns pgx.ErrNoRows if the query
+// doesn't return any rows.
+//
+// Use it for ad hoc queries alongside sqlc:
+//
+//	type docCount struct {
+//		Type  string `db:"type"`
+//		Count int64  `db:"count"`
+//	}
+//
+//	counts, err := pg.CollectRows[docCount](ctx, pool, `
+//		SELECT type, COUNT(*) AS count FROM document GROUP BY type`)
+func Scan[T any](
+	ctx context.Context, db postgres.DBTX, sql string, args ...any,
+) (T, error) {
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		var zero T
+
+		return zero, fmt.Errorf("run query: %w", err)
+	}
+
+	item, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[T])
+	if err != nil {
+		return item, scanError(rows, err)
+	}
+
+	return item, nil
+}
+
+// CollectRows runs the query and scans all rows into structs of type T, see
+// Scan for how columns are mapped.
+func CollectRows[T any](
+	ctx context.Context, db postgres.DBTX, sql string, args ...any,
+) ([]T, error) {
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("run query: %w", err)
+	}
+
+	items, err := pgx.CollectRows(rows, pgx.RowToStructByName[T])
+	if err != nil {
+		return nil, scanError(rows, err)
+	}
+
+	return items, nil
+}
+
+// scanError adds the name of the offending column to scan errors.
+func scanError(rows pgx.Rows, err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return err //nolint:wrapcheck
+	}
+
+	var argErr pgx.ScanArgError
+
+	fields := rows.FieldDescriptions()
+
+	if errors.As(err, &argErr) && argErr.ColumnIndex < len(fields) {
+		return fmt.Errorf("scan column %q: %w",
+			fields[argErr.ColumnIndex].Name, argErr.Err)
+	}
+
+	return fmt.Errorf("scan rows: %w", err)
+}
@@ -2,5 +2,36 @@ CREATE TABLE job_lock (
     name text NOT NULL PRIMARY KEY,
     holder text NOT NULL,
     touched timestamp with time zone NOT NULL,
-    iteration bigint NOT NULL
+    iteration bigint NOT NULL,
+    metadata jsonb
+);
+
+CREATE TABLE token_revocation (
+    kind text NOT NULL,
+    value text NOT NULL,
+    revoked timestamp with time zone NOT NULL,
+    expires timestamp with time zone NOT NULL,
+    PRIMARY KEY(kind, value)
+);
+
+CREATE TABLE dedup_key (
+    consumer text NOT NULL,
+    key text NOT NULL,
+    seen timestamp with time zone NOT NULL,
+    expires timestamp with time zone NOT NULL,
+    PRIMARY KEY(consumer, key)
+);
+
+CREATE TABLE token_cache (
+    key text NOT NULL PRIMARY KEY,
+    token jsonb NOT NULL,
+    expires timestamp with time zone NOT NULL
+);
+
+CREATE TABLE request_limit (
+    key text NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count bigint NOT NULL,
+    expires timestamp with time zone NOT NULL,
+    PRIMARY KEY(key, window_start)
 );
@@ -0,0 +1,50 @@
+package pg
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// PoolSnapshot describes the state of a connection pool.
+type PoolSnapshot struct {
+	TotalConns           int32  `json:"total_conns"`
+	IdleConns            int32  `json:"idle_conns"`
+	AcquiredConns        int32  `json:"acquired_conns"`
+	ConstructingConns    int32  `json:"constructing_conns"`
+	MaxConns             int32  `json:"max_conns"`
+	AcquireCount         int64  `json:"acquire_count"`
+	EmptyAcquireCount    int64  `json:"empty_acquire_count"`
+	CanceledAcquireCount int64  `json:"canceled_acquire_count"`
+	AcquireDuration      string `json:"acquire_duration"`
+}
+
+// PoolSnapshotSource returns a snapshot source for the connection pool, see
+// elephantine.HealthServer.AddSnapshotSource.
+func PoolSnapshotSource(pool *pgxpool.Pool) elephantine.SnapshotFunc {
+	return func(_ context.Context) (any, error) {
+		stat := pool.Stat()
+
+		return PoolSnapshot{
+			TotalConns:           stat.TotalConns(),
+			IdleConns:            stat.IdleConns(),
+			AcquiredConns:        stat.AcquiredConns(),
+			ConstructingConns:    stat.ConstructingConns(),
+			MaxConns:             stat.MaxConns(),
+			AcquireCount:         stat.AcquireCount(),
+			EmptyAcquireCount:    stat.EmptyAcquireCount(),
+			CanceledAcquireCount: stat.CanceledAcquireCount(),
+			AcquireDuration:      stat.AcquireDuration().String(),
+		}, nil
+	}
+}
+
+// JobLockSnapshotSource returns a snapshot source that lists the held job
+// locks, see elephantine.HealthServer.AddSnapshotSource.
+func JobLockSnapshotSource(db postgres.DBTX) elephantine.SnapshotFunc {
+	return func(ctx context.Context) (any, error) {
+		return ListJobLocks(ctx, db)
+	}
+}
@@ -0,0 +1,75 @@
+package pg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+	"golang.org/x/oauth2"
+)
+
+var _ elephantine.TokenCache = &TokenCache{}
+
+// TokenCache is a elephantine.TokenCache that shares tokens between the
+// replicas of a service through postgres, so that they don't all request new
+// tokens from the identity provider at startup. Tokens are stored in plain
+// text, so only use it with databases that are private to the service.
+type TokenCache struct {
+	db postgres.DBTX
+}
+
+// NewTokenCache creates a postgres backed token cache.
+func NewTokenCache(db postgres.DBTX) *TokenCache {
+	return &TokenCache{db: db}
+}
+
+// LoadToken implements elephantine.TokenCache.
+func (c *TokenCache) LoadToken(
+	ctx context.Context, key string,
+) (*oauth2.Token, error) {
+	data, err := postgres.New(c.db).GetCachedToken(ctx, key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read cached token: %w", err)
+	}
+
+	var token oauth2.Token
+
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return nil, fmt.Errorf("parse cached token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// StoreToken implements elephantine.TokenCache. Tokens without an expiry time
+// are not cached.
+func (c *TokenCache) StoreToken(
+	ctx context.Context, key string, token *oauth2.Token,
+) error {
+	if token.Expiry.IsZero() {
+		return nil
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("marshal token: %w", err)
+	}
+
+	err = postgres.New(c.db).SetCachedToken(ctx, postgres.SetCachedTokenParams{
+		Key:     key,
+		Token:   data,
+		Expires: Time(token.Expiry),
+	})
+	if err != nil {
+		return fmt.Errorf("store token: %w", err)
+	}
+
+	return nil
+}
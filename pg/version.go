@@ -0,0 +1,78 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/pg/postgres"
+)
+
+// RowVersion identifies the version column of the rows in a table.
+type RowVersion struct {
+	// Table is the name of the table, can be schema qualified using
+	// "schema.table".
+	Table string
+	// IDColumn is the primary key column.
+	IDColumn string
+	// VersionColumn is a bigint column that is incremented on every update.
+	VersionColumn string
+}
+
+// CurrentVersion returns the current version of a row, and locks the row for
+// update if called within a transaction. Returns false if the row doesn't
+// exist.
+func (rv RowVersion) CurrentVersion(
+	ctx context.Context, db postgres.DBTX, id any,
+) (int64, bool, error) {
+	table := pgx.Identifier(strings.Split(rv.Table, ".")).Sanitize()
+	idCol := pgx.Identifier{rv.IDColumn}.Sanitize()
+	versionCol := pgx.Identifier{rv.VersionColumn}.Sanitize()
+
+	//nolint:gosec
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s = $1 FOR UPDATE",
+		versionCol, table, idCol)
+
+	var version int64
+
+	err := db.QueryRow(ctx, query, id).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("read row version: %w", err)
+	}
+
+	return version, true, nil
+}
+
+// CheckVersion locks the row and checks its version against the If-Match
+// version token of the request, see elephantine.CheckVersionToken. Returns the
+// current version of the row, or zero if it doesn't exist.
+//
+// Call CheckVersion in the same transaction as the update to guarantee that
+// the row doesn't change between the check and the update.
+func (rv RowVersion) CheckVersion(
+	ctx context.Context, db postgres.DBTX, id any,
+) (int64, error) {
+	version, exists, err := rv.CurrentVersion(ctx, db, id)
+	if err != nil {
+		return 0, err
+	}
+
+	var current string
+
+	if exists {
+		current = elephantine.VersionToken(version)
+	}
+
+	err = elephantine.CheckVersionToken(ctx, current)
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return version, nil
+}
@@ -0,0 +1,378 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+)
+
+// DefaultProviderCacheMaxStaleness is the default max age of cached identity
+// provider documents.
+const DefaultProviderCacheMaxStaleness = 24 * time.Hour
+
+// ProviderCacheOptions controls the persistence of the last known good JWKS
+// and OpenID Connect discovery documents. Cached documents are used at
+// startup, so that a service can start while the identity provider is
+// unavailable.
+type ProviderCacheOptions struct {
+	// Dir is the cache directory. Caching is disabled if empty.
+	Dir string
+	// MaxStaleness is the max age of a cached document for it to be used.
+	// Defaults to DefaultProviderCacheMaxStaleness.
+	MaxStaleness time.Duration
+}
+
+var errStaleCache = errors.New("cached document is too old")
+
+func (o ProviderCacheOptions) enabled() bool {
+	return o.Dir != ""
+}
+
+func (o ProviderCacheOptions) name(kind string, docURL string) string {
+	sum := sha256.Sum256([]byte(docURL))
+
+	return kind + "-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// load reads a cached document, returns an error wrapping os.ErrNotExist if
+// there is no cached document, or errStaleCache together with the document if
+// it's too old.
+func (o ProviderCacheOptions) load(
+	kind string, docURL string,
+) ([]byte, time.Time, error) {
+	maxStaleness := o.MaxStaleness
+	if maxStaleness == 0 {
+		maxStaleness = DefaultProviderCacheMaxStaleness
+	}
+
+	path := filepath.Join(o.Dir, o.name(kind, docURL))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("stat cache file: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read cache file: %w", err)
+	}
+
+	if time.Since(info.ModTime()) > maxStaleness {
+		return data, info.ModTime(), errStaleCache
+	}
+
+	return data, info.ModTime(), nil
+}
+
+func (o ProviderCacheOptions) store(
+	kind string, docURL string, data []byte,
+) error {
+	return writeFileAtomic(o.Dir, o.name(kind, docURL), data)
+}
+
+// writeFileAtomic writes to a temporary file and renames it, so that
+// concurrent readers never see a partial file. The directory is created if
+// needed, the directory and file are only accessible by the current user.
+func writeFileAtomic(dir string, name string, data []byte) error {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("write data: %w", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadOpenIDConnectConfig loads the OpenID Connect discovery document. If the
+// cache is enabled and has a document that isn't older than the max staleness
+// it's used directly, and the cache is refreshed in the background. Otherwise
+// the document is fetched and cached. A stale cached document is used as a
+// last resort if the document can't be fetched.
+//
+// The document can also be read from a local file by using a "file://" URL
+// or a plain path, the cache isn't used for local files.
+func LoadOpenIDConnectConfig(
+	ctx context.Context, logger *slog.Logger, wellKnown string,
+	cache ProviderCacheOptions,
+) (*OpenIDConnectConfig, error) {
+	if path, ok := localDocumentPath(wellKnown); ok {
+		return OpenIDConnectConfigFromFile(path)
+	}
+
+	if !cache.enabled() {
+		return OpenIDConnectConfigFromURL(wellKnown)
+	}
+
+	data, modified, err := cache.load("oidc", wellKnown)
+	if err == nil {
+		var conf OpenIDConnectConfig
+
+		err = json.Unmarshal(data, &conf)
+		if err == nil {
+			logger.InfoContext(ctx, "using cached OIDC config",
+				"url", wellKnown,
+				"age", time.Since(modified).Round(time.Second).String())
+
+			go func() {
+				_, err := fetchOpenIDConnectConfig(
+					context.WithoutCancel(ctx), logger, wellKnown, cache)
+				if err != nil {
+					logger.WarnContext(ctx, "failed to refresh cached OIDC config",
+						LogKeyError, err,
+						"url", wellKnown)
+				}
+			}()
+
+			return &conf, nil
+		}
+	}
+
+	stale := errors.Is(err, errStaleCache)
+
+	if err != nil && !stale && !errors.Is(err, os.ErrNotExist) {
+		logger.WarnContext(ctx, "ignoring cached OIDC config",
+			LogKeyError, err,
+			"url", wellKnown)
+	}
+
+	conf, fetchErr := fetchOpenIDConnectConfig(ctx, logger, wellKnown, cache)
+	if fetchErr == nil || !stale {
+		return conf, fetchErr
+	}
+
+	var staleConf OpenIDConnectConfig
+
+	err = json.Unmarshal(data, &staleConf)
+	if err != nil {
+		return nil, fetchErr
+	}
+
+	logger.WarnContext(ctx, "using stale cached OIDC config",
+		LogKeyError, fetchErr,
+		"url", wellKnown,
+		"age", time.Since(modified).Round(time.Second).String())
+
+	return &staleConf, nil
+}
+
+// localDocumentPath returns the file path of "file://" URLs and plain paths.
+func localDocumentPath(location string) (string, bool) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", false
+	}
+
+	switch u.Scheme {
+	case "file":
+		return u.Path, true
+	case "":
+		return location, true
+	}
+
+	return "", false
+}
+
+// fetchOpenIDConnectConfig fetches the discovery document and stores it in the
+// cache. Failures to store the document are only logged, as the fetch itself
+// succeeded.
+func fetchOpenIDConnectConfig(
+	ctx context.Context, logger *slog.Logger, wellKnown string,
+	cache ProviderCacheOptions,
+) (*OpenIDConnectConfig, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform request: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server responded with: %q", res.Status)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var conf OpenIDConnectConfig
+
+	err = json.Unmarshal(data, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+	}
+
+	err = cache.store("oidc", wellKnown, data)
+	if err != nil {
+		logger.WarnContext(ctx, "failed to cache OIDC config",
+			LogKeyError, err,
+			"url", wellKnown)
+	}
+
+	return &conf, nil
+}
+
+// jwksCacheTransport persists successfully fetched JWKS documents.
+type jwksCacheTransport struct {
+	next   http.RoundTripper
+	url    string
+	cache  ProviderCacheOptions
+	logger *slog.Logger
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *jwksCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK ||
+		req.URL.String() != t.url {
+		return res, err //nolint:wrapcheck
+	}
+
+	data, err := io.ReadAll(res.Body)
+
+	_ = res.Body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("read JWKS response: %w", err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(data))
+
+	var jwks jwkset.JWKSMarshal
+
+	err = json.Unmarshal(data, &jwks)
+	if err != nil || len(jwks.Keys) == 0 {
+		return res, nil
+	}
+
+	err = t.cache.store("jwks", t.url, data)
+	if err != nil {
+		t.logger.WarnContext(req.Context(), "failed to cache JWKS",
+			LogKeyError, err,
+			"url", t.url)
+	}
+
+	return res, nil
+}
+
+// cachingJWKSClient returns a copy of the client that persists the JWKS
+// documents that it fetches.
+func cachingJWKSClient(
+	client *http.Client, jwksURL string, cache ProviderCacheOptions,
+	logger *slog.Logger,
+) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	c := *client
+
+	c.Transport = &jwksCacheTransport{
+		next:   next,
+		url:    jwksURL,
+		cache:  cache,
+		logger: logger,
+	}
+
+	return &c
+}
+
+// seedJWKSStorage loads cached keys into the storage if it's empty. Returns
+// the modification time of the cache file if keys were loaded from it.
+func seedJWKSStorage(
+	ctx context.Context, storage jwkset.Storage, jwksURL string,
+	cache ProviderCacheOptions, logger *slog.Logger,
+) (time.Time, error) {
+	keys, err := storage.KeyReadAll(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read keys: %w", err)
+	}
+
+	if len(keys) > 0 {
+		return time.Time{}, nil
+	}
+
+	data, modified, err := cache.load("jwks", jwksURL)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	var jwks jwkset.JWKSMarshal
+
+	err = json.Unmarshal(data, &jwks)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse cached JWKS: %w", err)
+	}
+
+	for _, m := range jwks.Keys {
+		jwk, err := jwkset.NewJWKFromMarshal(m,
+			jwkset.JWKMarshalOptions{}, jwkset.JWKValidateOptions{})
+		if err != nil {
+			return time.Time{}, fmt.Errorf(
+				"parse cached key %q: %w", m.KID, err)
+		}
+
+		err = storage.KeyWrite(ctx, jwk)
+		if err != nil {
+			return time.Time{}, fmt.Errorf(
+				"store cached key %q: %w", m.KID, err)
+		}
+	}
+
+	logger.WarnContext(ctx, "using cached JWKS until the next successful refresh",
+		"url", jwksURL,
+		"age", time.Since(modified).Round(time.Second).String())
+
+	return modified, nil
+}
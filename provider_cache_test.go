@@ -0,0 +1,203 @@
+package elephantine_test
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestProviderCacheColdStart(t *testing.T) {
+	ctx := test.Context(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cache := elephantine.ProviderCacheOptions{
+		Dir: t.TempDir(),
+	}
+
+	key := test.NewSigningKey(t)
+
+	jwksHandler, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     key.KeyID,
+		Algorithm: key.Method.Alg(),
+		Key:       key.Public(),
+	})
+	test.Must(t, err, "create JWKS handler")
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+
+	mux.Handle("GET /jwks", jwksHandler)
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		_ = json.NewEncoder(w).Encode(elephantine.OpenIDConnectConfig{
+			Issuer:  "test",
+			JwksURI: server.URL + "/jwks",
+		})
+	})
+
+	wellKnown := server.URL + "/.well-known/openid-configuration"
+
+	load := func() (*elephantine.JWTAuthInfoParser, error) {
+		conf, err := elephantine.LoadOpenIDConnectConfig(
+			ctx, logger, wellKnown, cache)
+		if err != nil {
+			return nil, err
+		}
+
+		parser, err := elephantine.NewJWKSAuthInfoParser(ctx, conf.JwksURI,
+			elephantine.JWTAuthInfoParserOptions{
+				Issuer: conf.Issuer,
+				JWKS: elephantine.JWKSOptions{
+					Logger: logger,
+					Cache:  cache,
+				},
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		return parser, parser.Ready(ctx)
+	}
+
+	_, err = load()
+	test.Must(t, err, "start while the identity provider is available")
+
+	server.Close()
+
+	parser, err := load()
+	test.Must(t, err, "start from cache during identity provider outage")
+
+	auth, err := parser.AuthInfoFromHeader(key.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:  "test",
+				Subject: "someone",
+			},
+		}))
+	test.Must(t, err, "validate token with cached key")
+
+	test.Equal(t, "core://user/someone", auth.Claims.Subject,
+		"get the expected subject")
+
+	cache.Dir = t.TempDir()
+
+	_, err = load()
+	test.MustNot(t, err, "fail to start without cached documents")
+}
+
+func TestProviderCacheOIDCFallback(t *testing.T) {
+	ctx := test.Context(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		_ = json.NewEncoder(w).Encode(elephantine.OpenIDConnectConfig{
+			Issuer: "test",
+		})
+	}))
+
+	wellKnown := server.URL + "/.well-known/openid-configuration"
+
+	// A file in place of the cache directory makes every cache write fail.
+	blocked := filepath.Join(t.TempDir(), "blocked")
+
+	err := os.WriteFile(blocked, nil, 0o600)
+	test.Must(t, err, "create blocking file")
+
+	conf, err := elephantine.LoadOpenIDConnectConfig(ctx, logger, wellKnown,
+		elephantine.ProviderCacheOptions{Dir: blocked})
+	test.Must(t, err, "load config when the cache can't be written")
+	test.Equal(t, "test", conf.Issuer, "get the fetched config")
+
+	cache := elephantine.ProviderCacheOptions{
+		Dir:          t.TempDir(),
+		MaxStaleness: time.Hour,
+	}
+
+	_, err = elephantine.LoadOpenIDConnectConfig(ctx, logger, wellKnown, cache)
+	test.Must(t, err, "load and cache config")
+
+	server.Close()
+
+	files, err := os.ReadDir(cache.Dir)
+	test.Must(t, err, "list cache files")
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	for _, f := range files {
+		err := os.Chtimes(filepath.Join(cache.Dir, f.Name()), old, old)
+		test.Must(t, err, "age cache file")
+	}
+
+	conf, err = elephantine.LoadOpenIDConnectConfig(ctx, logger, wellKnown, cache)
+	test.Must(t, err, "fall back to stale cached config during outage")
+	test.Equal(t, "test", conf.Issuer, "get the cached config")
+}
+
+func TestProviderCacheJWKSAge(t *testing.T) {
+	ctx := test.Context(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cache := elephantine.ProviderCacheOptions{
+		Dir: t.TempDir(),
+	}
+
+	key := test.NewSigningKey(t)
+
+	jwksHandler, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     key.KeyID,
+		Algorithm: key.Method.Alg(),
+		Key:       key.Public(),
+	})
+	test.Must(t, err, "create JWKS handler")
+
+	server := httptest.NewServer(jwksHandler)
+
+	load := func() error {
+		parser, err := elephantine.NewJWKSAuthInfoParser(ctx, server.URL,
+			elephantine.JWTAuthInfoParserOptions{
+				Issuer: "test",
+				JWKS: elephantine.JWKSOptions{
+					Logger:       logger,
+					Cache:        cache,
+					MaxStaleness: time.Hour,
+				},
+			})
+		if err != nil {
+			return err
+		}
+
+		return parser.Ready(ctx)
+	}
+
+	err = load()
+	test.Must(t, err, "start while the identity provider is available")
+
+	server.Close()
+
+	err = load()
+	test.Must(t, err, "start from fresh cache during identity provider outage")
+
+	files, err := os.ReadDir(cache.Dir)
+	test.Must(t, err, "list cache files")
+
+	old := time.Now().Add(-2 * time.Hour)
+
+	for _, f := range files {
+		err := os.Chtimes(filepath.Join(cache.Dir, f.Name()), old, old)
+		test.Must(t, err, "age cache file")
+	}
+
+	err = load()
+	test.MustNot(t, err, "count the staleness of cached keys from the cache file")
+}
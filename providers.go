@@ -0,0 +1,143 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/urfave/cli/v2"
+)
+
+// ProviderSettings are the settings for several named identity providers.
+type ProviderSettings map[string]AuthenticationSettings
+
+// ProvidersCLIFlags returns the CLI flags that are needed to configure the
+// named identity providers. The flags and environment variables are the same
+// as for AuthenticationCLIFlags, but suffixed with the provider name, so the
+// provider "partner" is configured with f.ex. "--oidc-config-partner" or
+// OIDC_CONFIG_PARTNER. The cache flags are shared by all providers.
+func ProvidersCLIFlags(names ...string) []cli.Flag {
+	var flags []cli.Flag
+
+	for _, name := range names {
+		flags = append(flags, providerCLIFlags(name)...)
+	}
+
+	return append(flags, providerCacheCLIFlags()...)
+}
+
+// ProviderSettingsFromCLI reads the settings for the named providers from the
+// flags created by ProvidersCLIFlags.
+func ProviderSettingsFromCLI(c *cli.Context, names ...string) ProviderSettings {
+	settings := make(ProviderSettings, len(names))
+
+	for _, name := range names {
+		settings[name] = providerSettingsFromCLI(c, name)
+	}
+
+	return settings
+}
+
+// ProviderSettingsFromEnv reads the settings for the named providers from the
+// same environment variables as ProvidersCLIFlags uses.
+func ProviderSettingsFromEnv(names ...string) (ProviderSettings, error) {
+	settings := make(ProviderSettings, len(names))
+
+	for _, name := range names {
+		s, err := providerSettingsFromEnv(name)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", name, err)
+		}
+
+		settings[name] = s
+	}
+
+	return settings, nil
+}
+
+// AuthenticationConfigs are authentication configurations keyed by provider
+// name.
+type AuthenticationConfigs map[string]*AuthenticationConfig
+
+// NewAuthenticationConfigs creates an authentication configuration for each
+// of the providers, see NewAuthenticationConfig.
+func NewAuthenticationConfigs(
+	ctx context.Context, settings ProviderSettings,
+	paramSource ParameterSource, scopes []string,
+) (AuthenticationConfigs, error) {
+	if len(settings) == 0 {
+		return nil, errors.New("no identity providers configured")
+	}
+
+	configs := make(AuthenticationConfigs, len(settings))
+
+	for name, s := range settings {
+		conf, err := NewAuthenticationConfig(ctx, s, paramSource, scopes)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", name, err)
+		}
+
+		configs[name] = conf
+	}
+
+	return configs, nil
+}
+
+// AuthenticationConfigsFromCLI creates authentication configurations for the
+// named providers from the flags created by ProvidersCLIFlags.
+func AuthenticationConfigsFromCLI(
+	c *cli.Context, paramSource ParameterSource, scopes []string,
+	names ...string,
+) (AuthenticationConfigs, error) {
+	return NewAuthenticationConfigs(c.Context,
+		ProviderSettingsFromCLI(c, names...), paramSource, scopes)
+}
+
+// AuthParser returns an auth info parser that accepts tokens from all the
+// providers. Tokens are validated by the parser of the provider that matches
+// the iss claim.
+func (configs AuthenticationConfigs) AuthParser() AuthInfoParser {
+	parsers := make(map[string]*JWTAuthInfoParser, len(configs))
+
+	for _, conf := range configs {
+		parsers[conf.OIDCConfig.Issuer] = conf.AuthParser
+	}
+
+	return &multiProviderParser{parsers: parsers}
+}
+
+type multiProviderParser struct {
+	parsers map[string]*JWTAuthInfoParser
+}
+
+// AuthInfoFromHeader implements AuthInfoParser.
+func (p *multiProviderParser) AuthInfoFromHeader(
+	authorization string,
+) (*AuthInfo, error) {
+	if authorization == "" {
+		return nil, ErrNoAuthorization
+	}
+
+	tokenType, token, _ := strings.Cut(authorization, " ")
+
+	if strings.ToLower(tokenType) != "bearer" {
+		return nil, errors.New("only bearer tokens are supported")
+	}
+
+	var claims jwt.RegisteredClaims
+
+	// The signature is verified by the parser for the issuer.
+	_, _, err := jwt.NewParser().ParseUnverified(token, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	parser, ok := p.parsers[claims.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("untrusted issuer %q", claims.Issuer)
+	}
+
+	return parser.AuthInfoFromHeader(authorization)
+}
@@ -0,0 +1,101 @@
+package elephantine_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/urfave/cli/v2"
+)
+
+func TestProviderSettingsFromEnv(t *testing.T) {
+	internal := test.NewOIDCServer(t)
+	partner := test.NewOIDCServer(t)
+
+	t.Setenv("OIDC_CONFIG_INTERNAL", internal.WellKnownURL())
+	t.Setenv("OIDC_CONFIG_PARTNER", partner.WellKnownURL())
+	t.Setenv("JWT_AUDIENCE_PARTNER", "repository")
+	t.Setenv("JWT_AUDIENCE_OPTIONAL_PARTNER", "false")
+
+	settings, err := elephantine.ProviderSettingsFromEnv("internal", "partner")
+	test.Must(t, err, "read provider settings")
+
+	test.EqualDiff(t, []string{"repository"},
+		settings["partner"].JWTAudiences, "read the partner audience")
+	test.EqualDiff(t, []string(nil),
+		settings["internal"].JWTAudiences, "not share the audience")
+
+	configs, err := elephantine.NewAuthenticationConfigs(
+		test.Context(t), settings, nil, nil)
+	test.Must(t, err, "create authentication configs")
+
+	parser := configs.AuthParser()
+
+	auth, err := parser.AuthInfoFromHeader(internal.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "core://user/internal",
+			},
+		}))
+	test.Must(t, err, "accept a token from the internal provider")
+
+	test.Equal(t, "core://user/internal", auth.Claims.Subject,
+		"get the internal subject")
+
+	_, err = parser.AuthInfoFromHeader(partner.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "core://user/partner",
+			},
+		}))
+	test.MustNot(t, err, "reject a partner token without the audience")
+
+	auth, err = parser.AuthInfoFromHeader(partner.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:  "core://user/partner",
+				Audience: jwt.ClaimStrings{"repository"},
+			},
+		}))
+	test.Must(t, err, "accept a token from the partner provider")
+
+	test.Equal(t, "core://user/partner", auth.Claims.Subject,
+		"get the partner subject")
+
+	other := test.NewOIDCServer(t)
+
+	_, err = parser.AuthInfoFromHeader(other.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "core://user/other",
+			},
+		}))
+	test.MustNot(t, err, "reject a token from an unknown provider")
+}
+
+func TestProvidersCLIFlags(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+
+	for _, f := range elephantine.ProvidersCLIFlags("internal", "partner") {
+		test.Must(t, f.Apply(set), "apply flag %v", f.Names())
+	}
+
+	err := set.Parse([]string{
+		"--oidc-config-internal", "https://internal.example.com/.well-known/openid-configuration",
+		"--client-id-partner", "partner-client",
+		"--auth-cache-dir", "/tmp/cache",
+	})
+	test.Must(t, err, "parse flags")
+
+	settings := elephantine.ProviderSettingsFromCLI(
+		cli.NewContext(cli.NewApp(), set, nil), "internal", "partner")
+
+	test.Equal(t, "https://internal.example.com/.well-known/openid-configuration",
+		settings["internal"].OIDCConfig, "read the internal OIDC config")
+	test.Equal(t, "partner-client", settings["partner"].ClientID,
+		"read the partner client ID")
+	test.Equal(t, "/tmp/cache", settings["partner"].Cache.Dir,
+		"share the cache directory")
+}
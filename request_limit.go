@@ -0,0 +1,318 @@
+package elephantine
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twitchtv/twirp"
+	"golang.org/x/time/rate"
+)
+
+// RequestLimit is a limit of the number of requests that a caller can make
+// in a period.
+type RequestLimit struct {
+	// Requests is the number of requests that are allowed per period.
+	Requests int
+	// Per is the length of the period.
+	Per time.Duration
+}
+
+// RequestLimitStore keeps track of the requests made by callers. Use
+// NewMemoryRequestLimitStore for limits per replica, or the postgres store in
+// the pg package for limits that are shared between replicas.
+type RequestLimitStore interface {
+	// Take records a request for the key, and returns false and the time
+	// until another request would be allowed if the limit has been
+	// reached.
+	Take(ctx context.Context, key string, limit RequestLimit) (bool, time.Duration, error)
+}
+
+// RequestLimiterOptions controls the behaviour of a RequestLimiter.
+type RequestLimiterOptions struct {
+	// Store keeps track of the requests. Defaults to an in-memory store.
+	Store RequestLimitStore
+	// Default is the limit for methods that don't have a limit of their
+	// own. A zero limit means that those methods aren't limited.
+	Default RequestLimit
+	// Methods are limits per method, keyed by Twirp method name, or
+	// "Service/Method" for when the options are shared between services
+	// with overlapping method names. For HTTP handlers the key is the
+	// route pattern, f.ex. "GET /documents/{id}".
+	Methods map[string]RequestLimit
+	// TrustForwardedFor makes the limiter take the client IP from the
+	// X-Forwarded-For header. Only enable it when the server is behind a
+	// proxy that appends to the header.
+	TrustForwardedFor bool
+	// TrustedProxies is the number of proxies in front of the server that
+	// append to the X-Forwarded-For header. The client IP is the entry
+	// that many hops from the right, as the entries to the left of it are
+	// controlled by the client. Defaults to 1.
+	TrustedProxies int
+	// Logger is used to log store failures if set. Requests are allowed
+	// when the store fails.
+	Logger *slog.Logger
+}
+
+// RequestLimiter limits the number of requests that a caller can make. Callers
+// are identified by the subject of their auth info, or by their IP address if
+// they are unauthenticated.
+type RequestLimiter struct {
+	opts RequestLimiterOptions
+}
+
+// NewRequestLimiter creates a new RequestLimiter.
+func NewRequestLimiter(opts RequestLimiterOptions) *RequestLimiter {
+	if opts.Store == nil {
+		opts.Store = NewMemoryRequestLimitStore()
+	}
+
+	if opts.TrustedProxies <= 0 {
+		opts.TrustedProxies = 1
+	}
+
+	return &RequestLimiter{
+		opts: opts,
+	}
+}
+
+type clientIPCtxKey struct{}
+
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPCtxKey{}, ip)
+}
+
+// clientIP returns the client IP of the request.
+func (l *RequestLimiter) clientIP(r *http.Request) string {
+	if l.opts.TrustForwardedFor {
+		var hops []string
+
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+
+		// Use the leftmost entry if there are fewer hops than trusted
+		// proxies, as all entries then have been added by proxies.
+		idx := max(len(hops)-l.opts.TrustedProxies, 0)
+
+		if idx < len(hops) {
+			ip := strings.TrimSpace(hops[idx])
+			if ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// callerKey identifies the caller by subject, or by client IP.
+func callerKey(ctx context.Context) string {
+	auth, ok := GetAuthInfo(ctx)
+	if ok && auth.Claims.Subject != "" {
+		return "sub:" + auth.Claims.Subject
+	}
+
+	ip, _ := ctx.Value(clientIPCtxKey{}).(string)
+
+	return "ip:" + ip
+}
+
+// take checks the limit for the caller and method. Methods without a limit of
+// their own share the default limit.
+func (l *RequestLimiter) take(
+	ctx context.Context, methodKeys ...string,
+) (bool, time.Duration) {
+	limit := l.opts.Default
+	bucket := "*"
+
+	for _, k := range methodKeys {
+		ml, ok := l.opts.Methods[k]
+		if ok {
+			limit = ml
+			bucket = k
+
+			break
+		}
+	}
+
+	if limit.Requests <= 0 || limit.Per <= 0 {
+		return true, 0
+	}
+
+	ok, retryAfter, err := l.opts.Store.Take(ctx,
+		bucket+"|"+callerKey(ctx), limit)
+	if err != nil {
+		if l.opts.Logger != nil {
+			l.opts.Logger.ErrorContext(ctx, "failed to check request limit",
+				LogKeyError, err)
+		}
+
+		return true, 0
+	}
+
+	return ok, retryAfter
+}
+
+// Middleware limits requests to a HTTP handler, responding with 429 Too Many
+// Requests and a Retry-After header when the limit has been reached. The
+// method key of the request is its route pattern, so the middleware must be
+// applied to the handlers that are registered on the mux, not to the mux
+// itself.
+func (l *RequestLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withClientIP(r.Context(), l.clientIP(r))
+
+		ok, retryAfter := l.take(ctx, r.Pattern)
+		if !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// SetRequestLimiter limits the number of calls that clients can make per
+// Twirp method. Calls over the limit get a ResourceExhausted error, which is
+// sent as a 429 Too Many Requests response with a Retry-After header.
+//
+// The check runs in the RequestRouted hook and must be set after
+// SetAuthInfoValidation so that the auth info is available.
+func (so *ServiceOptions) SetRequestLimiter(l *RequestLimiter) {
+	authMiddleware := so.AuthMiddleware
+
+	// Capture the client IP for unauthenticated callers.
+	so.AuthMiddleware = func(
+		w http.ResponseWriter, r *http.Request, next http.Handler,
+	) error {
+		r = r.WithContext(withClientIP(r.Context(), l.clientIP(r)))
+
+		if authMiddleware != nil {
+			return authMiddleware(w, r, next)
+		}
+
+		next.ServeHTTP(w, r)
+
+		return nil
+	}
+
+	hooks := twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			method, _ := twirp.MethodName(ctx)
+			service, _ := twirp.ServiceName(ctx)
+
+			ok, retryAfter := l.take(ctx, service+"/"+method, method)
+			if ok {
+				return ctx, nil
+			}
+
+			seconds := retryAfterSeconds(retryAfter)
+
+			_ = twirp.SetHTTPResponseHeader(ctx, "Retry-After", seconds)
+
+			return ctx, twirp.NewError(twirp.ResourceExhausted,
+				"rate limit exceeded").
+				WithMeta("retry_after", seconds)
+		},
+	}
+
+	if so.Hooks != nil {
+		so.Hooks = twirp.ChainHooks(so.Hooks, &hooks)
+	} else {
+		so.Hooks = &hooks
+	}
+}
+
+// MemoryRequestLimitStore is a RequestLimitStore that keeps token buckets in
+// memory.
+type MemoryRequestLimitStore struct {
+	m       sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+type memoryBucket struct {
+	limiter *rate.Limiter
+	limit   RequestLimit
+	used    time.Time
+}
+
+// NewMemoryRequestLimitStore creates an in-memory request limit store.
+func NewMemoryRequestLimitStore() *MemoryRequestLimitStore {
+	return &MemoryRequestLimitStore{
+		buckets: make(map[string]*memoryBucket),
+		swept:   time.Now(),
+	}
+}
+
+// Take implements RequestLimitStore.
+func (s *MemoryRequestLimitStore) Take(
+	_ context.Context, key string, limit RequestLimit,
+) (bool, time.Duration, error) {
+	now := time.Now()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &memoryBucket{
+			limiter: rate.NewLimiter(
+				rate.Every(limit.Per/time.Duration(limit.Requests)),
+				limit.Requests),
+			limit: limit,
+		}
+
+		s.buckets[key] = b
+	}
+
+	b.used = now
+
+	res := b.limiter.ReserveN(now, 1)
+
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		res.CancelAt(now)
+
+		return false, delay, nil
+	}
+
+	return true, 0, nil
+}
+
+// sweep drops buckets that have been refilled, as they're equivalent to new
+// buckets.
+func (s *MemoryRequestLimitStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+
+	s.swept = now
+
+	for k, b := range s.buckets {
+		if now.Sub(b.used) > b.limit.Per {
+			delete(s.buckets, k)
+		}
+	}
+}
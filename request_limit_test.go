@@ -0,0 +1,157 @@
+package elephantine_test
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+)
+
+var regenerate = flag.Bool("regenerate", false, "regenerate golden files")
+
+func TestSetRequestLimiter(t *testing.T) {
+	limiter := elephantine.NewRequestLimiter(elephantine.RequestLimiterOptions{
+		Default: elephantine.RequestLimit{Requests: 2, Per: time.Minute},
+		Methods: map[string]elephantine.RequestLimit{
+			"Documents/Delete": {Requests: 1, Per: time.Minute},
+		},
+	})
+
+	var so elephantine.ServiceOptions
+
+	so.SetRequestLimiter(limiter)
+
+	// call runs the request through the auth middleware to capture the
+	// client IP, and then through the routing hooks.
+	call := func(remoteAddr string, subject string, method string) error {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/twirp/Documents/"+method, nil)
+		req.RemoteAddr = remoteAddr
+
+		var ctx context.Context
+
+		err := so.AuthMiddleware(httptest.NewRecorder(), req,
+			http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				ctx = r.Context()
+			}))
+		test.Must(t, err, "run auth middleware")
+
+		ctx = ctxsetters.WithServiceName(ctx, "Documents")
+		ctx = ctxsetters.WithMethodName(ctx, method)
+
+		if subject != "" {
+			ctx = elephantine.SetAuthInfo(ctx, &elephantine.AuthInfo{
+				Claims: elephantine.JWTClaims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject: subject,
+					},
+				},
+			})
+		}
+
+		_, err = so.Hooks.RequestRouted(ctx)
+
+		return err //nolint:wrapcheck
+	}
+
+	test.Must(t, call("10.0.0.1:1234", "core://user/1", "Get"),
+		"allow the first call")
+	test.Must(t, call("10.0.0.2:1234", "core://user/1", "Update"),
+		"allow the second call")
+	test.IsTwirpError(t, call("10.0.0.3:1234", "core://user/1", "Get"),
+		twirp.ResourceExhausted)
+
+	test.Must(t, call("10.0.0.1:1234", "core://user/2", "Get"),
+		"keep separate limits per subject")
+
+	test.Must(t, call("10.0.0.1:1234", "core://user/1", "Delete"),
+		"keep separate limits for methods with their own limit")
+	err := call("10.0.0.1:1234", "core://user/1", "Delete")
+
+	test.AssertTwirpContract(t, err, twirp.ResourceExhausted, "retry_after")
+	test.TestTwirpErrorAgainstGolden(t, *regenerate, err,
+		filepath.Join("testdata", "request_limit_error.json"))
+
+	test.Must(t, call("10.0.0.4:1234", "", "Get"),
+		"allow unauthenticated calls")
+	test.Must(t, call("10.0.0.4:5678", "", "Get"),
+		"allow unauthenticated calls")
+	test.IsTwirpError(t, call("10.0.0.4:9012", "", "Get"),
+		twirp.ResourceExhausted)
+	test.Must(t, call("10.0.0.5:1234", "", "Get"),
+		"limit unauthenticated calls by IP")
+}
+
+func TestRequestLimiterMiddleware(t *testing.T) {
+	limiter := elephantine.NewRequestLimiter(elephantine.RequestLimiterOptions{
+		Methods: map[string]elephantine.RequestLimit{
+			"GET /export": {Requests: 1, Per: time.Minute},
+		},
+	})
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /export", limiter.Middleware(http.HandlerFunc(func(
+		_ http.ResponseWriter, _ *http.Request,
+	) {
+	})))
+
+	get := func() *http.Response {
+		rec := httptest.NewRecorder()
+
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+
+		return rec.Result()
+	}
+
+	test.Equal(t, http.StatusOK, get().StatusCode, "allow the first request")
+
+	res := get()
+
+	test.Equal(t, http.StatusTooManyRequests, res.StatusCode,
+		"reject requests over the limit")
+	test.Equal(t, "60", res.Header.Get("Retry-After"),
+		"tell the client when to retry")
+}
+
+func TestRequestLimiterForwardedFor(t *testing.T) {
+	limiter := elephantine.NewRequestLimiter(elephantine.RequestLimiterOptions{
+		Default:           elephantine.RequestLimit{Requests: 1, Per: time.Minute},
+		TrustForwardedFor: true,
+	})
+
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /export", limiter.Middleware(http.HandlerFunc(func(
+		_ http.ResponseWriter, _ *http.Request,
+	) {
+	})))
+
+	get := func(forwardedFor string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/export", nil)
+
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		mux.ServeHTTP(rec, req)
+
+		return rec.Result().StatusCode
+	}
+
+	test.Equal(t, http.StatusOK, get("1.1.1.1, 10.0.0.5"),
+		"allow the first request")
+	test.Equal(t, http.StatusTooManyRequests, get("2.2.2.2, 10.0.0.5"),
+		"ignore client controlled entries")
+	test.Equal(t, http.StatusOK, get("1.1.1.1, 10.0.0.6"),
+		"limit by the address added by the proxy")
+}
@@ -0,0 +1,350 @@
+package elephantine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// EnvInheritedListeners is the environment variable that is used to pass the
+// addresses of inherited listeners to a restarted process. The listener file
+// descriptors are passed in the same order, starting at fd 3.
+const EnvInheritedListeners = "ELEPHANTINE_LISTENERS"
+
+// EnvRestartReadyFD is the environment variable that is used to pass the file
+// descriptor that a restarted process uses to signal that it's ready.
+const EnvRestartReadyFD = "ELEPHANTINE_READY_FD"
+
+// DefaultRestartReadyTimeout is the default time that a restarted process has
+// to become ready.
+const DefaultRestartReadyTimeout = 30 * time.Second
+
+// RestarterOptions controls the behaviour of a Restarter.
+type RestarterOptions struct {
+	// ReadyTimeout is how long the new process has to call Ready before
+	// the restart is aborted. Defaults to DefaultRestartReadyTimeout.
+	ReadyTimeout time.Duration
+	// Args are the arguments for the new process. Defaults to
+	// os.Args[1:].
+	Args []string
+}
+
+// Restarter implements zero-downtime in-place restarts for deployments that
+// can't rely on rolling updates, like single instance installs on bare metal
+// or VMs.
+//
+// On restart the listeners are passed to a re-executed copy of the process,
+// which starts accepting connections on them and calls Ready once it has
+// started. The restart is aborted, and the old process keeps running, if the
+// new process doesn't become ready. Only supported on unix systems.
+type Restarter struct {
+	logger *slog.Logger
+	opts   RestarterOptions
+
+	m         sync.Mutex
+	inherited map[string]*os.File
+	readyFile *os.File
+	listeners []restartListener
+}
+
+type restartListener struct {
+	addr string
+	ln   *net.TCPListener
+}
+
+// NewRestarter creates a restarter, picking up any listeners that were
+// inherited from a parent process.
+func NewRestarter(
+	logger *slog.Logger, opts RestarterOptions,
+) (*Restarter, error) {
+	if opts.ReadyTimeout == 0 {
+		opts.ReadyTimeout = DefaultRestartReadyTimeout
+	}
+
+	if opts.Args == nil {
+		opts.Args = os.Args[1:]
+	}
+
+	r := Restarter{
+		logger:    logger,
+		opts:      opts,
+		inherited: make(map[string]*os.File),
+	}
+
+	env := os.Getenv(EnvInheritedListeners)
+	readyEnv := os.Getenv(EnvRestartReadyFD)
+
+	// Don't leak the listener configuration to our own child processes.
+	for _, name := range []string{EnvInheritedListeners, EnvRestartReadyFD} {
+		err := os.Unsetenv(name)
+		if err != nil {
+			return nil, fmt.Errorf("clear %s: %w", name, err)
+		}
+	}
+
+	if env != "" {
+		for i, addr := range strings.Split(env, ",") {
+			fd := uintptr(3 + i) //nolint:gosec
+
+			r.inherited[addr] = os.NewFile(fd, "listener:"+addr)
+		}
+	}
+
+	if readyEnv != "" {
+		fd, err := strconv.ParseUint(readyEnv, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ready fd %q: %w", readyEnv, err)
+		}
+
+		r.readyFile = os.NewFile(uintptr(fd), "restart-ready")
+	}
+
+	return &r, nil
+}
+
+// Inherited returns true if the process inherited listeners from a parent.
+func (r *Restarter) Inherited() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return len(r.inherited) > 0
+}
+
+// Listen returns a TCP listener for the address, using an inherited listener
+// if available.
+func (r *Restarter) Listen(addr string) (net.Listener, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var (
+		ln  net.Listener
+		err error
+	)
+
+	f, ok := r.inherited[addr]
+	if ok {
+		delete(r.inherited, addr)
+
+		ln, err = net.FileListener(f)
+
+		// FileListener dups the file descriptor, so we should close
+		// the inherited one regardless of the outcome.
+		_ = f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf(
+				"use inherited listener for %q: %w", addr, err)
+		}
+
+		r.logger.Info("using inherited listener",
+			LogKeyAddress, addr)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("listen on %q: %w", addr, err)
+		}
+	}
+
+	tcpLn, ok := ln.(*net.TCPListener)
+	if !ok {
+		_ = ln.Close()
+
+		return nil, fmt.Errorf("%q is not a TCP listener", addr)
+	}
+
+	r.listeners = append(r.listeners, restartListener{
+		addr: addr,
+		ln:   tcpLn,
+	})
+
+	return tcpLn, nil
+}
+
+// Ready tells the parent process that this process has started and is
+// accepting connections, so that the parent can start draining. Inherited
+// listeners that haven't been claimed through Listen are closed. Ready is a
+// no-op if the process wasn't started by a restart.
+func (r *Restarter) Ready() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for addr, f := range r.inherited {
+		r.logger.Warn("closing unclaimed inherited listener",
+			LogKeyAddress, addr)
+
+		_ = f.Close()
+
+		delete(r.inherited, addr)
+	}
+
+	if r.readyFile == nil {
+		return nil
+	}
+
+	f := r.readyFile
+
+	r.readyFile = nil
+
+	_, err := f.Write([]byte{1})
+
+	_ = f.Close()
+
+	if err != nil {
+		return fmt.Errorf("signal readiness to parent: %w", err)
+	}
+
+	return nil
+}
+
+// Restart starts a new copy of the process that inherits the listeners, and
+// waits for it to call Ready. The new process is killed if it doesn't become
+// ready in time. The caller is responsible for draining and stopping the
+// current process once Restart has returned.
+func (r *Restarter) Restart() (*os.Process, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.listeners) == 0 {
+		return nil, errors.New("no listeners to pass on")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("get executable path: %w", err)
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create ready pipe: %w", err)
+	}
+
+	defer readyR.Close()
+
+	addrs := make([]string, len(r.listeners))
+	files := make([]*os.File, len(r.listeners), len(r.listeners)+1)
+
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+
+	for i, l := range r.listeners {
+		f, err := l.ln.File()
+		if err != nil {
+			_ = readyW.Close()
+
+			return nil, fmt.Errorf(
+				"get file for listener %q: %w", l.addr, err)
+		}
+
+		addrs[i] = l.addr
+		files[i] = f
+	}
+
+	readyFD := 3 + len(files)
+
+	files = append(files, readyW)
+
+	cmd := exec.Command(exe, r.opts.Args...) //nolint:gosec
+
+	cmd.Env = append(os.Environ(),
+		EnvInheritedListeners+"="+strings.Join(addrs, ","),
+		EnvRestartReadyFD+"="+strconv.Itoa(readyFD))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("start new process: %w", err)
+	}
+
+	// Close our copy of the write end so that the read fails if the
+	// child exits without signalling readiness.
+	_ = readyW.Close()
+	files[len(files)-1] = nil
+
+	err = waitForRestartReady(readyR, r.opts.ReadyTimeout)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		return nil, fmt.Errorf("new process didn't become ready: %w", err)
+	}
+
+	// Reap the process when it exits so that it doesn't linger as a
+	// zombie if this process outlives it.
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	return cmd.Process, nil
+}
+
+func waitForRestartReady(ready *os.File, timeout time.Duration) error {
+	err := ready.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+
+	buf := make([]byte, 1)
+
+	_, err = ready.Read(buf)
+	if errors.Is(err, io.EOF) {
+		return errors.New("the process exited before it was ready")
+	} else if err != nil {
+		return fmt.Errorf("wait for ready signal: %w", err)
+	}
+
+	return nil
+}
+
+// RestartOnSignal restarts the process on SIGHUP and triggers a stop of the
+// graceful shutdown once the new process is ready.
+func (r *Restarter) RestartOnSignal(gs *GracefulShutdown) {
+	signals := make(chan os.Signal, 1)
+
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-gs.ShouldStop():
+				return
+			case <-signals:
+			}
+
+			proc, err := r.Restart()
+			if err != nil {
+				r.logger.Error("failed to restart",
+					LogKeyError, err)
+
+				continue
+			}
+
+			r.logger.Warn("restarted, draining old process",
+				LogKeyPID, proc.Pid)
+
+			gs.StopWithReason(ShutdownReasonSignal,
+				errors.New("restarted on SIGHUP"))
+
+			return
+		}
+	}()
+}
@@ -0,0 +1,186 @@
+package elephantine_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+const envRestartHelper = "ELEPHANTINE_TEST_RESTART_HELPER"
+
+// TestRestartHelper is the process that is started by the restart tests.
+func TestRestartHelper(t *testing.T) {
+	mode := os.Getenv(envRestartHelper)
+	if mode == "" {
+		t.Skip("only runs as a restarted process")
+	}
+
+	r, err := elephantine.NewRestarter(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		elephantine.RestarterOptions{})
+	test.Must(t, err, "create restarter")
+
+	switch mode {
+	case "crash":
+		os.Exit(1)
+	case "api":
+		serveRestartedAPI(t, r)
+
+		return
+	}
+
+	test.Equal(t, true, r.Inherited(), "inherit listeners")
+
+	ln, err := r.Listen(os.Getenv("ELEPHANTINE_TEST_RESTART_ADDR"))
+	test.Must(t, err, "listen on inherited address")
+
+	test.Must(t, r.Ready(), "signal readiness")
+
+	conn, err := ln.Accept()
+	test.Must(t, err, "accept connection")
+
+	_, _ = fmt.Fprintf(conn, "%d\n", os.Getpid())
+	_ = conn.Close()
+}
+
+// serveRestartedAPI runs an API server on the inherited listeners until it has
+// responded to a request.
+func serveRestartedAPI(t *testing.T, r *elephantine.Restarter) {
+	t.Helper()
+
+	server := elephantine.NewAPIServer(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		os.Getenv("ELEPHANTINE_TEST_RESTART_ADDR"),
+		os.Getenv("ELEPHANTINE_TEST_RESTART_HEALTH_ADDR"))
+
+	server.Restarter = r
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server.Mux.HandleFunc("GET /pid", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintf(w, "%d\n", os.Getpid())
+
+		cancel()
+	})
+
+	err := server.ListenAndServe(ctx)
+	test.Must(t, err, "serve API")
+}
+
+// freeAddr returns a local address that isn't in use.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	test.Must(t, err, "find free port")
+
+	addr := ln.Addr().String()
+
+	_ = ln.Close()
+
+	return addr
+}
+
+func TestRestarterAPIServer(t *testing.T) {
+	apiAddr := freeAddr(t)
+	healthAddr := freeAddr(t)
+
+	t.Setenv(envRestartHelper, "api")
+	t.Setenv("ELEPHANTINE_TEST_RESTART_ADDR", apiAddr)
+	t.Setenv("ELEPHANTINE_TEST_RESTART_HEALTH_ADDR", healthAddr)
+
+	r, err := elephantine.NewRestarter(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		elephantine.RestarterOptions{
+			ReadyTimeout: 10 * time.Second,
+			Args:         []string{"-test.run=^TestRestartHelper$"},
+		})
+	test.Must(t, err, "create restarter")
+
+	for _, addr := range []string{apiAddr, healthAddr} {
+		ln, err := r.Listen(addr)
+		test.Must(t, err, "listen on %q", addr)
+
+		t.Cleanup(func() { _ = ln.Close() })
+	}
+
+	proc, err := r.Restart()
+	test.Must(t, err, "restart into an API server")
+
+	req, err := http.NewRequestWithContext(test.Context(t),
+		http.MethodGet, "http://"+apiAddr+"/pid", nil)
+	test.Must(t, err, "create request")
+
+	res, err := http.DefaultClient.Do(req)
+	test.Must(t, err, "call the restarted API server")
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	test.Must(t, err, "read response")
+
+	test.Equal(t, strconv.Itoa(proc.Pid), strings.TrimSpace(string(body)),
+		"get served by the new process")
+}
+
+func TestRestarter(t *testing.T) {
+	addr := "127.0.0.1:0"
+
+	restart := func(mode string) (*elephantine.Restarter, net.Listener) {
+		t.Helper()
+
+		t.Setenv(envRestartHelper, mode)
+		t.Setenv("ELEPHANTINE_TEST_RESTART_ADDR", addr)
+
+		r, err := elephantine.NewRestarter(
+			slog.New(slog.NewTextHandler(io.Discard, nil)),
+			elephantine.RestarterOptions{
+				ReadyTimeout: 10 * time.Second,
+				Args:         []string{"-test.run=^TestRestartHelper$"},
+			})
+		test.Must(t, err, "create restarter")
+
+		ln, err := r.Listen(addr)
+		test.Must(t, err, "listen")
+
+		t.Cleanup(func() { _ = ln.Close() })
+
+		return r, ln
+	}
+
+	r, _ := restart("crash")
+
+	_, err := r.Restart()
+	test.MustNot(t, err, "fail when the new process exits before it's ready")
+
+	r, ln := restart("serve")
+
+	proc, err := r.Restart()
+	test.Must(t, err, "restart")
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	test.Must(t, err, "connect to the shared listener")
+
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	test.Must(t, err, "read response")
+
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	test.Must(t, err, "parse pid")
+
+	test.Equal(t, proc.Pid, pid, "get served by the new process")
+}
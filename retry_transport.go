@@ -0,0 +1,219 @@
+package elephantine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryTransportMetrics are metrics for retried HTTP client requests.
+type RetryTransportMetrics struct {
+	retries *prometheus.CounterVec
+}
+
+// NewRetryTransportMetrics registers retry metrics with the provided
+// registerer.
+func NewRetryTransportMetrics(
+	registerer prometheus.Registerer,
+) (*RetryTransportMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := RetryTransportMetrics{
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_request_retries_total",
+			Help: "Number of retried client requests, by reason for the retry.",
+		}, []string{"client", "reason"}),
+	}
+
+	err := registerer.Register(m.retries)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (m *RetryTransportMetrics) retried(client string, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.retries.WithLabelValues(client, reason).Inc()
+}
+
+// RetryTransport is a http.RoundTripper that retries idempotent requests that
+// fail with connection errors, 429 Too Many Requests, or 5xx responses. The
+// Retry-After header of the response is honoured.
+//
+// Requests are considered idempotent if they use one of the methods GET, HEAD,
+// OPTIONS, TRACE, PUT, or DELETE, or if they have an Idempotency-Key header.
+// Requests with a body can only be retried if the body can be recreated
+// through GetBody, which is set by http.NewRequest for the common body types.
+// Requests that are rejected by a CircuitBreakerTransport aren't retried.
+type RetryTransport struct {
+	// Base is the underlying transport, defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+	// Name of the client, used to label metrics.
+	Name string
+	// MaxAttempts is the maximum number of attempts per request,
+	// defaults to 3.
+	MaxAttempts int
+	// Backoff controls the wait between attempts, defaults to an
+	// exponential backoff starting at 100ms, up to 5s.
+	Backoff BackoffFunction
+	// MaxRetryAfter is the longest Retry-After that will be waited for,
+	// responses that ask for a longer wait are returned as-is. Defaults to
+	// 30s.
+	MaxRetryAfter time.Duration
+	// Metrics is used to count retries if set.
+	Metrics *RetryTransportMetrics
+}
+
+// NewRetryTransport creates a new RetryTransport with default settings.
+func NewRetryTransport(
+	base http.RoundTripper, name string, metrics *RetryTransportMetrics,
+) *RetryTransport {
+	return &RetryTransport{
+		Base:    base,
+		Name:    name,
+		Metrics: metrics,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	maxAttempts := t.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+
+	backoff := t.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(100*time.Millisecond, 5*time.Second)
+	}
+
+	maxRetryAfter := t.MaxRetryAfter
+	if maxRetryAfter == 0 {
+		maxRetryAfter = 30 * time.Second
+	}
+
+	if !retryableRequest(req) {
+		return base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("recreate request body: %w", err)
+			}
+
+			// RoundTrippers must not modify the original request.
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		res, err := base.RoundTrip(r)
+
+		reason, retry := retryReason(res, err)
+		if !retry || attempt >= maxAttempts ||
+			req.Context().Err() != nil {
+			return res, err //nolint:wrapcheck
+		}
+
+		wait := backoff(attempt)
+
+		if res != nil {
+			retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"))
+			if ok && retryAfter > maxRetryAfter {
+				return res, nil
+			}
+
+			wait = max(wait, retryAfter)
+
+			// Drain the body so that the connection can be reused.
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+			_ = res.Body.Close()
+		}
+
+		t.Metrics.retried(t.Name, reason)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+
+			return nil, errors.Join(req.Context().Err(), err)
+		}
+	}
+}
+
+func retryableRequest(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+func retryReason(res *http.Response, err error) (string, bool) {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "", false
+	case err != nil:
+		return "error", true
+	case res.StatusCode == http.StatusTooManyRequests,
+		res.StatusCode >= 500 && res.StatusCode != http.StatusNotImplemented:
+		return strconv.Itoa(res.StatusCode), true
+	}
+
+	return "", false
+}
+
+// parseRetryAfter parses a Retry-After header value given as either seconds
+// or a HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+
+	when, err := http.ParseTime(value)
+	if err == nil {
+		return max(time.Until(when), 0), true
+	}
+
+	return 0, false
+}
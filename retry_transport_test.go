@@ -0,0 +1,88 @@
+package elephantine_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		n := calls.Add(1)
+
+		switch {
+		case r.URL.Path == "/later":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/flaky" && n < 3:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewRetryTransportMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	transport := elephantine.NewRetryTransport(nil, "test", metrics)
+
+	transport.Backoff = elephantine.StaticBackoff(time.Millisecond)
+
+	client := http.Client{Transport: transport}
+
+	call := func(t *testing.T, method string, path string) int {
+		t.Helper()
+
+		calls.Store(0)
+
+		req, err := http.NewRequest(method, server.URL+path,
+			strings.NewReader("{}"))
+		test.Must(t, err, "create request")
+
+		res, err := client.Do(req)
+		test.Must(t, err, "perform request")
+
+		_ = res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	test.Equal(t, http.StatusOK, call(t, http.MethodPut, "/flaky"),
+		"succeed after retries")
+	test.Equal(t, 3, int(calls.Load()), "make three attempts")
+
+	test.Equal(t, http.StatusServiceUnavailable,
+		call(t, http.MethodPost, "/flaky"),
+		"return the error response for a non-idempotent request")
+	test.Equal(t, 1, int(calls.Load()), "make a single attempt")
+
+	test.Equal(t, http.StatusBadGateway, call(t, http.MethodGet, "/broken"),
+		"give up after max attempts")
+	test.Equal(t, 3, int(calls.Load()), "make three attempts")
+
+	test.Equal(t, http.StatusTooManyRequests,
+		call(t, http.MethodGet, "/later"),
+		"return the response when retry after is too long")
+	test.Equal(t, 1, int(calls.Load()), "make a single attempt")
+
+	test.Equal(t, 2, testutil.CollectAndCount(reg,
+		"client_request_retries_total"),
+		"count the retries by reason")
+}
@@ -0,0 +1,335 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/twitchtv/twirp"
+)
+
+// HasAllScopes returns true if the Scope claim contains all of the named
+// scopes.
+func (c JWTClaims) HasAllScopes(names ...string) bool {
+	scopes := strings.Split(c.Scope, " ")
+
+	for j := range names {
+		var found bool
+
+		for i := range scopes {
+			if scopes[i] == names[j] {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequireAllScopes returns the AuthInfo for the context if it has all the
+// named scopes.
+func RequireAllScopes(ctx context.Context, scopes ...string) (*AuthInfo, error) {
+	auth, ok := GetAuthInfo(ctx)
+	if !ok {
+		return nil, twirp.Unauthenticated.Error(
+			"no anonymous access allowed")
+	}
+
+	if !auth.Claims.HasAllScopes(scopes...) {
+		return nil, twirp.WrapError(twirp.PermissionDenied.Errorf(
+			"the scopes %s are required",
+			strings.Join(scopes, ", ")), errInsufficientScope)
+	}
+
+	return auth, nil
+}
+
+// RequireScopes returns the AuthInfo for the context if its scopes satisfy the
+// scope expression.
+func RequireScopes(ctx context.Context, expr *ScopeExpression) (*AuthInfo, error) {
+	auth, ok := GetAuthInfo(ctx)
+	if !ok {
+		return nil, twirp.Unauthenticated.Error(
+			"no anonymous access allowed")
+	}
+
+	if !expr.Eval(auth.Claims) {
+		return nil, twirp.WrapError(twirp.PermissionDenied.Errorf(
+			"the scope requirement %q isn't satisfied", expr.String()),
+			errInsufficientScope)
+	}
+
+	return auth, nil
+}
+
+// ScopeExpression is a boolean expression of scope requirements, like
+// "doc_read && (doc_admin || doc_write)". Supported operators are "&&", "||",
+// "!", and parentheses for grouping. "&&" binds tighter than "||".
+type ScopeExpression struct {
+	source string
+	root   scopeNode
+}
+
+// ParseScopeExpression parses a scope expression.
+func ParseScopeExpression(expr string) (*ScopeExpression, error) {
+	tokens, err := tokenizeScopeExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := scopeParser{tokens: tokens}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d",
+			p.tokens[p.pos].value, p.tokens[p.pos].pos)
+	}
+
+	return &ScopeExpression{
+		source: expr,
+		root:   root,
+	}, nil
+}
+
+// MustParseScopeExpression parses a scope expression and panics if it's
+// invalid. Intended for package level variables.
+func MustParseScopeExpression(expr string) *ScopeExpression {
+	e, err := ParseScopeExpression(expr)
+	if err != nil {
+		panic(fmt.Errorf("invalid scope expression %q: %w", expr, err))
+	}
+
+	return e
+}
+
+// String returns the source of the expression.
+func (e *ScopeExpression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the Scope claim.
+func (e *ScopeExpression) Eval(claims JWTClaims) bool {
+	scopes := make(map[string]bool)
+
+	for _, s := range strings.Split(claims.Scope, " ") {
+		scopes[s] = true
+	}
+
+	return e.root.eval(scopes)
+}
+
+type scopeNode interface {
+	eval(scopes map[string]bool) bool
+}
+
+type scopeName string
+
+func (n scopeName) eval(scopes map[string]bool) bool {
+	return scopes[string(n)]
+}
+
+type scopeNot struct {
+	operand scopeNode
+}
+
+func (n scopeNot) eval(scopes map[string]bool) bool {
+	return !n.operand.eval(scopes)
+}
+
+type scopeAnd []scopeNode
+
+func (n scopeAnd) eval(scopes map[string]bool) bool {
+	for _, o := range n {
+		if !o.eval(scopes) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type scopeOr []scopeNode
+
+func (n scopeOr) eval(scopes map[string]bool) bool {
+	for _, o := range n {
+		if o.eval(scopes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type scopeToken struct {
+	value string
+	pos   int
+}
+
+func isScopeRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) ||
+		strings.ContainsRune("_-.:/", r)
+}
+
+func tokenizeScopeExpression(expr string) ([]scopeToken, error) {
+	var tokens []scopeToken
+
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '!':
+			tokens = append(tokens, scopeToken{value: string(r), pos: i})
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf(
+					"expected %q at position %d",
+					string([]rune{r, r}), i)
+			}
+
+			tokens = append(tokens, scopeToken{
+				value: string([]rune{r, r}), pos: i,
+			})
+			i += 2
+		case isScopeRune(r):
+			start := i
+
+			for i < len(runes) && isScopeRune(runes[i]) {
+				i++
+			}
+
+			tokens = append(tokens, scopeToken{
+				value: string(runes[start:i]), pos: start,
+			})
+		default:
+			return nil, fmt.Errorf(
+				"unexpected character %q at position %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+type scopeParser struct {
+	tokens []scopeToken
+	pos    int
+}
+
+func (p *scopeParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos].value
+}
+
+func (p *scopeParser) parseOr() (scopeNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := scopeOr{first}
+
+	for p.peek() == "||" {
+		p.pos++
+
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		operands = append(operands, n)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+
+	return operands, nil
+}
+
+func (p *scopeParser) parseAnd() (scopeNode, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := scopeAnd{first}
+
+	for p.peek() == "&&" {
+		p.pos++
+
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		operands = append(operands, n)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+
+	return operands, nil
+}
+
+func (p *scopeParser) parseUnary() (scopeNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	tok := p.tokens[p.pos]
+
+	switch tok.value {
+	case "!":
+		p.pos++
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return scopeNot{operand: operand}, nil
+	case "(":
+		p.pos++
+
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, fmt.Errorf(
+				"missing closing parenthesis for position %d",
+				tok.pos)
+		}
+
+		p.pos++
+
+		return n, nil
+	case ")", "&&", "||":
+		return nil, fmt.Errorf("unexpected %q at position %d",
+			tok.value, tok.pos)
+	}
+
+	p.pos++
+
+	return scopeName(tok.value), nil
+}
@@ -0,0 +1,53 @@
+package elephantine_test
+
+import (
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+func TestScopeExpression(t *testing.T) {
+	expr := elephantine.MustParseScopeExpression(
+		"doc_read && (doc_admin || doc_write) && !doc_banned")
+
+	cases := map[string]bool{
+		"doc_read doc_write":            true,
+		"doc_read doc_admin":            true,
+		"doc_read":                      false,
+		"doc_write doc_admin":           false,
+		"doc_read doc_write doc_banned": false,
+	}
+
+	for scope, want := range cases {
+		got := expr.Eval(elephantine.JWTClaims{Scope: scope})
+
+		test.Equal(t, want, got, "evaluate for the scopes %q", scope)
+	}
+
+	invalid := []string{
+		"", "doc_read &&", "doc_read & doc_write", "(doc_read",
+		"doc_read)", "doc_read doc_write", "|| doc_read",
+	}
+
+	for _, e := range invalid {
+		_, err := elephantine.ParseScopeExpression(e)
+		test.MustNot(t, err, "reject the expression %q", e)
+	}
+}
+
+func TestRequireAllScopes(t *testing.T) {
+	ctx := elephantine.SetAuthInfo(test.Context(t), &elephantine.AuthInfo{
+		Claims: elephantine.JWTClaims{Scope: "doc_read doc_write"},
+	})
+
+	_, err := elephantine.RequireAllScopes(ctx, "doc_read", "doc_write")
+	test.Must(t, err, "accept when all scopes are present")
+
+	_, err = elephantine.RequireAllScopes(ctx, "doc_read", "doc_admin")
+	test.IsTwirpError(t, err, twirp.PermissionDenied)
+
+	_, err = elephantine.RequireAllScopes(test.Context(t), "doc_read")
+	test.IsTwirpError(t, err, twirp.Unauthenticated)
+}
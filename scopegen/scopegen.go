@@ -0,0 +1,300 @@
+// Package scopegen generates Go constants for the scopes in a scope registry,
+// so that services don't have to repeat scope strings in code.
+//
+// A registry is a YAML file that lists scopes and named sets of scopes:
+//
+//	scopes:
+//	  - name: doc_read
+//	    description: Read documents.
+//	  - name: doc_admin
+//	    description: Administer documents.
+//	sets:
+//	  - name: DocReaders
+//	    description: Scopes that allow reading documents.
+//	    scopes: [doc_read, doc_admin]
+//
+// Every scope gets a constant named after the scope, prefixed with "Scope",
+// "doc_read" becomes ScopeDocRead. Sets are emitted as string slices that can
+// be passed to f.ex. elephantine.RequireAnyScope(ctx, DocReaders...).
+package scopegen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Registry is a list of scopes and scope sets.
+type Registry struct {
+	Scopes []Scope `yaml:"scopes"`
+	Sets   []Set   `yaml:"sets"`
+}
+
+// Scope is a scope in the registry.
+type Scope struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Const overrides the generated constant name.
+	Const string `yaml:"const"`
+}
+
+// Set is a named set of scopes.
+type Set struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Scopes      []string `yaml:"scopes"`
+}
+
+// ParseRegistry reads and validates a YAML scope registry.
+func ParseRegistry(r io.Reader) (*Registry, error) {
+	dec := yaml.NewDecoder(r)
+
+	dec.KnownFields(true)
+
+	var reg Registry
+
+	err := dec.Decode(&reg)
+	if err != nil {
+		return nil, fmt.Errorf("parse registry: %w", err)
+	}
+
+	err = reg.normalise()
+	if err != nil {
+		return nil, err
+	}
+
+	return &reg, nil
+}
+
+// normalise sets the constant names and validates the registry.
+func (reg *Registry) normalise() error {
+	scopes := make(map[string]bool, len(reg.Scopes))
+	idents := map[string]string{
+		"AllScopes":         "the generated code",
+		"ScopeDescriptions": "the generated code",
+		"IsKnownScope":      "the generated code",
+	}
+
+	claimIdent := func(ident string, owner string) error {
+		if !token.IsIdentifier(ident) || !token.IsExported(ident) {
+			return fmt.Errorf("%s: %q is not an exported Go identifier",
+				owner, ident)
+		}
+
+		if other, ok := idents[ident]; ok {
+			return fmt.Errorf("%s: the name %q is already used by %s",
+				owner, ident, other)
+		}
+
+		idents[ident] = owner
+
+		return nil
+	}
+
+	for i := range reg.Scopes {
+		s := &reg.Scopes[i]
+
+		if s.Name == "" {
+			return fmt.Errorf("scope %d: missing name", i+1)
+		}
+
+		if strings.IndexFunc(s.Name, invalidScopeRune) != -1 {
+			return fmt.Errorf("scope %q: invalid characters in name", s.Name)
+		}
+
+		if scopes[s.Name] {
+			return fmt.Errorf("scope %q: duplicate scope", s.Name)
+		}
+
+		scopes[s.Name] = true
+
+		s.Description = strings.TrimSpace(s.Description)
+
+		if s.Const == "" {
+			s.Const = "Scope" + camelCase(s.Name)
+		}
+
+		err := claimIdent(s.Const, fmt.Sprintf("scope %q", s.Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range reg.Sets {
+		set := &reg.Sets[i]
+
+		set.Description = strings.TrimSpace(set.Description)
+
+		if set.Name == "" {
+			return fmt.Errorf("set %d: missing name", i+1)
+		}
+
+		owner := fmt.Sprintf("set %q", set.Name)
+
+		err := claimIdent(set.Name, owner)
+		if err != nil {
+			return err
+		}
+
+		if len(set.Scopes) == 0 {
+			return fmt.Errorf("%s: no scopes", owner)
+		}
+
+		for _, name := range set.Scopes {
+			if !scopes[name] {
+				return fmt.Errorf("%s: unknown scope %q", owner, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func invalidScopeRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) &&
+		!strings.ContainsRune("_-.:/", r)
+}
+
+// camelCase converts a scope name like "doc_read" or "doc:read" to
+// "DocRead".
+func camelCase(name string) string {
+	var b strings.Builder
+
+	upper := true
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+
+			continue
+		}
+
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Options controls code generation.
+type Options struct {
+	// Package is the name of the generated package.
+	Package string
+	// Source is mentioned in the generated file header.
+	Source string
+}
+
+// Generate writes the Go source for the registry.
+func Generate(w io.Writer, reg *Registry, opts Options) error {
+	if opts.Package == "" {
+		return errors.New("missing package name")
+	}
+
+	consts := make(map[string]string, len(reg.Scopes))
+
+	for _, s := range reg.Scopes {
+		consts[s.Name] = s.Const
+	}
+
+	var buf bytes.Buffer
+
+	err := fileTemplate.Execute(&buf, templateData{
+		Options:  opts,
+		Registry: reg,
+		Consts:   consts,
+	})
+	if err != nil {
+		return fmt.Errorf("render template: %w", err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("format generated code: %w", err)
+	}
+
+	_, err = w.Write(src)
+	if err != nil {
+		return fmt.Errorf("write generated code: %w", err)
+	}
+
+	return nil
+}
+
+type templateData struct {
+	Options  Options
+	Registry *Registry
+	Consts   map[string]string
+}
+
+var fileTemplate = template.Must(template.New("scopes").Funcs(template.FuncMap{
+	"comment": comment,
+}).Parse(`// Code generated by scopegen{{with .Options.Source}} from {{.}}{{end}}. DO NOT EDIT.
+
+package {{.Options.Package}}
+
+// Scopes in the registry.
+const (
+{{- range .Registry.Scopes}}
+{{- with comment .Description}}
+	{{.}}
+{{- end}}
+	{{.Const}} = {{printf "%q" .Name}}
+{{- end}}
+)
+
+// AllScopes contains all the scopes in the registry.
+var AllScopes = []string{
+{{- range .Registry.Scopes}}
+	{{.Const}},
+{{- end}}
+}
+{{range .Registry.Sets}}
+{{comment .Description}}
+var {{.Name}} = []string{
+{{- range .Scopes}}
+	{{index $.Consts .}},
+{{- end}}
+}
+{{end}}
+// ScopeDescriptions maps scopes to their descriptions.
+var ScopeDescriptions = map[string]string{
+{{- range .Registry.Scopes}}
+	{{.Const}}: {{printf "%q" .Description}},
+{{- end}}
+}
+
+// IsKnownScope returns true if the scope is in the registry.
+func IsKnownScope(scope string) bool {
+	_, ok := ScopeDescriptions[scope]
+
+	return ok
+}
+`))
+
+// comment formats a description as a comment, or returns an empty string if
+// there is no description.
+func comment(description string) string {
+	if description == "" {
+		return ""
+	}
+
+	lines := strings.Split(description, "\n")
+
+	for i := range lines {
+		lines[i] = strings.TrimRight("// "+lines[i], " ")
+	}
+
+	return strings.Join(lines, "\n")
+}
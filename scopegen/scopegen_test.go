@@ -0,0 +1,79 @@
+package scopegen_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ttab/elephantine/scopegen"
+	"github.com/ttab/elephantine/test"
+)
+
+var regenerate = flag.Bool("regenerate", false, "regenerate golden files")
+
+func TestGenerate(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "scopes.yaml"))
+	test.Must(t, err, "open registry")
+
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+
+	reg, err := scopegen.ParseRegistry(f)
+	test.Must(t, err, "parse registry")
+
+	out := t.TempDir()
+
+	gen, err := os.Create(filepath.Join(out, "scopes_gen.go"))
+	test.Must(t, err, "create output file")
+
+	err = scopegen.Generate(gen, reg, scopegen.Options{
+		Package: "scopes",
+		Source:  "scopes.yaml",
+	})
+	test.Must(t, err, "generate code")
+
+	test.Must(t, gen.Close(), "close output file")
+
+	test.TestDirAgainstGolden(t, *regenerate,
+		out, filepath.Join("testdata", "golden"))
+}
+
+func TestParseRegistryErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknown_set_scope": `
+scopes:
+  - name: doc_read
+sets:
+  - name: Readers
+    scopes: [doc_reed]`,
+		"duplicate_scope": `
+scopes:
+  - name: doc_read
+  - name: doc_read`,
+		"constant_collision": `
+scopes:
+  - name: doc_read
+  - name: doc:read`,
+		"reserved_name": `
+scopes:
+  - name: doc_read
+    const: AllScopes`,
+		"invalid_scope_name": `
+scopes:
+  - name: doc read`,
+		"unknown_field": `
+scopes:
+  - name: doc_read
+    descripton: Read documents.`,
+	}
+
+	for name, registry := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := scopegen.ParseRegistry(strings.NewReader(registry))
+			test.MustNot(t, err, "parse invalid registry")
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// Code generated by scopegen from scopes.yaml. DO NOT EDIT.
+
+package scopes
+
+// Scopes in the registry.
+const (
+	// Read documents.
+	ScopeDocRead = "doc_read"
+	// Create and update documents.
+	// Implies nothing about reading.
+	ScopeDocWrite = "doc_write"
+	// Administer documents.
+	ScopeDocAdmin = "doc_admin"
+	ScopeEventlog = "eventlog:read"
+)
+
+// AllScopes contains all the scopes in the registry.
+var AllScopes = []string{
+	ScopeDocRead,
+	ScopeDocWrite,
+	ScopeDocAdmin,
+	ScopeEventlog,
+}
+
+// Scopes that allow reading documents.
+var DocReaders = []string{
+	ScopeDocRead,
+	ScopeDocAdmin,
+}
+
+var DocWriters = []string{
+	ScopeDocWrite,
+	ScopeDocAdmin,
+}
+
+// ScopeDescriptions maps scopes to their descriptions.
+var ScopeDescriptions = map[string]string{
+	ScopeDocRead:  "Read documents.",
+	ScopeDocWrite: "Create and update documents.\nImplies nothing about reading.",
+	ScopeDocAdmin: "Administer documents.",
+	ScopeEventlog: "",
+}
+
+// IsKnownScope returns true if the scope is in the registry.
+func IsKnownScope(scope string) bool {
+	_, ok := ScopeDescriptions[scope]
+
+	return ok
+}
@@ -0,0 +1,142 @@
+package elephantine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultSecretTimeout is the default timeout for Vault and SSM operations.
+const DefaultSecretTimeout = 30 * time.Second
+
+// SecretSourceOptions controls the behaviour of the Vault and SSM parameter
+// sources.
+type SecretSourceOptions struct {
+	// Timeout is applied to every operation against the secret store, so
+	// that a hanging store doesn't block startup indefinitely. Defaults
+	// to DefaultSecretTimeout.
+	Timeout time.Duration
+	// Metrics is used to instrument the operations if set.
+	Metrics *SecretFetchMetrics
+	// RenewFraction is the fraction of the Vault login lease that should
+	// have passed before it's renewed. Defaults to DefaultRenewFraction.
+	RenewFraction float64
+	// RenewJitter randomly shifts the renewal by up to this fraction of
+	// the renewal delay, so that replicas that logged in at the same time
+	// don't renew at the same time. Defaults to DefaultRenewJitter, a
+	// negative value disables jitter.
+	RenewJitter float64
+	// RenewMaxBackoff caps the wait between failed renewal attempts.
+	// Defaults to 30s.
+	RenewMaxBackoff time.Duration
+}
+
+// Defaults for Vault lease renewal.
+const (
+	DefaultRenewFraction = 1.0 / 3
+	DefaultRenewJitter   = 0.1
+)
+
+func (opts SecretSourceOptions) withDefaults() SecretSourceOptions {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultSecretTimeout
+	}
+
+	if opts.RenewFraction == 0 {
+		opts.RenewFraction = DefaultRenewFraction
+	}
+
+	if opts.RenewJitter == 0 {
+		opts.RenewJitter = DefaultRenewJitter
+	}
+
+	if opts.RenewMaxBackoff == 0 {
+		opts.RenewMaxBackoff = 30 * time.Second
+	}
+
+	return opts
+}
+
+// SecretFetchMetrics are metrics for secret store operations.
+type SecretFetchMetrics struct {
+	duration    *prometheus.HistogramVec
+	failures    *prometheus.CounterVec
+	leaseExpiry *prometheus.GaugeVec
+}
+
+// NewSecretFetchMetrics registers secret store metrics with the provided
+// registerer.
+func NewSecretFetchMetrics(
+	registerer prometheus.Registerer,
+) (*SecretFetchMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := SecretFetchMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "secret_fetch_duration_seconds",
+			Help:    "Duration of secret store operations.",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"source", "operation"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "secret_fetch_failures_total",
+			Help: "Number of failed secret store operations.",
+		}, []string{"source", "operation"}),
+		leaseExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "secret_lease_expiry_timestamp_seconds",
+			Help: "Expiry time of the secret store login lease, subtract time() to get the remaining TTL.",
+		}, []string{"source"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.duration, m.failures, m.leaseExpiry,
+	}
+
+	for i, c := range collectors {
+		err := registerer.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+// secretOperation runs fn with the configured timeout and records metrics for
+// the operation.
+func secretOperation[T any](
+	ctx context.Context, opts SecretSourceOptions,
+	source string, operation string,
+	fn func(ctx context.Context) (T, error),
+) (T, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	v, err := fn(ctx)
+
+	if opts.Metrics != nil {
+		opts.Metrics.duration.WithLabelValues(source, operation).
+			Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			opts.Metrics.failures.WithLabelValues(source, operation).Inc()
+		}
+	}
+
+	return v, err
+}
+
+func (m *SecretFetchMetrics) leaseExpires(source string, expires time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.leaseExpiry.WithLabelValues(source).Set(float64(expires.Unix()))
+}
@@ -0,0 +1,378 @@
+package elephantine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShadowTransportMetrics are metrics for mirrored HTTP client requests.
+type ShadowTransportMetrics struct {
+	requests *prometheus.CounterVec
+}
+
+// NewShadowTransportMetrics registers shadow traffic metrics with the provided
+// registerer.
+func NewShadowTransportMetrics(
+	registerer prometheus.Registerer,
+) (*ShadowTransportMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := ShadowTransportMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "client_shadow_requests_total",
+			Help: "Number of requests mirrored to a shadow endpoint, by outcome.",
+		}, []string{"client", "outcome"}),
+	}
+
+	err := registerer.Register(m.requests)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+// Outcomes of shadow requests.
+const (
+	shadowOutcomeSent     = "sent"
+	shadowOutcomeMatch    = "match"
+	shadowOutcomeDiverged = "diverged"
+	shadowOutcomeError    = "error"
+	shadowOutcomeDropped  = "dropped"
+)
+
+func (m *ShadowTransportMetrics) outcome(client string, outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(client, outcome).Inc()
+}
+
+// ShadowResponse is a response that is passed to a ShadowCompareFunc.
+type ShadowResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Truncated is set if the body was larger than the max body size, or
+	// if the body of the primary response wasn't read to the end.
+	Truncated bool
+}
+
+// ShadowCompareFunc compares the response of the primary request with the
+// response of the shadow request, and returns false if they diverge.
+type ShadowCompareFunc func(req *http.Request, primary, shadow ShadowResponse) bool
+
+// ShadowOptions controls the behaviour of a ShadowTransport.
+type ShadowOptions struct {
+	// Name of the client, used to label metrics.
+	Name string
+	// Target is the shadow endpoint, the scheme and host of mirrored
+	// requests are replaced with the ones in the URL.
+	Target *url.URL
+	// Percentage of the requests that are mirrored, between 0 and 100.
+	Percentage float64
+	// Compare is used to compare responses if set, otherwise the shadow
+	// responses are discarded.
+	Compare ShadowCompareFunc
+	// MaxBodySize is the largest response body that will be captured for
+	// comparison. Defaults to 1MiB.
+	MaxBodySize int64
+	// MaxConcurrent is the maximum number of shadow requests in flight,
+	// requests are not mirrored while the limit is reached. Defaults to 10.
+	MaxConcurrent int
+	// Timeout for shadow requests. Defaults to 10s.
+	Timeout time.Duration
+	// Transport is used to send the shadow requests, defaults to the base
+	// transport.
+	Transport http.RoundTripper
+	// Logger is used to log failed shadow requests if set.
+	Logger *slog.Logger
+	// Metrics is used to count shadow requests if set.
+	Metrics *ShadowTransportMetrics
+}
+
+// ShadowTransport is a http.RoundTripper that mirrors a percentage of the
+// requests to a shadow endpoint, f.ex. a new version of a service. Shadow
+// requests are sent asynchronously and never affect the primary request.
+//
+// Requests with a body are only mirrored if the body can be recreated through
+// GetBody. Mirrored requests keep all their headers, including credentials, so
+// the shadow endpoint must be trusted like the primary.
+type ShadowTransport struct {
+	base http.RoundTripper
+	opts ShadowOptions
+	sem  chan struct{}
+}
+
+// NewShadowTransport creates a shadow transport that wraps the base transport.
+// The base defaults to http.DefaultTransport.
+func NewShadowTransport(
+	base http.RoundTripper, opts ShadowOptions,
+) *ShadowTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+
+	if opts.MaxConcurrent == 0 {
+		opts.MaxConcurrent = 10
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	if opts.Transport == nil {
+		opts.Transport = base
+	}
+
+	return &ShadowTransport{
+		base: base,
+		opts: opts,
+		sem:  make(chan struct{}, opts.MaxConcurrent),
+	}
+}
+
+// Shadow wraps the transport of the client with a ShadowTransport.
+func Shadow(opts ShadowOptions) HTTPClientOption {
+	return WrapTransport(func(base http.RoundTripper) http.RoundTripper {
+		return NewShadowTransport(base, opts)
+	})
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ShadowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.sample(req) {
+		return t.base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	select {
+	case t.sem <- struct{}{}:
+	default:
+		t.opts.Metrics.outcome(t.opts.Name, shadowOutcomeDropped)
+
+		return t.base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	shadowReq, cancel, err := t.shadowRequest(req)
+	if err != nil {
+		<-t.sem
+
+		t.failed(req, err)
+
+		return t.base.RoundTrip(req) //nolint:wrapcheck
+	}
+
+	res, err := t.base.RoundTrip(req)
+
+	var primary chan ShadowResponse
+
+	if err == nil && t.opts.Compare != nil {
+		primary = make(chan ShadowResponse, 1)
+
+		res.Body = &shadowCaptureBody{
+			ReadCloser: res.Body,
+			res:        res,
+			limit:      t.opts.MaxBodySize,
+			done:       primary,
+		}
+	}
+
+	go func() {
+		defer func() { <-t.sem }()
+		defer cancel()
+
+		t.mirror(req, shadowReq, primary)
+	}()
+
+	return res, err //nolint:wrapcheck
+}
+
+func (t *ShadowTransport) sample(req *http.Request) bool {
+	if t.opts.Target == nil || t.opts.Percentage <= 0 {
+		return false
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	return t.opts.Percentage >= 100 ||
+		rand.Float64()*100 < t.opts.Percentage //nolint:gosec
+}
+
+// shadowRequest creates a copy of the request that targets the shadow
+// endpoint. The shadow request isn't cancelled together with the primary
+// request.
+func (t *ShadowTransport) shadowRequest(
+	req *http.Request,
+) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(
+		context.WithoutCancel(req.Context()), t.opts.Timeout)
+
+	r := req.Clone(ctx)
+
+	r.URL.Scheme = t.opts.Target.Scheme
+	r.URL.Host = t.opts.Target.Host
+	r.Host = ""
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+
+			return nil, nil, fmt.Errorf("recreate request body: %w", err)
+		}
+
+		r.Body = body
+	}
+
+	return r, cancel, nil
+}
+
+func (t *ShadowTransport) mirror(
+	req *http.Request, shadowReq *http.Request, primary chan ShadowResponse,
+) {
+	res, err := t.opts.Transport.RoundTrip(shadowReq)
+	if err != nil {
+		t.failed(req, err)
+
+		return
+	}
+
+	defer res.Body.Close()
+
+	if primary == nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, t.opts.MaxBodySize))
+
+		t.opts.Metrics.outcome(t.opts.Name, shadowOutcomeSent)
+
+		return
+	}
+
+	shadow, err := captureShadowResponse(res, t.opts.MaxBodySize)
+	if err != nil {
+		t.failed(req, err)
+
+		return
+	}
+
+	var p ShadowResponse
+
+	select {
+	case p = <-primary:
+	case <-shadowReq.Context().Done():
+		t.failed(req, errors.New("timed out waiting for the primary response body"))
+
+		return
+	}
+
+	if !t.opts.Compare(req, p, shadow) {
+		t.opts.Metrics.outcome(t.opts.Name, shadowOutcomeDiverged)
+
+		return
+	}
+
+	t.opts.Metrics.outcome(t.opts.Name, shadowOutcomeMatch)
+}
+
+func (t *ShadowTransport) failed(req *http.Request, err error) {
+	t.opts.Metrics.outcome(t.opts.Name, shadowOutcomeError)
+
+	if t.opts.Logger != nil {
+		t.opts.Logger.Warn("shadow request failed",
+			LogKeyName, t.opts.Name,
+			LogKeyURL, req.URL.String(),
+			LogKeyError, err,
+		)
+	}
+}
+
+func captureShadowResponse(res *http.Response, limit int64) (ShadowResponse, error) {
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return ShadowResponse{}, fmt.Errorf("read shadow response: %w", err)
+	}
+
+	truncated := int64(len(body)) > limit
+	if truncated {
+		body = body[:limit]
+	}
+
+	return ShadowResponse{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+		Truncated:  truncated,
+	}, nil
+}
+
+// shadowCaptureBody captures the primary response body as it's read by the
+// caller, and delivers it for comparison once it has been read or closed.
+type shadowCaptureBody struct {
+	io.ReadCloser
+
+	res   *http.Response
+	limit int64
+	done  chan ShadowResponse
+
+	once      sync.Once
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *shadowCaptureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	room := b.limit - int64(b.buf.Len())
+
+	switch {
+	case int64(n) <= room:
+		b.buf.Write(p[:n])
+	default:
+		b.buf.Write(p[:room])
+		b.truncated = true
+	}
+
+	if errors.Is(err, io.EOF) {
+		b.deliver(b.truncated)
+	}
+
+	return n, err //nolint:wrapcheck
+}
+
+func (b *shadowCaptureBody) Close() error {
+	// Bodies that are closed before EOF are incomplete.
+	b.deliver(true)
+
+	return b.ReadCloser.Close() //nolint:wrapcheck
+}
+
+func (b *shadowCaptureBody) deliver(truncated bool) {
+	b.once.Do(func() {
+		b.done <- ShadowResponse{
+			StatusCode: b.res.StatusCode,
+			Header:     b.res.Header,
+			Body:       b.buf.Bytes(),
+			Truncated:  truncated,
+		}
+	})
+}
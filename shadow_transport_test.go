@@ -0,0 +1,102 @@
+package elephantine_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestShadowTransport(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		body, _ := io.ReadAll(r.Body)
+
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body)))
+	}))
+
+	t.Cleanup(primary.Close)
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		body, _ := io.ReadAll(r.Body)
+
+		if r.URL.Path == "/rewritten" {
+			_, _ = w.Write([]byte("something else"))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body)))
+	}))
+
+	t.Cleanup(shadow.Close)
+
+	target, err := url.Parse(shadow.URL)
+	test.Must(t, err, "parse shadow URL")
+
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewShadowTransportMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	client := elephantine.NewHTTPClient(5*time.Second,
+		elephantine.Shadow(elephantine.ShadowOptions{
+			Name:       "documents",
+			Target:     target,
+			Percentage: 100,
+			Compare: func(
+				_ *http.Request, p, s elephantine.ShadowResponse,
+			) bool {
+				return p.StatusCode == s.StatusCode &&
+					bytes.Equal(p.Body, s.Body)
+			},
+			Metrics: metrics,
+		}))
+
+	for _, path := range []string{"/same", "/rewritten"} {
+		res, err := client.Post(primary.URL+path, "text/plain",
+			strings.NewReader("payload"))
+		test.Must(t, err, "perform request")
+
+		body, err := io.ReadAll(res.Body)
+		test.Must(t, err, "read response")
+
+		_ = res.Body.Close()
+
+		test.Equal(t, path+" payload", string(body),
+			"get the primary response")
+	}
+
+	expected := `
+# HELP client_shadow_requests_total Number of requests mirrored to a shadow endpoint, by outcome.
+# TYPE client_shadow_requests_total counter
+client_shadow_requests_total{client="documents",outcome="diverged"} 1
+client_shadow_requests_total{client="documents",outcome="match"} 1
+`
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		err = testutil.GatherAndCompare(reg, strings.NewReader(expected),
+			"client_shadow_requests_total")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	test.Must(t, err, "record the divergence of the shadow responses")
+}
@@ -0,0 +1,154 @@
+package elephantine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// SnapshotFunc returns the current state of a component for inclusion in the
+// "/debug/snapshot" document. The value must be JSON serialisable.
+type SnapshotFunc func(ctx context.Context) (any, error)
+
+// DefaultSnapshotTimeout is the time that snapshot functions get to respond.
+const DefaultSnapshotTimeout = 5 * time.Second
+
+var processStart = time.Now()
+
+// RuntimeSnapshot describes the state of the Go runtime.
+type RuntimeSnapshot struct {
+	Uptime        string  `json:"uptime"`
+	Goroutines    int     `json:"goroutines"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	NumCPU        int     `json:"num_cpu"`
+	HeapAlloc     uint64  `json:"heap_alloc"`
+	HeapInuse     uint64  `json:"heap_inuse"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	StackInuse    uint64  `json:"stack_inuse"`
+	Sys           uint64  `json:"sys"`
+	NumGC         uint32  `json:"num_gc"`
+	LastGC        string  `json:"last_gc,omitempty"`
+	PauseTotalNs  uint64  `json:"pause_total_ns"`
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+// GetRuntimeSnapshot returns a snapshot of the Go runtime state.
+func GetRuntimeSnapshot() RuntimeSnapshot {
+	var mem runtime.MemStats
+
+	runtime.ReadMemStats(&mem)
+
+	snap := RuntimeSnapshot{
+		Uptime:        time.Since(processStart).Round(time.Second).String(),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapInuse:     mem.HeapInuse,
+		HeapObjects:   mem.HeapObjects,
+		StackInuse:    mem.StackInuse,
+		Sys:           mem.Sys,
+		NumGC:         mem.NumGC,
+		PauseTotalNs:  mem.PauseTotalNs,
+		GCCPUFraction: mem.GCCPUFraction,
+	}
+
+	if mem.LastGC != 0 {
+		snap.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339) //nolint:gosec
+	}
+
+	return snap
+}
+
+// AddSnapshotSource adds a named component to the "/debug/snapshot" document.
+// The Go runtime is always included as "runtime".
+func (s *HealthServer) AddSnapshotSource(name string, fn SnapshotFunc) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.snapshotSources[name] = fn
+}
+
+type snapshotEntry struct {
+	Value any    `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *HealthServer) snapshotHandler(
+	w http.ResponseWriter, req *http.Request,
+) {
+	ctx, cancel := context.WithTimeout(req.Context(), DefaultSnapshotTimeout)
+	defer cancel()
+
+	s.m.Lock()
+
+	sources := make(map[string]SnapshotFunc, len(s.snapshotSources))
+
+	for name, fn := range s.snapshotSources {
+		sources[name] = fn
+	}
+
+	s.m.Unlock()
+
+	type sourceResult struct {
+		name  string
+		entry snapshotEntry
+	}
+
+	// Buffered so that sources that respond after the timeout don't block.
+	results := make(chan sourceResult, len(sources))
+
+	for name, fn := range sources {
+		go func() {
+			var entry snapshotEntry
+
+			v, err := fn(ctx)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Value = v
+			}
+
+			results <- sourceResult{name: name, entry: entry}
+		}()
+	}
+
+	result := make(map[string]snapshotEntry, len(sources)+1)
+
+	result["runtime"] = snapshotEntry{Value: GetRuntimeSnapshot()}
+
+collect:
+	for range sources {
+		select {
+		case r := <-results:
+			result[r.name] = r.entry
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	// Sources that ignored the context are reported as timed out.
+	for name := range sources {
+		if _, ok := result[name]; !ok {
+			result[name] = snapshotEntry{
+				Error: "timed out: " + ctx.Err().Error(),
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+
+	enc.SetIndent("", "  ")
+
+	_ = enc.Encode(struct {
+		Time       time.Time                `json:"time"`
+		Components map[string]snapshotEntry `json:"components"`
+	}{
+		Time:       time.Now().UTC(),
+		Components: result,
+	})
+}
@@ -0,0 +1,310 @@
+package elephantine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEEvent is an event received from a server-sent events stream.
+type SSEEvent struct {
+	// ID is the event ID, or the ID of the last event that had an ID.
+	ID string
+	// Event is the event type, defaults to "message".
+	Event string
+	// Data is the event data, multiple data lines are joined by
+	// newlines.
+	Data string
+}
+
+// SSEClientOptions controls the behaviour of a SSEClient.
+type SSEClientOptions struct {
+	// Client is used to connect to the stream. Defaults to a client
+	// created with NewHTTPClient without a timeout, the timeout of the
+	// client applies to the whole stream and should be left unset.
+	Client *http.Client
+	// Header is added to every request, f.ex. for authorization.
+	Header http.Header
+	// LastEventID is the ID of the last event that was processed, the
+	// stream will resume after it.
+	LastEventID string
+	// HeartbeatTimeout is how long the client waits for an event or a
+	// comment before it reconnects. Defaults to 1m.
+	HeartbeatTimeout time.Duration
+	// Backoff controls the wait between reconnects. Defaults to an
+	// exponential backoff starting at 500ms, up to 30s. A retry interval
+	// sent by the server is used as the minimum wait.
+	Backoff BackoffFunction
+	// MaxLineSize is the longest line that the client accepts, a larger
+	// line makes Run fail, as reconnecting would only resume at the same
+	// event. Defaults to 1MiB.
+	MaxLineSize int
+	// Logger is used to log reconnects. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// SSEClient consumes a server-sent events stream, reconnecting with the
+// Last-Event-ID header when the connection is lost or the stream goes quiet
+// for longer than the heartbeat timeout:
+//
+//	client := elephantine.NewSSEClient(feedURL, elephantine.SSEClientOptions{
+//		LastEventID: checkpoint,
+//	})
+//
+//	go func() {
+//		for evt := range client.Events() {
+//			// ...handle the event...
+//		}
+//	}()
+//
+//	err := client.Run(ctx)
+type SSEClient struct {
+	url    string
+	opts   SSEClientOptions
+	events chan SSEEvent
+
+	m       sync.Mutex
+	lastID  string
+	retryIn time.Duration
+}
+
+// NewSSEClient creates a client for the stream at the URL.
+func NewSSEClient(streamURL string, opts SSEClientOptions) *SSEClient {
+	if opts.Client == nil {
+		opts.Client = NewHTTPClient(0)
+	}
+
+	if opts.HeartbeatTimeout == 0 {
+		opts.HeartbeatTimeout = time.Minute
+	}
+
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff(500*time.Millisecond, 30*time.Second)
+	}
+
+	if opts.MaxLineSize == 0 {
+		opts.MaxLineSize = 1 << 20
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &SSEClient{
+		url:    streamURL,
+		opts:   opts,
+		events: make(chan SSEEvent),
+		lastID: opts.LastEventID,
+	}
+}
+
+// Events returns the channel that events are delivered on. The channel is
+// closed when Run returns.
+func (c *SSEClient) Events() <-chan SSEEvent {
+	return c.events
+}
+
+// LastEventID returns the ID of the last event that was delivered.
+func (c *SSEClient) LastEventID() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.lastID
+}
+
+// Run connects to the stream and delivers events until the context is
+// cancelled, or the server ends the stream with 204 No Content. Client errors,
+// other than 408 Request Timeout and 429 Too Many Requests, are returned as a
+// HTTPError, and lines longer than the max line size as an error wrapping
+// bufio.ErrTooLong. Other failures lead to a reconnect.
+func (c *SSEClient) Run(ctx context.Context) error {
+	defer close(c.events)
+
+	for attempt := 1; ; attempt++ {
+		received, err := c.stream(ctx)
+
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, errSSEStreamEnded):
+			return nil
+		case isPermanentSSEError(err):
+			return err
+		}
+
+		if received {
+			attempt = 1
+		}
+
+		c.m.Lock()
+		wait := max(c.opts.Backoff(attempt), c.retryIn)
+		c.m.Unlock()
+
+		c.opts.Logger.WarnContext(ctx, "reconnecting to event stream",
+			LogKeyError, err,
+			LogKeyURL, c.url,
+			LogKeyAttempts, attempt,
+			LogKeyDelay, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+var errSSEStreamEnded = errors.New("the server ended the stream")
+
+func isPermanentSSEError(err error) bool {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return true
+	}
+
+	var httpErr *HTTPError
+
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	code := httpErr.StatusCode
+
+	return code >= 400 && code < 500 &&
+		code != http.StatusRequestTimeout &&
+		code != http.StatusTooManyRequests
+}
+
+// stream reads events from a single connection. Returns true if any events
+// were received.
+func (c *SSEClient) stream(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("create request: %w", err)
+	}
+
+	for k, v := range c.opts.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	lastID := c.LastEventID()
+
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+
+	// The heartbeat timer cancels the connection if nothing is received
+	// in time.
+	heartbeat := time.AfterFunc(c.opts.HeartbeatTimeout, cancel)
+	defer heartbeat.Stop()
+
+	res, err := c.opts.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("connect to stream: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNoContent:
+		return false, errSSEStreamEnded
+	case res.StatusCode != http.StatusOK:
+		return false, HTTPErrorFromResponseLimited(res, 4096)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+
+	scanner.Buffer(nil, c.opts.MaxLineSize)
+
+	var (
+		received bool
+		evt      SSEEvent
+		data     []string
+	)
+
+	for scanner.Scan() {
+		heartbeat.Reset(c.opts.HeartbeatTimeout)
+
+		line := scanner.Text()
+
+		if line == "" {
+			if len(data) == 0 {
+				evt = SSEEvent{}
+
+				continue
+			}
+
+			evt.ID = lastID
+			evt.Data = strings.Join(data, "\n")
+
+			if evt.Event == "" {
+				evt.Event = "message"
+			}
+
+			// Waiting for a slow consumer shouldn't trip the
+			// heartbeat timeout.
+			heartbeat.Stop()
+
+			select {
+			case c.events <- evt:
+			case <-ctx.Done():
+				return received, ctx.Err() //nolint:wrapcheck
+			}
+
+			heartbeat.Reset(c.opts.HeartbeatTimeout)
+
+			// Only resume after events that have been delivered.
+			c.m.Lock()
+			c.lastID = lastID
+			c.m.Unlock()
+
+			received = true
+			evt = SSEEvent{}
+			data = nil
+
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "":
+			// Comment, used by servers as a heartbeat.
+		case "event":
+			evt.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				lastID = value
+			}
+		case "retry":
+			ms, err := strconv.Atoi(value)
+			if err == nil {
+				c.m.Lock()
+				c.retryIn = time.Duration(ms) * time.Millisecond
+				c.m.Unlock()
+			}
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return received, fmt.Errorf("read stream: %w", err)
+	}
+
+	return received, errors.New("the stream was closed")
+}
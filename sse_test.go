@@ -0,0 +1,129 @@
+package elephantine_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestSSEClient(t *testing.T) {
+	var (
+		m       sync.Mutex
+		resumes []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, r *http.Request,
+	) {
+		m.Lock()
+		resumes = append(resumes, r.Header.Get("Last-Event-ID"))
+		conn := len(resumes)
+		m.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		switch conn {
+		case 1:
+			_, _ = fmt.Fprint(w, "retry: 10\n\n: heartbeat\n\n")
+			_, _ = fmt.Fprint(w, "id: 1\nevent: created\ndata: first\n\n")
+			_, _ = fmt.Fprint(w, "id: 2\ndata: multi\ndata: line\n\n")
+		case 2:
+			_, _ = fmt.Fprint(w, "id: 3\ndata: third\n\n")
+
+			w.(http.Flusher).Flush()
+
+			// Go quiet to trigger the heartbeat timeout.
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := elephantine.NewSSEClient(server.URL, elephantine.SSEClientOptions{
+		HeartbeatTimeout: 100 * time.Millisecond,
+		Backoff:          elephantine.StaticBackoff(time.Millisecond),
+	})
+
+	var events []elephantine.SSEEvent
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for evt := range client.Events() {
+			events = append(events, evt)
+		}
+	}()
+
+	err := client.Run(test.Context(t))
+	test.Must(t, err, "run until the server ends the stream")
+
+	<-done
+
+	test.Equal(t, 3, len(events), "receive all events")
+	test.Equal(t, elephantine.SSEEvent{
+		ID: "1", Event: "created", Data: "first",
+	}, events[0], "parse the first event")
+	test.Equal(t, elephantine.SSEEvent{
+		ID: "2", Event: "message", Data: "multi\nline",
+	}, events[1], "join data lines")
+	test.Equal(t, "3", client.LastEventID(), "track the last event ID")
+
+	m.Lock()
+	defer m.Unlock()
+
+	test.Equal(t, fmt.Sprint([]string{"", "2", "3"}), fmt.Sprint(resumes),
+		"resume from the last event ID")
+}
+
+func TestSSEClientLineTooLong(t *testing.T) {
+	var (
+		m     sync.Mutex
+		conns int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(
+		w http.ResponseWriter, _ *http.Request,
+	) {
+		m.Lock()
+		conns++
+		m.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		_, _ = fmt.Fprintf(w, "id: 1\ndata: %s\n\n", strings.Repeat("x", 128))
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := elephantine.NewSSEClient(server.URL, elephantine.SSEClientOptions{
+		Backoff:     elephantine.StaticBackoff(time.Millisecond),
+		MaxLineSize: 64,
+	})
+
+	go func() {
+		for range client.Events() {
+		}
+	}()
+
+	err := client.Run(test.Context(t))
+
+	test.Equal(t, true, errors.Is(err, bufio.ErrTooLong),
+		"fail on lines that are too long")
+	m.Lock()
+	defer m.Unlock()
+
+	test.Equal(t, 1, conns, "don't reconnect to the same event")
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// GoldenHelper transforms the files of an output directory before they're
+// compared to, or written to, the golden directory. Use it to normalise
+// contents that vary between runs, like timestamps and generated IDs.
+type GoldenHelper interface {
+	Transform(name string, data []byte) ([]byte, error)
+}
+
+// GoldenTransform is a function that implements GoldenHelper.
+type GoldenTransform func(name string, data []byte) ([]byte, error)
+
+// Transform implements GoldenHelper.
+func (fn GoldenTransform) Transform(name string, data []byte) ([]byte, error) {
+	return fn(name, data)
+}
+
+// ReplaceInGolden returns a GoldenHelper that replaces all occurrences of old
+// with replacement.
+func ReplaceInGolden(old string, replacement string) GoldenHelper {
+	return GoldenTransform(func(_ string, data []byte) ([]byte, error) {
+		return []byte(strings.ReplaceAll(string(data), old, replacement)), nil
+	})
+}
+
+// TestDirAgainstGolden compares the files in dir to the files in goldenDir,
+// both the file names and the contents have to match. When regenerate is true
+// the golden directory is replaced with the contents of dir instead.
+func TestDirAgainstGolden(
+	t TestingT, regenerate bool, dir string, goldenDir string,
+	helpers ...GoldenHelper,
+) {
+	t.Helper()
+
+	got, err := readGoldenTree(dir, helpers)
+	Must(t, err, "read output directory")
+
+	if regenerate {
+		err := writeGoldenTree(goldenDir, got)
+		Must(t, err, "regenerate golden directory")
+
+		return
+	}
+
+	want, err := readGoldenTree(goldenDir, nil)
+	Must(t, err, "read golden directory")
+
+	var problems []string
+
+	for _, name := range sortedKeys(want) {
+		data, ok := got[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf(
+				"%s: missing from output", name))
+
+			continue
+		}
+
+		diff := cmp.Diff(
+			strings.Split(string(want[name]), "\n"),
+			strings.Split(string(data), "\n"))
+		if diff != "" {
+			problems = append(problems, fmt.Sprintf(
+				"%s: mismatch (-want +got):\n%s", name, diff))
+		}
+	}
+
+	for _, name := range sortedKeys(got) {
+		if _, ok := want[name]; !ok {
+			problems = append(problems, fmt.Sprintf(
+				"%s: not in golden directory", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		t.Fatalf("output directory doesn't match %q:\n%s",
+			goldenDir, strings.Join(problems, "\n"))
+	}
+
+	if testing.Verbose() {
+		t.Logf("success: output directory matches %q", goldenDir)
+	}
+}
+
+// readGoldenTree reads all regular files in a directory tree, keyed by their
+// slash separated path relative to the directory.
+func readGoldenTree(
+	dir string, helpers []GoldenHelper,
+) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("relative path of %q: %w", path, err)
+		}
+
+		name := filepath.ToSlash(rel)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %q: %w", name, err)
+		}
+
+		for _, h := range helpers {
+			data, err = h.Transform(name, data)
+			if err != nil {
+				return fmt.Errorf("transform %q: %w", name, err)
+			}
+		}
+
+		files[name] = data
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk directory: %w", err)
+	}
+
+	return files, nil
+}
+
+func writeGoldenTree(dir string, files map[string][]byte) error {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("remove old golden directory: %w", err)
+	}
+
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			return fmt.Errorf("create directory for %q: %w", name, err)
+		}
+
+		//nolint:gosec
+		err = os.WriteFile(path, data, 0o644)
+		if err != nil {
+			return fmt.Errorf("write %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ttab/elephantine"
+)
+
+var _ elephantine.LeaderElector = &FakeLeaderElector{}
+
+// NewFakeLeaderElector creates a LeaderElector that doesn't hold leadership
+// until Grant() is called. Unlike real electors the fake can be granted
+// leadership again after it has been revoked.
+func NewFakeLeaderElector(identity string) *FakeLeaderElector {
+	return &FakeLeaderElector{
+		identity: identity,
+		changed:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// FakeLeaderElector is a LeaderElector where leadership is controlled by the
+// test through Grant() and Revoke().
+type FakeLeaderElector struct {
+	identity string
+
+	m        sync.Mutex
+	held     bool
+	changed  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// Grant leadership to the elector.
+func (f *FakeLeaderElector) Grant() {
+	f.setHeld(true)
+}
+
+// Revoke leadership from the elector, the context of running functions will be
+// cancelled.
+func (f *FakeLeaderElector) Revoke() {
+	f.setHeld(false)
+}
+
+// Held returns true if the elector holds leadership.
+func (f *FakeLeaderElector) Held() bool {
+	held, _ := f.state()
+
+	return held
+}
+
+func (f *FakeLeaderElector) setHeld(held bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.held == held {
+		return
+	}
+
+	f.held = held
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *FakeLeaderElector) state() (bool, <-chan struct{}) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.held, f.changed
+}
+
+// Acquire implements elephantine.LeaderElector.
+func (f *FakeLeaderElector) Acquire(ctx context.Context) error {
+	for {
+		held, changed := f.state()
+		if held {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck
+		case <-f.stopped:
+			return elephantine.ErrLeadershipLost
+		}
+	}
+}
+
+// Watch implements elephantine.LeaderElector.
+func (f *FakeLeaderElector) Watch() <-chan struct{} {
+	lost := make(chan struct{})
+
+	go func() {
+		defer close(lost)
+
+		for {
+			held, changed := f.state()
+			if !held {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-f.stopped:
+				return
+			}
+		}
+	}()
+
+	return lost
+}
+
+// Identity implements elephantine.LeaderElector.
+func (f *FakeLeaderElector) Identity() string {
+	return f.identity
+}
+
+// Yield implements elephantine.LeaderElector.
+func (f *FakeLeaderElector) Yield() {
+	f.stopOnce.Do(func() {
+		f.Revoke()
+		close(f.stopped)
+	})
+}
+
+// WithHeldJobLock calls fn with a FakeLeaderElector that already holds
+// leadership, so that code that runs under a job lock can be tested without
+// Postgres. The elector yields when the test is cleaned up.
+func WithHeldJobLock(
+	t Cleaner, fn func(ctx context.Context, lock *FakeLeaderElector),
+) {
+	lock := NewFakeLeaderElector("test")
+
+	lock.Grant()
+
+	t.Cleanup(lock.Yield)
+
+	fn(Context(t), lock)
+}
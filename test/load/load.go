@@ -0,0 +1,387 @@
+// Package load generates authenticated request load against HTTP and Twirp
+// endpoints, for use in performance regression tests:
+//
+//	server := test.NewOIDCServer(t)
+//
+//	result, err := load.Run(ctx, load.Options{
+//		Concurrency: 20,
+//		Requests:    2000,
+//		TokenSource: load.MintedTokenSource(t, server, claims),
+//	}, load.TwirpRequest(api.URL, "elephant.repository.Documents", "Get", req))
+//
+//	result.Check(t, load.Thresholds{P99: 50 * time.Millisecond})
+package load
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"golang.org/x/oauth2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// RequestFunc creates the request for the n:th call.
+type RequestFunc func(ctx context.Context, n int) (*http.Request, error)
+
+// Options controls how load is generated.
+type Options struct {
+	// Client is used to perform the requests. Defaults to a client with
+	// enough idle connections per host for the concurrency level.
+	Client *http.Client
+	// Concurrency is the number of concurrent workers. Defaults to 10.
+	Concurrency int
+	// Requests is the total number of requests to make.
+	Requests int
+	// Duration is the time to generate load for. Load generation stops
+	// at whichever comes first of Requests and Duration, at least one of
+	// them must be set.
+	Duration time.Duration
+	// TokenSource is used to authenticate the requests if set.
+	TokenSource oauth2.TokenSource
+}
+
+// MintedTokenSource returns a token source for a token minted by the fake
+// OIDC provider.
+func MintedTokenSource(
+	t test.TestingT, server *test.OIDCServer, claims elephantine.JWTClaims,
+) oauth2.TokenSource {
+	t.Helper()
+
+	return oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: server.Token(t, claims),
+		TokenType:   "Bearer",
+	})
+}
+
+// GetRequest returns a RequestFunc for GET requests to the URL.
+func GetRequest(url string) RequestFunc {
+	return func(ctx context.Context, _ int) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}
+}
+
+// TwirpRequest returns a RequestFunc that calls a Twirp method using the JSON
+// encoding. The base URL is the URL of the server, without the "/twirp"
+// prefix.
+func TwirpRequest(
+	baseURL string, service string, method string, msg proto.Message,
+) RequestFunc {
+	body, err := protojson.Marshal(msg)
+
+	endpoint := strings.TrimSuffix(baseURL, "/") +
+		"/twirp/" + service + "/" + method
+
+	return func(ctx context.Context, _ int) (*http.Request, error) {
+		if err != nil {
+			return nil, fmt.Errorf("marshal request message: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		return req, nil
+	}
+}
+
+// Run generates load until the requested number of requests have been made,
+// the duration has passed, or the context is cancelled. Failed requests are
+// recorded in the result, an error is only returned for invalid options or
+// if a request can't be created.
+func Run(
+	ctx context.Context, opts Options, newRequest RequestFunc,
+) (*Result, error) {
+	if opts.Requests <= 0 && opts.Duration <= 0 {
+		return nil, errors.New("either requests or duration must be set")
+	}
+
+	if opts.Concurrency == 0 {
+		opts.Concurrency = 10
+	}
+
+	if opts.Client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+
+		transport.MaxIdleConnsPerHost = opts.Concurrency
+
+		opts.Client = &http.Client{Transport: transport}
+	}
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	var (
+		wg      sync.WaitGroup
+		m       sync.Mutex
+		next    int
+		runErr  error
+		workers = make([]*Result, opts.Concurrency)
+	)
+
+	// claim returns the number of the next request, or false if we're
+	// done.
+	claim := func() (int, bool) {
+		m.Lock()
+		defer m.Unlock()
+
+		if runErr != nil || ctx.Err() != nil {
+			return 0, false
+		}
+
+		if opts.Requests > 0 && next >= opts.Requests {
+			return 0, false
+		}
+
+		n := next
+		next++
+
+		return n, true
+	}
+
+	fail := func(err error) {
+		m.Lock()
+		defer m.Unlock()
+
+		if runErr == nil {
+			runErr = err
+		}
+	}
+
+	start := time.Now()
+
+	for i := range workers {
+		res := newResult()
+
+		workers[i] = res
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				n, ok := claim()
+				if !ok {
+					return
+				}
+
+				req, err := newRequest(ctx, n)
+				if err != nil {
+					fail(fmt.Errorf("create request %d: %w", n, err))
+
+					return
+				}
+
+				err = authenticate(req, opts.TokenSource)
+				if err != nil {
+					fail(fmt.Errorf("authenticate request %d: %w", n, err))
+
+					return
+				}
+
+				res.record(opts.Client, req)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if runErr != nil {
+		return nil, runErr
+	}
+
+	total := newResult()
+
+	total.Elapsed = time.Since(start)
+
+	for _, w := range workers {
+		total.merge(w)
+	}
+
+	slices.Sort(total.Latencies)
+
+	return total, nil
+}
+
+func authenticate(req *http.Request, ts oauth2.TokenSource) error {
+	if ts == nil {
+		return nil
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	token.SetAuthHeader(req)
+
+	return nil
+}
+
+// Result contains the outcome of a load run.
+type Result struct {
+	// Requests is the number of performed requests.
+	Requests int
+	// Errors is the number of requests that failed or got a non-2xx
+	// response.
+	Errors int
+	// StatusCodes counts the responses by status code, transport errors
+	// are counted as status 0.
+	StatusCodes map[int]int
+	// Elapsed is the wall time of the run.
+	Elapsed time.Duration
+	// Latencies are the sorted request latencies.
+	Latencies []time.Duration
+}
+
+func newResult() *Result {
+	return &Result{
+		StatusCodes: make(map[int]int),
+	}
+}
+
+func (r *Result) record(client *http.Client, req *http.Request) {
+	start := time.Now()
+
+	res, err := client.Do(req)
+	if err == nil {
+		// Read the body so that the connection can be reused, and
+		// so that the latency includes the full response.
+		_, err = io.Copy(io.Discard, res.Body)
+
+		_ = res.Body.Close()
+	}
+
+	// Don't count requests that were cut off by the end of the run.
+	if err != nil && req.Context().Err() != nil {
+		return
+	}
+
+	r.Latencies = append(r.Latencies, time.Since(start))
+	r.Requests++
+
+	switch {
+	case err != nil:
+		r.StatusCodes[0]++
+		r.Errors++
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		r.StatusCodes[res.StatusCode]++
+		r.Errors++
+	default:
+		r.StatusCodes[res.StatusCode]++
+	}
+}
+
+func (r *Result) merge(o *Result) {
+	r.Requests += o.Requests
+	r.Errors += o.Errors
+	r.Latencies = append(r.Latencies, o.Latencies...)
+
+	for code, n := range o.StatusCodes {
+		r.StatusCodes[code] += n
+	}
+}
+
+// Percentile returns the latency percentile, f.ex. 99 for the 99th
+// percentile, using the nearest-rank method.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(r.Latencies))))
+
+	rank = min(max(rank, 1), len(r.Latencies))
+
+	return r.Latencies[rank-1]
+}
+
+// Throughput returns the number of requests per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed == 0 {
+		return 0
+	}
+
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// ErrorRate returns the share of requests that failed, between 0 and 1.
+func (r *Result) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+// String returns a summary of the result.
+func (r *Result) String() string {
+	return fmt.Sprintf(
+		"%d requests in %s (%.1f req/s), %d errors, p50=%s p90=%s p99=%s max=%s",
+		r.Requests, r.Elapsed.Round(time.Millisecond), r.Throughput(),
+		r.Errors,
+		r.Percentile(50), r.Percentile(90), r.Percentile(99),
+		r.Percentile(100))
+}
+
+// Thresholds are the limits that a result is checked against, zero values
+// are not checked.
+type Thresholds struct {
+	P50          time.Duration
+	P90          time.Duration
+	P99          time.Duration
+	MaxErrorRate float64
+}
+
+// Check fails the test if the result exceeds any of the thresholds. The
+// summary is logged.
+func (r *Result) Check(t test.TestingT, thresholds Thresholds) {
+	t.Helper()
+
+	var problems []string
+
+	checkLatency := func(name string, p float64, limit time.Duration) {
+		if limit > 0 && r.Percentile(p) > limit {
+			problems = append(problems, fmt.Sprintf(
+				"%s latency %s exceeds %s", name, r.Percentile(p), limit))
+		}
+	}
+
+	checkLatency("p50", 50, thresholds.P50)
+	checkLatency("p90", 90, thresholds.P90)
+	checkLatency("p99", 99, thresholds.P99)
+
+	if r.ErrorRate() > thresholds.MaxErrorRate {
+		problems = append(problems, fmt.Sprintf(
+			"error rate %.2f%% exceeds %.2f%%, responses: %v",
+			r.ErrorRate()*100, thresholds.MaxErrorRate*100,
+			r.StatusCodes))
+	}
+
+	if len(problems) > 0 {
+		t.Fatalf("failed: load test: %s\n%s",
+			strings.Join(problems, "\n"), r)
+	}
+
+	t.Logf("load test: %s", r)
+}
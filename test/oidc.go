@@ -0,0 +1,337 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/ttab/elephantine"
+)
+
+// OIDCClient is a client that can get tokens from an OIDCServer using the
+// client credentials grant.
+type OIDCClient struct {
+	Secret string
+	// Subject of the issued tokens, defaults to
+	// "core://application/{client id}".
+	Subject string
+	// Scopes that the client is allowed to request. All scopes are allowed
+	// if empty.
+	Scopes []string
+	// Units are added to the issued tokens.
+	Units []string
+}
+
+// OIDCServer is a mock OpenID Connect provider.
+type OIDCServer struct {
+	// URL is the base URL and issuer of the server.
+	URL string
+	// Key is used to sign tokens.
+	Key *SigningKey
+	// ClientID and ClientSecret are the credentials of the default client.
+	ClientID     string
+	ClientSecret string
+	// TokenTTL is the lifetime of issued tokens, defaults to five minutes.
+	TokenTTL time.Duration
+
+	m        sync.Mutex
+	clients  map[string]OIDCClient
+	userinfo map[string]map[string]any
+	revoked  map[string]bool
+}
+
+// NewOIDCServer starts a mock OpenID Connect provider that serves a
+// discovery document, a JWKS, and a token endpoint that supports the client
+// credentials grant. A default client is registered.
+func NewOIDCServer(t JWKSTestingT) *OIDCServer {
+	t.Helper()
+
+	s := OIDCServer{
+		Key:          NewSigningKey(t),
+		ClientID:     "test-client",
+		ClientSecret: uuid.NewString(),
+		TokenTTL:     5 * time.Minute,
+		clients:      make(map[string]OIDCClient),
+		userinfo:     make(map[string]map[string]any),
+		revoked:      make(map[string]bool),
+	}
+
+	s.clients[s.ClientID] = OIDCClient{
+		Secret: s.ClientSecret,
+	}
+
+	jwks, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     s.Key.KeyID,
+		Algorithm: s.Key.Method.Alg(),
+		Key:       s.Key.Public(),
+	})
+	Must(t, err, "create JWKS handler")
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.wellKnown)
+	mux.Handle("GET /jwks", jwks)
+	mux.HandleFunc("POST /token", s.token)
+	mux.HandleFunc("GET /userinfo", s.userinfoHandler)
+	mux.HandleFunc("POST /revoke", s.revoke)
+
+	server := httptest.NewServer(mux)
+
+	t.Cleanup(server.Close)
+
+	s.URL = server.URL
+
+	return &s
+}
+
+// WellKnownURL returns the URL of the discovery document.
+func (s *OIDCServer) WellKnownURL() string {
+	return s.URL + "/.well-known/openid-configuration"
+}
+
+// Settings returns authentication settings for the default client, for use
+// with elephantine.NewAuthenticationConfig.
+func (s *OIDCServer) Settings() elephantine.AuthenticationSettings {
+	return elephantine.AuthenticationSettings{
+		OIDCConfig:   s.WellKnownURL(),
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+	}
+}
+
+// AddClient registers a client.
+func (s *OIDCServer) AddClient(id string, client OIDCClient) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.clients[id] = client
+}
+
+// SetUserinfo sets the claims that the userinfo endpoint returns for the
+// subject. The "sub" claim is always set.
+func (s *OIDCServer) SetUserinfo(subject string, claims map[string]any) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.userinfo[subject] = claims
+}
+
+// IsRevoked returns true if the token has been revoked through the
+// revocation endpoint.
+func (s *OIDCServer) IsRevoked(token string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.revoked[token]
+}
+
+// Token mints a signed token for the claims. The issuer is set to the server
+// URL, and the token expires after the token TTL unless the claims has an
+// expiry time.
+func (s *OIDCServer) Token(t TestingT, claims elephantine.JWTClaims) string {
+	t.Helper()
+
+	token, err := s.sign(claims)
+	Must(t, err, "sign token")
+
+	return token
+}
+
+func (s *OIDCServer) sign(claims elephantine.JWTClaims) (string, error) {
+	claims.Issuer = s.URL
+
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(s.TokenTTL))
+	}
+
+	token := jwt.NewWithClaims(s.Key.Method, claims)
+
+	token.Header["kid"] = s.Key.KeyID
+
+	//nolint:wrapcheck
+	return token.SignedString(s.Key.Private)
+}
+
+// AccessKey works like Token, but returns an Authorization header value.
+func (s *OIDCServer) AccessKey(t TestingT, claims elephantine.JWTClaims) string {
+	t.Helper()
+
+	return "Bearer " + s.Token(t, claims)
+}
+
+func (s *OIDCServer) wellKnown(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, elephantine.OpenIDConnectConfig{
+		Issuer:                           s.URL,
+		TokenEndpoint:                    s.URL + "/token",
+		JwksURI:                          s.URL + "/jwks",
+		UserinfoEndpoint:                 s.URL + "/userinfo",
+		EndSessionEndpoint:               s.URL + "/logout",
+		RevocationEndpoint:               s.URL + "/revoke",
+		GrantTypesSupported:              []string{"client_credentials"},
+		IDTokenSigningAlgValuesSupported: []string{s.Key.Method.Alg()},
+	})
+}
+
+func (s *OIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+
+		return
+	}
+
+	clientID, client, ok := s.authenticateClient(r)
+	if !ok {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+
+		return
+	}
+
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+
+	for _, scope := range scopes {
+		if len(client.Scopes) > 0 && !slices.Contains(client.Scopes, scope) {
+			tokenError(w, http.StatusBadRequest, "invalid_scope")
+
+			return
+		}
+	}
+
+	subject := client.Subject
+	if subject == "" {
+		subject = "core://application/" + clientID
+	}
+
+	token, err := s.sign(elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  subject,
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ID:       uuid.NewString(),
+		},
+		Scope:           strings.Join(scopes, " "),
+		AuthorizedParty: clientID,
+		ClientID:        clientID,
+		Units:           client.Units,
+	})
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.TokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// authenticateClient checks the client credentials of a request with a parsed
+// form.
+func (s *OIDCServer) authenticateClient(r *http.Request) (string, OIDCClient, bool) {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		// Credentials are form encoded before being base64 encoded.
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	s.m.Lock()
+	client, known := s.clients[clientID]
+	s.m.Unlock()
+
+	if !known || client.Secret != secret {
+		return "", OIDCClient{}, false
+	}
+
+	return clientID, client, true
+}
+
+func (s *OIDCServer) revoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+
+		return
+	}
+
+	_, _, ok := s.authenticateClient(r)
+	if !ok {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+
+		return
+	}
+
+	s.m.Lock()
+	s.revoked[r.PostForm.Get("token")] = true
+	s.m.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *OIDCServer) userinfoHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		tokenError(w, http.StatusUnauthorized, "invalid_token")
+
+		return
+	}
+
+	var claims jwt.RegisteredClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims,
+		func(_ *jwt.Token) (any, error) {
+			return s.Key.Public(), nil
+		},
+		jwt.WithIssuer(s.URL),
+		jwt.WithValidMethods([]string{s.Key.Method.Alg()}))
+	if err != nil {
+		tokenError(w, http.StatusUnauthorized, "invalid_token")
+
+		return
+	}
+
+	info := map[string]any{}
+
+	s.m.Lock()
+
+	for k, v := range s.userinfo[claims.Subject] {
+		info[k] = v
+	}
+
+	s.m.Unlock()
+
+	info["sub"] = claims.Subject
+
+	writeJSON(w, http.StatusOK, info)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{
+		"error": code,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,134 @@
+package test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/ttab/elephantine"
+)
+
+// KeyType is the type of a signing key.
+type KeyType string
+
+const (
+	KeyTypeES384   KeyType = "ES384"
+	KeyTypeES256   KeyType = "ES256"
+	KeyTypeEd25519 KeyType = "EdDSA"
+)
+
+// SigningKey is a key that can be used to sign access tokens in tests.
+type SigningKey struct {
+	Type    KeyType
+	KeyID   string
+	Private crypto.Signer
+	Method  jwt.SigningMethod
+}
+
+// NewSigningKey creates a new signing key, defaults to a P-384 key if no key
+// type is given.
+func NewSigningKey(t TestingT, keyType ...KeyType) *SigningKey {
+	t.Helper()
+
+	kt := KeyTypeES384
+	if len(keyType) > 0 {
+		kt = keyType[0]
+	}
+
+	var (
+		key    crypto.Signer
+		method jwt.SigningMethod
+		err    error
+	)
+
+	switch kt {
+	case KeyTypeES384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		method = jwt.SigningMethodES384
+	case KeyTypeES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		method = jwt.SigningMethodES256
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		method = jwt.SigningMethodEdDSA
+	default:
+		t.Fatalf("unsupported key type %q", kt)
+	}
+
+	Must(t, err, "generate %s signing key", kt)
+
+	return &SigningKey{
+		Type:    kt,
+		KeyID:   uuid.NewString(),
+		Private: key,
+		Method:  method,
+	}
+}
+
+// Public returns the public key.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// Parser creates an auth info parser that accepts tokens signed with the key.
+func (k *SigningKey) Parser(
+	opts elephantine.JWTAuthInfoParserOptions,
+) *elephantine.JWTAuthInfoParser {
+	if len(opts.ValidMethods) == 0 {
+		opts.ValidMethods = []string{k.Method.Alg()}
+	}
+
+	return elephantine.NewStaticKeyAuthInfoParser(k.Public(), opts)
+}
+
+// AccessKey signs the claims and returns an Authorization header value. The
+// token expires after a minute unless the claims has an expiry time.
+func (k *SigningKey) AccessKey(
+	t TestingT, claims elephantine.JWTClaims,
+) string {
+	t.Helper()
+
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+	}
+
+	token := jwt.NewWithClaims(k.Method, claims)
+
+	token.Header["kid"] = k.KeyID
+
+	ss, err := token.SignedString(k.Private)
+	Must(t, err, "sign access token")
+
+	return "Bearer " + ss
+}
+
+// JWKSTestingT is the subset of testing.T that is needed to run a JWKS server.
+type JWKSTestingT interface {
+	TestingT
+	Cleaner
+}
+
+// JWKSServer starts a server that publishes the public key as a JWKS document
+// and returns its URL, for testing with elephantine.NewJWKSAuthInfoParser.
+func (k *SigningKey) JWKSServer(t JWKSTestingT) string {
+	t.Helper()
+
+	handler, err := elephantine.NewJWKSHandler(elephantine.JWKSKey{
+		KeyID:     k.KeyID,
+		Algorithm: k.Method.Alg(),
+		Key:       k.Public(),
+	})
+	Must(t, err, "create JWKS handler")
+
+	server := httptest.NewServer(handler)
+
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
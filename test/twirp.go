@@ -1,9 +1,16 @@
 package test
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/twitchtv/twirp"
 )
 
@@ -24,3 +31,84 @@ func IsTwirpError(
 		t.Logf("success: got a %q error", code)
 	}
 }
+
+// AssertTwirpContract verifies that err is a Twirp error with the expected
+// code, and that it has a non-empty value for each of the meta keys. The Twirp
+// error is returned so that the meta values can be checked further.
+func AssertTwirpContract(
+	t TestingT, err error, code twirp.ErrorCode, metaKeys ...string,
+) twirp.Error {
+	t.Helper()
+
+	IsTwirpError(t, err, code)
+
+	var tErr twirp.Error
+
+	_ = errors.As(err, &tErr)
+
+	var missing []string
+
+	for _, key := range metaKeys {
+		if tErr.Meta(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		t.Fatalf("failed: the %q error is missing the meta keys %s: got %v",
+			code, strings.Join(missing, ", "), tErr.MetaMap())
+	}
+
+	if testing.Verbose() && len(metaKeys) > 0 {
+		t.Logf("success: the %q error has the meta keys %s",
+			code, strings.Join(metaKeys, ", "))
+	}
+
+	return tErr
+}
+
+// TestTwirpErrorAgainstGolden compares the JSON payload that a Twirp server
+// would respond with for err to the contents of goldenFile. When regenerate
+// is true the golden file is written instead.
+func TestTwirpErrorAgainstGolden(
+	t TestingT, regenerate bool, err error, goldenFile string,
+) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+
+	ioErr := twirp.WriteError(rec, err)
+	Must(t, ioErr, "serialise Twirp error")
+
+	var got bytes.Buffer
+
+	ioErr = json.Indent(&got, rec.Body.Bytes(), "", "  ")
+	Must(t, ioErr, "indent Twirp error payload")
+
+	got.WriteString("\n")
+
+	if regenerate {
+		ioErr = os.MkdirAll(filepath.Dir(goldenFile), 0o700)
+		Must(t, ioErr, "create golden file directory")
+
+		ioErr = os.WriteFile(goldenFile, got.Bytes(), 0o600)
+		Must(t, ioErr, "write golden file")
+
+		return
+	}
+
+	want, ioErr := os.ReadFile(goldenFile)
+	Must(t, ioErr, "read golden file")
+
+	diff := cmp.Diff(
+		strings.Split(string(want), "\n"),
+		strings.Split(got.String(), "\n"))
+	if diff != "" {
+		t.Fatalf("Twirp error payload doesn't match %q (-want +got):\n%s",
+			goldenFile, diff)
+	}
+
+	if testing.Verbose() {
+		t.Logf("success: Twirp error payload matches %q", goldenFile)
+	}
+}
@@ -0,0 +1,321 @@
+// Package tmpstore provides a content-addressable store for large intermediate
+// payloads that shouldn't be kept in memory.
+package tmpstore
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMaxBytes is the default size budget of a store.
+const DefaultMaxBytes = 1 << 30
+
+var (
+	// ErrNotFound is returned when a key isn't in the store, either
+	// because it never was or because it has been evicted.
+	ErrNotFound = errors.New("not found in temp store")
+	// ErrTooLarge is returned when a payload is larger than the size
+	// budget of the store.
+	ErrTooLarge = errors.New("payload is larger than the temp store budget")
+	// ErrClosed is returned when the store has been closed.
+	ErrClosed = errors.New("temp store is closed")
+)
+
+// Metrics for a temp store.
+type Metrics struct {
+	bytes     prometheus.Gauge
+	files     prometheus.Gauge
+	evictions prometheus.Counter
+}
+
+// NewMetrics registers a set of temp store metrics with the provided
+// registerer.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	m := Metrics{
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tmpstore_bytes",
+			Help: "Number of bytes used by the temp store.",
+		}),
+		files: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tmpstore_files",
+			Help: "Number of files in the temp store.",
+		}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tmpstore_evictions_total",
+			Help: "Number of files that have been evicted to stay within the size budget.",
+		}),
+	}
+
+	collectors := []prometheus.Collector{m.bytes, m.files, m.evictions}
+
+	for i, c := range collectors {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"failed to register metrics collector %d: %w",
+				i, err)
+		}
+	}
+
+	return &m, nil
+}
+
+// Options controls the behaviour of a store.
+type Options struct {
+	// Dir is the directory that payloads are written to. A new directory
+	// in the system temp dir is created if left empty.
+	Dir string
+	// MaxBytes is the size budget of the store, the least recently used
+	// payloads are evicted to stay within the budget. Defaults to
+	// DefaultMaxBytes.
+	MaxBytes int64
+	// Metrics is optional.
+	Metrics *Metrics
+}
+
+// Store writes payloads to disk keyed by their SHA256 hash.
+type Store struct {
+	dir      string
+	ownsDir  bool
+	maxBytes int64
+	metrics  *Metrics
+
+	m       sync.Mutex
+	closed  bool
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// New creates a new store.
+func New(opts Options) (*Store, error) {
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+
+	s := Store{
+		dir:      opts.Dir,
+		maxBytes: opts.MaxBytes,
+		metrics:  opts.Metrics,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "tmpstore-")
+		if err != nil {
+			return nil, fmt.Errorf("create temp dir: %w", err)
+		}
+
+		s.dir = dir
+		s.ownsDir = true
+	} else {
+		err := os.MkdirAll(s.dir, 0o700)
+		if err != nil {
+			return nil, fmt.Errorf("create store dir: %w", err)
+		}
+	}
+
+	return &s, nil
+}
+
+// Put writes the payload to the store and returns its key and size.
+func (s *Store) Put(r io.Reader) (_ string, _ int64, outErr error) {
+	f, err := os.CreateTemp(s.dir, ".incoming-")
+	if err != nil {
+		return "", 0, fmt.Errorf("create temp file: %w", err)
+	}
+
+	defer func() {
+		// Clean up the incoming file unless it was moved into place.
+		if outErr != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	hash := sha256.New()
+
+	// Read at most one byte over the limit, so that oversized payloads
+	// are detected without writing them to disk.
+	size, err := io.Copy(io.MultiWriter(f, hash),
+		io.LimitReader(r, s.maxBytes+1))
+	if err != nil {
+		return "", 0, fmt.Errorf("write payload: %w", err)
+	}
+
+	if size > s.maxBytes {
+		return "", 0, ErrTooLarge
+	}
+
+	err = f.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("close temp file: %w", err)
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return "", 0, ErrClosed
+	}
+
+	if el, ok := s.entries[key]; ok {
+		// Same content, keep the existing file.
+		s.lru.MoveToFront(el)
+
+		_ = os.Remove(f.Name())
+
+		return key, size, nil
+	}
+
+	err = os.Rename(f.Name(), s.path(key))
+	if err != nil {
+		return "", 0, fmt.Errorf("move payload into place: %w", err)
+	}
+
+	s.entries[key] = s.lru.PushFront(&entry{key: key, size: size})
+	s.size += size
+
+	s.evict()
+	s.updateMetrics()
+
+	return key, size, nil
+}
+
+// Open opens the payload with the given key for reading. Evicting a payload
+// doesn't affect readers that already have it open.
+func (s *Store) Open(key string) (*os.File, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	s.lru.MoveToFront(el)
+
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		s.remove(el)
+		s.updateMetrics()
+
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("open payload: %w", err)
+	}
+
+	return f, nil
+}
+
+// Delete removes a payload from the store.
+func (s *Store) Delete(key string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return
+	}
+
+	s.remove(el)
+	s.updateMetrics()
+}
+
+// Size returns the number of bytes used by the store.
+func (s *Store) Size() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.size
+}
+
+// Close removes all payloads from the store. The store directory is removed
+// if it was created by the store.
+func (s *Store) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	for s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+	}
+
+	s.updateMetrics()
+
+	if s.ownsDir {
+		err := os.RemoveAll(s.dir)
+		if err != nil {
+			return fmt.Errorf("remove store dir: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key)
+}
+
+// evict removes the least recently used payloads until the store is within
+// its budget. Must be called with the lock held.
+func (s *Store) evict() {
+	for s.size > s.maxBytes && s.lru.Len() > 0 {
+		s.remove(s.lru.Back())
+
+		if s.metrics != nil {
+			s.metrics.evictions.Inc()
+		}
+	}
+}
+
+// remove deletes a payload. Must be called with the lock held.
+func (s *Store) remove(el *list.Element) {
+	e, _ := s.lru.Remove(el).(*entry)
+
+	delete(s.entries, e.key)
+
+	s.size -= e.size
+
+	_ = os.Remove(s.path(e.key))
+}
+
+func (s *Store) updateMetrics() {
+	if s.metrics == nil {
+		return
+	}
+
+	s.metrics.bytes.Set(float64(s.size))
+	s.metrics.files.Set(float64(s.lru.Len()))
+}
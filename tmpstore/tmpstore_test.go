@@ -0,0 +1,62 @@
+package tmpstore_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ttab/elephantine/test"
+	"github.com/ttab/elephantine/tmpstore"
+)
+
+func TestStoreEviction(t *testing.T) {
+	store, err := tmpstore.New(tmpstore.Options{
+		Dir:      t.TempDir(),
+		MaxBytes: 10,
+	})
+	test.Must(t, err, "create store")
+
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	keyA, _, err := store.Put(strings.NewReader("aaaa"))
+	test.Must(t, err, "put first payload")
+
+	keyB, _, err := store.Put(strings.NewReader("bbbb"))
+	test.Must(t, err, "put second payload")
+
+	// Use the first payload so that the second one is evicted.
+	f, err := store.Open(keyA)
+	test.Must(t, err, "open first payload")
+
+	_ = f.Close()
+
+	_, _, err = store.Put(strings.NewReader("cccc"))
+	test.Must(t, err, "put third payload")
+
+	test.Equal(t, int64(8), store.Size(), "stay within the budget")
+
+	_, err = store.Open(keyB)
+	test.Equal(t, true, errors.Is(err, tmpstore.ErrNotFound),
+		"evict the least recently used payload")
+
+	f, err = store.Open(keyA)
+	test.Must(t, err, "keep the recently used payload")
+
+	data, err := io.ReadAll(f)
+	test.Must(t, err, "read payload")
+
+	_ = f.Close()
+
+	test.Equal(t, "aaaa", string(data), "read the stored payload")
+
+	large := strings.NewReader("too large for the store")
+
+	_, _, err = store.Put(large)
+	test.Equal(t, true, errors.Is(err, tmpstore.ErrTooLarge),
+		"reject payloads larger than the budget")
+	test.Equal(t, true, large.Len() > 0,
+		"stop reading once the budget has been exceeded")
+}
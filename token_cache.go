@@ -0,0 +1,193 @@
+package elephantine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultTokenMinValidity is the minimum remaining validity of a cached token
+// for it to be used.
+const DefaultTokenMinValidity = time.Minute
+
+// TokenCache stores tokens so that they can be shared between processes.
+type TokenCache interface {
+	// LoadToken returns the cached token for the key, or nil if there
+	// is no cached token.
+	LoadToken(ctx context.Context, key string) (*oauth2.Token, error)
+	// StoreToken caches a token.
+	StoreToken(ctx context.Context, key string, token *oauth2.Token) error
+}
+
+// TokenCacheKey creates a cache key for the tokens of a client.
+func TokenCacheKey(tokenURL string, clientID string, scopes []string) string {
+	scopes = slices.Clone(scopes)
+
+	slices.Sort(scopes)
+
+	sum := sha256.Sum256([]byte(strings.Join(
+		[]string{tokenURL, clientID, strings.Join(scopes, " ")}, "\n")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// CachedTokenSourceOptions controls the behaviour of a cached token source.
+type CachedTokenSourceOptions struct {
+	// MinValidity is the minimum remaining validity of a cached token for
+	// it to be used. Defaults to DefaultTokenMinValidity.
+	MinValidity time.Duration
+	// Logger is used to log cache failures, which are not fatal. Defaults
+	// to slog.Default().
+	Logger *slog.Logger
+}
+
+// NewCachedTokenSource creates a token source that looks for a valid token in
+// the cache before asking the source for a new token, and stores new tokens
+// in the cache.
+func NewCachedTokenSource(
+	ctx context.Context, cache TokenCache, key string,
+	source oauth2.TokenSource, opts CachedTokenSourceOptions,
+) oauth2.TokenSource {
+	if opts.MinValidity == 0 {
+		opts.MinValidity = DefaultTokenMinValidity
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &cachedTokenSource{
+		ctx:    ctx,
+		cache:  cache,
+		key:    key,
+		source: source,
+		opts:   opts,
+	}
+}
+
+type cachedTokenSource struct {
+	ctx    context.Context
+	cache  TokenCache
+	key    string
+	source oauth2.TokenSource
+	opts   CachedTokenSourceOptions
+
+	m     sync.Mutex
+	token *oauth2.Token
+}
+
+func (ts *cachedTokenSource) usable(t *oauth2.Token) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+
+	return t.Expiry.IsZero() ||
+		time.Until(t.Expiry) > ts.opts.MinValidity
+}
+
+// Token implements oauth2.TokenSource.
+func (ts *cachedTokenSource) Token() (*oauth2.Token, error) {
+	ts.m.Lock()
+	defer ts.m.Unlock()
+
+	if ts.usable(ts.token) {
+		return ts.token, nil
+	}
+
+	cached, err := ts.cache.LoadToken(ts.ctx, ts.key)
+	if err != nil {
+		ts.opts.Logger.WarnContext(ts.ctx, "failed to load cached token",
+			LogKeyError, err)
+	}
+
+	if ts.usable(cached) {
+		ts.token = cached
+
+		return cached, nil
+	}
+
+	token, err := ts.source.Token()
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	ts.token = token
+
+	err = ts.cache.StoreToken(ts.ctx, ts.key, token)
+	if err != nil {
+		ts.opts.Logger.WarnContext(ts.ctx, "failed to cache token",
+			LogKeyError, err)
+	}
+
+	return token, nil
+}
+
+// FileTokenCache caches tokens as files in a directory. The files are only
+// readable by the current user.
+type FileTokenCache struct {
+	dir string
+}
+
+// NewFileTokenCache creates a file token cache that stores tokens in the
+// directory. Use the user cache directory for CLI tools:
+//
+//	dir, err := os.UserCacheDir()
+//	// ...
+//	cache := NewFileTokenCache(filepath.Join(dir, "my-tool", "tokens"))
+func NewFileTokenCache(dir string) *FileTokenCache {
+	return &FileTokenCache{dir: dir}
+}
+
+func (c *FileTokenCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// LoadToken implements TokenCache.
+func (c *FileTokenCache) LoadToken(
+	_ context.Context, key string,
+) (*oauth2.Token, error) {
+	data, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+
+	var token oauth2.Token
+
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return nil, fmt.Errorf("parse token file: %w", err)
+	}
+
+	return &token, nil
+}
+
+// StoreToken implements TokenCache.
+func (c *FileTokenCache) StoreToken(
+	_ context.Context, key string, token *oauth2.Token,
+) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("marshal token: %w", err)
+	}
+
+	err = writeFileAtomic(c.dir, key+".json", data)
+	if err != nil {
+		return fmt.Errorf("write token file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package elephantine_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"golang.org/x/oauth2"
+)
+
+type countingTokenSource struct {
+	calls atomic.Int32
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.calls.Add(1)
+
+	return &oauth2.Token{
+		AccessToken: "token",
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestFileTokenCache(t *testing.T) {
+	ctx := test.Context(t)
+	cache := elephantine.NewFileTokenCache(t.TempDir())
+	key := elephantine.TokenCacheKey(
+		"https://login.example.com/token", "client", []string{"b", "a"})
+
+	test.Equal(t, key, elephantine.TokenCacheKey(
+		"https://login.example.com/token", "client", []string{"a", "b"}),
+		"ignore scope order in cache key")
+
+	missing, err := cache.LoadToken(ctx, key)
+	test.Must(t, err, "load missing token")
+
+	if missing != nil {
+		t.Fatal("expected no cached token")
+	}
+
+	var source countingTokenSource
+
+	// Two separate token sources simulate separate processes.
+	for range 2 {
+		ts := elephantine.NewCachedTokenSource(ctx, cache, key, &source,
+			elephantine.CachedTokenSourceOptions{})
+
+		token, err := ts.Token()
+		test.Must(t, err, "get token")
+
+		test.Equal(t, "token", token.AccessToken, "get the access token")
+	}
+
+	test.Equal(t, 1, int(source.calls.Load()),
+		"only request a token once")
+
+	err = cache.StoreToken(ctx, key, &oauth2.Token{
+		AccessToken: "stale",
+		Expiry:      time.Now().Add(30 * time.Second),
+	})
+	test.Must(t, err, "store almost expired token")
+
+	ts := elephantine.NewCachedTokenSource(ctx, cache, key, &source,
+		elephantine.CachedTokenSourceOptions{})
+
+	token, err := ts.Token()
+	test.Must(t, err, "get token")
+
+	test.Equal(t, "token", token.AccessToken,
+		"don't use tokens that are about to expire")
+	test.Equal(t, 2, int(source.calls.Load()),
+		"request a new token")
+}
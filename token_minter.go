@@ -0,0 +1,310 @@
+package elephantine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	vault "github.com/hashicorp/vault/api"
+	"golang.org/x/oauth2"
+)
+
+// DefaultMintedTokenTTL is the default lifetime of minted tokens.
+const DefaultMintedTokenTTL = 5 * time.Minute
+
+// TokenSigner signs JWT signing strings.
+type TokenSigner interface {
+	// Algorithm returns the JWT "alg" of the signatures.
+	Algorithm() string
+	// KeyID returns the "kid" header value to use, can be empty.
+	KeyID() string
+	// Sign returns the raw signature for the signing string.
+	Sign(ctx context.Context, signingString string) ([]byte, error)
+}
+
+// NewECDSATokenSigner creates a token signer that uses a static ECDSA key. The
+// algorithm is picked based on the key curve.
+func NewECDSATokenSigner(key *ecdsa.PrivateKey, keyID string) (*ECDSATokenSigner, error) {
+	var method *jwt.SigningMethodECDSA
+
+	switch key.Curve {
+	case elliptic.P256():
+		method = jwt.SigningMethodES256
+	case elliptic.P384():
+		method = jwt.SigningMethodES384
+	case elliptic.P521():
+		method = jwt.SigningMethodES512
+	default:
+		return nil, errors.New("unsupported elliptic curve")
+	}
+
+	s := ECDSATokenSigner{
+		key:    key,
+		keyID:  keyID,
+		method: method,
+	}
+
+	return &s, nil
+}
+
+// ECDSATokenSigner signs tokens using a static ECDSA key.
+type ECDSATokenSigner struct {
+	key    *ecdsa.PrivateKey
+	keyID  string
+	method *jwt.SigningMethodECDSA
+}
+
+// Algorithm implements TokenSigner.
+func (s *ECDSATokenSigner) Algorithm() string {
+	return s.method.Alg()
+}
+
+// KeyID implements TokenSigner.
+func (s *ECDSATokenSigner) KeyID() string {
+	return s.keyID
+}
+
+// JWKSKey returns the public key of the signer, for use with NewJWKSHandler.
+func (s *ECDSATokenSigner) JWKSKey() JWKSKey {
+	return JWKSKey{
+		KeyID:     s.keyID,
+		Algorithm: s.method.Alg(),
+		Key:       &s.key.PublicKey,
+	}
+}
+
+// Sign implements TokenSigner.
+func (s *ECDSATokenSigner) Sign(_ context.Context, signingString string) ([]byte, error) {
+	sig, err := s.method.Sign(signingString, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign token: %w", err)
+	}
+
+	return sig, nil
+}
+
+// NewVaultTransitSigner creates a token signer that uses a Vault transit key.
+// The algorithm must match the key type, ES256 for "ecdsa-p256", ES384 for
+// "ecdsa-p384", and ES512 for "ecdsa-p521". The mount defaults to "transit".
+func NewVaultTransitSigner(
+	client *vault.Client, mount string, keyName string, algorithm string,
+) (*VaultTransitSigner, error) {
+	hashes := map[string]string{
+		"ES256": "sha2-256",
+		"ES384": "sha2-384",
+		"ES512": "sha2-512",
+	}
+
+	hash, ok := hashes[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	if mount == "" {
+		mount = "transit"
+	}
+
+	s := VaultTransitSigner{
+		client:    client,
+		mount:     strings.Trim(mount, "/"),
+		keyName:   keyName,
+		algorithm: algorithm,
+		hash:      hash,
+	}
+
+	return &s, nil
+}
+
+// VaultTransitSigner signs tokens using a Vault transit key, the private key
+// never leaves Vault.
+type VaultTransitSigner struct {
+	client    *vault.Client
+	mount     string
+	keyName   string
+	algorithm string
+	hash      string
+}
+
+// Algorithm implements TokenSigner.
+func (s *VaultTransitSigner) Algorithm() string {
+	return s.algorithm
+}
+
+// KeyID implements TokenSigner. The key name is used as the key ID.
+func (s *VaultTransitSigner) KeyID() string {
+	return s.keyName
+}
+
+// Sign implements TokenSigner.
+func (s *VaultTransitSigner) Sign(ctx context.Context, signingString string) ([]byte, error) {
+	path := fmt.Sprintf("%s/sign/%s/%s", s.mount, s.keyName, s.hash)
+
+	secret, err := s.client.Logical().WriteWithContext(ctx, path,
+		map[string]any{
+			"input": base64.StdEncoding.EncodeToString(
+				[]byte(signingString)),
+			// Gives us the raw r||s signature format that JWS
+			// uses.
+			"marshaling_algorithm": "jws",
+		})
+	if err != nil {
+		return nil, fmt.Errorf("sign using Vault transit: %w", err)
+	}
+
+	if secret == nil {
+		return nil, errors.New("no response from Vault transit")
+	}
+
+	vaultSig, ok := secret.Data["signature"].(string)
+	if !ok {
+		return nil, errors.New("no signature in Vault transit response")
+	}
+
+	// Signatures are formatted as "vault:v[key version]:[signature]".
+	parts := strings.SplitN(vaultSig, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected Vault signature format")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode Vault signature: %w", err)
+	}
+
+	return sig, nil
+}
+
+// TokenMinterOptions controls the claims that a TokenMinter sets on minted
+// tokens.
+type TokenMinterOptions struct {
+	// Issuer is set as the "iss" claim if the claims lack an issuer.
+	Issuer string
+	// Audience is set as the "aud" claim if the claims lack an audience.
+	Audience []string
+	// TTL is the lifetime of minted tokens, defaults to
+	// DefaultMintedTokenTTL.
+	TTL time.Duration
+}
+
+// TokenMinter signs JWTClaims for services that act as their own issuer, like
+// in test and edge environments. Tokens signed with a ECDSATokenSigner can be
+// verified by a parser created with NewStaticAuthInfoParser, or by a parser
+// created with NewJWKSAuthInfoParser if the public key is served using
+// NewJWKSHandler.
+type TokenMinter struct {
+	signer TokenSigner
+	opts   TokenMinterOptions
+}
+
+// NewTokenMinter creates a new token minter.
+func NewTokenMinter(signer TokenSigner, opts TokenMinterOptions) *TokenMinter {
+	if opts.TTL == 0 {
+		opts.TTL = DefaultMintedTokenTTL
+	}
+
+	return &TokenMinter{
+		signer: signer,
+		opts:   opts,
+	}
+}
+
+// Mint signs a token with the given claims. The issued at, expiry, and token ID
+// claims will be set if they're missing.
+func (m *TokenMinter) Mint(
+	ctx context.Context, claims JWTClaims,
+) (string, time.Time, error) {
+	now := time.Now()
+
+	if claims.Issuer == "" {
+		claims.Issuer = m.opts.Issuer
+	}
+
+	if len(claims.Audience) == 0 {
+		claims.Audience = m.opts.Audience
+	}
+
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(m.opts.TTL))
+	}
+
+	if claims.ID == "" {
+		claims.ID = uuid.NewString()
+	}
+
+	method := jwt.GetSigningMethod(m.signer.Algorithm())
+	if method == nil {
+		return "", time.Time{}, fmt.Errorf(
+			"unknown signing algorithm %q", m.signer.Algorithm())
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+
+	if kid := m.signer.KeyID(); kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("create signing string: %w", err)
+	}
+
+	sig, err := m.signer.Sign(ctx, signingString)
+	if err != nil {
+		return "", time.Time{}, err //nolint:wrapcheck
+	}
+
+	signed := signingString + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	return signed, claims.ExpiresAt.Time, nil
+}
+
+// TokenSource returns a token source that mints tokens with the given claims.
+// Tokens are reused until they're about to expire.
+func (m *TokenMinter) TokenSource(
+	ctx context.Context, claims JWTClaims,
+) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &mintingTokenSource{
+		ctx:    ctx,
+		minter: m,
+		claims: claims,
+	})
+}
+
+type mintingTokenSource struct {
+	//nolint:containedctx
+	ctx    context.Context
+	minter *TokenMinter
+	claims JWTClaims
+}
+
+// Token implements oauth2.TokenSource.
+func (s *mintingTokenSource) Token() (*oauth2.Token, error) {
+	// Copy the claims so that every token gets its own timestamps and ID.
+	claims := s.claims
+
+	if len(s.claims.Audience) > 0 {
+		claims.Audience = append(jwt.ClaimStrings{}, s.claims.Audience...)
+	}
+
+	signed, expiry, err := s.minter.Mint(s.ctx, claims)
+	if err != nil {
+		return nil, fmt.Errorf("mint token: %w", err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: signed,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
@@ -0,0 +1,56 @@
+package elephantine_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestTokenMinterRoundTrip(t *testing.T) {
+	ctx := test.Context(t)
+
+	jwtKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	test.Must(t, err, "create signing key")
+
+	signer, err := elephantine.NewECDSATokenSigner(jwtKey, "test")
+	test.Must(t, err, "create signer")
+
+	minter := elephantine.NewTokenMinter(signer,
+		elephantine.TokenMinterOptions{
+			Issuer:   "test-issuer",
+			Audience: []string{"repository"},
+		})
+
+	parser := elephantine.NewStaticAuthInfoParser(jwtKey.PublicKey,
+		elephantine.JWTAuthInfoParserOptions{
+			Issuer:   "test-issuer",
+			Audience: "repository",
+		})
+
+	ts := minter.TokenSource(ctx, elephantine.JWTClaims{
+		Scope: "doc_read",
+		Units: []string{"/editorial"},
+	})
+
+	token, err := ts.Token()
+	test.Must(t, err, "mint token")
+
+	info, err := parser.AuthInfoFromHeader(
+		fmt.Sprintf("Bearer %s", token.AccessToken))
+	test.Must(t, err, "parse minted token")
+
+	test.Equal(t, "doc_read", info.Claims.Scope, "get the minted scope")
+	test.EqualDiff(t, []string{"core://unit/editorial"},
+		info.Claims.Units, "get the minted units")
+
+	again, err := ts.Token()
+	test.Must(t, err, "get token again")
+
+	test.Equal(t, token.AccessToken, again.AccessToken,
+		"reuse the token until it expires")
+}
@@ -0,0 +1,230 @@
+package elephantine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrTokenPolicy is used to communicate that a token was rejected by a
+// TokenPolicy.
+var ErrTokenPolicy = errors.New("token violates policy")
+
+// TokenPolicy is a centrally managed set of requirements for access tokens,
+// enforced in addition to the validation done by the AuthInfoParser.
+//
+// Example policy file:
+//
+//	issuers:
+//	  - https://login.example.com/realms/elephant
+//	algorithms: [ES384]
+//	max_token_age: 12h
+//	max_key_age: 2160h
+//	required_claims: [sub, exp, iat]
+type TokenPolicy struct {
+	// Issuers are the trusted token issuers.
+	Issuers []string `yaml:"issuers"`
+	// Algorithms are the accepted signing algorithms.
+	Algorithms []string `yaml:"algorithms"`
+	// MaxTokenAge is the maximum time since the token was issued, requires
+	// tokens to have an iat claim.
+	MaxTokenAge time.Duration `yaml:"max_token_age"`
+	// MaxKeyAge is the maximum age of the signing key, counted from the
+	// NotBefore time of the X.509 certificate that is published with the
+	// key (x5c). Tokens signed by keys without a certificate are rejected.
+	// Only enforced by parsers that get their keys from a JWKS, as that's
+	// the only source of key metadata.
+	MaxKeyAge time.Duration `yaml:"max_key_age"`
+	// RequiredClaims are claims that must be present in the token.
+	RequiredClaims []string `yaml:"required_claims"`
+}
+
+// LoadTokenPolicy reads a YAML token policy file.
+func LoadTokenPolicy(path string) (*TokenPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+
+	var policy TokenPolicy
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	dec.KnownFields(true)
+
+	err = dec.Decode(&policy)
+	if err != nil {
+		return nil, fmt.Errorf("parse policy: %w", err)
+	}
+
+	err = policy.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	return &policy, nil
+}
+
+// Validate checks that the policy is usable.
+func (p *TokenPolicy) Validate() error {
+	for _, alg := range p.Algorithms {
+		if alg == "none" {
+			return errors.New(`the "none" algorithm cannot be allowed`)
+		}
+
+		if jwt.GetSigningMethod(alg) == nil {
+			return fmt.Errorf("unknown algorithm %q", alg)
+		}
+	}
+
+	if p.MaxTokenAge < 0 {
+		return errors.New("max token age cannot be negative")
+	}
+
+	if p.MaxKeyAge < 0 {
+		return errors.New("max key age cannot be negative")
+	}
+
+	return nil
+}
+
+// Check verifies that a token satisfies the policy. The token signature must
+// already have been verified, Check only looks at the contents of the token.
+func (p *TokenPolicy) Check(token string) error {
+	claims := jwt.MapClaims{}
+
+	t, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		return fmt.Errorf("parse token: %w", err)
+	}
+
+	if len(p.Algorithms) > 0 {
+		alg, _ := t.Header["alg"].(string)
+
+		if !slices.Contains(p.Algorithms, alg) {
+			return fmt.Errorf("%w: algorithm %q is not allowed",
+				ErrTokenPolicy, alg)
+		}
+	}
+
+	if len(p.Issuers) > 0 {
+		iss, _ := claims.GetIssuer()
+
+		if !slices.Contains(p.Issuers, iss) {
+			return fmt.Errorf("%w: issuer %q is not trusted",
+				ErrTokenPolicy, iss)
+		}
+	}
+
+	for _, name := range p.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("%w: missing %q claim",
+				ErrTokenPolicy, name)
+		}
+	}
+
+	if p.MaxTokenAge > 0 {
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			return fmt.Errorf("%w: missing or invalid iat claim",
+				ErrTokenPolicy)
+		}
+
+		if time.Since(iat.Time) > p.MaxTokenAge {
+			return fmt.Errorf("%w: token is older than %s",
+				ErrTokenPolicy, p.MaxTokenAge)
+		}
+	}
+
+	return nil
+}
+
+// CheckKey verifies that a signing key satisfies the policy.
+func (p *TokenPolicy) CheckKey(key jwkset.JWK) error {
+	if p.MaxKeyAge <= 0 {
+		return nil
+	}
+
+	kid := key.Marshal().KID
+
+	certs := key.X509().X5C
+	if len(certs) == 0 {
+		return fmt.Errorf("%w: the age of the key %q is unknown, it has no certificate",
+			ErrTokenPolicy, kid)
+	}
+
+	if time.Since(certs[0].NotBefore) > p.MaxKeyAge {
+		return fmt.Errorf("%w: the key %q is older than %s",
+			ErrTokenPolicy, kid, p.MaxKeyAge)
+	}
+
+	return nil
+}
+
+// CheckProvider verifies that an identity provider can issue tokens that
+// satisfy the policy. Intended as a startup assertion, so that a
+// misconfiguration is caught on deploy rather than on the first request.
+func (p *TokenPolicy) CheckProvider(conf *OpenIDConnectConfig) error {
+	if len(p.Issuers) > 0 && !slices.Contains(p.Issuers, conf.Issuer) {
+		return fmt.Errorf("the issuer %q is not trusted by the policy",
+			conf.Issuer)
+	}
+
+	supported := conf.IDTokenSigningAlgValuesSupported
+
+	if len(p.Algorithms) > 0 && len(supported) > 0 {
+		ok := slices.ContainsFunc(supported, func(alg string) bool {
+			return slices.Contains(p.Algorithms, alg)
+		})
+		if !ok {
+			return fmt.Errorf(
+				"the issuer only supports the algorithms %s, the policy allows %s",
+				strings.Join(supported, ", "),
+				strings.Join(p.Algorithms, ", "))
+		}
+	}
+
+	return nil
+}
+
+// EnforceTokenPolicy wraps an AuthInfoParser so that all tokens also are
+// checked against the policy.
+func EnforceTokenPolicy(parser AuthInfoParser, policy *TokenPolicy) AuthInfoParser {
+	return &policyParser{
+		parser: parser,
+		policy: policy,
+	}
+}
+
+type policyParser struct {
+	parser AuthInfoParser
+	policy *TokenPolicy
+}
+
+// AuthInfoFromHeader implements AuthInfoParser.
+func (pp *policyParser) AuthInfoFromHeader(authorization string) (*AuthInfo, error) {
+	auth, err := pp.parser.AuthInfoFromHeader(authorization)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	// Fail closed for parsers that don't expose the token.
+	if auth == nil || auth.Token == "" {
+		return nil, fmt.Errorf("%w: no token to check", ErrTokenPolicy)
+	}
+
+	err = pp.policy.Check(auth.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
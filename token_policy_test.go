@@ -0,0 +1,202 @@
+package elephantine_test
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+type tokenPolicyTestCase struct {
+	Key    *test.SigningKey
+	Claims elephantine.JWTClaims
+	Valid  bool
+}
+
+func TestTokenPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+
+	err := os.WriteFile(path, []byte(`
+issuers: [realm-a]
+algorithms: [ES384]
+max_token_age: 1h
+required_claims: [sub, sid]
+`), 0o600)
+	test.Must(t, err, "write policy file")
+
+	policy, err := elephantine.LoadTokenPolicy(path)
+	test.Must(t, err, "load policy")
+
+	es384 := test.NewSigningKey(t, test.KeyTypeES384)
+	es256 := test.NewSigningKey(t, test.KeyTypeES256)
+
+	claims := func(issuer string, issued time.Time, sid string) elephantine.JWTClaims {
+		return elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:   issuer,
+				Subject:  "someone",
+				IssuedAt: jwt.NewNumericDate(issued),
+			},
+			SessionID: sid,
+		}
+	}
+
+	now := time.Now()
+
+	cases := map[string]tokenPolicyTestCase{
+		"valid": {
+			Key:    es384,
+			Claims: claims("realm-a", now, "s1"),
+			Valid:  true,
+		},
+		"wrong_algorithm": {
+			Key:    es256,
+			Claims: claims("realm-a", now, "s1"),
+		},
+		"untrusted_issuer": {
+			Key:    es384,
+			Claims: claims("realm-b", now, "s1"),
+		},
+		"too_old": {
+			Key:    es384,
+			Claims: claims("realm-a", now.Add(-2*time.Hour), "s1"),
+		},
+		"missing_claim": {
+			Key:    es384,
+			Claims: claims("realm-a", now, ""),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			parser := elephantine.EnforceTokenPolicy(
+				tc.Key.Parser(elephantine.JWTAuthInfoParserOptions{}),
+				policy)
+
+			_, err := parser.AuthInfoFromHeader(tc.Key.AccessKey(t, tc.Claims))
+			if tc.Valid {
+				test.Must(t, err, "accept the token")
+
+				return
+			}
+
+			test.MustNot(t, err, "reject the token")
+			test.Equal(t, true, errors.Is(err, elephantine.ErrTokenPolicy),
+				"get a policy error")
+		})
+	}
+
+	err = policy.CheckProvider(&elephantine.OpenIDConnectConfig{
+		Issuer:                           "realm-a",
+		IDTokenSigningAlgValuesSupported: []string{"RS256", "ES384"},
+	})
+	test.Must(t, err, "accept a provider that satisfies the policy")
+
+	err = policy.CheckProvider(&elephantine.OpenIDConnectConfig{
+		Issuer:                           "realm-a",
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+	})
+	test.MustNot(t, err, "reject a provider with the wrong algorithms")
+}
+
+func TestTokenPolicyMaxKeyAge(t *testing.T) {
+	policy := &elephantine.TokenPolicy{
+		MaxKeyAge: 24 * time.Hour,
+	}
+
+	// jwksParser publishes the key with a certificate that is valid from
+	// the given time, or without a certificate if the time is zero.
+	jwksParser := func(notBefore time.Time) (*test.SigningKey, elephantine.AuthInfoParser) {
+		key := test.NewSigningKey(t)
+
+		jwksKey := elephantine.JWKSKey{
+			KeyID:     key.KeyID,
+			Algorithm: key.Method.Alg(),
+			Key:       key.Public(),
+		}
+
+		if !notBefore.IsZero() {
+			template := x509.Certificate{
+				SerialNumber: big.NewInt(1),
+				Subject:      pkix.Name{CommonName: "signing"},
+				NotBefore:    notBefore,
+				NotAfter:     notBefore.Add(365 * 24 * time.Hour),
+			}
+
+			der, err := x509.CreateCertificate(rand.Reader,
+				&template, &template, key.Public(), key.Private)
+			test.Must(t, err, "create certificate")
+
+			cert, err := x509.ParseCertificate(der)
+			test.Must(t, err, "parse certificate")
+
+			jwksKey.Certificates = []*x509.Certificate{cert}
+		}
+
+		handler, err := elephantine.NewJWKSHandler(jwksKey)
+		test.Must(t, err, "create JWKS handler")
+
+		server := httptest.NewServer(handler)
+
+		t.Cleanup(server.Close)
+
+		parser, err := elephantine.NewJWKSAuthInfoParser(test.Context(t),
+			server.URL, elephantine.JWTAuthInfoParserOptions{
+				Issuer:       "test",
+				ValidMethods: []string{key.Method.Alg()},
+				Policy:       policy,
+			})
+		test.Must(t, err, "create parser")
+
+		return key, parser
+	}
+
+	check := func(notBefore time.Time) error {
+		key, parser := jwksParser(notBefore)
+
+		_, err := parser.AuthInfoFromHeader(key.AccessKey(t,
+			elephantine.JWTClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Issuer:  "test",
+					Subject: "someone",
+				},
+			}))
+
+		return err
+	}
+
+	test.Must(t, check(time.Now().Add(-time.Hour)), "accept a fresh key")
+
+	err := check(time.Now().Add(-48 * time.Hour))
+	test.Equal(t, true, errors.Is(err, elephantine.ErrTokenPolicy),
+		"reject an old key")
+
+	err = check(time.Time{})
+	test.Equal(t, true, errors.Is(err, elephantine.ErrTokenPolicy),
+		"reject a key of unknown age")
+}
+
+type tokenlessParser struct{}
+
+func (tokenlessParser) AuthInfoFromHeader(_ string) (*elephantine.AuthInfo, error) {
+	return &elephantine.AuthInfo{}, nil
+}
+
+func TestTokenPolicyWithoutToken(t *testing.T) {
+	parser := elephantine.EnforceTokenPolicy(tokenlessParser{},
+		&elephantine.TokenPolicy{})
+
+	_, err := parser.AuthInfoFromHeader("Bearer opaque")
+	test.Equal(t, true, errors.Is(err, elephantine.ErrTokenPolicy),
+		"reject auth info without a token")
+}
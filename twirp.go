@@ -62,6 +62,10 @@ func LoggingHooks(
 			auth, ok := GetAuthInfo(ctx)
 			if ok {
 				SetLogMetadata(ctx, LogKeySubject, auth.Claims.Subject)
+
+				if auth.Actor != "" {
+					SetLogMetadata(ctx, LogKeyActor, auth.Actor)
+				}
 			}
 
 			return ctx, nil
@@ -0,0 +1,80 @@
+package elephantine
+
+import (
+	"context"
+
+	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/ttab/elephantine"
+
+type twirpSpanCtxKey struct{}
+
+// TracingHooks creates a span for every Twirp RPC with the service, method,
+// and subject as attributes. Error responses are recorded on the span, and
+// 5xx responses set the span status to error. The spans are created by the
+// global tracer provider if tp is nil.
+func TracingHooks(tp trace.TracerProvider) *twirp.ServerHooks {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	tracer := tp.Tracer(tracerName)
+
+	return &twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			service, _ := twirp.ServiceName(ctx)
+			method, _ := twirp.MethodName(ctx)
+
+			attrs := []attribute.KeyValue{
+				semconv.RPCSystemKey.String("twirp"),
+				semconv.RPCService(service),
+				semconv.RPCMethod(method),
+			}
+
+			auth, ok := GetAuthInfo(ctx)
+			if ok {
+				attrs = append(attrs,
+					semconv.EnduserID(auth.Claims.Subject))
+			}
+
+			ctx, span := tracer.Start(ctx, service+"/"+method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attrs...),
+			)
+
+			return context.WithValue(ctx, twirpSpanCtxKey{}, span), nil
+		},
+		Error: func(ctx context.Context, err twirp.Error) context.Context {
+			span, ok := ctx.Value(twirpSpanCtxKey{}).(trace.Span)
+			if !ok {
+				return ctx
+			}
+
+			status := twirp.ServerHTTPStatusFromErrorCode(err.Code())
+
+			span.SetAttributes(
+				attribute.String("rpc.twirp.error_code", string(err.Code())),
+			)
+
+			if status >= 500 {
+				span.SetStatus(codes.Error, err.Msg())
+			}
+
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			span, ok := ctx.Value(twirpSpanCtxKey{}).(trace.Span)
+			if !ok {
+				return
+			}
+
+			span.End()
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package elephantine_test
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingHooks(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	var so elephantine.ServiceOptions
+
+	so.AddTracingHooks(tp)
+
+	call := func(method string, twErr twirp.Error) {
+		ctx := ctxsetters.WithServiceName(test.Context(t), "Documents")
+		ctx = ctxsetters.WithMethodName(ctx, method)
+		ctx = elephantine.SetAuthInfo(ctx, &elephantine.AuthInfo{
+			Claims: elephantine.JWTClaims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject: "core://user/1",
+				},
+			},
+		})
+
+		ctx, err := so.Hooks.RequestRouted(ctx)
+		test.Must(t, err, "route request")
+
+		if twErr != nil {
+			ctx = so.Hooks.Error(ctx, twErr)
+		}
+
+		so.Hooks.ResponseSent(ctx)
+	}
+
+	call("Get", nil)
+	call("Update", twirp.InternalError("database is down"))
+
+	spans := recorder.Ended()
+
+	test.Equal(t, 2, len(spans), "end a span per RPC")
+	test.Equal(t, "Documents/Get", spans[0].Name(), "name the span after the method")
+
+	attrs := make(map[attribute.Key]string)
+
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value.Emit()
+	}
+
+	test.Equal(t, "twirp", attrs["rpc.system"], "set the RPC system")
+	test.Equal(t, "Documents", attrs["rpc.service"], "set the service")
+	test.Equal(t, "Get", attrs["rpc.method"], "set the method")
+	test.Equal(t, "core://user/1", attrs["enduser.id"], "set the subject")
+
+	test.Equal(t, codes.Unset, spans[0].Status().Code,
+		"leave the status of successful calls unset")
+	test.Equal(t, codes.Error, spans[1].Status().Code,
+		"set the error status for internal errors")
+}
@@ -0,0 +1,107 @@
+package elephantine
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var unitBase = &url.URL{
+	Scheme: "core",
+	Host:   "unit",
+}
+
+// NormalizeUnitURI resolves unit references without a scheme against
+// "core://unit/", so that "/editorial" and "editorial" both become
+// "core://unit/editorial". This is the same normalization that
+// AuthInfoFromHeader applies to the units claim.
+func NormalizeUnitURI(unit string) (string, error) {
+	parsed, err := url.Parse(unit)
+	if err != nil {
+		return "", fmt.Errorf("invalid unit URI: %w", err)
+	}
+
+	if parsed.Scheme != "" {
+		return unit, nil
+	}
+
+	return unitBase.ResolveReference(parsed).String(), nil
+}
+
+// HasUnit returns true if the Units claim grants membership in the unit. A
+// unit claim that ends with "/*" grants membership in the unit and all units
+// below it, so "core://unit/news/*" matches "core://unit/news/sports".
+//
+// The URI is normalized in the same way as the units claim.
+func (c JWTClaims) HasUnit(uri string) bool {
+	uri, err := NormalizeUnitURI(uri)
+	if err != nil {
+		return false
+	}
+
+	for _, unit := range c.normalizedUnits() {
+		if unitMatches(unit, uri) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasAnyUnitPrefix returns true if the Units claim contains the unit identified
+// by prefix or any unit below it, so "core://unit/news" matches both
+// "core://unit/news" and "core://unit/news/sports".
+//
+// The prefix is normalized in the same way as the units claim.
+func (c JWTClaims) HasAnyUnitPrefix(prefix string) bool {
+	prefix, err := NormalizeUnitURI(prefix)
+	if err != nil {
+		return false
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	for _, unit := range c.normalizedUnits() {
+		if unitMatches(unit, prefix) {
+			return true
+		}
+
+		unit = strings.TrimSuffix(unit, "/*")
+
+		if strings.HasPrefix(unit, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizedUnits returns the normalized units, claims that haven't been
+// through AuthInfoFromHeader can still contain unqualified units.
+func (c JWTClaims) normalizedUnits() []string {
+	units := make([]string, 0, len(c.Units))
+
+	for _, u := range c.Units {
+		n, err := NormalizeUnitURI(u)
+		if err != nil {
+			continue
+		}
+
+		units = append(units, n)
+	}
+
+	return units
+}
+
+func unitMatches(unit string, uri string) bool {
+	if unit == uri {
+		return true
+	}
+
+	base, wildcard := strings.CutSuffix(unit, "/*")
+	if !wildcard {
+		return false
+	}
+
+	return uri == base || strings.HasPrefix(uri, base+"/")
+}
@@ -0,0 +1,228 @@
+package elephantine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultUserinfoCacheTTL is the default time that userinfo responses are
+// cached for.
+const DefaultUserinfoCacheTTL = 5 * time.Minute
+
+// UserinfoClaims are the standard OpenID Connect profile and email claims.
+type UserinfoClaims struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+}
+
+// UserinfoMetrics are metrics for userinfo requests.
+type UserinfoMetrics struct {
+	duration *prometheus.HistogramVec
+}
+
+// NewUserinfoMetrics registers userinfo metrics with the provided registerer.
+func NewUserinfoMetrics(
+	registerer prometheus.Registerer,
+) (*UserinfoMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := UserinfoMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "userinfo_request_duration_seconds",
+			Help:    "Duration of userinfo requests, by response status.",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"status"}),
+	}
+
+	err := registerer.Register(m.duration)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (m *UserinfoMetrics) observe(start time.Time, status string) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
+
+// Userinfo fetches the claims of the user that the access token belongs to
+// from the userinfo endpoint of the identity provider, and decodes them into
+// v, which can be a struct or a map[string]any. The token can have a "Bearer "
+// prefix.
+//
+// If the identity provider rejects the token a HTTPError with status 401 or
+// 403 is returned, other failures are returned as HTTPErrors with status 502.
+func (conf *AuthenticationConfig) Userinfo(
+	ctx context.Context, token string, v any,
+) error {
+	endpoint := conf.OIDCConfig.UserinfoEndpoint
+	if endpoint == "" {
+		return errors.New("the identity provider has no userinfo endpoint")
+	}
+
+	token = strings.TrimPrefix(token, "Bearer ")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		conf.UserinfoMetrics.observe(start, "error")
+
+		return HTTPErrorf(http.StatusBadGateway,
+			"userinfo request failed: %v", err)
+	}
+
+	defer res.Body.Close()
+
+	conf.UserinfoMetrics.observe(start, strconv.Itoa(res.StatusCode))
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return HTTPErrorf(res.StatusCode,
+			"the identity provider rejected the token")
+	default:
+		return HTTPErrorf(http.StatusBadGateway,
+			"userinfo endpoint responded with: %q", res.Status)
+	}
+
+	// Limit the size of the response, userinfo documents are small.
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+	if err != nil {
+		return HTTPErrorf(http.StatusBadGateway,
+			"invalid userinfo response: %v", err)
+	}
+
+	return nil
+}
+
+// FetchUserinfo fetches the standard userinfo claims for the access token,
+// see Userinfo. Responses are cached per token for the userinfo cache TTL.
+func (conf *AuthenticationConfig) FetchUserinfo(
+	ctx context.Context, token string,
+) (*UserinfoClaims, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
+
+	cache := conf.getUserinfoCache()
+	key := sha256.Sum256([]byte(token))
+
+	item := cache.Get(key)
+	if item != nil && !item.IsExpired() {
+		info := item.Value()
+
+		return &info, nil
+	}
+
+	var info UserinfoClaims
+
+	err := conf.Userinfo(ctx, token, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.Set(key, info, ttlcache.DefaultTTL)
+
+	return &info, nil
+}
+
+func (conf *AuthenticationConfig) getUserinfoCache() *ttlcache.Cache[[sha256.Size]byte, UserinfoClaims] {
+	conf.m.Lock()
+	defer conf.m.Unlock()
+
+	if conf.userinfoCache != nil {
+		return conf.userinfoCache
+	}
+
+	ttl := conf.settings.UserinfoCacheTTL
+	if ttl == 0 {
+		ttl = DefaultUserinfoCacheTTL
+	}
+
+	conf.userinfoCache = ttlcache.New(
+		ttlcache.WithTTL[[sha256.Size]byte, UserinfoClaims](ttl),
+		ttlcache.WithCapacity[[sha256.Size]byte, UserinfoClaims](
+			DefaultAuthInfoCacheSize),
+	)
+
+	return conf.userinfoCache
+}
+
+// EnrichFromUserinfo fills in the name and email claims from the userinfo
+// endpoint when the token lacks them. It can be used as
+// JWTAuthInfoParserOptions.Enrich, and is used by the auth parser of the
+// config when AuthenticationSettings.EnrichFromUserinfo is set.
+//
+// Profile data is best effort, failures are logged but don't reject the
+// token.
+func (conf *AuthenticationConfig) EnrichFromUserinfo(auth *AuthInfo) error {
+	if auth.Claims.Name != "" && auth.Claims.Email != "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	info, err := conf.FetchUserinfo(ctx, auth.Token)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to fetch userinfo",
+			LogKeySubject, auth.Claims.Subject,
+			LogKeyError, err)
+
+		return nil
+	}
+
+	if info.Subject != auth.Claims.OriginalSub {
+		slog.WarnContext(ctx, "userinfo subject doesn't match the token",
+			LogKeySubject, auth.Claims.Subject,
+			"userinfo_sub", info.Subject)
+
+		return nil
+	}
+
+	if auth.Claims.Name == "" {
+		auth.Claims.Name = info.Name
+	}
+
+	if auth.Claims.Name == "" {
+		auth.Claims.Name = info.PreferredUsername
+	}
+
+	if auth.Claims.Email == "" {
+		auth.Claims.Email = info.Email
+	}
+
+	return nil
+}
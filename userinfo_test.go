@@ -0,0 +1,122 @@
+package elephantine_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestUserinfo(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	server.SetUserinfo("core://user/someone", map[string]any{
+		"name":  "Some One",
+		"email": "someone@example.com",
+	})
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		ctx, server.Settings(), nil, nil)
+	test.Must(t, err, "create authentication config")
+
+	reg := prometheus.NewRegistry()
+
+	conf.UserinfoMetrics, err = elephantine.NewUserinfoMetrics(reg)
+	test.Must(t, err, "create userinfo metrics")
+
+	accessKey := server.AccessKey(t, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "core://user/someone",
+		},
+	})
+
+	var profile struct {
+		Subject string `json:"sub"`
+		Name    string `json:"name"`
+		Email   string `json:"email"`
+	}
+
+	err = conf.Userinfo(ctx, accessKey, &profile)
+	test.Must(t, err, "fetch userinfo into a struct")
+
+	test.Equal(t, "core://user/someone", profile.Subject, "get the subject")
+	test.Equal(t, "Some One", profile.Name, "get the name")
+
+	var claims map[string]any
+
+	err = conf.Userinfo(ctx, accessKey, &claims)
+	test.Must(t, err, "fetch userinfo into a map")
+
+	test.Equal(t, "someone@example.com", claims["email"], "get the email")
+
+	other := test.NewOIDCServer(t)
+
+	err = conf.Userinfo(ctx, other.AccessKey(t, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "core://user/someone",
+		},
+	}), &claims)
+	test.Equal(t, true,
+		elephantine.IsHTTPErrorWithStatus(err, http.StatusUnauthorized),
+		"get a 401 error for a token from another issuer")
+
+	test.Equal(t, 2, testutil.CollectAndCount(reg), "record the requests")
+}
+
+func TestEnrichFromUserinfo(t *testing.T) {
+	ctx := test.Context(t)
+	server := test.NewOIDCServer(t)
+
+	server.SetUserinfo("core://user/someone", map[string]any{
+		"preferred_username": "someone",
+		"email":              "someone@example.com",
+	})
+
+	settings := server.Settings()
+
+	settings.EnrichFromUserinfo = true
+
+	conf, err := elephantine.NewAuthenticationConfig(
+		ctx, settings, nil, nil)
+	test.Must(t, err, "create authentication config")
+
+	auth, err := conf.AuthParser.AuthInfoFromHeader(server.AccessKey(t,
+		elephantine.JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "core://user/someone",
+			},
+			Name: "Some One",
+		}))
+	test.Must(t, err, "parse token")
+
+	test.Equal(t, "Some One", auth.Claims.Name, "keep the name from the token")
+	test.Equal(t, "someone@example.com", auth.Claims.Email,
+		"add the email from userinfo")
+
+	accessKey := server.AccessKey(t, elephantine.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: "core://user/someone",
+		},
+	})
+
+	auth, err = conf.AuthParser.AuthInfoFromHeader(accessKey)
+	test.Must(t, err, "parse token without a name")
+
+	test.Equal(t, "someone", auth.Claims.Name,
+		"fall back to the preferred username")
+
+	server.SetUserinfo("core://user/someone", map[string]any{
+		"name": "Changed",
+	})
+
+	info, err := conf.FetchUserinfo(ctx, accessKey)
+	test.Must(t, err, "fetch userinfo")
+
+	test.Equal(t, "someone@example.com", info.Email,
+		"get the cached userinfo")
+}
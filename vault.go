@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
@@ -22,6 +25,15 @@ const (
 
 // NewVault creates a vault client that can be used as a ParameterSource.
 func NewVault() (*Vault, error) {
+	return NewVaultWithOptions(context.Background(), SecretSourceOptions{})
+}
+
+// NewVaultWithOptions creates a vault client that can be used as a
+// ParameterSource. All Vault operations, including the initial login, are
+// bounded by the configured timeout.
+func NewVaultWithOptions(
+	ctx context.Context, opts SecretSourceOptions,
+) (*Vault, error) {
 	config := vault.DefaultConfig()
 
 	client, err := vault.NewClient(config)
@@ -31,10 +43,12 @@ func NewVault() (*Vault, error) {
 
 	v := Vault{
 		parameters: make(map[string]map[string]string),
+		opts:       opts.withDefaults(),
 		Client:     client,
+		stop:       make(chan struct{}),
 	}
 
-	err = v.authChain()
+	err = v.authChain(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -47,10 +61,12 @@ func NewVault() (*Vault, error) {
 type Vault struct {
 	// Cache the data for secrets ...yes that's a silly type declaration.
 	parameters map[string]map[string]string
+	opts       SecretSourceOptions
 
 	Client *vault.Client
 
 	stop         chan struct{}
+	m            sync.Mutex
 	startOfLease time.Time
 	vaultLogin   *vault.Secret
 }
@@ -60,41 +76,204 @@ type Vault struct {
 // lost or fails to renew. Returns immediately without an error if a token was
 // used to authenticate directly with vault.
 func (v *Vault) KeepAlive() error {
-	if v.vaultLogin == nil {
+	return v.KeepAliveContext(context.Background())
+}
+
+// KeepAliveContext works like KeepAlive, but also stops when the context is
+// cancelled.
+//
+// The lease is renewed when SecretSourceOptions.RenewFraction of it has
+// passed, shifted by a random jitter. Failed renewals are retried with backoff
+// for as long as the lease is valid. Non-renewable logins, and leases that
+// couldn't be renewed in time, are replaced by logging in again through the
+// same auth chain as the initial login. The current token is kept until the
+// new login succeeds.
+func (v *Vault) KeepAliveContext(ctx context.Context) error {
+	login, startOfLease := v.lease()
+	if login == nil {
 		return nil
 	}
 
 	for {
-		if !v.vaultLogin.Auth.Renewable {
-			return errors.New("vault login is not renewable")
+		ttl := loginTTL(login)
+		renewAt := startOfLease.Add(v.renewalDelay(ttl))
+
+		select {
+		case <-v.stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(renewAt)):
+		}
+
+		err := v.refresh(ctx, login, startOfLease.Add(ttl))
+		if err != nil {
+			return err
+		}
+
+		login, startOfLease = v.lease()
+		if login == nil {
+			return nil
+		}
+	}
+}
+
+// LeaseRemaining returns the time that is left of the Vault login lease. Zero
+// is returned if Vault was authenticated with a token.
+func (v *Vault) LeaseRemaining() time.Duration {
+	login, startOfLease := v.lease()
+	if login == nil {
+		return 0
+	}
+
+	expires := startOfLease.Add(loginTTL(login))
+
+	return max(time.Until(expires), 0)
+}
+
+func (v *Vault) lease() (*vault.Secret, time.Time) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	return v.vaultLogin, v.startOfLease
+}
+
+func (v *Vault) setLease(login *vault.Secret) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.startOfLease = time.Now()
+	v.vaultLogin = login
+
+	v.opts.Metrics.leaseExpires("vault", v.startOfLease.Add(loginTTL(login)))
+}
+
+// loginTTL returns the lease duration of a login. Vault reports the lease of
+// auth responses in the auth block.
+func loginTTL(login *vault.Secret) time.Duration {
+	if login.Auth != nil {
+		return time.Duration(login.Auth.LeaseDuration) * time.Second
+	}
+
+	return time.Duration(login.LeaseDuration) * time.Second
+}
+
+func (v *Vault) clearLease() {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.startOfLease = time.Time{}
+	v.vaultLogin = nil
+}
+
+// renewalDelay returns the time after the start of the lease when it should
+// be renewed.
+func (v *Vault) renewalDelay(ttl time.Duration) time.Duration {
+	delay := float64(ttl) * v.opts.RenewFraction
+
+	if v.opts.RenewJitter > 0 {
+		delay *= 1 + v.opts.RenewJitter*(2*rand.Float64()-1) //nolint:gosec
+	}
+
+	return time.Duration(delay)
+}
+
+// refresh renews the login, or logs in again if the login isn't renewable or
+// can't be renewed before it expires.
+func (v *Vault) refresh(
+	ctx context.Context, login *vault.Secret, expires time.Time,
+) error {
+	backoff := ExponentialBackoff(time.Second, v.opts.RenewMaxBackoff)
+
+	var renewErr error
+
+	for attempt := 1; login.Auth != nil && login.Auth.Renewable; attempt++ {
+		renewErr = v.renew(ctx, login)
+		if renewErr == nil {
+			return nil
 		}
 
-		endOfLease := v.startOfLease.Add(
-			time.Duration(v.vaultLogin.LeaseDuration) * time.Second)
-		leaseDuration := time.Until(endOfLease)
+		wait := backoff(attempt)
+
+		if time.Now().Add(wait).After(expires) {
+			break
+		}
 
 		select {
 		case <-v.stop:
 			return nil
-		case <-time.After(leaseDuration / 3):
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
 		}
+	}
 
-		// Renew for the same period as the initial lease.
-		secret, err := v.Client.Auth().Token().RenewSelf(
-			v.vaultLogin.LeaseDuration,
-		)
-		if err != nil {
-			return fmt.Errorf("renew Vault login lease: %w", err)
+	// Keep using the current token, which is valid until the lease
+	// expires, until we have logged in again.
+	for attempt := 1; ; attempt++ {
+		err := v.login(ctx)
+		if err == nil {
+			return nil
 		}
 
-		if secret.Auth == nil || secret.Auth.ClientToken == "" {
-			return errors.New("no token returned by renewal")
+		wait := backoff(attempt)
+
+		if time.Now().Add(wait).After(expires) {
+			return errors.Join(renewErr,
+				fmt.Errorf("log in to Vault again: %w", err))
+		}
+
+		select {
+		case <-v.stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
 		}
+	}
+}
 
-		v.startOfLease = time.Now()
-		v.Client.SetToken(secret.Auth.ClientToken)
-		v.vaultLogin = secret
+func (v *Vault) renew(ctx context.Context, login *vault.Secret) error {
+	// Renew for the same period as the initial lease.
+	secret, err := secretOperation(ctx, v.opts, "vault", "renew",
+		func(ctx context.Context) (*vault.Secret, error) {
+			//nolint:wrapcheck
+			return v.Client.Auth().Token().RenewSelfWithContext(
+				ctx, int(loginTTL(login)/time.Second),
+			)
+		})
+	if err != nil {
+		return fmt.Errorf("renew Vault login lease: %w", err)
+	}
+
+	if secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return errors.New("no token returned by renewal")
 	}
+
+	v.Client.SetToken(secret.Auth.ClientToken)
+	v.setLease(secret)
+
+	return nil
+}
+
+// LogValue implements slog.LogValuer, the Vault token and cached secrets are
+// left out.
+func (v *Vault) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("address", v.Client.Address()),
+		slog.Any("token", RedactedLogValue(v.Client.Token())),
+	}
+
+	login, startOfLease := v.lease()
+
+	if login != nil {
+		attrs = append(attrs,
+			slog.Time("start_of_lease", startOfLease),
+			slog.Int("lease_duration", int(loginTTL(login)/time.Second)),
+		)
+	}
+
+	return slog.GroupValue(attrs...)
 }
 
 // Stop the keepalive loop.
@@ -102,16 +281,25 @@ func (v *Vault) Stop() {
 	close(v.stop)
 }
 
-func (v *Vault) authChain() error {
+func (v *Vault) authChain(ctx context.Context) error {
 	if v.Client.Token() != "" {
 		return nil
 	}
 
+	return v.login(ctx)
+}
+
+// login authenticates with the token file or the Kubernetes auth method. The
+// current token is kept if the login fails.
+func (v *Vault) login(ctx context.Context) error {
 	if v.tryTokenFile() {
+		// Tokens from the token file aren't leased by us.
+		v.clearLease()
+
 		return nil
 	}
 
-	err := v.kubernetesAuth()
+	err := v.kubernetesAuth(ctx)
 	if err != nil {
 		return fmt.Errorf("kubernetes auth failed: %w", err)
 	}
@@ -119,7 +307,7 @@ func (v *Vault) authChain() error {
 	return nil
 }
 
-func (v *Vault) kubernetesAuth() error {
+func (v *Vault) kubernetesAuth(ctx context.Context) error {
 	tokenPath := os.Getenv(EnvServiceAccountToken)
 	if tokenPath == "" {
 		tokenPath = DefaultServiceAccountTokenPath
@@ -138,13 +326,24 @@ func (v *Vault) kubernetesAuth() error {
 		return fmt.Errorf("initialize Kubernetes auth method: %w", err)
 	}
 
-	secret, err := v.Client.Auth().Login(context.Background(), k8sAuth)
+	// Log in with a token-less copy of the client, so that the current
+	// token stays in place if the login fails.
+	client, err := v.Client.Clone()
+	if err != nil {
+		return fmt.Errorf("clone vault client: %w", err)
+	}
+
+	secret, err := secretOperation(ctx, v.opts, "vault", "login",
+		func(ctx context.Context) (*vault.Secret, error) {
+			//nolint:wrapcheck
+			return client.Auth().Login(ctx, k8sAuth)
+		})
 	if err != nil {
 		return fmt.Errorf("log in to vault: %w", err)
 	}
 
-	v.startOfLease = time.Now()
-	v.vaultLogin = secret
+	v.Client.SetToken(secret.Auth.ClientToken)
+	v.setLease(secret)
 
 	return nil
 }
@@ -201,7 +400,11 @@ func (v *Vault) GetParameterValue(ctx context.Context, name string) (string, err
 }
 
 func (v *Vault) dataMapFromEntry(ctx context.Context, path string) (map[string]string, error) {
-	res, err := v.Client.KVv2("secret").Get(ctx, path)
+	res, err := secretOperation(ctx, v.opts, "vault", "read",
+		func(ctx context.Context) (*vault.KVSecret, error) {
+			//nolint:wrapcheck
+			return v.Client.KVv2("secret").Get(ctx, path)
+		})
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from KV store: %w", err)
 	}
@@ -0,0 +1,181 @@
+package elephantine_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestVaultRenewalDelay(t *testing.T) {
+	ttl := time.Hour
+
+	test.Equal(t, 30*time.Minute,
+		elephantine.VaultRenewalDelay(elephantine.SecretSourceOptions{
+			RenewFraction: 0.5,
+			RenewJitter:   -1,
+		}, ttl),
+		"renew at the configured fraction without jitter")
+
+	opts := elephantine.SecretSourceOptions{
+		RenewFraction: 0.5,
+		RenewJitter:   0.2,
+	}
+
+	var varied bool
+
+	for range 1000 {
+		delay := elephantine.VaultRenewalDelay(opts, ttl)
+
+		if delay < 24*time.Minute || delay > 36*time.Minute {
+			t.Fatalf("delay %s is outside of the jitter bounds", delay)
+		}
+
+		varied = varied || delay != 30*time.Minute
+	}
+
+	test.Equal(t, true, varied, "apply jitter to the delay")
+
+	delay := elephantine.VaultRenewalDelay(
+		elephantine.SecretSourceOptions{}, 3*time.Hour)
+
+	if delay < 54*time.Minute || delay > 66*time.Minute {
+		t.Fatalf("default delay %s is outside of the default bounds", delay)
+	}
+}
+
+// fakeVault serves the Vault token renewal and Kubernetes login endpoints.
+type fakeVault struct {
+	m           sync.Mutex
+	logins      int
+	renewals    int
+	failLogins  bool
+	renewTokens []string
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	switch r.URL.Path {
+	case "/v1/auth/kubernetes/login":
+		if f.failLogins && f.logins > 0 {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		f.logins++
+
+		token := "token-" + string(rune('0'+f.logins))
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"auth": map[string]any{
+				"client_token":   token,
+				"lease_duration": 1,
+				"renewable":      true,
+			},
+		})
+	case "/v1/auth/token/renew-self":
+		f.renewals++
+		f.renewTokens = append(f.renewTokens, r.Header.Get("X-Vault-Token"))
+
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) state() (int, int, []string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return f.logins, f.renewals, append([]string(nil), f.renewTokens...)
+}
+
+func newFakeVault(t *testing.T, fake *fakeVault) *elephantine.Vault {
+	t.Helper()
+
+	server := httptest.NewServer(fake)
+
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "token")
+
+	err := os.WriteFile(tokenPath, []byte("service-account-token"), 0o600)
+	test.Must(t, err, "write service account token")
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("HOME", dir)
+	t.Setenv(elephantine.EnvServiceAccountToken, tokenPath)
+
+	v, err := elephantine.NewVaultWithOptions(test.Context(t),
+		elephantine.SecretSourceOptions{
+			RenewFraction: 0.1,
+			RenewJitter:   -1,
+		})
+	test.Must(t, err, "log in to Vault")
+
+	test.Equal(t, "token-1", v.Client.Token(), "use the login token")
+
+	return v
+}
+
+func TestVaultRenewalFallback(t *testing.T) {
+	var fake fakeVault
+
+	v := newFakeVault(t, &fake)
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- v.KeepAliveContext(test.Context(t))
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		logins, _, _ := fake.state()
+		if logins >= 2 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a new login")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	v.Stop()
+
+	err := <-done
+	test.Must(t, err, "stop keepalive")
+
+	_, renewals, tokens := fake.state()
+
+	test.Equal(t, true, renewals > 0, "try to renew before logging in")
+	test.Equal(t, "token-1", tokens[0], "renew with the current token")
+	test.Equal(t, true, v.LeaseRemaining() > 0, "get a new lease")
+}
+
+func TestVaultLoginFailureKeepsToken(t *testing.T) {
+	fake := fakeVault{failLogins: true}
+
+	v := newFakeVault(t, &fake)
+
+	err := v.KeepAliveContext(test.Context(t))
+	test.MustNot(t, err, "fail when the lease can't be renewed or replaced")
+
+	test.Equal(t, "token-1", v.Client.Token(),
+		"keep the current token when logging in again fails")
+}
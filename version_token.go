@@ -0,0 +1,128 @@
+package elephantine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/twitchtv/twirp"
+)
+
+// IfMatchHeader is the request header that carries the expected version token
+// for conditional updates.
+const IfMatchHeader = "If-Match"
+
+// VersionTokenHeader is the response header that carries the current version
+// token of an entity.
+const VersionTokenHeader = "ETag"
+
+// VersionToken creates a version token for an entity with a numeric version.
+func VersionToken(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ContentVersionToken creates a version token for an entity that lacks a
+// version counter, based on a hash of its serialized data.
+func ContentVersionToken(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return strconv.Quote(base64.RawURLEncoding.EncodeToString(sum[:16]))
+}
+
+// WithIfMatch adds a If-Match version token to the request metadata of
+// outgoing Twirp calls made with the returned context.
+func WithIfMatch(ctx context.Context, token string) (context.Context, error) {
+	header, ok := twirp.HTTPRequestHeaders(ctx)
+	if ok {
+		header = header.Clone()
+	} else {
+		header = make(http.Header)
+	}
+
+	header.Set(IfMatchHeader, token)
+
+	//nolint:wrapcheck
+	return twirp.WithHTTPRequestHeaders(ctx, header)
+}
+
+type ifMatchCtxKey struct{}
+
+// withRequestIfMatch stores the If-Match header values of an incoming request
+// in the context. They're kept out of the Twirp request headers so that they
+// aren't forwarded to other services together with the context.
+func withRequestIfMatch(ctx context.Context, values []string) context.Context {
+	if len(values) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, ifMatchCtxKey{}, values)
+}
+
+// GetIfMatch returns the If-Match version tokens that were sent with the
+// request. The header is read by the middleware set up in
+// ServiceOptions.SetAuthInfoValidation.
+func GetIfMatch(ctx context.Context) ([]string, bool) {
+	values, _ := ctx.Value(ifMatchCtxKey{}).([]string)
+
+	var tokens []string
+
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+
+	return tokens, len(tokens) > 0
+}
+
+// CheckVersionToken checks the current version token of an entity against the
+// If-Match tokens of the request. Requests without If-Match, or with the
+// wildcard "*", are unconditional. Returns a FailedPrecondition Twirp error
+// with the current version token as "current_version" metadata if the check
+// fails.
+//
+// Pass an empty current token for entities that don't exist, that only
+// satisfies unconditional requests.
+func CheckVersionToken(ctx context.Context, current string) error {
+	tokens, ok := GetIfMatch(ctx)
+	if !ok {
+		return nil
+	}
+
+	for _, t := range tokens {
+		if t == "*" && current != "" {
+			return nil
+		}
+
+		if current != "" && strings.TrimPrefix(t, "W/") == current {
+			return nil
+		}
+	}
+
+	return VersionMismatchError(current)
+}
+
+// VersionMismatchError returns the standard FailedPrecondition error for
+// conditional requests where the version token didn't match.
+func VersionMismatchError(current string) twirp.Error {
+	err := twirp.FailedPrecondition.Error(
+		"the entity has been modified, version token mismatch")
+
+	if current != "" {
+		err = err.WithMeta("current_version", current)
+	}
+
+	return err
+}
+
+// SetVersionToken sets the version token response header for a Twirp response.
+func SetVersionToken(ctx context.Context, token string) error {
+	//nolint:wrapcheck
+	return twirp.SetHTTPResponseHeader(ctx, VersionTokenHeader, token)
+}
@@ -0,0 +1,68 @@
+package elephantine_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+	"github.com/twitchtv/twirp"
+)
+
+func TestCheckVersionToken(t *testing.T) {
+	current := elephantine.VersionToken(12)
+
+	cases := map[string]struct {
+		IfMatch []string
+		Current string
+		Fail    bool
+	}{
+		"unconditional":        {Current: current},
+		"matching":             {IfMatch: []string{`"12"`}, Current: current},
+		"weak_matching":        {IfMatch: []string{`W/"12"`}, Current: current},
+		"one_of_list":          {IfMatch: []string{`"11", "12"`}, Current: current},
+		"wildcard":             {IfMatch: []string{"*"}, Current: current},
+		"stale":                {IfMatch: []string{`"11"`}, Current: current, Fail: true},
+		"wildcard_missing":     {IfMatch: []string{"*"}, Fail: true},
+		"unconditional_create": {},
+	}
+
+	var so elephantine.ServiceOptions
+
+	so.SetAuthInfoValidation(nil, elephantine.ServiceAuthOptional)
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+
+			for _, v := range tc.IfMatch {
+				req.Header.Add(elephantine.IfMatchHeader, v)
+			}
+
+			var ctx context.Context
+
+			err := so.AuthMiddleware(httptest.NewRecorder(), req,
+				http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+					ctx = r.Context()
+				}))
+			test.Must(t, err, "run auth middleware")
+
+			headers, _ := twirp.HTTPRequestHeaders(ctx)
+
+			test.Equal(t, "", headers.Get(elephantine.IfMatchHeader),
+				"don't forward the If-Match header")
+
+			err = elephantine.CheckVersionToken(ctx, tc.Current)
+
+			if !tc.Fail {
+				test.Must(t, err, "pass the version check")
+
+				return
+			}
+
+			test.IsTwirpError(t, err, twirp.FailedPrecondition)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package elephantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrWarmupIncomplete is returned by the warm-up ready check until the
+// warm-up has completed.
+var ErrWarmupIncomplete = errors.New("warm-up has not completed")
+
+// WarmupMetrics are metrics for warm-up tasks.
+type WarmupMetrics struct {
+	duration *prometheus.GaugeVec
+}
+
+// NewWarmupMetrics registers warm-up metrics with the provided registerer.
+func NewWarmupMetrics(registerer prometheus.Registerer) (*WarmupMetrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := WarmupMetrics{
+		duration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "warmup_task_duration_seconds",
+			Help: "The time it took to run a warm-up task, by result.",
+		}, []string{"task", "result"}),
+	}
+
+	err := registerer.Register(m.duration)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to register metrics collector: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (m *WarmupMetrics) observe(task string, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.duration.WithLabelValues(task, result).Set(d.Seconds())
+}
+
+// WarmupFunc is a warm-up task, f.ex. priming a cache or preparing
+// statements. It should respect the cancellation of the context.
+type WarmupFunc func(ctx context.Context) error
+
+// WarmupOptions controls the behaviour of a Warmup.
+type WarmupOptions struct {
+	// Timeout is the longest time that the warm-up is allowed to take.
+	// Defaults to 30s.
+	Timeout time.Duration
+	// Metrics is used to report task timings if set.
+	Metrics *WarmupMetrics
+}
+
+type warmupTask struct {
+	name string
+	fn   WarmupFunc
+}
+
+// Warmup runs warm-up tasks before the application is marked as ready, so
+// that the first requests after a deploy don't have to pay for cold caches.
+// Register the tasks with Add, the ready check with
+// HealthServer.AddWarmup, and start the warm-up with Run once the application
+// has been set up.
+//
+// Warm-up is best effort: tasks that fail or time out are logged, but the
+// application is marked as ready once the warm-up has completed either way.
+type Warmup struct {
+	logger *slog.Logger
+	opts   WarmupOptions
+
+	m     sync.Mutex
+	tasks []warmupTask
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewWarmup creates a new Warmup.
+func NewWarmup(logger *slog.Logger, opts WarmupOptions) *Warmup {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	return &Warmup{
+		logger: logger,
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+}
+
+// Add registers a warm-up task. Tasks that are added after Run has been
+// called are ignored.
+func (w *Warmup) Add(name string, fn WarmupFunc) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.tasks = append(w.tasks, warmupTask{
+		name: name,
+		fn:   fn,
+	})
+}
+
+// Run runs the warm-up tasks concurrently and waits for them to complete, or
+// for the timeout to be reached. Tasks that still are running when the
+// timeout is reached are left to finish in the background. The returned error
+// joins the errors of the failed tasks. Subsequent calls are no-ops.
+func (w *Warmup) Run(ctx context.Context) error {
+	var err error
+
+	w.once.Do(func() {
+		defer close(w.done)
+
+		err = w.run(ctx)
+	})
+
+	return err
+}
+
+func (w *Warmup) run(ctx context.Context) error {
+	w.m.Lock()
+	tasks := w.tasks
+	w.m.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	results := make(chan error, len(tasks))
+	pending := make(map[string]bool, len(tasks))
+
+	var (
+		m        sync.Mutex
+		timedOut bool
+	)
+
+	for _, task := range tasks {
+		pending[task.name] = true
+	}
+
+	for _, task := range tasks {
+		go func() {
+			err := task.fn(ctx)
+
+			m.Lock()
+			delete(pending, task.name)
+			late := timedOut
+			m.Unlock()
+
+			// The task has already been reported as timed out.
+			if late {
+				return
+			}
+
+			duration := time.Since(start)
+			result := "ok"
+
+			if err != nil {
+				result = "error"
+				err = fmt.Errorf("warm-up task %q: %w", task.name, err)
+
+				w.logger.WarnContext(ctx, "warm-up task failed",
+					LogKeyName, task.name,
+					LogKeyError, err,
+				)
+			}
+
+			w.opts.Metrics.observe(task.name, result, duration)
+
+			results <- err
+		}()
+	}
+
+	var errs []error
+
+	for range tasks {
+		select {
+		case err := <-results:
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case <-ctx.Done():
+			m.Lock()
+
+			timedOut = true
+
+			for name := range pending {
+				w.opts.Metrics.observe(name, "timeout", time.Since(start))
+
+				errs = append(errs, fmt.Errorf(
+					"warm-up task %q: %w", name, ctx.Err()))
+			}
+
+			m.Unlock()
+
+			w.logger.WarnContext(ctx, "warm-up timed out",
+				LogKeyError, ctx.Err(),
+				LogKeyDuration, time.Since(start),
+			)
+
+			return errors.Join(errs...)
+		}
+	}
+
+	w.logger.Info("warm-up completed",
+		LogKeyDuration, time.Since(start),
+	)
+
+	return errors.Join(errs...)
+}
+
+// Done returns a channel that is closed once the warm-up has completed.
+func (w *Warmup) Done() <-chan struct{} {
+	return w.done
+}
+
+// ReadyCheck returns a ReadyFunc that fails until the warm-up has completed.
+func (w *Warmup) ReadyCheck() ReadyFunc {
+	return func(_ context.Context) error {
+		select {
+		case <-w.done:
+			return nil
+		default:
+			return ErrWarmupIncomplete
+		}
+	}
+}
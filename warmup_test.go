@@ -0,0 +1,80 @@
+package elephantine_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttab/elephantine"
+	"github.com/ttab/elephantine/test"
+)
+
+func TestWarmup(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	metrics, err := elephantine.NewWarmupMetrics(reg)
+	test.Must(t, err, "create metrics")
+
+	warmup := elephantine.NewWarmup(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		elephantine.WarmupOptions{
+			Timeout: 100 * time.Millisecond,
+			Metrics: metrics,
+		})
+
+	primed := make(chan struct{})
+
+	warmup.Add("cache", func(_ context.Context) error {
+		close(primed)
+
+		return nil
+	})
+
+	warmup.Add("templates", func(_ context.Context) error {
+		return errors.New("invalid template")
+	})
+
+	warmup.Add("statements", func(_ context.Context) error {
+		// Ignores the context and outlives the timeout.
+		time.Sleep(time.Second)
+
+		return nil
+	})
+
+	ready := warmup.ReadyCheck()
+
+	err = ready(test.Context(t))
+	if !errors.Is(err, elephantine.ErrWarmupIncomplete) {
+		t.Fatalf("expected the ready check to fail before warm-up, got: %v", err)
+	}
+
+	start := time.Now()
+
+	err = warmup.Run(test.Context(t))
+	test.MustNot(t, err, "report the failed tasks")
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("warm-up didn't respect the timeout, took %s",
+			time.Since(start))
+	}
+
+	<-primed
+
+	msg := err.Error()
+
+	if !strings.Contains(msg, "invalid template") ||
+		!strings.Contains(msg, `"statements"`) {
+		t.Fatalf("expected the failed and timed out tasks in the error, got: %v", err)
+	}
+
+	err = ready(test.Context(t))
+	test.Must(t, err, "be ready after the warm-up")
+
+	count := testutil.CollectAndCount(reg, "warmup_task_duration_seconds")
+	test.Equal(t, 3, count, "report a timing for every task")
+}